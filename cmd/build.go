@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"sysweaver/internal/logging"
+	"sysweaver/internal/progress"
+	"sysweaver/internal/store"
+	"sysweaver/pkg/sysweaver"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	// Флаги управления стадиями
+	skipImage   bool
+	reuseRootfs string
+	scriptsFrom string
+	checkpoint  bool
+	resumeBuild bool
+
+	// Выбор скриптов (--skip, --only, --from, --until)
+	skipScripts []string
+	onlyScripts []string
+	fromScript  string
+	untilScript string
+
+	// Пауза перед каждым скриптом (--step)
+	stepThrough bool
+
+	// Вывод плана сборки без ее выполнения
+	dryRun bool
+
+	// Матричная сборка и рабочий каталог jail
+	matrixBuild  bool
+	workspaceDir string
+
+	// Кэш слоев скриптов и общее хранилище слоев
+	layerCache  bool
+	cacheRemote string
+	cachePush   bool
+
+	// Профили и переопределения значений конфигурации (--profile, --set path=value)
+	configProfiles  []string
+	configOverrides []string
+
+	// Повторное разрешение удаленных слоев шаблона вместо версий из sysweaver.lock
+	updateLock bool
+
+	// Повторное разрешение версий пакетов вместо версий из packages.lock
+	updatePackageLock bool
+
+	// Файл отчета JUnit XML о проверках стадии test
+	junitReport string
+
+	// Вывод хода сборки: auto, tty (строка состояния), plain или json (события NDJSON в stdout)
+	progressFormat string
+
+	// Число одновременно выполняемых независимых скриптов стадии
+	scriptJobs int
+)
+
+// buildCmd представляет команду для создания образа
+var buildCmd = &cobra.Command{
+	Use:   "build [template]",
+	Short: "Build a Linux image from a template",
+	Long: `Build a Linux image using the specified template.
+The template should contain all necessary scripts and configurations; it may
+also be a git URL (https://host/repo.git#ref:path).
+
+The build runs the stages prepare, install, configure, image, test and
+cleanup, each executing scripts/<stage>/*.sh inside the jail in name order
+(scripts.yaml sets order, dependencies, conditions and retries). Script logs
+are written to <output>/logs, and every build ends with a summary of stages,
+scripts, artifacts and warnings.
+
+Common modes:
+  --jobs N            run up to N independent scripts of a stage at once
+  --from, --until,
+  --only, --skip      run a subset of the scripts while developing a template
+  --step, --dry-run   confirm every script, or only print the build plan
+  --cache             reuse the layers of unchanged scripts (--cache-remote
+                      shares them between machines)
+  --matrix            build every arch x profile combination of matrix:
+  --checkpoint,
+  --resume            save the jail state and continue a failed build
+  --quiet, --format   print only artifact paths or the JSON build record
+  --progress json     write NDJSON build events to stdout
+
+A failed build exits with a code for the failure class: 2 config, 3 host
+prerequisite, 4 jail or stage step, 5 script or test assertion, 6 image or
+output, 7 publish or upload, 1 anything else. Ctrl-C stops the build and
+releases the jail, loop devices and mounts.
+
+Details on stages, the layer cache and packages.lock, matrix builds, hooks,
+plugins and events are in docs/ (build.md, cache.md, matrix.md, hooks.md,
+plugins.md, events.md, config.md).`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		scripted := quiet || resultFormat == formatJSON
+		if scripted && matrixBuild {
+			return fmt.Errorf("--quiet and --format json are not supported with --matrix")
+		}
+		if scripted && (progressFormat == "tty" || progressFormat == "json") {
+			return fmt.Errorf("--progress %s cannot be used with --quiet or --format json", progressFormat)
+		}
+		if err := redirectOutput(); err != nil {
+			return err
+		}
+		// Прогресс идет в перенаправленный stdout вместе с остальным выводом
+		progress.SetReporter(progress.PrintReporter(os.Stdout))
+
+		switch progressFormat {
+		case "auto":
+			// Строка состояния мешала бы вводу в --step и --manual
+			if logging.IsTerminal(os.Stdout) && !scripted && !matrixBuild && !dryRun && !stepThrough && !manual {
+				display, err := startProgressDisplay(cmd)
+				if err != nil {
+					return err
+				}
+				defer display.Stop()
+			}
+		case "tty":
+			if matrixBuild || stepThrough || manual {
+				return fmt.Errorf("--progress tty cannot be used with --matrix, --step or --manual")
+			}
+			if !dryRun {
+				display, err := startProgressDisplay(cmd)
+				if err != nil {
+					return err
+				}
+				defer display.Stop()
+			}
+		case "plain":
+		case "json":
+			if matrixBuild {
+				return fmt.Errorf("--progress json is not supported with --matrix")
+			}
+			enableProgressEvents()
+		default:
+			return fmt.Errorf("invalid progress format %q (expected auto, tty, plain or json)", progressFormat)
+		}
+		if matrixBuild {
+			return runMatrix(cmd, args[0])
+		}
+		// Ctrl-C или SIGTERM прерывают сборку с освобождением jail, loop-устройств
+		// и точек монтирования; повторный сигнал завершает процесс сразу
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		context.AfterFunc(ctx, stop)
+
+		builder := sysweaver.Builder{Logger: slog.Default(), Events: printBuildOutput}
+		record, err := builder.Build(ctx, buildOptions(args[0]))
+		if record != nil {
+			writeBuildResult(record)
+		}
+		writeFailure(err)
+		return err
+	},
+}
+
+// buildOptions возвращает параметры сборки из флагов команды
+func buildOptions(templateArg string) sysweaver.Options {
+	return sysweaver.Options{
+		Template:           templateArg,
+		Config:             configPath,
+		Output:             outputPath,
+		StateDir:           stateDir,
+		Profiles:           configProfiles,
+		Overrides:          configOverrides,
+		Vars:               configVars,
+		IncludeDirs:        includeDirs,
+		AllowUnknownFields: allowUnknownFields,
+		SkipImage:          skipImage,
+		ReuseRootfs:        reuseRootfs,
+		ScriptsFrom:        scriptsFrom,
+		Checkpoint:         checkpoint,
+		Resume:             resumeBuild,
+		Skip:               skipScripts,
+		Only:               onlyScripts,
+		From:               fromScript,
+		Until:              untilScript,
+		Jobs:               scriptJobs,
+		Workspace:          workspaceDir,
+		Cache:              layerCache,
+		CacheRemote:        cacheRemote,
+		CachePush:          cachePush,
+		UpdateLock:         updateLock,
+		UpdatePackageLock:  updatePackageLock,
+		JUnitReport:        junitReport,
+		DryRun:             dryRun,
+		Step:               stepThrough,
+		Manual:             manual,
+		Verbose:            verbose,
+		ToolVersion:        version,
+	}
+}
+
+// enableProgressEvents направляет в stdout события NDJSON, а весь
+// остальной вывод сборки - в stderr
+func enableProgressEvents() {
+	progress.SetEventWriter(os.Stdout)
+	os.Stdout = os.Stderr
+	progress.SetReporter(progress.EventReporter)
+}
+
+// startProgressDisplay выводит строку состояния сборки в терминал; журнал
+// переключается на перенаправленный stderr
+func startProgressDisplay(cmd *cobra.Command) (*progress.Display, error) {
+	display, err := progress.StartDisplay(os.Stdout)
+	if err != nil {
+		return nil, err
+	}
+	if err := setupLogging(cmd); err != nil {
+		display.Stop()
+		return nil, err
+	}
+	return display, nil
+}
+
+// printBuildOutput выводит в stdout ход сборки из событий output
+func printBuildOutput(e sysweaver.Event) {
+	if e.Type == sysweaver.EventOutput {
+		fmt.Fprint(os.Stdout, e.Message)
+	}
+}
+
+// writeBuildResult выводит запись о сборке (--format json) или пути
+// артефактов успешной сборки (--quiet)
+func writeBuildResult(record *store.Record) {
+	switch {
+	case resultFormat == formatJSON:
+		if err := writeResult(record); err != nil {
+			slog.Warn("error writing build result", "error", err)
+		}
+	case quiet && record.Result == store.ResultSuccess:
+		for _, artifact := range record.Artifacts {
+			fmt.Fprintln(resultOutput, artifact.Path)
+		}
+	}
+}
+
+func init() {
+	// Флаги для команды build
+	buildCmd.Flags().StringVarP(&outputPath, "output", "o", "./output", "Output directory for the built image")
+	buildCmd.Flags().StringVarP(&configPath, "config", "c", "", "Path to the configuration file (defaults to template/config.yaml)")
+	buildCmd.Flags().BoolVarP(&manual, "manual", "m", false, "Enter manual mode after scripts execution")
+	buildCmd.Flags().BoolVar(&skipImage, "skip-image", false, "Stop before the image stage and save the rootfs to <output>/rootfs")
+	buildCmd.Flags().StringVar(&reuseRootfs, "reuse-rootfs", "", "Run the image stage and the following ones on top of a rootfs saved by a previous run")
+	buildCmd.Flags().StringVar(&scriptsFrom, "scripts-from", "", "Start the build from the given stage (prepare, install, configure, image, test, cleanup)")
+	buildCmd.Flags().StringSliceVar(&configProfiles, "profile", nil, "Apply config profiles from the profiles section, in order (repeatable or comma-separated)")
+	buildCmd.Flags().StringArrayVar(&configOverrides, "set", nil, "Override a config value by dotted path, e.g. --set system.hostname=edge01 (repeatable)")
+	buildCmd.Flags().BoolVar(&checkpoint, "checkpoint", false, "Checkpoint the jail after each stage (overlay snapshot + CRIU) and restore it on --scripts-from")
+	buildCmd.Flags().IntVarP(&scriptJobs, "jobs", "j", 1, "Run up to N independent scripts of a stage in parallel")
+	buildCmd.Flags().StringSliceVar(&skipScripts, "skip", nil, "Skip scripts by name, stage/name or glob (repeatable or comma-separated)")
+	buildCmd.Flags().StringSliceVar(&onlyScripts, "only", nil, "Run only the given scripts by name, stage/name or glob (repeatable or comma-separated)")
+	buildCmd.Flags().StringVar(&fromScript, "from", "", "Skip the scripts before the given one")
+	buildCmd.Flags().StringVar(&untilScript, "until", "", "Skip the scripts after the given one")
+	buildCmd.Flags().BoolVar(&stepThrough, "step", false, "Pause before every script to run it, skip it or open a jail shell first")
+	buildCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the build plan (config, mounts, scripts, artifacts) without building")
+	buildCmd.Flags().BoolVar(&matrixBuild, "matrix", false, "Build every combination of the matrix section (arch x profile)")
+	buildCmd.Flags().StringVar(&workspaceDir, "workspace", "", "Directory for the jail chroot, overlay and checkpoints (overrides jail.yaml)")
+	buildCmd.Flags().BoolVar(&layerCache, "cache", false, "Reuse cached script layers for the unchanged beginning of the pipeline")
+	buildCmd.Flags().StringVar(&cacheRemote, "cache-remote", "", "Shared layer cache (s3://bucket/prefix or oci://registry/repository)")
+	buildCmd.Flags().BoolVar(&cachePush, "cache-push", false, "Upload saved script layers to --cache-remote")
+	buildCmd.Flags().BoolVar(&resumeBuild, "resume", false, "Continue the last failed build from the failed script, reusing its saved jail state")
+	buildCmd.Flags().BoolVar(&updateLock, "update", false, "Re-resolve remote base templates and layers and rewrite sysweaver.lock")
+	buildCmd.Flags().BoolVar(&updatePackageLock, "update-lock", false, "Resolve the latest package versions and rewrite this build's entry in packages.lock")
+	buildCmd.Flags().StringVar(&junitReport, "junit", "", "Write the test stage assertion results to a JUnit XML file")
+	buildCmd.Flags().StringVar(&progressFormat, "progress", "auto", "Progress output: auto, tty (live status line), plain, or json for NDJSON build events on stdout")
+
+	// Отключаем вывод справки при ошибках
+	buildCmd.SilenceUsage = true
+	buildCmd.SilenceErrors = true
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"sysweaver/internal/config"
+	"sysweaver/internal/structures"
+	"sysweaver/internal/template"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	// Флаги команды config resolve
+	resolveShowOrigin bool
+)
+
+// configCmd объединяет команды работы с конфигурацией
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect build configuration",
+}
+
+// configResolveCmd выводит итоговую конфигурацию сборки
+var configResolveCmd = &cobra.Command{
+	Use:   "resolve [template]",
+	Short: "Print the effective merged build config",
+	Long: `Print the build config exactly as a build would see it. Sources are merged
+in increasing precedence:
+
+  1. /etc/sysweaver/config.yaml
+  2. ~/.config/sysweaver/config.yaml
+  3. config.yaml of base templates (extends), base first, then of addon
+     layers (layers:) in declaration order
+  4. the template config.yaml (or --config) with its includes
+  5. --profile, then --set
+
+Variables are substituted and the result is validated. With --show-origin,
+every value is annotated with the file and line it came from.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tmpl, err := template.Open(args[0], template.Options{StateDir: stateDir})
+		if err != nil {
+			return err
+		}
+
+		path := configPath
+		if path == "" {
+			path = template.ConfigPath(tmpl.Dir)
+		}
+
+		data, err := config.Resolve(path, &structures.BuildConfig{}, buildConfigOptions(tmpl), resolveShowOrigin)
+		if err != nil {
+			return err
+		}
+		os.Stdout.Write(data)
+		return nil
+	},
+}
+
+func init() {
+	configResolveCmd.Flags().StringVarP(&configPath, "config", "c", "", "Path to the configuration file (defaults to template/config.yaml)")
+	configResolveCmd.Flags().StringSliceVar(&configProfiles, "profile", nil, "Apply config profiles from the profiles section, in order (repeatable or comma-separated)")
+	configResolveCmd.Flags().StringArrayVar(&configOverrides, "set", nil, "Override a config value by dotted path (repeatable)")
+	configResolveCmd.Flags().BoolVar(&resolveShowOrigin, "show-origin", false, "Annotate values with the file and line they come from")
+
+	configCmd.AddCommand(configResolveCmd)
+}
+
+// buildConfigOptions собирает параметры загрузки конфигурации сборки из флагов;
+// конфигурации базовых слоев шаблона ложатся под его config.yaml
+func buildConfigOptions(tmpl *template.Template) config.Options {
+	opts := loadOptions()
+	opts.Layers = append(config.DefaultLayers(), tmpl.ConfigLayers()...)
+	opts.Profiles = configProfiles
+	opts.Overrides = configOverrides
+	return opts
+}
+
+// loadOptions возвращает общие для файлов шаблона параметры загрузки из
+// глобальных флагов --var, --include-dir и --allow-unknown-fields
+func loadOptions() config.Options {
+	return config.Options{
+		Vars:               configVars,
+		IncludeDirs:        includeDirs,
+		AllowUnknownFields: allowUnknownFields,
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sysweaver/internal/config"
+	"sysweaver/internal/retention"
+	"sysweaver/internal/structures"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	// Флаги команды gc
+	gcKeepLast int
+	gcMaxAge   string
+	gcMaxSize  string
+	gcDryRun   bool
+)
+
+// gcCmd представляет команду очистки каталога состояния
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Prune old builds, their artifacts and cached layers",
+	Long: `Remove old builds from the artifact store together with their artifacts
+and script logs, and prune the layer cache, according to a retention policy:
+
+  retention:
+    keep_last: 10   # keep the last N builds of every template
+    max_age: 30d    # remove builds and layers unused for longer (or e.g. 72h)
+    max_size: 200G  # then remove the oldest builds and layers until under the limit
+
+The policy is read from the retention section of /etc/sysweaver/config.yaml
+and the user config file; --keep-last, --max-age and --max-size override it.
+The latest build of every template is always kept, and an artifact is only
+deleted when no remaining build references the same file.
+
+A template's own retention section is applied automatically after each of
+its builds. Use --dry-run to list what would be removed.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := redirectOutput(); err != nil {
+			return err
+		}
+
+		var cfg structures.RetentionConfig
+		if err := config.LoadSection(config.DefaultLayers(), "retention", &cfg); err != nil {
+			return err
+		}
+		if cmd.Flags().Changed("keep-last") {
+			cfg.KeepLast = gcKeepLast
+		}
+		if cmd.Flags().Changed("max-age") {
+			cfg.MaxAge = gcMaxAge
+		}
+		if cmd.Flags().Changed("max-size") {
+			cfg.MaxSize = gcMaxSize
+		}
+
+		policy, err := retention.NewPolicy(cfg)
+		if err != nil {
+			return err
+		}
+		if policy.Empty() {
+			return fmt.Errorf("no retention policy: set the retention section in %s or use --keep-last, --max-age or --max-size", config.DefaultLayers()[0])
+		}
+
+		result, err := retention.Collect(stateDir, policy, gcDryRun)
+		if result != nil {
+			result.Print(os.Stdout, slog.Default(), gcDryRun)
+			if resultFormat == formatJSON {
+				if err := writeResult(result); err != nil {
+					return err
+				}
+			}
+		}
+		return err
+	},
+}
+
+func init() {
+	gcCmd.Flags().IntVar(&gcKeepLast, "keep-last", 0, "Keep the last N builds of every template (0 keeps all)")
+	gcCmd.Flags().StringVar(&gcMaxAge, "max-age", "", "Remove builds and cached layers older than this (e.g. 72h, 30d)")
+	gcCmd.Flags().StringVar(&gcMaxSize, "max-size", "", "Remove the oldest builds and layers until the total is under this size (e.g. 200G)")
+	gcCmd.Flags().BoolVar(&gcDryRun, "dry-run", false, "List what would be removed without removing anything")
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sysweaver/internal/logging"
+	"sysweaver/internal/progress"
+	"sysweaver/internal/retention"
+	"sysweaver/internal/store"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	// Флаги команды history
+	historyTemplate string
+	historyResult   string
+	historySince    string
+	historyLimit    int
+)
+
+// historyCmd представляет команду просмотра истории сборок
+var historyCmd = &cobra.Command{
+	Use:   "history [build-id]",
+	Short: "List past builds from the artifact store",
+	Long: `List builds recorded in the local artifact store, newest first, with their
+result, duration and template. Records are kept in the state directory
+(--state-dir) as builds/<id>/record.json.
+
+Builds can be filtered by template path (--template, a substring), result
+(--result success|failed) and age (--since, e.g. 12h or 7d):
+
+  sysweaver history --template appliance --result failed --since 7d
+
+With a build ID, the details of that build are shown: config hash,
+stages, artifacts and script logs. --format json prints the full records;
+--quiet prints only build IDs.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := redirectOutput(); err != nil {
+			return err
+		}
+
+		st, err := store.Open(stateDir)
+		if err != nil {
+			return err
+		}
+
+		if len(args) == 1 {
+			record, err := st.Get(args[0])
+			if err != nil {
+				return err
+			}
+			return showBuild(st, record)
+		}
+
+		records, err := filterHistory(st)
+		if err != nil {
+			return err
+		}
+		return listHistory(records)
+	},
+}
+
+// filterHistory возвращает записи хранилища, отобранные флагами команды
+func filterHistory(st *store.Store) ([]*store.Record, error) {
+	switch historyResult {
+	case "", store.ResultSuccess, store.ResultFailed:
+	default:
+		return nil, fmt.Errorf("invalid result %q (expected %s or %s)", historyResult, store.ResultSuccess, store.ResultFailed)
+	}
+
+	var since time.Time
+	if historySince != "" {
+		age, err := retention.ParseAge(historySince)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --since value: %w", err)
+		}
+		since = time.Now().Add(-age)
+	}
+
+	records, err := st.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var selected []*store.Record
+	for _, record := range records {
+		if historyTemplate != "" && !strings.Contains(record.Template, historyTemplate) {
+			continue
+		}
+		if historyResult != "" && record.Result != historyResult {
+			continue
+		}
+		if record.StartedAt.Before(since) {
+			continue
+		}
+		selected = append(selected, record)
+		if historyLimit > 0 && len(selected) == historyLimit {
+			break
+		}
+	}
+	return selected, nil
+}
+
+// listHistory выводит список сборок
+func listHistory(records []*store.Record) error {
+	if resultFormat == formatJSON {
+		if records == nil {
+			records = []*store.Record{}
+		}
+		return writeResult(records)
+	}
+	if quiet {
+		for _, record := range records {
+			fmt.Fprintln(resultOutput, record.ID)
+		}
+		return nil
+	}
+
+	if len(records) == 0 {
+		fmt.Println("No builds found in the artifact store")
+		return nil
+	}
+
+	for _, record := range records {
+		duration := record.FinishedAt.Sub(record.StartedAt).Round(time.Second)
+		fmt.Printf("%s  %s  %s %8s  %s %s  %s\n", record.ID, record.StartedAt.Format("2006-01-02 15:04"),
+			logging.Result(record.Result, fmt.Sprintf("%-8s", record.Result)), duration,
+			record.Name, record.Version, record.Template)
+	}
+	fmt.Printf("\n%d build(s)\n", len(records))
+	return nil
+}
+
+// showBuild выводит подробности сборки
+func showBuild(st *store.Store, record *store.Record) error {
+	if resultFormat == formatJSON {
+		return writeResult(record)
+	}
+	if quiet {
+		fmt.Fprintln(resultOutput, record.ID)
+		return nil
+	}
+
+	fmt.Printf("Build:     %s\n", record.ID)
+	fmt.Printf("Image:     %s %s\n", record.Name, record.Version)
+	fmt.Printf("Template:  %s\n", record.Template)
+	if record.Source != nil {
+		fmt.Printf("Source:    %s@%s\n", record.Source.URL, record.Source.Commit)
+	}
+	if record.ConfigHash != "" {
+		fmt.Printf("Config:    sha256:%s\n", record.ConfigHash)
+	}
+	fmt.Printf("Started:   %s\n", record.StartedAt.Format(time.RFC3339))
+	fmt.Printf("Duration:  %s\n", record.FinishedAt.Sub(record.StartedAt).Round(time.Second))
+	fmt.Printf("Result:    %s\n", logging.Result(record.Result, record.Result))
+	if record.Error != "" {
+		fmt.Printf("Error:     %s\n", record.Error)
+	}
+	fmt.Printf("Output:    %s\n", record.OutputDir)
+	fmt.Printf("Record:    %s\n", filepath.Join(st.BuildDir(record.ID), "record.json"))
+
+	if len(record.Stages) > 0 {
+		fmt.Println("\nStages:")
+		for _, stage := range record.Stages {
+			fmt.Printf("  %-12s %-8s %s\n", stage.Name, stage.Result, time.Duration(stage.Duration*float64(time.Second)).Round(100*time.Millisecond))
+		}
+	}
+
+	if len(record.Artifacts) > 0 {
+		fmt.Println("\nArtifacts:")
+		for _, artifact := range record.Artifacts {
+			fmt.Printf("  %s (%s)\n", artifact.Path, progress.FormatBytes(artifact.Size))
+		}
+	}
+
+	var logs []store.ScriptRun
+	for _, run := range record.Scripts {
+		if run.Log != "" {
+			logs = append(logs, run)
+		}
+	}
+	if len(logs) > 0 {
+		fmt.Println("\nScript logs:")
+		for _, run := range logs {
+			fmt.Printf("  %s/%s (exit %d): %s\n", run.Stage, run.Name, run.ExitCode, run.Log)
+		}
+	}
+	return nil
+}
+
+func init() {
+	historyCmd.Flags().StringVar(&historyTemplate, "template", "", "Only builds of templates whose path contains this string")
+	historyCmd.Flags().StringVar(&historyResult, "result", "", "Only builds with this result (success or failed)")
+	historyCmd.Flags().StringVar(&historySince, "since", "", "Only builds started within this period (e.g. 12h, 7d)")
+	historyCmd.Flags().IntVarP(&historyLimit, "limit", "n", 20, "Maximum number of builds to list (0 for all)")
+}
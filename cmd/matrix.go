@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sysweaver/internal/config"
+	"sysweaver/internal/logging"
+	"sysweaver/internal/manifest"
+	"sysweaver/internal/store"
+	"sysweaver/internal/structures"
+	"sysweaver/internal/template"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// matrixSummaryFile - сводка матричной сборки в директории вывода
+const matrixSummaryFile = "matrix-summary.json"
+
+// matrixCombination - одна комбинация секции matrix
+type matrixCombination struct {
+	Name    string `json:"name"` // arch-profile, имя рабочего каталога и подкаталога вывода
+	Arch    string `json:"arch,omitempty"`
+	Profile string `json:"profile,omitempty"`
+}
+
+// matrixResult - итог сборки комбинации
+type matrixResult struct {
+	matrixCombination
+	Result    string   `json:"result"`
+	BuildID   string   `json:"build_id,omitempty"`
+	Duration  float64  `json:"duration_seconds"`
+	OutputDir string   `json:"output_dir"`
+	Artifacts []string `json:"artifacts,omitempty"`
+	Error     string   `json:"error,omitempty"`
+	Workspace string   `json:"workspace,omitempty"` // Сохраненный рабочий каталог неудавшейся сборки
+}
+
+// runMatrix собирает каждую комбинацию секции matrix отдельным процессом
+// sysweaver build с собственными рабочим каталогом jail и подкаталогом вывода
+func runMatrix(cmd *cobra.Command, templateArg string) error {
+	if resumeBuild || stepThrough || workspaceDir != "" {
+		return fmt.Errorf("--matrix cannot be used with --resume, --step or --workspace")
+	}
+	// Вывод матрицы идет туда же, куда вывод сборки: в stdout после
+	// перенаправления (redirectOutput), а не в исходный поток результата
+	out := io.Writer(os.Stdout)
+
+	tmpl, err := template.Open(templateArg, template.Options{StateDir: stateDir, Update: updateLock})
+	if err != nil {
+		return err
+	}
+	if written, err := tmpl.WriteLock(); err != nil {
+		return err
+	} else if written {
+		fmt.Fprintf(out, "Updated %s\n", filepath.Join(tmpl.Dir, template.LockFile))
+	}
+
+	path := configPath
+	if path == "" {
+		path = template.ConfigPath(tmpl.Dir)
+	}
+	var buildConfig structures.BuildConfig
+	if err := config.Load(path, &buildConfig, buildConfigOptions(tmpl)); err != nil {
+		return fmt.Errorf("error loading build config: %w", err)
+	}
+
+	combinations, err := matrixCombinations(buildConfig.Matrix)
+	if err != nil {
+		return err
+	}
+	if len(combinations) == 0 {
+		return fmt.Errorf("%s has no matrix section (arch and/or profile lists)", path)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("error locating sysweaver executable: %w", err)
+	}
+
+	// Рабочие каталоги jail комбинаций; сборки не делят chroot и overlay
+	workspace, err := os.MkdirTemp("", "sysweaver-matrix-")
+	if err != nil {
+		return fmt.Errorf("error creating matrix workspace: %w", err)
+	}
+
+	parallel := max(buildConfig.Matrix.Parallel, 1)
+	fmt.Fprintf(out, "Matrix build: %d combinations, up to %d at a time\n", len(combinations), parallel)
+
+	results := make([]matrixResult, len(combinations))
+	slots := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	var outputMutex sync.Mutex
+	baseArgs := matrixArgs(cmd)
+
+	for i, combination := range combinations {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			args := append([]string{"build", templateArg}, baseArgs...)
+			outputDir := filepath.Join(outputPath, combination.Name)
+			args = append(args, "--output", outputDir, "--workspace", filepath.Join(workspace, combination.Name))
+			if combination.Arch != "" {
+				args = append(args, "--set", "arch="+combination.Arch)
+			}
+			if combination.Profile != "" {
+				args = append(args, "--profile", combination.Profile)
+			}
+
+			results[i] = runMatrixBuild(executable, args, combination, outputDir, out, &outputMutex)
+		}()
+	}
+	wg.Wait()
+
+	// Рабочие каталоги неудавшихся сборок сохраняются для разбора: в них
+	// остаются overlay jail и состояние стадий
+	kept := false
+	for i := range results {
+		dir := filepath.Join(workspace, results[i].Name)
+		if results[i].Result == store.ResultSuccess {
+			os.RemoveAll(dir)
+			continue
+		}
+		results[i].Workspace = dir
+		kept = true
+	}
+	if !kept {
+		os.RemoveAll(workspace)
+	}
+
+	return reportMatrix(out, results)
+}
+
+// matrixCombinations возвращает комбинации arch × profile без исключенных
+func matrixCombinations(matrix structures.MatrixConfig) ([]matrixCombination, error) {
+	if len(matrix.Arch) == 0 && len(matrix.Profile) == 0 {
+		return nil, nil
+	}
+
+	for _, exclude := range matrix.Exclude {
+		for key := range exclude {
+			if key != "arch" && key != "profile" {
+				return nil, fmt.Errorf("matrix exclude: unknown key %q (available: arch, profile)", key)
+			}
+		}
+	}
+
+	arches, profiles := matrix.Arch, matrix.Profile
+	if len(arches) == 0 {
+		arches = []string{""}
+	}
+	if len(profiles) == 0 {
+		profiles = []string{""}
+	}
+
+	var combinations []matrixCombination
+	for _, arch := range arches {
+		for _, profile := range profiles {
+			values := map[string]string{"arch": arch, "profile": profile}
+			excluded := false
+			for _, exclude := range matrix.Exclude {
+				matched := true
+				for key, value := range exclude {
+					matched = matched && values[key] == value
+				}
+				excluded = excluded || matched
+			}
+			if excluded {
+				continue
+			}
+
+			var parts []string
+			for _, part := range []string{arch, profile} {
+				if part != "" {
+					parts = append(parts, part)
+				}
+			}
+			combinations = append(combinations, matrixCombination{Name: strings.Join(parts, "-"), Arch: arch, Profile: profile})
+		}
+	}
+	return combinations, nil
+}
+
+// matrixArgs возвращает флаги текущего запуска для сборок комбинаций без
+// флагов, которые задает сама матрица
+func matrixArgs(cmd *cobra.Command) []string {
+	var args []string
+	cmd.Flags().Visit(func(flag *pflag.Flag) {
+		switch flag.Name {
+		case "matrix", "output", "workspace", "update":
+			return
+		}
+		if values, ok := flag.Value.(pflag.SliceValue); ok {
+			for _, value := range values.GetSlice() {
+				args = append(args, "--"+flag.Name+"="+value)
+			}
+			return
+		}
+		args = append(args, "--"+flag.Name+"="+flag.Value.String())
+	})
+	return args
+}
+
+// runMatrixBuild запускает сборку комбинации; ее вывод печатается построчно
+// с префиксом имени комбинации в out
+func runMatrixBuild(executable string, args []string, combination matrixCombination, outputDir string, out io.Writer, outputMutex *sync.Mutex) matrixResult {
+	result := matrixResult{matrixCombination: combination, OutputDir: outputDir}
+
+	prefixed := &prefixWriter{prefix: "[" + combination.Name + "] ", out: out, mutex: outputMutex}
+	buildCmd := exec.Command(executable, args...)
+	buildCmd.Stdout = prefixed
+	buildCmd.Stderr = prefixed
+
+	startedAt := time.Now()
+	err := buildCmd.Run()
+	prefixed.Flush()
+	result.Duration = time.Since(startedAt).Seconds()
+
+	result.Result = store.ResultSuccess
+	if err != nil {
+		result.Result = store.ResultFailed
+		result.Error = err.Error()
+	}
+
+	// Подробности - из манифеста, который сборка записывает в каталог вывода
+	if m, err := manifest.Read(filepath.Join(outputDir, manifest.FileName)); err == nil {
+		result.BuildID = m.BuildID
+		if m.Error != "" {
+			result.Error = m.Error
+		}
+		for _, artifact := range m.Artifacts {
+			result.Artifacts = append(result.Artifacts, artifact.Path)
+		}
+	}
+	return result
+}
+
+// reportMatrix выводит сводку матричной сборки в out и записывает ее в matrix-summary.json
+func reportMatrix(out io.Writer, results []matrixResult) error {
+	failed := 0
+	fmt.Fprintln(out, "\nMatrix:")
+	for _, result := range results {
+		details := fmt.Sprintf("%d artifacts in %s", len(result.Artifacts), result.OutputDir)
+		if result.Result != store.ResultSuccess {
+			failed++
+			details = result.Error
+		}
+		duration := time.Duration(result.Duration * float64(time.Second)).Round(time.Second)
+		fmt.Fprintf(out, "  %-28s %s %-8s %s\n", result.Name, logging.Result(result.Result, fmt.Sprintf("%-8s", result.Result)), duration, details)
+		if result.Workspace != "" {
+			fmt.Fprintf(out, "  %-28s workspace kept in %s\n", "", result.Workspace)
+		}
+	}
+
+	if !dryRun {
+		if err := writeMatrixSummary(out, results); err != nil {
+			return err
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d matrix builds failed", failed, len(results))
+	}
+	return nil
+}
+
+// writeMatrixSummary записывает сводку матричной сборки в директорию вывода
+func writeMatrixSummary(out io.Writer, results []matrixResult) error {
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding matrix summary: %w", err)
+	}
+	summary := filepath.Join(outputPath, matrixSummaryFile)
+	if err := os.MkdirAll(outputPath, 0755); err != nil {
+		return fmt.Errorf("error creating output directory: %w", err)
+	}
+	if err := os.WriteFile(summary, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing matrix summary: %w", err)
+	}
+	fmt.Fprintf(out, "Matrix summary written to %s\n", summary)
+	return nil
+}
+
+// prefixWriter печатает вывод построчно с префиксом; строки нескольких
+// одновременных сборок не перемешиваются
+type prefixWriter struct {
+	prefix  string
+	out     io.Writer
+	mutex   *sync.Mutex
+	pending []byte
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.pending = append(w.pending, p...)
+	for {
+		i := bytes.IndexByte(w.pending, '\n')
+		if i < 0 {
+			break
+		}
+		w.writeLine(w.pending[:i+1])
+		w.pending = w.pending[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush печатает незавершенную последнюю строку
+func (w *prefixWriter) Flush() {
+	if len(w.pending) > 0 {
+		w.writeLine(append(w.pending, '\n'))
+		w.pending = nil
+	}
+}
+
+func (w *prefixWriter) writeLine(line []byte) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	fmt.Fprintf(w.out, "%s%s", w.prefix, line)
+}
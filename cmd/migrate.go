@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sysweaver/internal/config"
+	"sysweaver/internal/structures"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	// Флаги команды migrate-config
+	migrateDryRun bool
+)
+
+// migrateConfigCmd представляет команду перевода конфигурации на текущую схему
+var migrateConfigCmd = &cobra.Command{
+	Use:   "migrate-config [template|config.yaml]...",
+	Short: "Upgrade template configs to the current schema version",
+	Long: `Upgrade config.yaml files written for older SysWeaver versions to the
+current schema (schemaVersion) and print what changed. A template directory
+argument migrates its config.yaml. Included fragments are not rewritten;
+pass them explicitly to migrate them too.
+
+Unknown keys that look like typos of known ones are renamed, other unknown
+keys are removed. Review the printed changes: builds never guess like this
+and reject unknown keys instead.
+
+With --dry-run the changes are printed and the upgraded config is written
+to stdout instead of the file.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		for _, arg := range args {
+			if err := migrateConfigFile(arg); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	migrateConfigCmd.Flags().BoolVar(&migrateDryRun, "dry-run", false, "Print the migrated config instead of writing it")
+}
+
+// migrateConfigFile переводит один файл конфигурации на текущую схему
+func migrateConfigFile(path string) error {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, "config.yaml")
+	}
+
+	result, err := config.MigrateFile(path, &structures.BuildConfig{})
+	if err != nil {
+		return err
+	}
+
+	if len(result.Changes) == 0 {
+		fmt.Printf("%s: already at schema version %d\n", path, result.To)
+		return nil
+	}
+
+	fmt.Printf("%s: schema version %d -> %d\n", path, result.From, result.To)
+	for _, change := range result.Changes {
+		fmt.Printf("  - %s\n", change)
+	}
+
+	if migrateDryRun {
+		fmt.Println("---")
+		os.Stdout.Write(result.Data)
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, result.Data, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("error writing %s: %w", path, err)
+	}
+	return nil
+}
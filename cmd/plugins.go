@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"sysweaver/internal/plugin"
+
+	"github.com/spf13/cobra"
+)
+
+// pluginsCmd представляет команду вывода найденных внешних плагинов
+var pluginsCmd = &cobra.Command{
+	Use:   "plugins [template]",
+	Short: "List external output, publish and hook plugins",
+	Long: `List the external plugins that builds can use: executables named
+sysweaver-output-<name>, sysweaver-publish-<name> and sysweaver-hook-<name>
+found in the template's plugins/ directory (if a template is given), the
+directories in $SYSWEAVER_PLUGIN_PATH, <state-dir>/plugins,
+/usr/local/lib/sysweaver/plugins and /usr/lib/sysweaver/plugins. A plugin in an earlier directory hides one
+with the same name in a later directory.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var templateDir string
+		if len(args) == 1 {
+			templateDir = args[0]
+		}
+
+		plugins := plugin.List(plugin.Dirs(templateDir, stateDir))
+		if resultFormat == formatJSON {
+			return writeResult(plugins)
+		}
+		if len(plugins) == 0 {
+			fmt.Println("No plugins found")
+			return nil
+		}
+		for _, p := range plugins {
+			fmt.Printf("%-8s %-20s %s\n", p.Kind, p.Name, p.Path)
+		}
+		return nil
+	},
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sysweaver/internal/store"
+)
+
+// Результат команды (--format json) или пути артефактов (--quiet) пишутся в
+// исходный stdout; обычный вывод при этом уходит в stderr или отбрасывается,
+// чтобы stdout можно было разбирать в скриптах.
+
+// Форматы результата команд
+const (
+	formatText = "text"
+	formatJSON = "json"
+)
+
+var (
+	// Поток для результата команды (исходный stdout)
+	resultOutput = os.Stdout
+
+	// Результат уже выведен
+	resultWritten bool
+)
+
+// redirectOutput отделяет результат команды от обычного вывода: с --quiet
+// обычный вывод отбрасывается, с --format json уходит в stderr
+func redirectOutput() error {
+	switch resultFormat {
+	case formatText, formatJSON:
+	default:
+		return fmt.Errorf("invalid format %q (expected %s or %s)", resultFormat, formatText, formatJSON)
+	}
+
+	resultOutput = os.Stdout
+	switch {
+	case quiet:
+		devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		os.Stdout = devNull
+	case resultFormat == formatJSON:
+		os.Stdout = os.Stderr
+	}
+	return nil
+}
+
+// writeResult выводит результат команды в формате JSON
+func writeResult(v interface{}) error {
+	resultWritten = true
+	encoder := json.NewEncoder(resultOutput)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// writeFailure выводит результат с ошибкой, если команда завершилась до
+// получения своего результата
+func writeFailure(err error) {
+	if err == nil || resultWritten || resultFormat != formatJSON {
+		return
+	}
+	writeResult(struct {
+		Result string `json:"result"`
+		Error  string `json:"error"`
+	}{store.ResultFailed, err.Error()})
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sysweaver/internal/apk"
+	"sysweaver/internal/imagemount"
+	"sysweaver/internal/store"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	// Флаги команды search
+	searchContent  bool
+	searchMaxSize  int64
+	searchPackages []string
+	searchTemplate string
+)
+
+// searchMatch - найденный в образе файл
+type searchMatch struct {
+	Path    string // Путь внутри образа
+	Line    int    // Номер строки совпадения в содержимом (0 - совпало имя)
+	Text    string // Строка с совпадением
+	Package string // Пакет-владелец файла
+}
+
+// searchCmd представляет команду поиска файлов внутри собранного образа
+var searchCmd = &cobra.Command{
+	Use:   "search [image] [pattern]",
+	Short: "Search for files inside a built image or builds in the artifact store",
+	Long: `Search file names (and optionally file contents) inside a built artifact.
+
+The image (ISO, raw, qcow2/vmdk/vhdx/vdi or a rootfs directory) is mounted
+read-only. The pattern is a shell glob matched against file names
+(e.g. 'libfoo.so*'); with --content it is also used as a regular expression
+over file contents. Each match is reported with the apk package owning it.
+
+With --package, no image is needed: the local artifact store is queried for
+builds containing a package matching the constraint, e.g.
+
+  sysweaver search --package 'openssl<3.0.14'
+
+Supported operators: <, <=, >, >=, =; a bare name matches any version.
+Several --package flags select builds matching any of the constraints.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(searchPackages) > 0 {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.ExactArgs(2)(cmd, args)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(searchPackages) > 0 {
+			return searchStore(searchPackages, searchTemplate)
+		}
+
+		imagePath, pattern := args[0], args[1]
+
+		var logWriter io.Writer = io.Discard
+		if verbose {
+			logWriter = os.Stdout
+		}
+
+		mounted, err := imagemount.MountReadOnly(imagePath, logWriter)
+		if err != nil {
+			return fmt.Errorf("error mounting image: %w", err)
+		}
+		defer mounted.Close()
+
+		var contentRe *regexp.Regexp
+		if searchContent {
+			contentRe, err = regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("invalid content pattern: %w", err)
+			}
+		}
+
+		packages, err := apk.ReadInstalled(mounted.Root)
+		if err != nil {
+			return err
+		}
+		owners := apk.FileOwners(packages)
+
+		var matches []searchMatch
+		for _, part := range mounted.Partitions {
+			found, err := searchTree(part.Dir, pattern, contentRe)
+			if err != nil {
+				return err
+			}
+
+			// Определяем пакет-владельца; пути в некорневых разделах помечаем именем раздела
+			for i := range found {
+				rel := strings.TrimPrefix(found[i].Path, "/")
+				if owner, ok := owners[rel]; ok && part.Dir == mounted.Root {
+					found[i].Package = owner.Name + "-" + owner.Version
+				}
+				if part.Dir != mounted.Root {
+					found[i].Path = filepath.Base(part.Dir) + ":" + found[i].Path
+				}
+			}
+			matches = append(matches, found...)
+		}
+
+		if len(matches) == 0 {
+			fmt.Printf("No matches for %q in %s\n", pattern, imagePath)
+			return nil
+		}
+
+		for _, m := range matches {
+			owner := m.Package
+			if owner == "" {
+				owner = "(not owned by any package)"
+			}
+			if m.Line > 0 {
+				fmt.Printf("%s:%d: %s [%s]\n", m.Path, m.Line, m.Text, owner)
+			} else {
+				fmt.Printf("%s [%s]\n", m.Path, owner)
+			}
+		}
+
+		fmt.Printf("\n%d match(es) found\n", len(matches))
+		return nil
+	},
+}
+
+// searchStore ищет в хранилище артефактов сборки с пакетами, удовлетворяющими ограничениям
+func searchStore(queries []string, template string) error {
+	var constraints []apk.Constraint
+	for _, q := range queries {
+		c, err := apk.ParseConstraint(q)
+		if err != nil {
+			return err
+		}
+		constraints = append(constraints, c)
+	}
+
+	st, err := store.Open(stateDir)
+	if err != nil {
+		return err
+	}
+
+	records, err := st.List()
+	if err != nil {
+		return err
+	}
+
+	found := 0
+	for _, record := range records {
+		if template != "" && !strings.Contains(record.Template, template) {
+			continue
+		}
+
+		var hits []string
+		for _, pkg := range record.Packages {
+			for _, c := range constraints {
+				if c.Matches(pkg.Name, pkg.Version) {
+					hits = append(hits, pkg.Name+"-"+pkg.Version)
+					break
+				}
+			}
+		}
+		if len(hits) == 0 {
+			continue
+		}
+
+		found++
+		fmt.Printf("%s  %s %s  (%s, %s)\n", record.ID, record.Name, record.Version,
+			record.Result, record.StartedAt.Format("2006-01-02 15:04"))
+		fmt.Printf("  template: %s\n", record.Template)
+		fmt.Printf("  packages: %s\n", strings.Join(hits, ", "))
+		for _, artifact := range record.Artifacts {
+			fmt.Printf("  artifact: %s\n", artifact.Path)
+		}
+	}
+
+	if found == 0 {
+		fmt.Println("No matching builds found in the artifact store")
+		return nil
+	}
+
+	fmt.Printf("\n%d build(s) found\n", found)
+	return nil
+}
+
+// searchTree обходит дерево и ищет совпадения по имени и, если задано, по содержимому
+func searchTree(root, pattern string, contentRe *regexp.Regexp) ([]searchMatch, error) {
+	var matches []searchMatch
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Недоступные каталоги пропускаем
+			return nil
+		}
+
+		rel, _ := filepath.Rel(root, path)
+		imagePath := "/" + filepath.ToSlash(rel)
+		if rel == "." {
+			return nil
+		}
+
+		if ok, _ := filepath.Match(pattern, d.Name()); ok {
+			matches = append(matches, searchMatch{Path: imagePath})
+		}
+
+		if contentRe == nil || !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil || info.Size() > searchMaxSize {
+			return nil
+		}
+
+		found, err := searchFileContent(path, contentRe)
+		if err != nil {
+			return nil
+		}
+		for _, m := range found {
+			m.Path = imagePath
+			matches = append(matches, m)
+		}
+
+		return nil
+	})
+
+	return matches, err
+}
+
+// searchFileContent ищет совпадения регулярного выражения в строках файла
+func searchFileContent(path string, re *regexp.Regexp) ([]searchMatch, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var matches []searchMatch
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Bytes()
+		if !re.Match(text) {
+			continue
+		}
+
+		// Для бинарных файлов не печатаем содержимое строки
+		preview := "(binary file matches)"
+		if isPrintable(text) {
+			preview = strings.TrimSpace(string(text))
+			if len(preview) > 200 {
+				preview = preview[:200] + "..."
+			}
+		}
+
+		matches = append(matches, searchMatch{Line: line, Text: preview})
+		if !isPrintable(text) {
+			break
+		}
+	}
+
+	return matches, nil
+}
+
+// isPrintable проверяет, похожи ли данные на текст
+func isPrintable(data []byte) bool {
+	for _, b := range data {
+		if b == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func init() {
+	searchCmd.Flags().BoolVar(&searchContent, "content", false, "Also search file contents (pattern is a regular expression)")
+	searchCmd.Flags().Int64Var(&searchMaxSize, "max-size", 64*1024*1024, "Skip files larger than this many bytes when searching contents")
+	searchCmd.Flags().StringArrayVar(&searchPackages, "package", nil, "Query the artifact store for builds containing a package (e.g. 'openssl<3.0.14')")
+	searchCmd.Flags().StringVar(&searchTemplate, "template", "", "Limit the artifact store query to templates whose path contains this string")
+}
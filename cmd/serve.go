@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sysweaver/internal/progress"
+	"sysweaver/internal/tftp"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	// Флаги команды serve
+	serveListen   string
+	serveTLSCert  string
+	serveTLSKey   string
+	serveTFTP     string
+	serveTFTPRoot string
+)
+
+// serveCmd представляет команду раздачи артефактов по HTTP и TFTP
+var serveCmd = &cobra.Command{
+	Use:   "serve [directory]",
+	Short: "Serve build artifacts over HTTP(S) and optionally TFTP",
+	Long: `Serve an output directory over HTTP(S) so lab machines can fetch freshly
+built images. Directories get an index with sizes and SHA256 digests
+(from SHA256SUMS); files support range requests for resumable downloads.
+
+With --tftp, the directory (or --tftp-root) is also served read-only over
+TFTP for PXE boot:
+
+  sysweaver serve ./output --tftp :69 --tftp-root ./output/pxe`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		root, err := filepath.Abs(args[0])
+		if err != nil {
+			return fmt.Errorf("error resolving directory: %w", err)
+		}
+		if info, err := os.Stat(root); err != nil || !info.IsDir() {
+			return fmt.Errorf("not a directory: %s", args[0])
+		}
+
+		if (serveTLSCert == "") != (serveTLSKey == "") {
+			return fmt.Errorf("--tls-cert and --tls-key must be used together")
+		}
+
+		errs := make(chan error, 2)
+
+		if serveTFTP != "" {
+			tftpRoot := root
+			if serveTFTPRoot != "" {
+				if tftpRoot, err = filepath.Abs(serveTFTPRoot); err != nil {
+					return fmt.Errorf("error resolving TFTP root: %w", err)
+				}
+			}
+
+			server := &tftp.Server{Root: tftpRoot, Logger: slog.Default()}
+			slog.Info("serving over TFTP", "dir", tftpRoot, "address", serveTFTP)
+			go func() { errs <- server.ListenAndServe(serveTFTP) }()
+		}
+
+		// Файлы открываются через os.Root: экспортированный rootfs содержит
+		// абсолютные симлинки, которые не должны выводить за пределы директории
+		dir, err := os.OpenRoot(root)
+		if err != nil {
+			return fmt.Errorf("error opening directory: %w", err)
+		}
+		defer dir.Close()
+
+		server := &http.Server{
+			Addr:              serveListen,
+			Handler:           artifactHandler{root: dir},
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+
+		go func() {
+			if serveTLSCert != "" {
+				slog.Info("serving on https://"+serveListen, "dir", root)
+				errs <- server.ListenAndServeTLS(serveTLSCert, serveTLSKey)
+			} else {
+				slog.Info("serving on http://"+serveListen, "dir", root)
+				errs <- server.ListenAndServe()
+			}
+		}()
+
+		return <-errs
+	},
+}
+
+func init() {
+	serveCmd.Flags().StringVarP(&serveListen, "listen", "l", ":8080", "HTTP listen address")
+	serveCmd.Flags().StringVar(&serveTLSCert, "tls-cert", "", "TLS certificate file (enables HTTPS)")
+	serveCmd.Flags().StringVar(&serveTLSKey, "tls-key", "", "TLS private key file")
+	serveCmd.Flags().StringVar(&serveTFTP, "tftp", "", "Also serve files over TFTP on this address (e.g. :69)")
+	serveCmd.Flags().StringVar(&serveTFTPRoot, "tftp-root", "", "Directory served over TFTP (default: the served directory)")
+}
+
+// artifactHandler раздает файлы директории; для поддиректорий выводит индекс
+type artifactHandler struct {
+	root *os.Root
+}
+
+func (h artifactHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	urlPath := path.Clean("/" + r.URL.Path)
+	slog.Info(r.Method+" "+urlPath, "remote", r.RemoteAddr)
+
+	// os.Root отклоняет пути и симлинки, ведущие за пределы корня
+	rel := filepath.FromSlash(strings.TrimPrefix(urlPath, "/"))
+	if rel == "" {
+		rel = "."
+	}
+	file, err := h.root.Open(rel)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if info.IsDir() {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			// Цель строится из очищенного пути: r.URL.Path вида //host
+			// превратился бы в перенаправление на другой сайт
+			target := urlPath
+			if target != "/" {
+				target += "/"
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		if err := renderIndex(w, urlPath, file, h.root, rel); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// ServeContent поддерживает Range и условные запросы
+	w.Header().Set("Accept-Ranges", "bytes")
+	http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+}
+
+// indexEntry - строка индекса директории
+type indexEntry struct {
+	Name     string
+	Dir      bool
+	Size     string
+	Modified string
+	SHA256   string
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>SysWeaver artifacts: {{.Path}}</title>
+<style>body{font-family:sans-serif;margin:2em}table{border-collapse:collapse}td,th{padding:.3em 1em;text-align:left}code{font-size:.85em}</style>
+</head>
+<body>
+<h1>{{.Path}}</h1>
+<table>
+<tr><th>Name</th><th>Size</th><th>Modified</th><th>SHA256</th></tr>
+{{if ne .Path "/"}}<tr><td><a href="../">../</a></td><td></td><td></td><td></td></tr>{{end}}
+{{range .Entries}}<tr><td><a href="{{.Name}}{{if .Dir}}/{{end}}">{{.Name}}{{if .Dir}}/{{end}}</a></td><td>{{.Size}}</td><td>{{.Modified}}</td><td><code>{{.SHA256}}</code></td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// renderIndex выводит список файлов директории с дайджестами из SHA256SUMS
+func renderIndex(w http.ResponseWriter, urlPath string, dir *os.File, root *os.Root, rel string) error {
+	entries, err := dir.ReadDir(-1)
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	sums := directorySums(root, rel)
+
+	var rows []indexEntry
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		row := indexEntry{
+			Name:     entry.Name(),
+			Dir:      entry.IsDir(),
+			Modified: info.ModTime().Format("2006-01-02 15:04"),
+			SHA256:   sums[entry.Name()],
+		}
+		if !entry.IsDir() {
+			row.Size = progress.FormatBytes(info.Size())
+		}
+		rows = append(rows, row)
+	}
+
+	// Директории первыми, затем файлы по имени
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].Dir && !rows[j].Dir })
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return indexTemplate.Execute(w, map[string]interface{}{"Path": urlPath, "Entries": rows})
+}
+
+// directorySums возвращает дайджесты файлов директории rel из SHA256SUMS в
+// ней и в родительских директориях: сборка указывает артефакты подкаталогов
+// путем относительно директории вывода
+func directorySums(root *os.Root, rel string) map[string]string {
+	sums := make(map[string]string)
+	dir, prefix := filepath.ToSlash(rel), ""
+	for {
+		for name, sum := range readSums(root, filepath.Join(filepath.FromSlash(dir), "SHA256SUMS")) {
+			name, ok := strings.CutPrefix(name, prefix)
+			if _, seen := sums[name]; ok && !seen && !strings.Contains(name, "/") {
+				sums[name] = sum
+			}
+		}
+		if dir == "." {
+			return sums
+		}
+		prefix = path.Base(dir) + "/" + prefix
+		dir = path.Dir(dir)
+	}
+}
+
+// readSums читает файл контрольных сумм в формате coreutils
+func readSums(root *os.Root, path string) map[string]string {
+	sums := make(map[string]string)
+
+	file, err := root.Open(path)
+	if err != nil {
+		return sums
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if sum, name, ok := strings.Cut(scanner.Text(), "  "); ok {
+			sums[strings.TrimPrefix(name, "*")] = sum
+		}
+	}
+	return sums
+}
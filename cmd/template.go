@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sysweaver/internal/registry"
+	"sysweaver/internal/template"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	// Флаги команды template
+	templateRegistries []string
+)
+
+// templateCmd объединяет команды работы с реестрами шаблонов
+var templateCmd = &cobra.Command{
+	Use:   "template",
+	Short: "Discover and add templates from registries",
+	Long: `Work with template registries. A registry is a YAML index served over
+HTTP(S) or stored as a local file:
+
+  templates:
+    - name: alpine-server
+      description: Minimal Alpine server with SSH
+      maintainer: Infra team <infra@example.com>
+      source: https://git.example.com/org/templates.git#v1.4:alpine/server
+      outputs: [iso, qcow2]
+      tags: [alpine, server]
+
+The source is a template directory (relative to a local index) or a git URL
+as accepted by build. Registries are given with --registry or as a
+comma-separated list in SYSWEAVER_REGISTRY; HTTP indexes are cached in the
+state directory until 'sysweaver template update'.`,
+}
+
+// templateSearchCmd ищет шаблоны в реестрах
+var templateSearchCmd = &cobra.Command{
+	Use:   "search [query]",
+	Short: "Search registries by name, description or tag",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		entries, err := registry.Load(stateDir, registry.URLs(templateRegistries))
+		if err != nil {
+			return err
+		}
+		if len(args) > 0 {
+			entries = registry.Search(entries, args[0])
+		}
+
+		if len(entries) == 0 {
+			fmt.Println("No matching templates found")
+			return nil
+		}
+
+		for _, entry := range entries {
+			fmt.Printf("%s  %s\n", entry.Name, entry.Description)
+			if entry.Maintainer != "" {
+				fmt.Printf("  maintainer: %s\n", entry.Maintainer)
+			}
+			if len(entry.Outputs) > 0 {
+				fmt.Printf("  outputs: %s\n", strings.Join(entry.Outputs, ", "))
+			}
+			if len(entry.Tags) > 0 {
+				fmt.Printf("  tags: %s\n", strings.Join(entry.Tags, ", "))
+			}
+			fmt.Printf("  source: %s\n", entry.Source)
+		}
+		return nil
+	},
+}
+
+// templateAddCmd копирует шаблон из реестра в локальный каталог
+var templateAddCmd = &cobra.Command{
+	Use:   "add [name] [directory]",
+	Short: "Copy a registry template into a local directory",
+	Long: `Copy a template from a registry into a local directory (./<name> by
+default) so it can be customized and built. Git sources are fetched through
+the template cache.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		entries, err := registry.Load(stateDir, registry.URLs(templateRegistries))
+		if err != nil {
+			return err
+		}
+		entry, err := registry.Find(entries, args[0])
+		if err != nil {
+			return err
+		}
+
+		dest := entry.Name
+		if len(args) > 1 {
+			dest = args[1]
+		}
+		if _, err := os.Stat(dest); err == nil {
+			return fmt.Errorf("destination already exists: %s", dest)
+		}
+
+		src := entry.Source
+		if template.IsRemote(src) {
+			if src, _, err = template.Fetch(entry.Source, stateDir); err != nil {
+				return err
+			}
+		}
+
+		if err := template.Copy(src, dest); err != nil {
+			os.RemoveAll(dest)
+			return fmt.Errorf("error copying template: %w", err)
+		}
+
+		fmt.Printf("Added template %s from %s to %s\n", entry.Name, entry.Source, dest)
+		return nil
+	},
+}
+
+// templateUpdateCmd обновляет кеш индексов реестров
+var templateUpdateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Refresh cached registry indexes",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return registry.Update(stateDir, registry.URLs(templateRegistries))
+	},
+}
+
+func init() {
+	templateCmd.PersistentFlags().StringArrayVar(&templateRegistries, "registry", nil, "Template registry index URL or file (repeatable; default $SYSWEAVER_REGISTRY)")
+
+	templateCmd.AddCommand(templateSearchCmd)
+	templateCmd.AddCommand(templateAddCmd)
+	templateCmd.AddCommand(templateUpdateCmd)
+}
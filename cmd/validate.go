@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sysweaver/internal/config"
+	"sysweaver/internal/logging"
+	"sysweaver/internal/output"
+	"sysweaver/internal/scripts"
+	"sysweaver/internal/store"
+	"sysweaver/internal/structures"
+	"sysweaver/internal/template"
+	"sysweaver/pkg/sysweaver"
+
+	"github.com/spf13/cobra"
+)
+
+// validateResult - результат проверки шаблона
+type validateResult struct {
+	Template string         `json:"template"`
+	Result   string         `json:"result"`
+	Errors   []string       `json:"errors,omitempty"`
+	Scripts  map[string]int `json:"scripts,omitempty"` // Число скриптов по стадиям
+}
+
+// validateCmd представляет команду для проверки шаблона
+var validateCmd = &cobra.Command{
+	Use:   "validate [template]",
+	Short: "Validate a template",
+	Long: `Validate the structure and configuration of a template without building it.
+
+The template (a directory or a git URL) is resolved with its base templates
+and layers, then checked:
+
+  - config.yaml is loaded and validated as a build would (with the system
+    and user config layers, --profile and --set)
+  - jail.yaml is present and valid
+  - scripts.yaml is valid and the scripts of every stage can be ordered
+
+All problems are reported before the command fails. With --format json the
+result is printed as {"template", "result", "errors", "scripts"}.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := redirectOutput(); err != nil {
+			return err
+		}
+		err := runValidate(args[0])
+		writeFailure(err)
+		return err
+	},
+}
+
+// runValidate проверяет шаблон и выводит результат
+func runValidate(templateArg string) error {
+	fmt.Printf("Validating template: %s\n", templateArg)
+
+	tmpl, err := template.Open(templateArg, template.Options{StateDir: stateDir})
+	if err != nil {
+		return err
+	}
+	result := validateResult{Template: tmpl.Dir, Scripts: make(map[string]int)}
+	problem := func(err error) {
+		fmt.Printf("  %s %v\n", logging.Failure("✗"), err)
+		result.Errors = append(result.Errors, err.Error())
+	}
+
+	// Файлы слоев шаблона собираются во временный каталог, как при сборке
+	templateDir := tmpl.Dir
+	if tmpl.Composed() {
+		composed, err := os.MkdirTemp("", "sysweaver-template-")
+		if err != nil {
+			return fmt.Errorf("error creating template directory: %w", err)
+		}
+		defer os.RemoveAll(composed)
+
+		if err := tmpl.Compose(composed); err != nil {
+			return err
+		}
+		templateDir = composed
+	}
+
+	path := configPath
+	if path == "" {
+		path = template.ConfigPath(tmpl.Dir)
+	}
+	var buildConfig structures.BuildConfig
+	if err := config.Load(path, &buildConfig, buildConfigOptions(tmpl)); err != nil {
+		problem(fmt.Errorf("config: %w", err))
+	} else if err := output.Validate(buildConfig.Outputs); err != nil {
+		// Каждый неверный выход - отдельная проблема
+		for _, err := range err.(interface{ Unwrap() []error }).Unwrap() {
+			problem(fmt.Errorf("config: %w", err))
+		}
+	}
+
+	jailPath := filepath.Join(templateDir, template.JailFile)
+	if _, err := os.Stat(jailPath); err != nil {
+		problem(fmt.Errorf("%s not found", template.JailFile))
+	} else if err := config.Load(jailPath, &structures.JailConfig{}, loadOptions()); err != nil {
+		problem(fmt.Errorf("jail: %w", err))
+	}
+
+	manifest, err := scripts.LoadManifest(filepath.Join(templateDir, scripts.ManifestFile), sysweaver.Stages())
+	if err != nil {
+		problem(err)
+	} else {
+		for _, stage := range sysweaver.Stages() {
+			steps, err := manifest.Plan(stage, filepath.Join(templateDir, "scripts", stage))
+			if err != nil {
+				problem(err)
+				continue
+			}
+			if len(steps) > 0 {
+				result.Scripts[stage] = len(steps)
+			}
+		}
+	}
+
+	result.Result = store.ResultSuccess
+	if len(result.Errors) > 0 {
+		result.Result = store.ResultFailed
+	}
+	if resultFormat == formatJSON {
+		if err := writeResult(result); err != nil {
+			return err
+		}
+	}
+
+	if len(result.Errors) > 0 {
+		return &sysweaver.ConfigError{Err: fmt.Errorf("template %s is invalid: %d problem(s)", templateArg, len(result.Errors))}
+	}
+	fmt.Println(logging.Success("Template is valid"))
+	return nil
+}
+
+func init() {
+	validateCmd.Flags().StringVarP(&configPath, "config", "c", "", "Path to the configuration file (defaults to template/config.yaml)")
+	validateCmd.Flags().StringSliceVar(&configProfiles, "profile", nil, "Apply config profiles from the profiles section, in order (repeatable or comma-separated)")
+	validateCmd.Flags().StringArrayVar(&configOverrides, "set", nil, "Override a config value by dotted path (repeatable)")
+}
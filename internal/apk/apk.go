@@ -0,0 +1,121 @@
+package apk
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// InstalledDB - путь к базе установленных пакетов apk относительно корня ФС
+const InstalledDB = "lib/apk/db/installed"
+
+// Package - запись о пакете из базы установленных пакетов apk
+type Package struct {
+	Name        string
+	Version     string
+	Arch        string
+	License     string
+	Origin      string
+	Description string
+	URL         string
+	Maintainer  string
+	Commit      string
+	Checksum    string   // Z:/C: - контрольная сумма пакета
+	Depends     []string // D:
+	Files       []string // Файлы пакета, пути относительно корня без ведущего /
+}
+
+// ReadInstalled читает базу установленных пакетов из корневой ФС образа.
+// Если база отсутствует (не Alpine), возвращается пустой список без ошибки.
+func ReadInstalled(root string) ([]Package, error) {
+	file, err := os.Open(filepath.Join(root, InstalledDB))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error opening apk database: %w", err)
+	}
+	defer file.Close()
+
+	var packages []Package
+	var current *Package
+	var currentDir string
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// Пустая строка завершает запись пакета
+		if line == "" {
+			if current != nil {
+				packages = append(packages, *current)
+				current = nil
+			}
+			continue
+		}
+
+		if len(line) < 2 || line[1] != ':' {
+			continue
+		}
+
+		if current == nil {
+			current = &Package{}
+			currentDir = ""
+		}
+
+		key, value := line[0], line[2:]
+		switch key {
+		case 'P':
+			current.Name = value
+		case 'V':
+			current.Version = value
+		case 'A':
+			current.Arch = value
+		case 'L':
+			current.License = value
+		case 'o':
+			current.Origin = value
+		case 'T':
+			current.Description = value
+		case 'U':
+			current.URL = value
+		case 'm':
+			current.Maintainer = value
+		case 'c':
+			current.Commit = value
+		case 'C':
+			current.Checksum = value
+		case 'D':
+			current.Depends = strings.Fields(value)
+		case 'F':
+			currentDir = value
+		case 'R':
+			current.Files = append(current.Files, filepath.Join(currentDir, value))
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading apk database: %w", err)
+	}
+
+	if current != nil {
+		packages = append(packages, *current)
+	}
+
+	return packages, nil
+}
+
+// FileOwners строит индекс "путь файла -> пакет"
+func FileOwners(packages []Package) map[string]*Package {
+	owners := make(map[string]*Package)
+	for i := range packages {
+		for _, file := range packages[i].Files {
+			owners[file] = &packages[i]
+		}
+	}
+	return owners
+}
@@ -0,0 +1,193 @@
+package apk
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"syscall"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Точные версии пакетов apk, установленных в собранную систему, записываются
+// в packages.lock рядом с config.yaml. Набор пакетов зависит от архитектуры и
+// профилей, поэтому у каждого сочетания своя запись. Пока запись есть,
+// сборка устанавливает пакеты именно этих версий; --update-lock заново
+// разрешает версии и перезаписывает запись.
+
+// LockFile - имя lock-файла пакетов
+const LockFile = "packages.lock"
+
+const lockVersion = 1
+
+const lockHeader = "# Generated by sysweaver. Commit this file; refresh it with 'sysweaver build --update-lock'.\n"
+
+// Lock - содержимое lock-файла пакетов
+type Lock struct {
+	Version int         `yaml:"version"`
+	Sets    []LockedSet `yaml:"sets"`
+}
+
+// LockedSet - зафиксированный набор пакетов для архитектуры и профилей
+type LockedSet struct {
+	Key       string   `yaml:"key"`       // Архитектура и профили: x86_64, x86_64+dev
+	Requested []string `yaml:"requested"` // Имена пакетов из packages конфигурации
+	Packages  []string `yaml:"packages"`  // name=version всех установленных пакетов
+}
+
+// LockKey возвращает ключ записи для архитектуры и выбранных профилей
+func LockKey(arch string, profiles []string) string {
+	return strings.Join(append([]string{arch}, profiles...), "+")
+}
+
+// ReadLock возвращает запись key. Если файла или записи нет, возвращается
+// nil без ошибки.
+func ReadLock(path, key string) (*LockedSet, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", path, err)
+	}
+
+	lock, err := parseLock(path, data)
+	if err != nil {
+		return nil, err
+	}
+	return lock.find(key), nil
+}
+
+// UpdateLock записывает версии установленных пакетов и запрошенные в
+// конфигурации пакеты в запись key, сохраняя остальные. Файл блокируется на
+// время обновления: сборки матрицы обновляют его одновременно.
+func UpdateLock(path, key string, requested []string, packages []Package) error {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %w", path, err)
+	}
+	defer file.Close()
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("error locking %s: %w", path, err)
+	}
+	defer syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", path, err)
+	}
+	lock, err := parseLock(path, data)
+	if err != nil {
+		return err
+	}
+
+	pinned := make([]string, 0, len(packages))
+	for _, pkg := range packages {
+		pinned = append(pinned, pkg.Name+"="+pkg.Version)
+	}
+	slices.Sort(pinned)
+
+	names := make([]string, 0, len(requested))
+	for _, entry := range requested {
+		if c, err := ParseConstraint(entry); err == nil {
+			names = append(names, c.Name)
+		}
+	}
+	slices.Sort(names)
+	set := LockedSet{Key: key, Requested: slices.Compact(names), Packages: pinned}
+
+	if locked := lock.find(key); locked != nil {
+		*locked = set
+	} else {
+		lock.Sets = append(lock.Sets, set)
+	}
+	slices.SortFunc(lock.Sets, func(a, b LockedSet) int { return strings.Compare(a.Key, b.Key) })
+	lock.Version = lockVersion
+
+	var buf bytes.Buffer
+	buf.WriteString(lockHeader)
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(lock); err != nil {
+		return fmt.Errorf("error encoding %s: %w", LockFile, err)
+	}
+	encoder.Close()
+
+	if err := file.Truncate(0); err != nil {
+		return fmt.Errorf("error writing %s: %w", path, err)
+	}
+	if _, err := file.WriteAt(buf.Bytes(), 0); err != nil {
+		return fmt.Errorf("error writing %s: %w", path, err)
+	}
+	return nil
+}
+
+// Pin возвращает ограничения для apk add: пакеты конфигурации и
+// зафиксированные версии остальных пакетов. Пакеты, для которых конфигурация
+// задает свое ограничение версии, lock-файлом не фиксируются. changed -
+// пакеты, добавленные в конфигурацию или удаленные из нее после записи.
+func (l *LockedSet) Pin(packages []string) (constraints, changed []string) {
+	versions := make(map[string]string)
+	for _, entry := range l.Packages {
+		name, _, _ := strings.Cut(entry, "=")
+		versions[name] = entry
+	}
+
+	configured := make(map[string]bool)
+	for _, entry := range packages {
+		c, err := ParseConstraint(entry)
+		if err != nil {
+			continue
+		}
+		configured[c.Name] = true
+		if !slices.Contains(l.Requested, c.Name) {
+			changed = append(changed, "+"+c.Name)
+		}
+		// Без своего ограничения пакет получает версию из lock-файла
+		if pinned, ok := versions[c.Name]; ok && c.Operator == "" {
+			entry = pinned
+		}
+		constraints = append(constraints, entry)
+		delete(versions, c.Name)
+	}
+	// Удаленные из конфигурации пакеты не устанавливаются заново через world
+	for _, name := range l.Requested {
+		if !configured[name] {
+			changed = append(changed, "-"+name)
+			delete(versions, name)
+		}
+	}
+
+	for _, entry := range l.Packages {
+		name, _, _ := strings.Cut(entry, "=")
+		if _, ok := versions[name]; ok {
+			constraints = append(constraints, entry)
+		}
+	}
+	return constraints, changed
+}
+
+func parseLock(path string, data []byte) (*Lock, error) {
+	lock := &Lock{Version: lockVersion}
+	if err := yaml.Unmarshal(data, lock); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	if lock.Version > lockVersion {
+		return nil, fmt.Errorf("%s has version %d, this sysweaver supports up to %d", path, lock.Version, lockVersion)
+	}
+	return lock, nil
+}
+
+// find возвращает запись с ключом key
+func (l *Lock) find(key string) *LockedSet {
+	for i := range l.Sets {
+		if l.Sets[i].Key == key {
+			return &l.Sets[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package apk
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RepositoriesFile - список репозиториев apk относительно корня ФС
+const RepositoriesFile = "etc/apk/repositories"
+
+// Repositories возвращает пути репозиториев относительно зеркала
+// (например, v3.20/main) из /etc/apk/repositories
+func Repositories(root string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(root, RepositoriesFile))
+	if err != nil {
+		return nil, fmt.Errorf("error reading apk repositories: %w", err)
+	}
+
+	var repos []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if suffix, ok := repoSuffix(line); ok {
+			repos = append(repos, suffix)
+		}
+	}
+
+	return repos, nil
+}
+
+// Arch возвращает архитектуру apk системы (/etc/apk/arch)
+func Arch(root string) string {
+	data, err := os.ReadFile(filepath.Join(root, "etc/apk/arch"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// SetMirror переписывает /etc/apk/repositories на указанное зеркало,
+// сохраняя ветки и репозитории (v3.20/main, v3.20/community, ...)
+func SetMirror(root, mirror string) error {
+	path := filepath.Join(root, RepositoriesFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading apk repositories: %w", err)
+	}
+
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		if suffix, ok := repoSuffix(line); ok {
+			prefix := ""
+			if strings.HasPrefix(strings.TrimSpace(line), "@") {
+				// Сохраняем тег репозитория: @testing https://...
+				prefix = strings.Fields(line)[0] + " "
+			}
+			lines[i] = prefix + strings.TrimRight(mirror, "/") + "/" + suffix
+		}
+	}
+
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		return fmt.Errorf("error writing apk repositories: %w", err)
+	}
+
+	return nil
+}
+
+// repoSuffix выделяет путь репозитория после каталога alpine/ в URL
+func repoSuffix(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", false
+	}
+
+	fields := strings.Fields(line)
+	url := fields[len(fields)-1]
+	if !strings.Contains(url, "://") {
+		return "", false
+	}
+
+	i := strings.Index(url, "/alpine/")
+	if i < 0 {
+		return "", false
+	}
+
+	return strings.Trim(url[i+len("/alpine/"):], "/"), true
+}
@@ -0,0 +1,178 @@
+package apk
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Суффиксы версий apk и их порядок относительно релиза (0)
+var suffixOrder = map[string]int{
+	"alpha": -4,
+	"beta":  -3,
+	"pre":   -2,
+	"rc":    -1,
+	"cvs":   1,
+	"svn":   2,
+	"git":   3,
+	"hg":    4,
+	"p":     5,
+}
+
+// version - разобранная версия apk: 1.2.3a_rc1-r2
+type version struct {
+	numbers  []int
+	letter   byte
+	suffixes [][2]int // пары (порядок суффикса, номер)
+	revision int
+}
+
+// parseVersion разбирает строку версии в формате apk
+func parseVersion(s string) version {
+	var v version
+
+	if i := strings.LastIndex(s, "-r"); i >= 0 {
+		if rev, err := strconv.Atoi(s[i+2:]); err == nil {
+			v.revision = rev
+			s = s[:i]
+		}
+	}
+
+	parts := strings.Split(s, "_")
+	main := parts[0]
+
+	// Буквенный суффикс после последней цифры: 1.2.3a
+	if n := len(main); n > 0 && main[n-1] >= 'a' && main[n-1] <= 'z' {
+		v.letter = main[n-1]
+		main = main[:n-1]
+	}
+
+	for _, field := range strings.Split(main, ".") {
+		num, _ := strconv.Atoi(field)
+		v.numbers = append(v.numbers, num)
+	}
+
+	for _, suffix := range parts[1:] {
+		name := strings.TrimRight(suffix, "0123456789")
+		num, _ := strconv.Atoi(suffix[len(name):])
+		v.suffixes = append(v.suffixes, [2]int{suffixOrder[name], num})
+	}
+
+	return v
+}
+
+// CompareVersions сравнивает версии apk; возвращает -1, 0 или 1
+func CompareVersions(a, b string) int {
+	va, vb := parseVersion(a), parseVersion(b)
+
+	for i := 0; i < len(va.numbers) || i < len(vb.numbers); i++ {
+		// Отсутствующий компонент меньше любого присутствующего: 1.0 < 1.0.0
+		if i >= len(va.numbers) {
+			return -1
+		}
+		if i >= len(vb.numbers) {
+			return 1
+		}
+		if c := compareInt(va.numbers[i], vb.numbers[i]); c != 0 {
+			return c
+		}
+	}
+
+	if c := compareInt(int(va.letter), int(vb.letter)); c != 0 {
+		return c
+	}
+
+	for i := 0; i < len(va.suffixes) || i < len(vb.suffixes); i++ {
+		var sa, sb [2]int
+		if i < len(va.suffixes) {
+			sa = va.suffixes[i]
+		}
+		if i < len(vb.suffixes) {
+			sb = vb.suffixes[i]
+		}
+		if c := compareInt(sa[0], sb[0]); c != 0 {
+			return c
+		}
+		if c := compareInt(sa[1], sb[1]); c != 0 {
+			return c
+		}
+	}
+
+	return compareInt(va.revision, vb.revision)
+}
+
+func compareInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Constraint - ограничение на пакет вида openssl<3.0.14
+type Constraint struct {
+	Name     string
+	Operator string // "", "<", "<=", ">", ">=", "="
+	Version  string
+}
+
+// ParseConstraint разбирает ограничение "name", "name<ver", "name>=ver" и т.д.
+func ParseConstraint(s string) (Constraint, error) {
+	s = strings.TrimSpace(s)
+
+	i := strings.IndexAny(s, "<>=")
+	if i < 0 {
+		if s == "" {
+			return Constraint{}, fmt.Errorf("empty package constraint")
+		}
+		return Constraint{Name: s}, nil
+	}
+
+	c := Constraint{Name: strings.TrimSpace(s[:i])}
+	rest := s[i:]
+	for _, op := range []string{"<=", ">=", "<", ">", "="} {
+		if strings.HasPrefix(rest, op) {
+			c.Operator = op
+			c.Version = strings.TrimSpace(rest[len(op):])
+			break
+		}
+	}
+
+	if c.Name == "" || c.Version == "" {
+		return Constraint{}, fmt.Errorf("invalid package constraint: %s", s)
+	}
+
+	return c, nil
+}
+
+// Matches проверяет, удовлетворяет ли пакет ограничению
+func (c Constraint) Matches(name, version string) bool {
+	if name != c.Name {
+		return false
+	}
+	if c.Operator == "" {
+		return true
+	}
+
+	cmp := CompareVersions(version, c.Version)
+	switch c.Operator {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "=":
+		return cmp == 0
+	}
+	return false
+}
+
+// String возвращает ограничение в исходной записи
+func (c Constraint) String() string {
+	return c.Name + c.Operator + c.Version
+}
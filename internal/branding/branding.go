@@ -0,0 +1,276 @@
+package branding
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"sysweaver/internal/structures"
+)
+
+// Оформление из branding: конфигурации применяется к корневой ФС перед
+// скриптами стадии configure. Поля os-release обновляются в файле, на
+// который указывает /etc/os-release, остальные поля сохраняются. Шаблоны
+// motd и issue выполняются как text/template с данными сборки (Data).
+// Фон и тема GRUB копируются в /usr/share/sysweaver и /boot/grub/themes и
+// прописываются в /etc/default/grub для последующего grub-mkconfig.
+
+// Data - данные сборки для шаблонов motd и issue
+type Data struct {
+	Name       string   // NAME из branding или name конфигурации
+	ID         string   // ID os-release
+	Version    string   // Версия системы
+	PrettyName string   // PRETTY_NAME
+	BuildID    string   // Идентификатор сборки sysweaver
+	Date       string   // Дата сборки (YYYY-MM-DD, с учетом SOURCE_DATE_EPOCH)
+	Arch       string   // Архитектура
+	Hostname   string   // system.hostname
+	Profiles   []string // Примененные профили конфигурации
+}
+
+// Configured сообщает, задано ли оформление в конфигурации
+func Configured(cfg structures.BrandingConfig) bool {
+	return cfg != structures.BrandingConfig{}
+}
+
+// NewData собирает данные шаблонов из конфигурации и сведений о сборке
+func NewData(cfg *structures.BuildConfig, buildID string, date time.Time, profiles []string) Data {
+	b := cfg.Branding
+	data := Data{
+		Name:       b.Name,
+		ID:         b.ID,
+		Version:    b.Version,
+		PrettyName: b.PrettyName,
+		BuildID:    buildID,
+		Date:       date.UTC().Format("2006-01-02"),
+		Arch:       cfg.Arch,
+		Hostname:   cfg.System.Hostname,
+		Profiles:   profiles,
+	}
+	if data.Name == "" {
+		data.Name = cfg.Name
+	}
+	if data.Version == "" {
+		data.Version = cfg.Version
+	}
+	if data.PrettyName == "" {
+		data.PrettyName = strings.TrimSpace(data.Name + " " + data.Version)
+	}
+	return data
+}
+
+// Apply применяет оформление к корневой ФС root; пути файлов оформления
+// отсчитываются от templateDir
+func Apply(root, templateDir string, cfg structures.BrandingConfig, data Data) error {
+	if cfg.Name != "" {
+		if err := updateOSRelease(root, cfg, data); err != nil {
+			return fmt.Errorf("error updating os-release: %w", err)
+		}
+	}
+
+	for _, file := range []struct{ source, target string }{
+		{cfg.Motd, "etc/motd"},
+		{cfg.Issue, "etc/issue"},
+	} {
+		if file.source == "" {
+			continue
+		}
+		if err := renderFile(filepath.Join(templateDir, file.source), filepath.Join(root, file.target), data); err != nil {
+			return err
+		}
+	}
+
+	var grub []string
+	if cfg.Splash != "" {
+		target := "/usr/share/sysweaver/splash" + filepath.Ext(cfg.Splash)
+		if err := copyPath(filepath.Join(templateDir, cfg.Splash), filepath.Join(root, target)); err != nil {
+			return fmt.Errorf("error installing splash: %w", err)
+		}
+		grub = append(grub, "GRUB_BACKGROUND="+strconv.Quote(target))
+	}
+	if cfg.GrubTheme != "" {
+		source := filepath.Join(templateDir, cfg.GrubTheme)
+		if _, err := os.Stat(filepath.Join(source, "theme.txt")); err != nil {
+			return fmt.Errorf("grub theme %s has no theme.txt", cfg.GrubTheme)
+		}
+		name := data.ID
+		if name == "" {
+			name = filepath.Base(source)
+		}
+		target := "/boot/grub/themes/" + name
+		if err := copyPath(source, filepath.Join(root, target)); err != nil {
+			return fmt.Errorf("error installing grub theme: %w", err)
+		}
+		grub = append(grub, "GRUB_THEME="+strconv.Quote(target+"/theme.txt"))
+	}
+	if len(grub) > 0 {
+		if err := setKeys(filepath.Join(root, "etc/default/grub"), grub, false); err != nil {
+			return fmt.Errorf("error updating /etc/default/grub: %w", err)
+		}
+	}
+	return nil
+}
+
+// updateOSRelease записывает поля оформления в os-release
+func updateOSRelease(root string, cfg structures.BrandingConfig, data Data) error {
+	path := filepath.Join(root, "etc/os-release")
+	if link, err := os.Readlink(path); err == nil {
+		// Обычно ../usr/lib/os-release: ссылка остается, меняется файл
+		if filepath.IsAbs(link) {
+			path = filepath.Join(root, link)
+		} else {
+			path = filepath.Join(filepath.Dir(path), link)
+		}
+	}
+
+	current := readKeys(path)
+	keys := []string{
+		"NAME=" + strconv.Quote(data.Name),
+		"VERSION=" + strconv.Quote(data.Version),
+		"PRETTY_NAME=" + strconv.Quote(data.PrettyName),
+	}
+	if data.Version != "" {
+		keys = append(keys, "VERSION_ID="+data.Version)
+	}
+	if cfg.ID != "" {
+		keys = append(keys, "ID="+cfg.ID)
+		// Утилиты, проверяющие дистрибутив, находят исходный ID в ID_LIKE
+		if id := current["ID"]; id != "" && id != cfg.ID && !strings.Contains(" "+current["ID_LIKE"]+" ", " "+id+" ") {
+			keys = append(keys, "ID_LIKE="+strconv.Quote(strings.TrimSpace(id+" "+current["ID_LIKE"])))
+		}
+	}
+	if cfg.HomeURL != "" {
+		keys = append(keys, "HOME_URL="+strconv.Quote(cfg.HomeURL))
+	}
+	if data.BuildID != "" {
+		keys = append(keys, "BUILD_ID="+data.BuildID)
+	}
+	return setKeys(path, keys, true)
+}
+
+// readKeys читает значения KEY=value файла в формате os-release
+func readKeys(path string) map[string]string {
+	values := make(map[string]string)
+	file, err := os.Open(path)
+	if err != nil {
+		return values
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = strings.Trim(value, "'")
+		}
+		values[strings.TrimSpace(key)] = value
+	}
+	return values
+}
+
+// setKeys заменяет строки KEY= файла и дописывает отсутствующие.
+// replaceFile - записать новый файл вместо изменения на месте (для
+// os-release, который может быть общим с пакетом).
+func setKeys(path string, keys []string, replaceFile bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	pending := make(map[string]string)
+	var order []string
+	for _, entry := range keys {
+		key, _, _ := strings.Cut(entry, "=")
+		pending[key] = entry
+		order = append(order, key)
+	}
+
+	var lines []string
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		key, _, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if entry, found := pending[key]; ok && found {
+			lines = append(lines, entry)
+			delete(pending, key)
+			continue
+		}
+		if line != "" || len(lines) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	for _, key := range order {
+		if entry, found := pending[key]; found {
+			lines = append(lines, entry)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if replaceFile {
+		os.Remove(path)
+	}
+	return os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+// renderFile выполняет шаблон source и записывает результат в target
+func renderFile(source, target string, data Data) error {
+	text, err := os.ReadFile(source)
+	if err != nil {
+		return fmt.Errorf("error reading branding template: %w", err)
+	}
+	tmpl, err := template.New(filepath.Base(source)).Option("missingkey=error").Parse(string(text))
+	if err != nil {
+		return fmt.Errorf("invalid branding template %s: %w", filepath.Base(source), err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return fmt.Errorf("error rendering %s: %w", filepath.Base(source), err)
+	}
+
+	os.Remove(target)
+	if err := os.WriteFile(target, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("error writing %s: %w", filepath.Base(target), err)
+	}
+	return nil
+}
+
+// copyPath копирует файл или каталог src в dst
+func copyPath(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, 0644)
+	})
+}
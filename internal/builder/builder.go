@@ -0,0 +1,322 @@
+package builder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"sysweaver/internal/digest"
+	"sysweaver/internal/download"
+	"sysweaver/internal/logging"
+)
+
+// Управляемые билдеры - rootfs, загруженные sysweaver fetch-builder в
+// <state-dir>/builders/<distro>-<version>-<arch>. В jail.yaml на них
+// ссылаются по имени вместо пути: builder_path: alpine:3.20
+
+// DefaultMirror - зеркало Alpine по умолчанию
+const DefaultMirror = "https://dl-cdn.alpinelinux.org/alpine"
+
+// Файл описания загруженного билдера рядом с его каталогом
+const infoSuffix = ".json"
+
+// Архитектуры Go и соответствующие им архитектуры Alpine
+var goArches = map[string]string{
+	"amd64":   "x86_64",
+	"arm64":   "aarch64",
+	"386":     "x86",
+	"arm":     "armv7",
+	"ppc64le": "ppc64le",
+	"s390x":   "s390x",
+	"riscv64": "riscv64",
+}
+
+// Ref - ссылка на билдер вида alpine:3.20
+type Ref struct {
+	Distro  string
+	Version string
+}
+
+func (r Ref) String() string {
+	return r.Distro + ":" + r.Version
+}
+
+// Info - описание загруженного билдера
+type Info struct {
+	Ref       string        `json:"ref"`
+	Arch      string        `json:"arch"`
+	Release   string        `json:"release"` // Полная версия выпуска (3.20.3) или имя образа
+	URL       string        `json:"url"`
+	Digest    digest.Digest `json:"digest"`
+	FetchedAt time.Time     `json:"fetched_at"`
+
+	// Выполненные проверки архива: sha256 (latest-releases.yaml и файл .sha256)
+	// и gpg (подпись .asc) с отпечатком ключа подписи
+	Verified []string `json:"verified"`
+	Signer   string   `json:"signer,omitempty"`
+}
+
+// FetchOptions - параметры загрузки билдера
+type FetchOptions struct {
+	Mirrors []string // Зеркала Alpine (по умолчанию DefaultMirror)
+	Key     string   // Открытый ключ GPG для проверки подписи (пусто - без проверки)
+
+	Logger *slog.Logger // Журнал загрузки; nil - slog.Default()
+	Out    io.Writer    // Сообщения о ходе загрузки; nil - os.Stdout
+}
+
+// ParseRef разбирает ссылку distro:version. Поддерживается только alpine;
+// версия - ветка выпусков (3.20) или edge.
+func ParseRef(s string) (Ref, error) {
+	distro, version, ok := strings.Cut(s, ":")
+	if !ok || version == "" {
+		return Ref{}, fmt.Errorf("invalid builder %q (expected distro:version, e.g. alpine:3.20)", s)
+	}
+	if distro != "alpine" {
+		return Ref{}, fmt.Errorf("unsupported builder distro %q (supported: alpine)", distro)
+	}
+	return Ref{Distro: distro, Version: strings.TrimPrefix(version, "v")}, nil
+}
+
+// IsRef сообщает, является ли builder_path ссылкой на управляемый билдер,
+// а не путем
+func IsRef(path string) bool {
+	if strings.ContainsRune(path, '/') {
+		return false
+	}
+	_, err := ParseRef(path)
+	return err == nil
+}
+
+// HostArch возвращает архитектуру хоста в обозначениях Alpine
+func HostArch() string {
+	if arch, ok := goArches[runtime.GOARCH]; ok {
+		return arch
+	}
+	return runtime.GOARCH
+}
+
+// Path возвращает каталог управляемого билдера
+func Path(stateDir string, ref Ref, arch string) string {
+	return filepath.Join(stateDir, "builders", fmt.Sprintf("%s-%s-%s", ref.Distro, ref.Version, arch))
+}
+
+// ReadInfo читает описание билдера из каталога path. Для билдеров, не
+// загруженных fetch-builder, возвращает nil без ошибки.
+func ReadInfo(path string) (*Info, error) {
+	data, err := os.ReadFile(path + infoSuffix)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var info Info
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("error parsing builder info: %w", err)
+	}
+	return &info, nil
+}
+
+// release - запись latest-releases.yaml
+type release struct {
+	Flavor  string `yaml:"flavor"`
+	File    string `yaml:"file"`
+	Version string `yaml:"version"`
+	SHA256  string `yaml:"sha256"`
+}
+
+// Fetch загружает minirootfs последнего выпуска ветки ref для arch, сверяет
+// ее SHA256 с latest-releases.yaml и опубликованным файлом .sha256, при
+// заданном ключе проверяет подпись GPG и распаковывает архив в каталог
+// билдера, заменяя прежнюю версию
+func Fetch(stateDir string, ref Ref, arch string, opts FetchOptions) (*Info, error) {
+	mirrors := opts.Mirrors
+	if len(mirrors) == 0 {
+		mirrors = []string{DefaultMirror}
+	}
+	branch := "v" + ref.Version
+	if ref.Version == "edge" {
+		branch = "edge"
+	}
+	releases := fmt.Sprintf("%s/releases/%s", branch, arch)
+
+	dest := Path(stateDir, ref, arch)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return nil, fmt.Errorf("error creating builders directory: %w", err)
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(dest), ".fetch-")
+	if err != nil {
+		return nil, fmt.Errorf("error creating builders directory: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	loader := download.New(mirrors)
+	loader.Logger = opts.Logger
+	loader.Out = opts.Out
+	if opts.Out == nil {
+		opts.Out = os.Stdout
+	}
+	index := filepath.Join(tmp, "latest-releases.yaml")
+	if _, err := loader.Fetch(releases+"/latest-releases.yaml", index); err != nil {
+		return nil, fmt.Errorf("error fetching %s release list: %w", ref, err)
+	}
+
+	rel, err := findMinirootfs(index)
+	if err != nil {
+		return nil, fmt.Errorf("%s (%s): %w", ref, arch, err)
+	}
+	expected := digest.Digest(digest.SHA256 + ":" + rel.SHA256)
+
+	fmt.Fprintf(opts.Out, "Downloading %s\n", rel.File)
+	archive := filepath.Join(tmp, filepath.Base(rel.File))
+	result, err := loader.Fetch(releases+"/"+rel.File, archive)
+	if err != nil {
+		return nil, err
+	}
+	if err := digest.Verify(archive, expected); err != nil {
+		return nil, err
+	}
+	verified := []string{"sha256"}
+
+	// Опубликованная контрольная сумма должна совпадать со списком выпусков
+	sums := archive + ".sha256"
+	if _, err := loader.Fetch(releases+"/"+rel.File+".sha256", sums); err != nil {
+		logging.OrDefault(opts.Logger).Warn("no published checksum", "file", rel.File, "error", err)
+	} else if err := checkSumsFile(sums, rel.SHA256); err != nil {
+		return nil, err
+	}
+
+	var signer string
+	if opts.Key != "" {
+		signature := archive + ".asc"
+		if _, err := loader.Fetch(releases+"/"+rel.File+".asc", signature); err != nil {
+			return nil, fmt.Errorf("error fetching signature of %s: %w", rel.File, err)
+		}
+		if signer, err = verifySignature(tmp, opts.Key, signature, archive); err != nil {
+			return nil, fmt.Errorf("signature of %s: %w", rel.File, err)
+		}
+		fmt.Fprintf(opts.Out, "Signature of %s verified (key %s)\n", rel.File, signer)
+		verified = append(verified, "gpg")
+	}
+
+	rootfs := filepath.Join(tmp, "rootfs")
+	if err := os.Mkdir(rootfs, 0755); err != nil {
+		return nil, err
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command("tar", "-x", "-p", "--numeric-owner", "-C", rootfs, "-f", archive)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("error unpacking %s: %w (%s)", rel.File, err, strings.TrimSpace(stderr.String()))
+	}
+
+	info := &Info{
+		Ref:       ref.String(),
+		Arch:      arch,
+		Release:   rel.Version,
+		URL:       result.URL,
+		Digest:    expected,
+		FetchedAt: time.Now().UTC(),
+		Verified:  verified,
+		Signer:    signer,
+	}
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	// Старый билдер заменяется целиком; описание пишется последним и
+	// означает, что каталог готов
+	os.Remove(dest + infoSuffix)
+	if err := os.RemoveAll(dest); err != nil {
+		return nil, fmt.Errorf("error removing previous builder: %w", err)
+	}
+	if err := os.Rename(rootfs, dest); err != nil {
+		return nil, fmt.Errorf("error installing builder: %w", err)
+	}
+	if err := os.WriteFile(dest+infoSuffix, data, 0644); err != nil {
+		return nil, fmt.Errorf("error saving builder info: %w", err)
+	}
+	return info, nil
+}
+
+// findMinirootfs находит minirootfs в latest-releases.yaml
+func findMinirootfs(path string) (*release, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var releases []release
+	if err := yaml.Unmarshal(data, &releases); err != nil {
+		return nil, fmt.Errorf("error parsing release list: %w", err)
+	}
+	for _, rel := range releases {
+		if rel.Flavor == "alpine-minirootfs" {
+			if rel.File == "" || len(rel.SHA256) != 64 {
+				return nil, fmt.Errorf("release list has no file or sha256 for minirootfs")
+			}
+			return &rel, nil
+		}
+	}
+	return nil, fmt.Errorf("no minirootfs in the release list")
+}
+
+// checkSumsFile сверяет файл в формате sha256sum с ожидаемой суммой
+func checkSumsFile(path, expected string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 || !strings.EqualFold(fields[0], expected) {
+		return fmt.Errorf("published checksum of %s does not match the release list", strings.TrimSuffix(filepath.Base(path), ".sha256"))
+	}
+	return nil
+}
+
+// verifySignature проверяет отделенную подпись файла ключом key во временном
+// каталоге gpg и возвращает отпечаток ключа подписи
+func verifySignature(tmp, key, signature, file string) (string, error) {
+	home := filepath.Join(tmp, "gnupg")
+	if err := os.Mkdir(home, 0700); err != nil {
+		return "", err
+	}
+
+	gpg := func(args ...string) (string, error) {
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command("gpg", append([]string{"--homedir", home, "--batch", "--status-fd", "1"}, args...)...)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return "", fmt.Errorf("gpg %s: %w (%s)", args[0], err, strings.TrimSpace(stderr.String()))
+		}
+		return stdout.String(), nil
+	}
+
+	if _, err := gpg("--import", key); err != nil {
+		return "", err
+	}
+	status, err := gpg("--verify", signature, file)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(status, "\n") {
+		if fields := strings.Fields(line); len(fields) > 2 && fields[1] == "VALIDSIG" {
+			return fields[2], nil
+		}
+	}
+	return "", fmt.Errorf("gpg reported no valid signature")
+}
@@ -0,0 +1,189 @@
+package builder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"sysweaver/internal/digest"
+)
+
+// Билдер из образа контейнера - ФС образа, экспортированная в
+// <state-dir>/builders/image-<id>. Каталог определяется идентификатором
+// образа, поэтому обновленный образ экспортируется заново:
+//
+//	builder_path: docker://registry.example.com/base/alpine:3.20
+//	builder_path: podman://alpine:3.20
+//	builder_path: containers-storage:localhost/hardened-alpine
+
+// Префиксы ссылок на образы и утилиты, которыми они экспортируются
+var imageTransports = []struct {
+	prefix string
+	tool   string
+}{
+	{"docker://", "docker"},
+	{"podman://", "podman"},
+	{"containers-storage:", "podman"},
+}
+
+// Архитектуры Alpine и соответствующие платформы контейнеров
+var platforms = map[string]string{
+	"x86_64":  "linux/amd64",
+	"aarch64": "linux/arm64",
+	"x86":     "linux/386",
+	"armv7":   "linux/arm/v7",
+	"ppc64le": "linux/ppc64le",
+	"s390x":   "linux/s390x",
+	"riscv64": "linux/riscv64",
+}
+
+// IsImage сообщает, является ли builder_path ссылкой на образ контейнера
+func IsImage(path string) bool {
+	_, _, ok := parseImage(path)
+	return ok
+}
+
+func parseImage(ref string) (tool, image string, ok bool) {
+	for _, t := range imageTransports {
+		if image, ok := strings.CutPrefix(ref, t.prefix); ok && image != "" {
+			return t.tool, image, true
+		}
+	}
+	return "", "", false
+}
+
+// ExportImage экспортирует ФС образа ref для архитектуры arch (пусто - по
+// умолчанию утилиты) в каталог билдера и возвращает путь к нему. Отсутствующий
+// локально образ загружается; уже экспортированный образ не экспортируется повторно.
+// Сообщения о ходе экспорта пишутся в w.
+func ExportImage(stateDir, ref, arch string, w io.Writer) (string, error) {
+	tool, image, ok := parseImage(ref)
+	if !ok {
+		return "", fmt.Errorf("invalid image reference %q", ref)
+	}
+
+	var platform []string
+	if arch != "" {
+		p, ok := platforms[arch]
+		if !ok {
+			return "", fmt.Errorf("unsupported image architecture %q", arch)
+		}
+		platform = []string{"--platform", p}
+	}
+
+	id, err := imageID(tool, image)
+	if err != nil {
+		fmt.Fprintf(w, "Image %s not found locally, pulling it\n", image)
+		if _, err := run(tool, append(append([]string{"pull"}, platform...), image)...); err != nil {
+			return "", err
+		}
+		if id, err = imageID(tool, image); err != nil {
+			return "", err
+		}
+	}
+
+	dest := filepath.Join(stateDir, "builders", "image-"+strings.TrimPrefix(id, "sha256:")[:16])
+	if _, err := os.Stat(dest + infoSuffix); err == nil {
+		return dest, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return "", fmt.Errorf("error creating builders directory: %w", err)
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(dest), ".export-")
+	if err != nil {
+		return "", fmt.Errorf("error creating builders directory: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	fmt.Fprintf(w, "Exporting filesystem of %s (%s)\n", image, id)
+
+	// export работает с контейнерами: создаем остановленный контейнер образа
+	out, err := run(tool, append(append([]string{"create"}, platform...), image, "sh")...)
+	if err != nil {
+		return "", err
+	}
+	container := strings.TrimSpace(out)
+	defer run(tool, "rm", container)
+
+	rootfs := filepath.Join(tmp, "rootfs")
+	if err := os.Mkdir(rootfs, 0755); err != nil {
+		return "", err
+	}
+	export := exec.Command(tool, "export", container)
+	untar := exec.Command("tar", "-x", "-p", "--numeric-owner", "-C", rootfs)
+	var stderr bytes.Buffer
+	untar.Stderr = &stderr
+	pipe, err := export.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	untar.Stdin = pipe
+	if err := untar.Start(); err != nil {
+		return "", fmt.Errorf("error starting tar: %w", err)
+	}
+	if err := export.Run(); err != nil {
+		untar.Wait()
+		return "", fmt.Errorf("%s export: %w", tool, err)
+	}
+	if err := untar.Wait(); err != nil {
+		return "", fmt.Errorf("error unpacking %s: %w (%s)", image, err, strings.TrimSpace(stderr.String()))
+	}
+
+	info := &Info{
+		Ref:       ref,
+		Arch:      arch,
+		Release:   image,
+		Digest:    digest.Digest(id),
+		FetchedAt: time.Now().UTC(),
+		Verified:  []string{},
+	}
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.RemoveAll(dest); err != nil {
+		return "", fmt.Errorf("error removing previous builder: %w", err)
+	}
+	if err := os.Rename(rootfs, dest); err != nil {
+		return "", fmt.Errorf("error installing builder: %w", err)
+	}
+	if err := os.WriteFile(dest+infoSuffix, data, 0644); err != nil {
+		return "", fmt.Errorf("error saving builder info: %w", err)
+	}
+	return dest, nil
+}
+
+// imageID возвращает идентификатор локального образа (sha256:...)
+func imageID(tool, image string) (string, error) {
+	out, err := run(tool, "image", "inspect", "--format", "{{.Id}}", image)
+	if err != nil {
+		return "", err
+	}
+	id := strings.TrimSpace(out)
+	if !strings.HasPrefix(id, "sha256:") {
+		id = "sha256:" + id
+	}
+	if len(id) < len("sha256:")+16 {
+		return "", fmt.Errorf("unexpected image id %q", id)
+	}
+	return id, nil
+}
+
+func run(tool string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(tool, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s %s: %w (%s)", tool, args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,187 @@
+package builder
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"sysweaver/internal/digest"
+	"sysweaver/internal/imagemount"
+	"sysweaver/internal/rootfs"
+)
+
+// Импортированный билдер - корневая ФС готового образа (ISO, raw, qcow2 и
+// других форматов qemu), извлеченная в <state-dir>/builders/import-<id>.
+// Так шаблон дорабатывает образ поставщика вместо сборки с нуля:
+//
+//	builder_path: images/vendor-appliance.qcow2
+//
+// Разделы из /etc/fstab образа (/boot, /boot/efi) извлекаются на свои места.
+// Образ извлекается заново, если изменились его размер или время изменения.
+
+// Расширения файлов образов, импортируемых как билдер
+var imageExts = []string{".iso", ".img", ".raw", ".qcow2", ".vmdk", ".vhdx", ".vdi"}
+
+// IsImageFile сообщает, указывает ли builder_path на файл образа
+func IsImageFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular() && slices.Contains(imageExts, strings.ToLower(filepath.Ext(path)))
+}
+
+// ImportImage извлекает корневую ФС образа path в каталог билдера и
+// возвращает путь к нему. Уже извлеченный образ повторно не извлекается.
+// Вывод утилит монтирования пишется в logWriter, сообщения о ходе импорта - в out.
+func ImportImage(stateDir, path string, logWriter, out io.Writer) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%d\x00%d", abs, info.Size(), info.ModTime().UnixNano())
+	dest := filepath.Join(stateDir, "builders", "import-"+hex.EncodeToString(h.Sum(nil))[:16])
+	if _, err := os.Stat(dest + infoSuffix); err == nil {
+		return dest, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return "", fmt.Errorf("error creating builders directory: %w", err)
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(dest), ".import-")
+	if err != nil {
+		return "", fmt.Errorf("error creating builders directory: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	fmt.Fprintf(out, "Importing root filesystem of %s\n", filepath.Base(abs))
+	mounted, err := imagemount.MountReadOnly(abs, logWriter)
+	if err != nil {
+		return "", err
+	}
+	defer mounted.Close()
+
+	root := filepath.Join(tmp, "rootfs")
+	if err := os.Mkdir(root, 0755); err != nil {
+		return "", err
+	}
+	if err := copyTree(mounted.Root, root); err != nil {
+		return "", err
+	}
+
+	for mountpoint, dir := range fstabPartitions(mounted) {
+		fmt.Fprintf(out, "Importing %s partition\n", mountpoint)
+		target := filepath.Join(root, mountpoint)
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return "", err
+		}
+		if err := copyTree(dir, target); err != nil {
+			return "", err
+		}
+	}
+
+	digests, err := digest.File(abs, []string{digest.SHA256})
+	if err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(&Info{
+		Ref:       abs,
+		Release:   filepath.Base(abs),
+		Digest:    digests[0],
+		FetchedAt: time.Now().UTC(),
+		Verified:  []string{},
+	}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.RemoveAll(dest); err != nil {
+		return "", fmt.Errorf("error removing previous builder: %w", err)
+	}
+	if err := os.Rename(root, dest); err != nil {
+		return "", fmt.Errorf("error installing builder: %w", err)
+	}
+	if err := os.WriteFile(dest+infoSuffix, data, 0644); err != nil {
+		return "", fmt.Errorf("error saving builder info: %w", err)
+	}
+	return dest, nil
+}
+
+// fstabPartitions сопоставляет некорневые разделы образа точкам монтирования
+// из /etc/fstab по UUID, LABEL или PARTUUID
+func fstabPartitions(m *imagemount.Mounted) map[string]string {
+	file, err := os.Open(filepath.Join(m.Root, "etc/fstab"))
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	// Идентификаторы разделов вида UUID=... -> каталог раздела
+	ids := make(map[string]string)
+	for _, part := range m.Partitions {
+		if part.Device == "" || part.Dir == m.Root {
+			continue
+		}
+		out, err := exec.Command("blkid", "-o", "export", part.Device).Output()
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(out), "\n") {
+			key, _, _ := strings.Cut(line, "=")
+			if key == "UUID" || key == "LABEL" || key == "PARTUUID" {
+				ids[line] = part.Dir
+			}
+		}
+	}
+
+	partitions := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || fields[1] == "/" || !strings.HasPrefix(fields[1], "/") {
+			continue
+		}
+		if dir, ok := ids[fields[0]]; ok {
+			partitions[fields[1]] = dir
+		}
+	}
+	return partitions
+}
+
+// copyTree копирует дерево с сохранением владельцев, прав и xattrs, не выходя
+// за пределы файловой системы src
+func copyTree(src, dst string) error {
+	cmd := exec.Command("tar", "-x", "-p", "--numeric-owner", "--xattrs", "--xattrs-include=*", "-C", dst, "-f", "-")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error starting tar: %w", err)
+	}
+
+	writeErr := rootfs.WriteTar(stdin, src, rootfs.TarOptions{Xattrs: true})
+	stdin.Close()
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("error extracting %s: %w (%s)", src, err, strings.TrimSpace(stderr.String()))
+	}
+	if writeErr != nil {
+		return fmt.Errorf("error copying %s: %w", src, writeErr)
+	}
+	return nil
+}
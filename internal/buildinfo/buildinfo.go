@@ -0,0 +1,97 @@
+package buildinfo
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"sysweaver/internal/helpers"
+	"sysweaver/internal/secrets"
+	"sysweaver/internal/structures"
+)
+
+// Dir - каталог сведений о сборке внутри jail (tmpfs, не попадает в rootfs)
+const Dir = "/etc/sysweaver"
+
+// Path - итоговая конфигурация сборки для скриптов
+const Path = Dir + "/build.json"
+
+// JSON возвращает итоговую конфигурацию сборки в JSON.
+// Ключи совпадают с config.yaml; из секретов остаются только имена.
+func JSON(cfg *structures.BuildConfig) ([]byte, error) {
+	sanitized := *cfg
+	sanitized.Secrets = nil
+
+	// Через YAML, чтобы ключи совпадали с config.yaml
+	data, err := yaml.Marshal(&sanitized)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding build config: %w", err)
+	}
+	var generic map[string]interface{}
+	if err := yaml.Unmarshal(data, &generic); err != nil {
+		return nil, fmt.Errorf("error encoding build config: %w", err)
+	}
+
+	names := make([]string, 0, len(cfg.Secrets))
+	for _, secret := range cfg.Secrets {
+		names = append(names, secret.Name)
+	}
+	if len(names) > 0 {
+		generic["secrets"] = names
+	}
+
+	return json.MarshalIndent(generic, "", "  ")
+}
+
+var nonIdentifier = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// Env возвращает переменные SYSWEAVER_* для скриптов
+func Env(cfg *structures.BuildConfig, profiles []string) []string {
+	env := []string{
+		"SYSWEAVER_BUILD_JSON=" + Path,
+		"SYSWEAVER_LIB=" + helpers.Path,
+		"SYSWEAVER_TEST_RESULTS=" + helpers.ResultsPath,
+		"SYSWEAVER_NAME=" + cfg.Name,
+		"SYSWEAVER_VERSION=" + cfg.Version,
+		"SYSWEAVER_ARCH=" + cfg.Arch,
+		"SYSWEAVER_DISTRO=" + cfg.Base.Distro,
+		"SYSWEAVER_DISTRO_VERSION=" + cfg.Base.Version,
+		"SYSWEAVER_HOSTNAME=" + cfg.System.Hostname,
+		"SYSWEAVER_TIMEZONE=" + cfg.System.Timezone,
+		"SYSWEAVER_LOCALE=" + cfg.System.Locale,
+		"SYSWEAVER_PACKAGES=" + strings.Join(cfg.Packages, " "),
+		"SYSWEAVER_PROFILES=" + strings.Join(profiles, " "),
+		"SYSWEAVER_ISO_LABEL=" + cfg.ISO.Label,
+		"SYSWEAVER_ISO_PUBLISHER=" + cfg.ISO.Publisher,
+		"SYSWEAVER_ISO_COMPRESSION=" + cfg.ISO.Compression,
+	}
+
+	outputs := make([]string, 0, len(cfg.Outputs))
+	for _, spec := range cfg.Outputs {
+		outputs = append(outputs, spec.Type)
+	}
+	env = append(env, "SYSWEAVER_OUTPUTS="+strings.Join(outputs, " "))
+
+	// Разделы: список имен и параметры каждого (SYSWEAVER_PARTITION_ROOT_SIZE=...)
+	names := make([]string, 0, len(cfg.Partitions))
+	for _, p := range cfg.Partitions {
+		names = append(names, p.Name)
+		prefix := "SYSWEAVER_PARTITION_" + strings.Trim(nonIdentifier.ReplaceAllString(strings.ToUpper(p.Name), "_"), "_") + "_"
+		env = append(env,
+			prefix+"SIZE="+p.Size,
+			prefix+"FILESYSTEM="+p.Filesystem,
+			prefix+"MOUNT="+p.Mount,
+			prefix+"FLAGS="+strings.Join(p.Flags, " "),
+		)
+	}
+	env = append(env, "SYSWEAVER_PARTITIONS="+strings.Join(names, " "))
+
+	if len(cfg.Secrets) > 0 {
+		env = append(env, "SYSWEAVER_SECRETS_DIR="+secrets.Dir)
+	}
+
+	return env
+}
@@ -0,0 +1,326 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"sysweaver/internal/apk"
+	"sysweaver/internal/scripts"
+)
+
+// Кэш слоев хранит изменения верхнего слоя overlay, сделанные каждым скриптом,
+// под ключом, который зависит от всего, что влияет на результат скрипта:
+//
+//	base   = sha256(пакеты билдера, итоговая конфигурация, файлы шаблона кроме scripts/)
+//	key[i] = sha256(key[i-1], стадия, имя, содержимое скрипта, его запись в scripts.yaml)
+//
+// Пока ключи начала конвейера совпадают с сохраненными, их слои накладываются
+// на пустой верхний слой вместо выполнения скриптов. Слой хранится в
+// <dir>/<key>/: layer.tar (новые и измененные файлы, с xattrs overlay),
+// removed (удаленные пути) и SHA256SUMS (контрольные суммы для общего кэша).
+
+// Имена файлов слоя
+const (
+	layerFile   = "layer.tar"
+	removedFile = "removed"
+)
+
+// Cache - каталог слоев
+type Cache struct {
+	dir string
+}
+
+// Open возвращает кэш слоев в каталоге состояния stateDir
+func Open(stateDir string) *Cache {
+	return &Cache{dir: filepath.Join(stateDir, "cache", "layers")}
+}
+
+// Dir возвращает каталог кэша
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+// BaseKey вычисляет ключ начала конвейера: пакеты билдера, итоговая
+// конфигурация и файлы шаблона, кроме скриптов (их учитывает StepKey)
+func BaseKey(builder string, config []byte, templateDir string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "builder %s\n", builder)
+	if installed, err := os.ReadFile(filepath.Join(builder, apk.InstalledDB)); err == nil {
+		h.Write(installed)
+	}
+	fmt.Fprintf(h, "config %d\n", len(config))
+	h.Write(config)
+
+	err := filepath.Walk(templateDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(templateDir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "scripts" || rel == scripts.ManifestFile {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "link %s %s\n", filepath.ToSlash(rel), link)
+		case info.Mode().IsRegular():
+			fmt.Fprintf(h, "file %s %o %d\n", filepath.ToSlash(rel), info.Mode().Perm(), info.Size())
+			return hashFile(h, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("error computing cache key of %s: %w", templateDir, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// StepKey вычисляет ключ скрипта по ключу предыдущего шага
+func StepKey(prev, stage string, step scripts.Step) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s/%s\n", prev, stage, step.Name)
+
+	entry, err := yaml.Marshal(step.Script)
+	if err != nil {
+		return "", err
+	}
+	h.Write(entry)
+
+	if err := hashFile(h, step.Path); err != nil {
+		return "", fmt.Errorf("error computing cache key of %s: %w", step.Name, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Has сообщает, есть ли в кэше слой с ключом key
+func (c *Cache) Has(key string) bool {
+	_, err := os.Stat(filepath.Join(c.dir, key, layerFile))
+	return err == nil
+}
+
+// Restore накладывает слои keys по порядку на каталог dest
+func (c *Cache) Restore(keys []string, dest string) error {
+	for _, key := range keys {
+		layer := filepath.Join(c.dir, key)
+
+		removed, err := os.ReadFile(filepath.Join(layer, removedFile))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error reading cached layer %s: %w", key, err)
+		}
+		for _, rel := range strings.Split(string(removed), "\x00") {
+			if rel != "" {
+				os.RemoveAll(filepath.Join(dest, rel))
+			}
+		}
+
+		if err := tar(dest, "--extract", "--file", filepath.Join(layer, layerFile)); err != nil {
+			return fmt.Errorf("error restoring cached layer %s: %w", key, err)
+		}
+
+		// Время изменения каталога слоя - время последнего использования (для sysweaver gc)
+		now := time.Now()
+		os.Chtimes(layer, now, now)
+	}
+	return nil
+}
+
+// Layer - слой в кэше
+type Layer struct {
+	Key  string
+	Size int64
+	Used time.Time // Время сохранения или последнего восстановления
+}
+
+// Layers возвращает слои кэша. Каталоги незавершенных сохранений (.tmp-*)
+// возвращаются с ключом-именем каталога, чтобы их тоже можно было удалить.
+func (c *Cache) Layers() ([]Layer, error) {
+	entries, err := os.ReadDir(c.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading layer cache: %w", err)
+	}
+
+	var layers []Layer
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !e.IsDir() {
+			continue
+		}
+		layer := Layer{Key: e.Name(), Used: info.ModTime()}
+		filepath.WalkDir(filepath.Join(c.dir, e.Name()), func(path string, d fs.DirEntry, err error) error {
+			if err == nil && d.Type().IsRegular() {
+				if info, err := d.Info(); err == nil {
+					layer.Size += info.Size()
+				}
+			}
+			return nil
+		})
+		layers = append(layers, layer)
+	}
+	return layers, nil
+}
+
+// Remove удаляет слой из кэша
+func (c *Cache) Remove(key string) error {
+	if key == "" || filepath.Base(key) != key {
+		return fmt.Errorf("invalid layer key: %q", key)
+	}
+	if err := os.RemoveAll(filepath.Join(c.dir, key)); err != nil {
+		return fmt.Errorf("error removing cached layer %s: %w", key, err)
+	}
+	return nil
+}
+
+// Store сохраняет под ключом key изменения дерева root по сравнению с
+// состоянием before и возвращает новое состояние дерева
+func (c *Cache) Store(key, root string, before Index) (Index, error) {
+	after, err := Scan(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var changed, removed []string
+	for rel, entry := range after {
+		if prev, ok := before[rel]; !ok || prev != entry {
+			changed = append(changed, rel)
+		}
+	}
+	for rel := range before {
+		if _, ok := after[rel]; !ok {
+			removed = append(removed, rel)
+		}
+	}
+	sort.Strings(changed)
+	sort.Strings(removed)
+
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating layer cache: %w", err)
+	}
+	tmp, err := os.MkdirTemp(c.dir, ".tmp-")
+	if err != nil {
+		return nil, fmt.Errorf("error creating layer cache: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	list := filepath.Join(tmp, "files")
+	if err := os.WriteFile(list, nullList(changed), 0644); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(tmp, removedFile), nullList(removed), 0644); err != nil {
+		return nil, err
+	}
+	if err := tar(root, "--create", "--file", filepath.Join(tmp, layerFile), "--no-recursion", "--null", "--files-from", list); err != nil {
+		return nil, fmt.Errorf("error saving layer %s: %w", key, err)
+	}
+	os.Remove(list)
+	if err := writeSums(tmp); err != nil {
+		return nil, fmt.Errorf("error saving layer %s: %w", key, err)
+	}
+
+	// Слой появляется в кэше целиком или не появляется вовсе
+	dest := filepath.Join(c.dir, key)
+	os.RemoveAll(dest)
+	if err := os.Rename(tmp, dest); err != nil {
+		return nil, fmt.Errorf("error saving layer %s: %w", key, err)
+	}
+	return after, nil
+}
+
+// Index - состояние файлов дерева для поиска изменений
+type Index map[string]entry
+
+type entry struct {
+	mode         os.FileMode
+	size         int64
+	mtime, ctime int64
+	ino, rdev    uint64
+	link         string
+}
+
+// Scan возвращает состояние файлов дерева root
+func Scan(root string) (Index, error) {
+	index := make(Index)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		e := entry{mode: info.Mode(), size: info.Size(), mtime: info.ModTime().UnixNano()}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			e.ctime = stat.Ctim.Nano()
+			e.ino = stat.Ino
+			e.rdev = uint64(stat.Rdev)
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			e.link, _ = os.Readlink(path)
+		}
+		index["./"+filepath.ToSlash(rel)] = e
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error scanning %s: %w", root, err)
+	}
+	return index, nil
+}
+
+// tar запускает GNU tar в каталоге dir с сохранением владельцев, устройств
+// (whiteout overlay) и xattrs (непрозрачные каталоги overlay)
+func tar(dir string, args ...string) error {
+	args = append([]string{"-C", dir, "--numeric-owner", "--xattrs", "--xattrs-include=*"}, args...)
+	cmd := exec.Command("tar", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func nullList(paths []string) []byte {
+	var b bytes.Buffer
+	for _, path := range paths {
+		b.WriteString(path)
+		b.WriteByte(0)
+	}
+	return b.Bytes()
+}
+
+func hashFile(w io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(w, bufio.NewReader(file))
+	return err
+}
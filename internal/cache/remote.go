@@ -0,0 +1,238 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Общий кэш слоев позволяет CI и разработчикам использовать слои, собранные
+// на других машинах. Слой хранится в удаленном хранилище как набор файлов
+// layer.tar, removed и SHA256SUMS; загруженный слой принимается, только если
+// его файлы совпадают с SHA256SUMS.
+//
+//	s3://bucket/prefix          объекты prefix/<key>/<файл> (утилита aws)
+//	oci://registry/repository   артефакт repository:<key> (утилита oras)
+
+// Файл с контрольными суммами файлов слоя
+const sumsFile = "SHA256SUMS"
+
+// Тип артефакта слоя в OCI-реестре
+const ociArtifactType = "application/vnd.sysweaver.layer.v1"
+
+// layerFiles - файлы слоя, передаваемые в удаленное хранилище
+var layerFiles = []string{layerFile, removedFile, sumsFile}
+
+// Remote - удаленное хранилище слоев
+type Remote interface {
+	// Pull загружает файлы слоя key в каталог dir; false - слоя в хранилище нет
+	Pull(key, dir string) (bool, error)
+	// Push выгружает файлы слоя из каталога dir под ключом key
+	Push(key, dir string) error
+	String() string
+}
+
+// OpenRemote возвращает удаленное хранилище по URL s3://bucket/prefix или
+// oci://registry/repository
+func OpenRemote(url string) (Remote, error) {
+	switch {
+	case strings.HasPrefix(url, "s3://"):
+		bucket, prefix, _ := strings.Cut(strings.TrimPrefix(url, "s3://"), "/")
+		if bucket == "" {
+			return nil, fmt.Errorf("invalid cache remote %s: bucket is required", url)
+		}
+		return s3Remote{bucket: bucket, prefix: strings.Trim(prefix, "/")}, nil
+	case strings.HasPrefix(url, "oci://"):
+		repository := strings.Trim(strings.TrimPrefix(url, "oci://"), "/")
+		if !strings.Contains(repository, "/") {
+			return nil, fmt.Errorf("invalid cache remote %s: expected oci://registry/repository", url)
+		}
+		return ociRemote{repository: repository}, nil
+	}
+	return nil, fmt.Errorf("unsupported cache remote %s: expected s3:// or oci:// URL", url)
+}
+
+// Fetch загружает слой key из удаленного хранилища в кэш и проверяет его
+// целостность. Возвращает false, если слоя в хранилище нет.
+func (c *Cache) Fetch(remote Remote, key string) (bool, error) {
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return false, fmt.Errorf("error creating layer cache: %w", err)
+	}
+	tmp, err := os.MkdirTemp(c.dir, ".tmp-")
+	if err != nil {
+		return false, fmt.Errorf("error creating layer cache: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	found, err := remote.Pull(key, tmp)
+	if err != nil || !found {
+		return false, err
+	}
+	if err := verifySums(tmp); err != nil {
+		return false, fmt.Errorf("layer %s from %s failed verification: %w", key, remote, err)
+	}
+
+	dest := filepath.Join(c.dir, key)
+	os.RemoveAll(dest)
+	if err := os.Rename(tmp, dest); err != nil {
+		return false, fmt.Errorf("error saving layer %s: %w", key, err)
+	}
+	return true, nil
+}
+
+// Push выгружает слой key из кэша в удаленное хранилище
+func (c *Cache) Push(remote Remote, key string) error {
+	dir := filepath.Join(c.dir, key)
+	// Слои, сохраненные до появления SHA256SUMS
+	if _, err := os.Stat(filepath.Join(dir, sumsFile)); os.IsNotExist(err) {
+		if err := writeSums(dir); err != nil {
+			return err
+		}
+	}
+	if err := remote.Push(key, dir); err != nil {
+		return fmt.Errorf("error pushing layer %s to %s: %w", key, remote, err)
+	}
+	return nil
+}
+
+// writeSums записывает контрольные суммы файлов слоя в формате sha256sum
+func writeSums(dir string) error {
+	var b bytes.Buffer
+	for _, name := range []string{layerFile, removedFile} {
+		sum, err := sumFile(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "%s  %s\n", sum, name)
+	}
+	return os.WriteFile(filepath.Join(dir, sumsFile), b.Bytes(), 0644)
+}
+
+// verifySums сверяет файлы слоя с SHA256SUMS
+func verifySums(dir string) error {
+	data, err := os.ReadFile(filepath.Join(dir, sumsFile))
+	if err != nil {
+		return err
+	}
+
+	expected := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		sum, name, ok := strings.Cut(line, "  ")
+		if !ok {
+			return fmt.Errorf("malformed %s", sumsFile)
+		}
+		expected[name] = sum
+	}
+
+	for _, name := range []string{layerFile, removedFile} {
+		want, ok := expected[name]
+		if !ok {
+			return fmt.Errorf("%s has no checksum for %s", sumsFile, name)
+		}
+		got, err := sumFile(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		if got != want {
+			return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", name, want, got)
+		}
+	}
+	return nil
+}
+
+func sumFile(path string) (string, error) {
+	h := sha256.New()
+	if err := hashFile(h, path); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// s3Remote - слои в S3 или совместимом хранилище. Учетные данные, регион и
+// адрес хранилища берутся из окружения утилиты aws (AWS_PROFILE, AWS_REGION,
+// AWS_ENDPOINT_URL).
+type s3Remote struct {
+	bucket, prefix string
+}
+
+func (r s3Remote) String() string {
+	return "s3://" + path.Join(r.bucket, r.prefix)
+}
+
+func (r s3Remote) url(key, name string) string {
+	return "s3://" + path.Join(r.bucket, r.prefix, key, name)
+}
+
+func (r s3Remote) Pull(key, dir string) (bool, error) {
+	// SHA256SUMS выгружается последним, поэтому его наличие означает полный слой
+	for _, name := range []string{sumsFile, layerFile, removedFile} {
+		if err := run("", "aws", "s3", "cp", "--only-show-errors", r.url(key, name), filepath.Join(dir, name)); err != nil {
+			if name == sumsFile && notFound(err) {
+				return false, nil
+			}
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (r s3Remote) Push(key, dir string) error {
+	for _, name := range layerFiles {
+		if err := run("", "aws", "s3", "cp", "--only-show-errors", filepath.Join(dir, name), r.url(key, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ociRemote - слои как артефакты OCI-реестра с тегом-ключом. Авторизация -
+// через конфигурацию docker/oras (oras login).
+type ociRemote struct {
+	repository string
+}
+
+func (r ociRemote) String() string {
+	return "oci://" + r.repository
+}
+
+func (r ociRemote) Pull(key, dir string) (bool, error) {
+	if err := run("", "oras", "pull", "--output", dir, r.repository+":"+key); err != nil {
+		if notFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (r ociRemote) Push(key, dir string) error {
+	args := []string{"push", r.repository + ":" + key, "--artifact-type", ociArtifactType}
+	for _, name := range layerFiles {
+		args = append(args, name+":application/octet-stream")
+	}
+	return run(dir, "oras", args...)
+}
+
+// run выполняет утилиту хранилища в каталоге dir
+func run(dir, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %s: %w (%s)", name, args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// notFound сообщает, что ошибка утилиты означает отсутствие объекта
+func notFound(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "not found") || strings.Contains(msg, "404") || strings.Contains(msg, "nosuchkey")
+}
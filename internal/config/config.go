@@ -1,39 +1,200 @@
 package config
 
 import (
+	"bytes"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"gopkg.in/yaml.v3"
+
+	"sysweaver/internal/logging"
 )
 
-// LoadConfig загружает конфигурацию из YAML-файла в указанную структуру
+// Options - параметры загрузки конфигурации сборки.
+// Приоритет источников по возрастанию: Layers по порядку, сам файл
+// (с его include), профили, переопределения --set.
+type Options struct {
+	Layers    []string // Базовые файлы под основным (DefaultLayers); отсутствующие пропускаются
+	Profiles  []string // Профили из секции profiles, применяемые по порядку (--profile)
+	Overrides []string // Переопределения path=value (--set)
+
+	Vars        []string // Переменные NAME=VALUE для ${NAME} (--var)
+	IncludeDirs []string // Общие каталоги фрагментов include (--include-dir)
+
+	// AllowUnknownFields отключает строгий разбор: неизвестные ключи
+	// игнорируются, как в старых версиях (--allow-unknown-fields)
+	AllowUnknownFields bool
+
+	Logger *slog.Logger // Журнал предупреждений загрузки; nil - slog.Default()
+}
+
+// DefaultLayers возвращает системный и пользовательский файлы конфигурации
+func DefaultLayers() []string {
+	layers := []string{"/etc/sysweaver/config.yaml"}
+	if dir, err := os.UserConfigDir(); err == nil {
+		layers = append(layers, filepath.Join(dir, "sysweaver", "config.yaml"))
+	}
+	return layers
+}
+
+// LoadSection загружает секцию key из файлов layers (отсутствующие
+// пропускаются) в out; значения более поздних файлов перекрывают ранние.
+// Остальные секции файлов не проверяются.
+func LoadSection(layers []string, key string, out interface{}) error {
+	for _, path := range layers {
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error reading config file: %w", err)
+		}
+
+		var doc map[string]yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("error parsing %s: %w", path, err)
+		}
+		if node, ok := doc[key]; ok {
+			if err := node.Decode(out); err != nil {
+				return fmt.Errorf("error parsing %s section of %s: %w", key, path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// LoadConfig загружает конфигурацию из YAML-файла (с учетом include) в указанную структуру
 func LoadConfig(path string, config interface{}) error {
-	// Проверяем существование файла
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		return fmt.Errorf("config file not found: %s", path)
+	return Load(path, config, Options{})
+}
+
+// Load загружает конфигурацию и применяет к ней опции
+func Load(path string, config interface{}, opts Options) error {
+	_, err := load(path, config, opts)
+	return err
+}
+
+// Resolve загружает конфигурацию как Load и возвращает итоговый YAML после
+// слияния всех источников. С showOrigin у значений указывается, откуда они взяты.
+func Resolve(path string, config interface{}, opts Options, showOrigin bool) ([]byte, error) {
+	doc, err := load(path, config, opts)
+	if err != nil {
+		return nil, err
+	}
+	if doc.root == nil {
+		return nil, nil
+	}
+
+	if showOrigin {
+		annotateOrigins(doc, doc.root)
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc.root); err != nil {
+		return nil, fmt.Errorf("error encoding config: %w", err)
 	}
+	encoder.Close()
+	return buf.Bytes(), nil
+}
 
-	// Чтение файла
-	data, err := os.ReadFile(path)
+func load(path string, config interface{}, opts Options) (*document, error) {
+	doc, err := loadDocument(path, opts.Layers, opts.IncludeDirs)
 	if err != nil {
-		return fmt.Errorf("error reading config file: %w", err)
+		return nil, err
 	}
 
-	// Распаковка YAML в структуру
-	if err := yaml.Unmarshal(data, config); err != nil {
-		return fmt.Errorf("error parsing config file: %w", err)
+	// Старые схемы переводятся на текущую в памяти (только структурно:
+	// неизвестные ключи отклоняются ниже, а не угадываются)
+	if doc.root != nil {
+		from, changes, err := migrate(doc.root, config, false)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		// Предупреждение - в stderr, чтобы не смешиваться с выводом config resolve
+		if from < SchemaVersion {
+			attrs := []interface{}{"config", path}
+			// Последнее изменение - сама запись версии
+			if len(changes) > 1 {
+				attrs = append(attrs, "changes", strings.Join(changes[:len(changes)-1], "; "))
+			}
+			logging.OrDefault(opts.Logger).Warn(fmt.Sprintf("config uses schema version %d, run 'sysweaver migrate-config' to upgrade it to %d", from, SchemaVersion), attrs...)
+		}
 	}
 
-	return nil
+	if err := applyProfiles(doc, opts.Profiles); err != nil {
+		return nil, err
+	}
+	doc.finalize()
+
+	// Переопределения применяются до подстановки: --set name=${site} допустимо
+	if err := applyOverrides(doc, opts.Overrides); err != nil {
+		return nil, err
+	}
+	if doc.root == nil {
+		return doc, validateNode(config, doc)
+	}
+
+	if err := substitute(doc, opts.Vars); err != nil {
+		return nil, err
+	}
+
+	if err := doc.root.Decode(config); err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+
+	// Неизвестные ключи (опечатки вроде filesytem:) отклоняются,
+	// иначе они молча превращаются в пустые значения
+	if !opts.AllowUnknownFields {
+		if err := checkUnknownFields(config, doc); err != nil {
+			return nil, err
+		}
+	}
+
+	return doc, validateNode(config, doc)
 }
 
-// ValidateConfig проверяет валидность загруженной конфигурации
+// annotateOrigins добавляет к значениям комментарии с файлом и строкой
+func annotateOrigins(doc *document, node *yaml.Node) {
+	origin := func(n *yaml.Node) string {
+		if n.Line == 0 {
+			return doc.fileOf(n)
+		}
+		return fmt.Sprintf("%s:%d", doc.fileOf(n), n.Line)
+	}
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if value.Kind == yaml.ScalarNode {
+				value.LineComment = origin(value)
+			} else {
+				key.LineComment = origin(key)
+				annotateOrigins(doc, value)
+			}
+		}
+	case yaml.SequenceNode:
+		// Комментарии к элементам возможны только в блочной записи
+		node.Style &^= yaml.FlowStyle
+		for _, item := range node.Content {
+			if item.Kind == yaml.ScalarNode {
+				item.LineComment = origin(item)
+			} else {
+				annotateOrigins(doc, item)
+			}
+		}
+	}
+}
+
+// ValidateConfig проверяет конфигурацию по правилам тегов validate.
+// Без исходного YAML ошибки содержат только путь к полю.
 func ValidateConfig(config interface{}) error {
-	// Здесь будет логика валидации в зависимости от типа конфигурации
-	// Для начала реализуем заглушку
-	return nil
+	return validateNode(config, &document{})
 }
 
 // LoadTemplateConfig загружает конфигурацию из шаблона
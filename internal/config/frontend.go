@@ -0,0 +1,56 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Фронтенды конфигурации. Файл с расширением из frontends вычисляется
+// внешней программой в YAML, который дальше загружается как обычный
+// config.yaml (include, профили, --set, проверка схемы). Так конфигурацию
+// сложного продукта можно писать на CUE с циклами, условиями и
+// ограничениями типов, например, генерируя варианты разметки дисков:
+//
+//	name:    "edge"
+//	version: "2.1"
+//	outputs: [for f in ["qcow2", "vmdk", "vhdx"] {type: f}]
+//
+// Номера строк в ошибках проверки относятся к вычисленному YAML.
+var frontends = map[string][]string{
+	".cue": {"cue", "export", "--out", "yaml"},
+}
+
+// IsFrontend сообщает, вычисляется ли файл конфигурации внешней программой
+func IsFrontend(path string) bool {
+	_, ok := frontends[filepath.Ext(path)]
+	return ok
+}
+
+// ReadSource читает файл конфигурации; файлы фронтендов вычисляются в YAML
+func ReadSource(path string) ([]byte, error) {
+	command, ok := frontends[filepath.Ext(path)]
+	if !ok {
+		return os.ReadFile(path)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	if _, err := exec.LookPath(command[0]); err != nil {
+		return nil, fmt.Errorf("%s requires %s in PATH: %w", filepath.Base(path), command[0], err)
+	}
+
+	// Файл вычисляется в своем каталоге, чтобы работали относительные импорты
+	cmd := exec.Command(command[0], append(command[1:], filepath.Base(path))...)
+	cmd.Dir = filepath.Dir(path)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	data, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error evaluating %s with %s: %w: %s", path, command[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return data, nil
+}
@@ -0,0 +1,367 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Включение фрагментов конфигурации:
+//
+//	include:
+//	  - common/partitions.yaml
+//	  - packages/*.yaml
+//
+// Пути ищутся относительно включающего файла, затем в общих каталогах
+// (Options.IncludeDirs из --include-dir и SYSWEAVER_INCLUDE_PATH). Порядок слияния: фрагменты
+// в порядке перечисления (совпавшие с шаблоном - по имени), затем сам файл.
+// Каждый следующий слой перекрывает предыдущие: отображения сливаются
+// рекурсивно, списки и скаляры заменяются целиком. Ключ с суффиксом +
+// (packages+:) дописывает элементы к списку из предыдущих слоев, с суффиксом -
+// (packages-:) удаляет их из него.
+
+// includeKey - директива включения в корне файла
+const includeKey = "include"
+
+// templateKeys - директивы шаблона, которые разрешаются до загрузки конфигурации
+var templateKeys = map[string]bool{"extends": true, "layers": true}
+
+// document - конфигурация, собранная из файла и его include
+type document struct {
+	path  string                // Основной файл
+	root  *yaml.Node            // Корень (nil для пустого файла)
+	files map[*yaml.Node]string // Файл, из которого взят узел
+}
+
+// fileOf возвращает файл, в котором задан узел
+func (d *document) fileOf(node *yaml.Node) string {
+	if file, ok := d.files[node]; ok {
+		return file
+	}
+	return d.path
+}
+
+// loadDocument читает файл конфигурации и рекурсивно применяет include
+func loadDocument(path string, layers, shared []string) (*document, error) {
+	l := &loader{files: make(map[*yaml.Node]string), includeDirs: shared}
+
+	// Базовые слои (системный и пользовательский файлы) необязательны
+	var base *yaml.Node
+	for _, layer := range layers {
+		if _, err := os.Stat(layer); os.IsNotExist(err) {
+			continue
+		}
+		root, err := l.load(layer)
+		if err != nil {
+			return nil, err
+		}
+		if root != nil {
+			base = l.merge(base, root)
+		}
+	}
+
+	root, err := l.load(path)
+	if err != nil {
+		return nil, err
+	}
+	if base != nil {
+		if root == nil {
+			root = base
+		} else {
+			root = l.merge(base, root)
+		}
+	}
+	return &document{path: path, root: root, files: l.files}, nil
+}
+
+type loader struct {
+	files       map[*yaml.Node]string
+	stack       []string // Цепочка включений для обнаружения циклов
+	includeDirs []string // Общие каталоги фрагментов из параметров загрузки
+}
+
+func (l *loader) load(path string) (*yaml.Node, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving %s: %w", path, err)
+	}
+	for _, parent := range l.stack {
+		if parent == abs {
+			return nil, fmt.Errorf("include cycle: %s -> %s", strings.Join(l.stack, " -> "), abs)
+		}
+	}
+	l.stack = append(l.stack, abs)
+	defer func() { l.stack = l.stack[:len(l.stack)-1] }()
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, fmt.Errorf("config file not found: %s", path)
+	}
+
+	data, err := ReadSource(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+	if len(document.Content) == 0 {
+		return nil, nil
+	}
+
+	root := document.Content[0]
+	l.mark(root, path)
+	if root.Kind != yaml.MappingNode {
+		return root, nil
+	}
+
+	// Директивы шаблона (extends) разрешает пакет template
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if templateKeys[root.Content[i].Value] {
+			root.Content = append(root.Content[:i:i], root.Content[i+2:]...)
+			i -= 2
+		}
+	}
+
+	includes, err := takeIncludes(root, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var merged *yaml.Node
+	for _, include := range includes {
+		paths, err := resolveInclude(include, filepath.Dir(path), l.includeDirs)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		for _, includePath := range paths {
+			fragment, err := l.load(includePath)
+			if err != nil {
+				return nil, err
+			}
+			if fragment != nil {
+				merged = l.merge(merged, fragment)
+			}
+		}
+	}
+
+	if merged == nil {
+		return root, nil
+	}
+	return l.merge(merged, root), nil
+}
+
+// mark запоминает файл происхождения для всех узлов дерева
+func (l *loader) mark(node *yaml.Node, path string) {
+	l.files[node] = path
+	for _, child := range node.Content {
+		l.mark(child, path)
+	}
+}
+
+// takeIncludes извлекает директиву include из корня файла
+func takeIncludes(root *yaml.Node, path string) ([]string, error) {
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != includeKey {
+			continue
+		}
+
+		value := root.Content[i+1]
+		root.Content = append(root.Content[:i:i], root.Content[i+2:]...)
+
+		var includes []string
+		switch value.Kind {
+		case yaml.ScalarNode:
+			includes = []string{value.Value}
+		case yaml.SequenceNode:
+			if err := value.Decode(&includes); err != nil {
+				return nil, fmt.Errorf("%s:%d: include must be a list of paths", path, value.Line)
+			}
+		default:
+			return nil, fmt.Errorf("%s:%d: include must be a path or a list of paths", path, value.Line)
+		}
+		return includes, nil
+	}
+	return nil, nil
+}
+
+// resolveInclude находит файлы фрагмента: относительно включающего файла,
+// затем в общих каталогах shared. Шаблоны раскрываются в отсортированный список.
+func resolveInclude(include, dir string, shared []string) ([]string, error) {
+	if filepath.IsAbs(include) {
+		return globInclude(include)
+	}
+
+	searched := append([]string{dir}, includeDirs(shared)...)
+	for _, base := range searched {
+		paths, err := globInclude(filepath.Join(base, include))
+		if err != nil {
+			return nil, err
+		}
+		if len(paths) > 0 {
+			return paths, nil
+		}
+	}
+
+	return nil, fmt.Errorf("include %q not found (searched: %s)", include, strings.Join(searched, ", "))
+}
+
+func globInclude(pattern string) ([]string, error) {
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid include pattern %q: %w", pattern, err)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// includeDirs возвращает общие каталоги: заданные в параметрах, затем
+// SYSWEAVER_INCLUDE_PATH
+func includeDirs(shared []string) []string {
+	dirs := append([]string{}, shared...)
+	for _, dir := range filepath.SplitList(os.Getenv("SYSWEAVER_INCLUDE_PATH")) {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// merge накладывает overlay на base и возвращает результат.
+// Ключ name+ без списка в base сохраняет суффикс, чтобы дописать элементы
+// на следующем уровне включений; оставшиеся суффиксы снимает finalize.
+func (l *loader) merge(base, overlay *yaml.Node) *yaml.Node {
+	if overlay.Kind != yaml.MappingNode {
+		return overlay
+	}
+	if base == nil || base.Kind != yaml.MappingNode {
+		base = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	}
+
+	result := &yaml.Node{
+		Kind:   yaml.MappingNode,
+		Tag:    "!!map",
+		Line:   overlay.Line,
+		Column: overlay.Column,
+	}
+	l.files[result] = l.files[overlay]
+	result.Content = append(result.Content, base.Content...)
+
+	for i := 0; i+1 < len(overlay.Content); i += 2 {
+		key, value := overlay.Content[i], overlay.Content[i+1]
+		name, op := splitListKey(key.Value)
+		appendList, removeList := op == "+", op == "-"
+
+		// Ищем ключ name или еще не примененное name+ (name-)
+		index, pending, pendingRemove := -1, false, false
+		for j := 0; j+1 < len(result.Content); j += 2 {
+			if existingName, existingOp := splitListKey(result.Content[j].Value); existingName == name {
+				index, pending, pendingRemove = j, existingOp == "+", existingOp == "-"
+				break
+			}
+		}
+
+		var existing *yaml.Node
+		if index >= 0 && !pendingRemove {
+			existing = result.Content[index+1]
+		}
+
+		switch {
+		case removeList && existing != nil && existing.Kind == yaml.SequenceNode && value.Kind == yaml.SequenceNode:
+			removed := make(map[string]bool, len(value.Content))
+			for _, item := range value.Content {
+				removed[item.Value] = true
+			}
+			kept := *existing
+			kept.Content = nil
+			for _, item := range existing.Content {
+				if item.Kind != yaml.ScalarNode || !removed[item.Value] {
+					kept.Content = append(kept.Content, item)
+				}
+			}
+			value = &kept
+			l.files[value] = l.files[existing]
+			// Удаление из еще не примененного списка оставляет его отложенным
+			key, removeList, appendList = result.Content[index], false, pending
+		case appendList && existing != nil && existing.Kind == yaml.SequenceNode && value.Kind == yaml.SequenceNode:
+			joined := *value
+			joined.Content = append(append([]*yaml.Node{}, existing.Content...), value.Content...)
+			value = &joined
+			l.files[value] = l.files[overlay.Content[i+1]]
+			// Дописывание к еще не примененному списку остается отложенным
+			appendList = pending
+		case value.Kind == yaml.MappingNode:
+			if pending {
+				existing = nil
+			}
+			value = l.merge(existing, value)
+		}
+
+		if key.Value != name && !appendList && !removeList {
+			key = l.rename(key, name)
+		}
+
+		if index >= 0 {
+			result.Content[index], result.Content[index+1] = key, value
+		} else {
+			result.Content = append(result.Content, key, value)
+		}
+	}
+
+	return result
+}
+
+// finalize снимает оставшиеся суффиксы + и - после всех слияний
+func (d *document) finalize() {
+	if d.root != nil {
+		l := &loader{files: d.files}
+		d.root = l.finalize(d.root)
+	}
+}
+
+// finalize снимает суффиксы + у ключей, которым не нашлось списка для
+// дописывания, и убирает ключи с суффиксом -, которым не нашлось списка
+func (l *loader) finalize(node *yaml.Node) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return node
+	}
+
+	result := *node
+	l.files[&result] = l.files[node]
+	result.Content = make([]*yaml.Node, 0, len(node.Content))
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i]
+		name, op := splitListKey(key.Value)
+		switch op {
+		case "-":
+			continue
+		case "+":
+			key = l.rename(key, name)
+		}
+		result.Content = append(result.Content, key, l.finalize(node.Content[i+1]))
+	}
+	return &result
+}
+
+// splitListKey отделяет от ключа суффикс дописывания (+) или удаления (-)
+func splitListKey(key string) (string, string) {
+	for _, op := range []string{"+", "-"} {
+		if name, ok := strings.CutSuffix(key, op); ok && name != "" {
+			return name, op
+		}
+	}
+	return key, ""
+}
+
+// rename возвращает копию узла ключа с новым именем
+func (l *loader) rename(key *yaml.Node, name string) *yaml.Node {
+	renamed := *key
+	renamed.Value = name
+	l.files[&renamed] = l.files[key]
+	return &renamed
+}
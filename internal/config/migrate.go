@@ -0,0 +1,275 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SchemaVersion - текущая версия схемы конфигурации сборки.
+// Файлы без schemaVersion считаются версией 1.
+const SchemaVersion = 2
+
+// schemaVersionKey - поле версии схемы в корне файла
+const schemaVersionKey = "schemaVersion"
+
+// migration переводит документ с версии from на from+1 и возвращает список изменений.
+// Миграции с rewriteOnly угадывают намерения пользователя и применяются только
+// в migrate-config, где результат просматривается; при загрузке - лишь структурные.
+type migration struct {
+	from        int
+	rewriteOnly bool
+	apply       func(root *yaml.Node, typ reflect.Type) []string
+}
+
+var migrations = []migration{
+	// Версия 1 игнорировала неизвестные ключи, версия 2 их отклоняет.
+	// При загрузке такие ключи отклоняет checkUnknownFields.
+	{from: 1, rewriteOnly: true, apply: pruneUnknownKeys},
+}
+
+// MigrationResult - результат миграции файла
+type MigrationResult struct {
+	From    int
+	To      int
+	Changes []string
+	Data    []byte // Содержимое файла в новой схеме
+}
+
+// MigrateFile переводит файл конфигурации на текущую схему.
+// config задает тип конфигурации (например, *structures.BuildConfig).
+func MigrateFile(path string, config interface{}) (*MigrationResult, error) {
+	if IsFrontend(path) {
+		return nil, fmt.Errorf("%s is evaluated to YAML by an external tool and cannot be migrated in place", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+	if len(document.Content) == 0 || document.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s: config must be a mapping", path)
+	}
+
+	from, changes, err := migrate(document.Content[0], config, true)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	result := &MigrationResult{From: from, To: SchemaVersion, Changes: changes, Data: data}
+	if len(changes) == 0 {
+		return result, nil
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&document); err != nil {
+		return nil, fmt.Errorf("error encoding migrated config: %w", err)
+	}
+	encoder.Close()
+	result.Data = buf.Bytes()
+
+	return result, nil
+}
+
+// migrate применяет миграции к корню документа и возвращает исходную версию.
+// Без rewrite пропускаются миграции rewriteOnly.
+// Для типов без поля schemaVersion (jail.yaml) миграции не применяются.
+func migrate(root *yaml.Node, config interface{}, rewrite bool) (int, []string, error) {
+	typ := reflect.TypeOf(config)
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct || !hasYAMLField(typ, schemaVersionKey) || root.Kind != yaml.MappingNode {
+		return SchemaVersion, nil, nil
+	}
+
+	version, err := schemaVersion(root)
+	if err != nil {
+		return 0, nil, err
+	}
+	if version > SchemaVersion {
+		return 0, nil, fmt.Errorf("config schema version %d is newer than supported version %d, upgrade SysWeaver", version, SchemaVersion)
+	}
+
+	var changes []string
+	for _, m := range migrations {
+		if m.from >= version && m.from < SchemaVersion && (rewrite || !m.rewriteOnly) {
+			changes = append(changes, m.apply(root, typ)...)
+		}
+	}
+
+	if version < SchemaVersion {
+		setSchemaVersion(root, SchemaVersion)
+		changes = append(changes, fmt.Sprintf("set %s: %d (was %d)", schemaVersionKey, SchemaVersion, version))
+	}
+
+	return version, changes, nil
+}
+
+// schemaVersion читает версию схемы из корня документа
+func schemaVersion(root *yaml.Node) (int, error) {
+	value := mappingValue(root, schemaVersionKey)
+	if value == nil {
+		return 1, nil
+	}
+	version, err := strconv.Atoi(value.Value)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("line %d: invalid %s %q", value.Line, schemaVersionKey, value.Value)
+	}
+	return version, nil
+}
+
+// setSchemaVersion записывает версию схемы первым ключом документа
+func setSchemaVersion(root *yaml.Node, version int) {
+	if value := mappingValue(root, schemaVersionKey); value != nil {
+		value.Value = strconv.Itoa(version)
+		return
+	}
+	key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: schemaVersionKey}
+	value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(version)}
+
+	// Комментарий в начале файла остается над новым первым ключом
+	if len(root.Content) > 0 {
+		key.HeadComment, root.Content[0].HeadComment = root.Content[0].HeadComment, ""
+	}
+	root.Content = append([]*yaml.Node{key, value}, root.Content...)
+}
+
+// Директивы корня документа, не являющиеся полями конфигурации
+var directiveKeys = map[string]bool{includeKey: true, varsKey: true, profilesKey: true, "extends": true, "layers": true}
+
+// pruneUnknownKeys удаляет ключи, которым нет поля в схеме.
+// Ключи, похожие на известные (опечатки вроде filesytem), переименовываются.
+func pruneUnknownKeys(root *yaml.Node, typ reflect.Type) []string {
+	var changes []string
+	pruneNode(root, typ, "", &changes)
+	return changes
+}
+
+func pruneNode(node *yaml.Node, typ reflect.Type, path string, changes *[]string) {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+
+	switch {
+	case typ.Kind() == reflect.Slice && node.Kind == yaml.SequenceNode:
+		for i, item := range node.Content {
+			pruneNode(item, typ.Elem(), fmt.Sprintf("%s[%d]", path, i), changes)
+		}
+		return
+	case typ.Kind() != reflect.Struct || node.Kind != yaml.MappingNode:
+		return
+	}
+
+	known := make(map[string]bool)
+	collectKeys(typ, known)
+
+	kept := node.Content[:0]
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		name, _ := splitListKey(key.Value)
+
+		keyPath := name
+		if path != "" {
+			keyPath = path + "." + name
+		}
+
+		if path == "" && directiveKeys[name] {
+			kept = append(kept, key, value)
+			continue
+		}
+		if !known[name] {
+			suggestion := closestKey(name, known)
+			if suggestion == "" || mappingValue(node, suggestion) != nil {
+				*changes = append(*changes, fmt.Sprintf("removed unknown key %s (line %d)", keyPath, key.Line))
+				continue
+			}
+			*changes = append(*changes, fmt.Sprintf("renamed %s to %s (line %d)", keyPath, suggestion, key.Line))
+			key.Value = strings.Replace(key.Value, name, suggestion, 1)
+			keyPath = strings.TrimSuffix(keyPath, name) + suggestion
+			name = suggestion
+		}
+
+		if field, ok := fieldTypeByYAMLName(typ, name); ok {
+			pruneNode(value, field, keyPath, changes)
+		}
+		kept = append(kept, key, value)
+	}
+	node.Content = kept
+}
+
+// hasYAMLField проверяет, есть ли у структуры поле с указанным именем в YAML
+func hasYAMLField(typ reflect.Type, name string) bool {
+	_, ok := fieldTypeByYAMLName(typ, name)
+	return ok
+}
+
+// fieldTypeByYAMLName возвращает тип поля структуры по имени в YAML
+func fieldTypeByYAMLName(typ reflect.Type, name string) (reflect.Type, bool) {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		fieldName, inline := yamlName(field)
+		if inline {
+			inner := field.Type
+			if inner.Kind() == reflect.Pointer {
+				inner = inner.Elem()
+			}
+			if inner.Kind() == reflect.Struct {
+				if found, ok := fieldTypeByYAMLName(inner, name); ok {
+					return found, true
+				}
+			}
+			continue
+		}
+		if fieldName == name {
+			return field.Type, true
+		}
+	}
+	return nil, false
+}
+
+// closestKey возвращает известный ключ на расстоянии не больше 2 правок
+func closestKey(name string, known map[string]bool) string {
+	best, bestDistance := "", 3
+	for _, candidate := range sortedKeys(known) {
+		if d := editDistance(name, candidate); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// editDistance - расстояние Левенштейна между строками
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// applyOverrides применяет переопределения вида path=value (--set).
+// Путь - ключи через точку, элементы списков по индексу: partitions.0.size
+// или partitions[0].size. Значение разбирается как YAML, так что можно
+// задать и список: packages=[vim,curl]. Недостающие отображения создаются.
+func applyOverrides(doc *document, overrides []string) error {
+	for _, override := range overrides {
+		path, raw, ok := strings.Cut(override, "=")
+		if !ok || path == "" {
+			return fmt.Errorf("invalid override %q (expected path=value)", override)
+		}
+
+		var parsed yaml.Node
+		if err := yaml.Unmarshal([]byte(raw), &parsed); err != nil {
+			return fmt.Errorf("invalid override %q: %w", override, err)
+		}
+		value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"}
+		if len(parsed.Content) > 0 {
+			value = parsed.Content[0]
+		}
+		markOverride(doc, value, override)
+
+		if doc.root == nil {
+			doc.root = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		}
+		mark := func(node *yaml.Node) { markOverride(doc, node, override) }
+		if err := setPath(doc.root, splitPath(path), value, mark); err != nil {
+			return fmt.Errorf("invalid override %q: %w", override, err)
+		}
+	}
+	return nil
+}
+
+// markOverride помечает узлы значения как заданные в командной строке
+func markOverride(doc *document, node *yaml.Node, override string) {
+	if doc.files == nil {
+		doc.files = make(map[*yaml.Node]string)
+	}
+	doc.files[node] = "--set " + override
+	node.Line, node.Column = 0, 0
+	for _, child := range node.Content {
+		markOverride(doc, child, override)
+	}
+}
+
+// splitPath разбивает путь a.b[0].c на элементы a, b, 0, c
+func splitPath(path string) []string {
+	path = strings.NewReplacer("[", ".", "]", "").Replace(path)
+	return strings.Split(path, ".")
+}
+
+// setPath заменяет значение по пути внутри node.
+// mark помечает созданные ключи, чтобы ошибки в них указывали на --set.
+func setPath(node *yaml.Node, path []string, value *yaml.Node, mark func(*yaml.Node)) error {
+	key := path[0]
+	last := len(path) == 1
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value != key {
+				continue
+			}
+			if last {
+				node.Content[i+1] = value
+				return nil
+			}
+			child := node.Content[i+1]
+			if child.Kind != yaml.MappingNode && child.Kind != yaml.SequenceNode {
+				child = containerFor(path[1])
+				node.Content[i+1] = child
+			}
+			return setPath(child, path[1:], value, mark)
+		}
+
+		keyNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}
+		mark(keyNode)
+		if last {
+			node.Content = append(node.Content, keyNode, value)
+			return nil
+		}
+		child := containerFor(path[1])
+		node.Content = append(node.Content, keyNode, child)
+		return setPath(child, path[1:], value, mark)
+
+	case yaml.SequenceNode:
+		index, err := strconv.Atoi(key)
+		if err != nil || index < 0 || index > len(node.Content) {
+			return fmt.Errorf("%q is not a valid index for a list of %d elements", key, len(node.Content))
+		}
+		if index == len(node.Content) {
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"})
+		}
+		if !last && node.Content[index].Kind != yaml.MappingNode && node.Content[index].Kind != yaml.SequenceNode {
+			node.Content[index] = containerFor(path[1])
+		}
+		if last {
+			node.Content[index] = value
+			return nil
+		}
+		return setPath(node.Content[index], path[1:], value, mark)
+	}
+
+	return fmt.Errorf("cannot set %s on a scalar value", key)
+}
+
+// containerFor создает недостающий узел: список для числового ключа, иначе отображение
+func containerFor(key string) *yaml.Node {
+	if _, err := strconv.Atoi(key); err == nil {
+		return &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	}
+	return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Профили - именованные наборы переопределений в одном config.yaml:
+//
+//	packages: [busybox, openrc]
+//	profiles:
+//	  dev:
+//	    packages+: [strace, gdb]
+//	  container:
+//	    packages-: [openrc]
+//	  minimal:
+//	    iso:
+//	      compression: zstd
+//
+// Выбранные профили (--profile) накладываются на конфигурацию по порядку
+// по тем же правилам, что и include. Без --profile секция игнорируется.
+
+// profilesKey - секция профилей в корне файла
+const profilesKey = "profiles"
+
+// applyProfiles извлекает секцию profiles и накладывает выбранные профили
+func applyProfiles(doc *document, names []string) error {
+	if doc.root == nil || doc.root.Kind != yaml.MappingNode {
+		if len(names) > 0 {
+			return fmt.Errorf("%s: profile %s not found: config has no profiles", doc.path, names[0])
+		}
+		return nil
+	}
+
+	var block *yaml.Node
+	root := doc.root
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == profilesKey {
+			block = root.Content[i+1]
+			root.Content = append(root.Content[:i:i], root.Content[i+2:]...)
+			break
+		}
+	}
+
+	profiles := make(map[string]*yaml.Node)
+	if block != nil {
+		if block.Kind != yaml.MappingNode {
+			return fmt.Errorf("%s:%d: profiles must be a mapping of profile names", doc.fileOf(block), block.Line)
+		}
+		for i := 0; i+1 < len(block.Content); i += 2 {
+			profiles[block.Content[i].Value] = block.Content[i+1]
+		}
+	}
+
+	l := &loader{files: doc.files}
+	for _, name := range names {
+		profile, ok := profiles[name]
+		if !ok {
+			return fmt.Errorf("%s: unknown profile %q (available: %s)", doc.path, name, strings.Join(profileNames(profiles), ", "))
+		}
+		if profile.Kind != yaml.MappingNode {
+			return fmt.Errorf("%s:%d: profile %s must be a mapping", doc.fileOf(profile), profile.Line, name)
+		}
+		doc.root = l.merge(doc.root, profile)
+	}
+
+	return nil
+}
+
+func profileNames(profiles map[string]*yaml.Node) []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return []string{"none"}
+	}
+	return names
+}
@@ -0,0 +1,347 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"sysweaver/internal/image"
+	"sysweaver/internal/retention"
+)
+
+// Правила проверки задаются тегом validate у полей структур конфигурации.
+// Правила разделяются запятыми:
+//
+//	required             - поле должно быть задано (для списков - непустым)
+//	required_if=type s3  - обязательно, если соседнее поле type равно s3
+//	oneof=a b c          - значение из списка
+//	size                 - размер вида 512M, 2G
+//	url                  - абсолютный URL со схемой и хостом
+//
+// Кроме required, правила к пустым значениям не применяются, а для списков
+// строк проверяется каждый элемент.
+
+// FieldError - ошибка в одном поле конфигурации
+type FieldError struct {
+	Path    string // Путь к полю (outputs[1].type)
+	File    string // Файл, в котором задано значение (с учетом include)
+	Line    int    // Строка в YAML (0, если неизвестна)
+	Column  int
+	Message string
+}
+
+func (e FieldError) Error() string {
+	switch {
+	case e.Line > 0 && e.File != "":
+		return fmt.Sprintf("%s:%d:%d: %s: %s", e.File, e.Line, e.Column, e.Path, e.Message)
+	case e.Line > 0:
+		return fmt.Sprintf("%d:%d: %s: %s", e.Line, e.Column, e.Path, e.Message)
+	case e.File != "":
+		return fmt.Sprintf("%s: %s: %s", e.File, e.Path, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// ValidationError - все ошибки проверки одного файла конфигурации
+type ValidationError struct {
+	File   string
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, 0, len(e.Errors))
+	for _, fieldErr := range e.Errors {
+		lines = append(lines, fieldErr.Error())
+	}
+	if len(lines) == 1 {
+		return "invalid config: " + lines[0]
+	}
+	return fmt.Sprintf("invalid config (%d errors):\n  %s", len(lines), strings.Join(lines, "\n  "))
+}
+
+// validateNode проверяет структуру config по тегам validate.
+// По узлам документа определяются позиции ошибок.
+func validateNode(config interface{}, doc *document) error {
+	v := &validator{doc: doc}
+	return v.run(config)
+}
+
+type validator struct {
+	doc    *document
+	errors []FieldError
+	// Проверять неизвестные ключи вместо правил validate
+	unknownFields bool
+}
+
+func (v *validator) run(config interface{}) error {
+	v.walk(reflect.ValueOf(config), v.doc.root, "")
+	if len(v.errors) == 0 {
+		return nil
+	}
+	return &ValidationError{File: v.doc.path, Errors: v.errors}
+}
+
+// checkUnknownFields ищет ключи YAML, которым нет соответствующего поля.
+// Проверка идет по дереву узлов, а не через KnownFields декодера: после
+// include документ собран из нескольких файлов, и у каждого ключа
+// сохраняются свои файл и строка. Заодно покрываются типы со своим
+// UnmarshalYAML (полная форма OutputSpec), которые KnownFields не видит.
+func checkUnknownFields(config interface{}, doc *document) error {
+	v := &validator{doc: doc, unknownFields: true}
+	return v.run(config)
+}
+
+func (v *validator) fail(node *yaml.Node, path, format string, args ...interface{}) {
+	fieldErr := FieldError{Path: path, Message: fmt.Sprintf(format, args...)}
+	if node != nil {
+		fieldErr.File = v.doc.fileOf(node)
+		fieldErr.Line, fieldErr.Column = node.Line, node.Column
+	}
+	v.errors = append(v.errors, fieldErr)
+}
+
+// walk обходит значение вместе с соответствующим узлом YAML
+func (v *validator) walk(value reflect.Value, node *yaml.Node, path string) {
+	for value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return
+		}
+		value = value.Elem()
+	}
+
+	switch value.Kind() {
+	case reflect.Struct:
+		v.walkStruct(value, node, path)
+	case reflect.Slice:
+		for i := 0; i < value.Len(); i++ {
+			v.walk(value.Index(i), childAt(node, i), fmt.Sprintf("%s[%d]", path, i))
+		}
+	}
+}
+
+func (v *validator) walkStruct(value reflect.Value, node *yaml.Node, path string) {
+	typ := value.Type()
+	if v.unknownFields {
+		v.checkKeys(typ, node, path)
+	}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, inline := yamlName(field)
+		if name == "-" {
+			continue
+		}
+		if inline {
+			v.walk(value.Field(i), node, path)
+			continue
+		}
+
+		fieldPath := name
+		if path != "" {
+			fieldPath = path + "." + name
+		}
+
+		// Для отсутствующего поля ошибка указывает на родительский узел
+		fieldNode := mappingValue(node, name)
+		position := fieldNode
+		if position == nil {
+			position = node
+		}
+
+		if rules := field.Tag.Get("validate"); rules != "" && !v.unknownFields {
+			v.check(value, value.Field(i), rules, position, fieldNode, fieldPath)
+		}
+		v.walk(value.Field(i), fieldNode, fieldPath)
+	}
+}
+
+// checkKeys сообщает о ключах отображения, не совпадающих с полями структуры
+func (v *validator) checkKeys(typ reflect.Type, node *yaml.Node, path string) {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return
+	}
+
+	known := make(map[string]bool)
+	collectKeys(typ, known)
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i]
+		if known[key.Value] {
+			continue
+		}
+		keyPath := key.Value
+		if path != "" {
+			keyPath = path + "." + key.Value
+		}
+		v.fail(key, keyPath, "unknown field (valid fields: %s)", strings.Join(sortedKeys(known), ", "))
+	}
+}
+
+// collectKeys собирает имена полей структуры в YAML, включая inline-поля
+func collectKeys(typ reflect.Type, known map[string]bool) {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, inline := yamlName(field)
+		if inline {
+			fieldType := field.Type
+			if fieldType.Kind() == reflect.Pointer {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.Kind() == reflect.Struct {
+				collectKeys(fieldType, known)
+			}
+			continue
+		}
+		if name != "-" {
+			known[name] = true
+		}
+	}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// check применяет правила поля
+func (v *validator) check(parent, field reflect.Value, rules string, position, fieldNode *yaml.Node, path string) {
+	for _, rule := range strings.Split(rules, ",") {
+		name, arg, _ := strings.Cut(rule, "=")
+
+		switch name {
+		case "required":
+			if isEmpty(field) {
+				v.fail(position, path, "is required")
+			}
+		case "required_if":
+			sibling, expected, _ := strings.Cut(arg, " ")
+			if other, ok := fieldByYAMLName(parent, sibling); ok && fmt.Sprint(other.Interface()) == expected && isEmpty(field) {
+				v.fail(position, path, "is required when %s is %q", sibling, expected)
+			}
+		default:
+			// Значение в короткой форме (outputs: [qcow2]) не имеет своего узла
+			valueNode := fieldNode
+			if valueNode == nil {
+				valueNode = position
+			}
+			v.eachString(field, valueNode, path, func(s string, node *yaml.Node, elemPath string) {
+				if msg := checkValue(name, arg, s); msg != "" {
+					v.fail(node, elemPath, "%s", msg)
+				}
+			})
+		}
+	}
+}
+
+// eachString вызывает fn для строкового значения или каждого элемента списка строк
+func (v *validator) eachString(field reflect.Value, node *yaml.Node, path string, fn func(string, *yaml.Node, string)) {
+	switch {
+	case field.Kind() == reflect.String:
+		if field.String() != "" {
+			fn(field.String(), node, path)
+		}
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+		for i := 0; i < field.Len(); i++ {
+			if s := field.Index(i).String(); s != "" {
+				elemNode := childAt(node, i)
+				if elemNode == nil {
+					elemNode = node
+				}
+				fn(s, elemNode, fmt.Sprintf("%s[%d]", path, i))
+			}
+		}
+	}
+}
+
+// checkValue проверяет строку по правилу и возвращает пояснение ошибки
+func checkValue(rule, arg, value string) string {
+	switch rule {
+	case "oneof":
+		allowed := strings.Fields(arg)
+		for _, candidate := range allowed {
+			if value == candidate {
+				return ""
+			}
+		}
+		return fmt.Sprintf("unsupported value %q (expected one of: %s)", value, strings.Join(allowed, ", "))
+	case "size":
+		if _, err := image.ParseSize(value); err != nil {
+			return fmt.Sprintf("invalid size %q (expected a number with an optional K, M, G or T suffix)", value)
+		}
+	case "duration":
+		if _, err := retention.ParseAge(value); err != nil {
+			return fmt.Sprintf("invalid duration %q (expected e.g. 72h or 30d)", value)
+		}
+	case "url":
+		parsed, err := url.Parse(value)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return fmt.Sprintf("invalid URL %q (expected an absolute URL such as https://host/path)", value)
+		}
+	}
+	return ""
+}
+
+// yamlName возвращает имя поля в YAML и признак inline
+func yamlName(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("yaml")
+	name, options, _ := strings.Cut(tag, ",")
+	inline := strings.Contains(","+options+",", ",inline,")
+	if name == "" {
+		name = strings.ToLower(field.Name)
+	}
+	return name, inline
+}
+
+// fieldByYAMLName находит поле структуры по имени в YAML
+func fieldByYAMLName(value reflect.Value, name string) (reflect.Value, bool) {
+	typ := value.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		if fieldName, _ := yamlName(typ.Field(i)); fieldName == name {
+			return value.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+func isEmpty(field reflect.Value) bool {
+	switch field.Kind() {
+	case reflect.Slice, reflect.Map:
+		return field.Len() == 0
+	}
+	return field.IsZero()
+}
+
+// mappingValue возвращает узел значения ключа key в отображении
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// childAt возвращает i-й элемент последовательности
+func childAt(node *yaml.Node, i int) *yaml.Node {
+	if node == nil || node.Kind != yaml.SequenceNode || i >= len(node.Content) {
+		return nil
+	}
+	return node.Content[i]
+}
@@ -0,0 +1,267 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+
+	"sysweaver/internal/funcs"
+)
+
+// Подстановка переменных в значения конфигурации:
+//
+//	vars:
+//	  site: edge01
+//	  release: "3.20"
+//	system:
+//	  hostname: ${site}
+//	  timezone: ${TZ:-UTC}
+//	version: '{{ .release }}-{{ env "BUILD_NUMBER" }}'
+//
+// ${NAME} ищется в --var NAME=VALUE, затем в окружении, затем в блоке vars
+// (значения по умолчанию). ${NAME:-default} задает значение для неопределенной
+// переменной, $${ - экранированный ${. Значения, содержащие {{, обрабатываются
+// как шаблоны text/template с переменными vars и --var и функциями
+// библиотеки funcs (env, default, file, sha256sum, semver, semverCompare, ...);
+// пути file отсчитываются от каталога основного файла конфигурации.
+//
+// Команды хуков (hooks.pre_build, post_build, on_failure) выполняет sh, поэтому
+// ${NAME} в них не подставляется: его раскрывает оболочка (например,
+// ${SYSWEAVER_OUTPUT_DIR}). Шаблоны {{ ... }} в них обрабатываются.
+
+// varsKey - блок переменных в корне файла
+const varsKey = "vars"
+
+var varPattern = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// substitute применяет переменные ко всем строковым значениям документа;
+// vars - переменные NAME=VALUE из командной строки
+func substitute(doc *document, vars []string) error {
+	if doc.root == nil || doc.root.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	cli, err := parseVars(vars)
+	if err != nil {
+		return err
+	}
+
+	r := &resolver{doc: doc, cli: cli, raw: make(map[string]*yaml.Node), values: make(map[string]string), resolving: make(map[string]bool)}
+	if err := r.takeVars(); err != nil {
+		return err
+	}
+	r.shell = hookCommands(doc.root)
+
+	return r.walk(doc.root)
+}
+
+// parseVars разбирает переменные вида NAME=VALUE
+func parseVars(assignments []string) (map[string]string, error) {
+	vars := make(map[string]string)
+	for _, assignment := range assignments {
+		name, value, ok := strings.Cut(assignment, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid variable %q (expected NAME=VALUE)", assignment)
+		}
+		vars[name] = value
+	}
+	return vars, nil
+}
+
+type resolver struct {
+	doc       *document
+	cli       map[string]string
+	raw       map[string]*yaml.Node // Значения блока vars до подстановки
+	values    map[string]string     // Разрешенные значения блока vars
+	resolving map[string]bool       // Для обнаружения циклических ссылок
+	shell     map[*yaml.Node]bool   // Команды хуков: ${} раскрывает оболочка
+}
+
+// hookKeys - списки команд хуков, выполняемых через sh -c
+var hookKeys = []string{"pre_build", "post_build", "on_failure"}
+
+// hookCommands возвращает узлы команд хуков из корня документа
+func hookCommands(root *yaml.Node) map[*yaml.Node]bool {
+	commands := make(map[*yaml.Node]bool)
+	hooks := mappingValue(root, "hooks")
+	if hooks == nil || hooks.Kind != yaml.MappingNode {
+		return commands
+	}
+	for _, key := range hookKeys {
+		if list := mappingValue(hooks, key); list != nil && list.Kind == yaml.SequenceNode {
+			for _, command := range list.Content {
+				commands[command] = true
+			}
+		}
+	}
+	return commands
+}
+
+// takeVars извлекает блок vars из корня документа
+func (r *resolver) takeVars() error {
+	root := r.doc.root
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != varsKey {
+			continue
+		}
+
+		block := root.Content[i+1]
+		root.Content = append(root.Content[:i:i], root.Content[i+2:]...)
+
+		if block.Kind != yaml.MappingNode {
+			return r.errorf(block, "vars must be a mapping")
+		}
+		for j := 0; j+1 < len(block.Content); j += 2 {
+			value := block.Content[j+1]
+			if value.Kind != yaml.ScalarNode {
+				return r.errorf(value, "variable %s must be a scalar", block.Content[j].Value)
+			}
+			r.raw[block.Content[j].Value] = value
+		}
+		return nil
+	}
+	return nil
+}
+
+// lookup возвращает значение переменной: --var, окружение, блок vars
+func (r *resolver) lookup(name string) (string, bool, error) {
+	if value, ok := r.cli[name]; ok {
+		return value, true, nil
+	}
+	if value, ok := os.LookupEnv(name); ok {
+		return value, true, nil
+	}
+
+	node, ok := r.raw[name]
+	if !ok {
+		return "", false, nil
+	}
+	if value, ok := r.values[name]; ok {
+		return value, true, nil
+	}
+	if r.resolving[name] {
+		return "", false, r.errorf(node, "variable %s refers to itself", name)
+	}
+
+	r.resolving[name] = true
+	value, err := r.expand(node)
+	delete(r.resolving, name)
+	if err != nil {
+		return "", false, err
+	}
+	r.values[name] = value
+	return value, true, nil
+}
+
+// walk заменяет переменные во всех скалярах дерева
+func (r *resolver) walk(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		value, err := r.expand(node)
+		if err != nil {
+			return err
+		}
+		if value != node.Value {
+			node.Value = value
+			// Тип обычного скаляра определяется заново: port: ${PORT} -> int
+			if node.Style == 0 {
+				node.Tag = ""
+			}
+		}
+		return nil
+	}
+
+	for _, child := range node.Content {
+		if err := r.walk(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expand подставляет переменные в значение узла
+func (r *resolver) expand(node *yaml.Node) (string, error) {
+	value := node.Value
+	if !strings.Contains(value, "$") && !strings.Contains(value, "{{") {
+		return value, nil
+	}
+
+	if r.shell[node] {
+		return r.templateIfNeeded(node, value)
+	}
+
+	var expandErr error
+	value = varPattern.ReplaceAllStringFunc(value, func(match string) string {
+		if match == "$${" {
+			return "${"
+		}
+		groups := varPattern.FindStringSubmatch(match)
+		name, hasDefault, fallback := groups[1], groups[2] != "", groups[3]
+
+		resolved, ok, err := r.lookup(name)
+		switch {
+		case err != nil:
+			expandErr = err
+		case ok:
+			return resolved
+		case hasDefault:
+			return fallback
+		case expandErr == nil:
+			expandErr = r.errorf(node, "undefined variable %s", name)
+		}
+		return ""
+	})
+	if expandErr != nil {
+		return "", expandErr
+	}
+	return r.templateIfNeeded(node, value)
+}
+
+// templateIfNeeded выполняет значение как шаблон, если оно содержит {{
+func (r *resolver) templateIfNeeded(node *yaml.Node, value string) (string, error) {
+	if strings.Contains(value, "{{") {
+		return r.template(node, value)
+	}
+	return value, nil
+}
+
+// template выполняет значение как шаблон text/template
+func (r *resolver) template(node *yaml.Node, text string) (string, error) {
+	data := make(map[string]string)
+	for name := range r.raw {
+		value, _, err := r.lookup(name)
+		if err != nil {
+			return "", err
+		}
+		data[name] = value
+	}
+	for name, value := range r.cli {
+		data[name] = value
+	}
+
+	tmpl, err := template.New("value").
+		Option("missingkey=error").
+		Funcs(funcs.Map(filepath.Dir(r.doc.path))).
+		Parse(text)
+	if err != nil {
+		return "", r.errorf(node, "invalid template: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", r.errorf(node, "error executing template: %v", err)
+	}
+	return buf.String(), nil
+}
+
+func (r *resolver) errorf(node *yaml.Node, format string, args ...interface{}) error {
+	if node.Line == 0 {
+		return fmt.Errorf("%s: %s", r.doc.fileOf(node), fmt.Sprintf(format, args...))
+	}
+	return fmt.Errorf("%s:%d:%d: %s", r.doc.fileOf(node), node.Line, node.Column, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,207 @@
+package digest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Поддерживаемые алгоритмы
+const (
+	SHA256 = "sha256"
+	SHA512 = "sha512"
+	BLAKE3 = "blake3"
+)
+
+// Default - алгоритмы, вычисляемые, если в конфигурации не указано иное
+var Default = []string{SHA256}
+
+// Digest - дайджест в самоописываемом виде <алгоритм>:<hex>.
+// Префикс алгоритма позволяет проверять старые сборки после смены алгоритма.
+type Digest string
+
+// New формирует дайджест из имени алгоритма и значения
+func New(algo string, sum []byte) Digest {
+	return Digest(algo + ":" + hex.EncodeToString(sum))
+}
+
+// Algorithm возвращает имя алгоритма дайджеста
+func (d Digest) Algorithm() string {
+	algo, _, _ := strings.Cut(string(d), ":")
+	return algo
+}
+
+// Hex возвращает значение дайджеста
+func (d Digest) Hex() string {
+	_, value, _ := strings.Cut(string(d), ":")
+	return value
+}
+
+// Parse разбирает строку дайджеста и проверяет алгоритм
+func Parse(s string) (Digest, error) {
+	algo, value, ok := strings.Cut(s, ":")
+	if !ok || value == "" {
+		return "", fmt.Errorf("invalid digest %q: expected <algorithm>:<hex>", s)
+	}
+	if err := Validate([]string{algo}); err != nil {
+		return "", err
+	}
+	if _, err := hex.DecodeString(value); err != nil {
+		return "", fmt.Errorf("invalid digest %q: %w", s, err)
+	}
+	return Digest(s), nil
+}
+
+// Validate проверяет, что все алгоритмы поддерживаются
+func Validate(algos []string) error {
+	for _, algo := range algos {
+		switch algo {
+		case SHA256, SHA512, BLAKE3:
+		default:
+			return fmt.Errorf("unsupported digest algorithm: %s (available: %s, %s, %s)", algo, SHA256, SHA512, BLAKE3)
+		}
+	}
+	return nil
+}
+
+// File вычисляет дайджесты файла указанными алгоритмами.
+// sha256 и sha512 считаются за один проход, blake3 - утилитой b3sum.
+func File(path string, algos []string) ([]Digest, error) {
+	if len(algos) == 0 {
+		algos = Default
+	}
+	if err := Validate(algos); err != nil {
+		return nil, err
+	}
+
+	hashers := make(map[string]hash.Hash)
+	var writers []io.Writer
+	for _, algo := range algos {
+		var h hash.Hash
+		switch algo {
+		case SHA256:
+			h = sha256.New()
+		case SHA512:
+			h = sha512.New()
+		default:
+			continue
+		}
+		hashers[algo] = h
+		writers = append(writers, h)
+	}
+
+	if len(writers) > 0 {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("error opening %s: %w", path, err)
+		}
+		defer file.Close()
+
+		if _, err := io.Copy(io.MultiWriter(writers...), file); err != nil {
+			return nil, fmt.Errorf("error hashing %s: %w", path, err)
+		}
+	}
+
+	digests := make([]Digest, 0, len(algos))
+	for _, algo := range algos {
+		if h, ok := hashers[algo]; ok {
+			digests = append(digests, New(algo, h.Sum(nil)))
+			continue
+		}
+
+		sum, err := blake3File(path)
+		if err != nil {
+			return nil, err
+		}
+		digests = append(digests, sum)
+	}
+
+	return digests, nil
+}
+
+// Verify проверяет файл по дайджесту, вычисляя его тем же алгоритмом
+func Verify(path string, expected Digest) error {
+	if _, err := Parse(string(expected)); err != nil {
+		return err
+	}
+
+	digests, err := File(path, []string{expected.Algorithm()})
+	if err != nil {
+		return err
+	}
+
+	if !strings.EqualFold(string(digests[0]), string(expected)) {
+		return fmt.Errorf("digest mismatch for %s: expected %s, got %s", path, expected, digests[0])
+	}
+
+	return nil
+}
+
+// blake3File вычисляет BLAKE3 файла утилитой b3sum
+func blake3File(path string) (Digest, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("b3sum", "--no-names", path)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("error computing blake3 of %s: %w (%s)", path, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return Digest(BLAKE3 + ":" + strings.TrimSpace(string(out))), nil
+}
+
+// Имена файлов контрольных сумм в формате coreutils
+var sumsFiles = map[string]string{
+	SHA256: "SHA256SUMS",
+	SHA512: "SHA512SUMS",
+	BLAKE3: "B3SUMS",
+}
+
+// SumsFile возвращает имя файла контрольных сумм алгоритма
+func SumsFile(algo string) string {
+	return sumsFiles[algo]
+}
+
+// Include добавляет алгоритм в список, если его там нет
+func Include(algos []string, algo string) []string {
+	for _, a := range algos {
+		if a == algo {
+			return algos
+		}
+	}
+	return append([]string{algo}, algos...)
+}
+
+// WriteSums записывает в dir файл контрольных сумм алгоритма (SHA256SUMS, ...)
+// в формате "<hex>  <имя>", пригодном для sha256sum -c. files - имя файла -> его дайджесты.
+func WriteSums(dir, algo string, names []string, files map[string][]Digest) (string, error) {
+	fileName, ok := sumsFiles[algo]
+	if !ok {
+		return "", fmt.Errorf("unsupported digest algorithm: %s", algo)
+	}
+
+	var b strings.Builder
+	for _, name := range names {
+		for _, d := range files[name] {
+			if d.Algorithm() == algo {
+				fmt.Fprintf(&b, "%s  %s\n", d.Hex(), name)
+			}
+		}
+	}
+
+	path := filepath.Join(dir, fileName)
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return "", fmt.Errorf("error writing %s: %w", fileName, err)
+	}
+
+	return path, nil
+}
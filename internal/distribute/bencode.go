@@ -0,0 +1,53 @@
+package distribute
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// bencode кодирует значение в формате BitTorrent (строки, целые, списки, словари)
+func bencode(buf *bytes.Buffer, v interface{}) error {
+	switch value := v.(type) {
+	case string:
+		buf.WriteString(strconv.Itoa(len(value)))
+		buf.WriteByte(':')
+		buf.WriteString(value)
+	case []byte:
+		buf.WriteString(strconv.Itoa(len(value)))
+		buf.WriteByte(':')
+		buf.Write(value)
+	case int:
+		fmt.Fprintf(buf, "i%de", value)
+	case int64:
+		fmt.Fprintf(buf, "i%de", value)
+	case []interface{}:
+		buf.WriteByte('l')
+		for _, item := range value {
+			if err := bencode(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	case map[string]interface{}:
+		// Ключи словаря должны идти в лексикографическом порядке
+		keys := make([]string, 0, len(value))
+		for k := range value {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		buf.WriteByte('d')
+		for _, k := range keys {
+			bencode(buf, k)
+			if err := bencode(buf, value[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	default:
+		return fmt.Errorf("bencode: unsupported type %T", v)
+	}
+	return nil
+}
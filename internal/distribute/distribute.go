@@ -0,0 +1,93 @@
+package distribute
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"sysweaver/internal/image"
+	"sysweaver/internal/rootfs"
+	"sysweaver/internal/store"
+	"sysweaver/internal/structures"
+)
+
+// Артефакты, для которых по умолчанию создаются торренты и Metalink
+var defaultArtifacts = []string{"*.iso", "*.img", "*.raw", "*.qcow2", "*.vmdk", "*.vhdx", "*.vdi", "*.tar.*"}
+
+// Generate создает .torrent и .meta4 для подходящих артефактов.
+// Metalink ссылается на дайджесты, уже вычисленные для артефактов сборки.
+// Сообщения о ходе создания пишутся в out.
+func Generate(cfg structures.DistributeConfig, artifacts []store.Artifact, version string, out io.Writer) ([]string, error) {
+	if cfg.Torrent == nil && cfg.Metalink == nil {
+		return nil, nil
+	}
+
+	patterns := cfg.Artifacts
+	if len(patterns) == 0 {
+		patterns = defaultArtifacts
+	}
+
+	created := time.Now()
+	if epoch := rootfs.SourceDateEpoch(); epoch != nil {
+		created = *epoch
+	}
+
+	var torrentOpts TorrentOptions
+	if cfg.Torrent != nil {
+		torrentOpts = TorrentOptions{
+			Trackers: cfg.Torrent.Trackers,
+			WebSeeds: cfg.Torrent.WebSeeds,
+			Private:  cfg.Torrent.Private,
+			Created:  created,
+		}
+		if cfg.Torrent.PieceSize != "" {
+			size, err := image.ParseSize(cfg.Torrent.PieceSize)
+			if err != nil {
+				return nil, fmt.Errorf("invalid torrent piece_size: %w", err)
+			}
+			torrentOpts.PieceSize = size
+		}
+	}
+
+	var produced []string
+	for _, artifact := range artifacts {
+		if !matchAny(patterns, artifact.Name) {
+			continue
+		}
+
+		if cfg.Torrent != nil {
+			fmt.Fprintf(out, "Creating torrent for %s\n", artifact.Name)
+			path, err := WriteTorrent(artifact.Path, torrentOpts)
+			if err != nil {
+				return produced, err
+			}
+			produced = append(produced, path)
+		}
+
+		if cfg.Metalink != nil {
+			fmt.Fprintf(out, "Creating metalink for %s\n", artifact.Name)
+			path, err := WriteMetalink(MetalinkFile{
+				Path:    artifact.Path,
+				Name:    artifact.Name,
+				Size:    artifact.Size,
+				Digests: artifact.Digests,
+			}, version, cfg.Metalink.Mirrors, created)
+			if err != nil {
+				return produced, err
+			}
+			produced = append(produced, path)
+		}
+	}
+
+	return produced, nil
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
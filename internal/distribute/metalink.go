@@ -0,0 +1,83 @@
+package distribute
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"sysweaver/internal/digest"
+)
+
+// Имена алгоритмов в Metalink (реестр IANA Hash Function Textual Names)
+var metalinkHashNames = map[string]string{
+	digest.SHA256: "sha-256",
+	digest.SHA512: "sha-512",
+}
+
+// MetalinkFile - файл, описываемый документом Metalink
+type MetalinkFile struct {
+	Path    string
+	Name    string
+	Size    int64
+	Digests []digest.Digest
+}
+
+type metalinkDocument struct {
+	XMLName   xml.Name            `xml:"urn:ietf:params:xml:ns:metalink metalink"`
+	Generator string              `xml:"generator"`
+	Published string              `xml:"published"`
+	Files     []metalinkFileEntry `xml:"file"`
+}
+
+type metalinkFileEntry struct {
+	Name    string         `xml:"name,attr"`
+	Size    int64          `xml:"size"`
+	Version string         `xml:"version,omitempty"`
+	Hashes  []metalinkHash `xml:"hash"`
+	URLs    []metalinkURL  `xml:"url"`
+}
+
+type metalinkHash struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type metalinkURL struct {
+	Priority int    `xml:"priority,attr"`
+	Value    string `xml:",chardata"`
+}
+
+// WriteMetalink создает документ Metalink 4 (RFC 5854) для файла.
+// mirrors - базовые URL, к которым добавляется имя файла; порядок задает приоритет.
+func WriteMetalink(file MetalinkFile, version string, mirrors []string, created time.Time) (string, error) {
+	entry := metalinkFileEntry{Name: file.Name, Size: file.Size, Version: version}
+
+	for _, d := range file.Digests {
+		if name, ok := metalinkHashNames[d.Algorithm()]; ok {
+			entry.Hashes = append(entry.Hashes, metalinkHash{Type: name, Value: d.Hex()})
+		}
+	}
+	for i, mirror := range mirrors {
+		entry.URLs = append(entry.URLs, metalinkURL{Priority: i + 1, Value: strings.TrimRight(mirror, "/") + "/" + file.Name})
+	}
+
+	doc := metalinkDocument{
+		Generator: "SysWeaver",
+		Published: created.UTC().Format(time.RFC3339),
+		Files:     []metalinkFileEntry{entry},
+	}
+
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("error encoding metalink: %w", err)
+	}
+
+	dest := file.Path + ".meta4"
+	if err := os.WriteFile(dest, append([]byte(xml.Header), append(data, '\n')...), 0644); err != nil {
+		return "", fmt.Errorf("error writing %s: %w", dest, err)
+	}
+
+	return dest, nil
+}
@@ -0,0 +1,122 @@
+package distribute
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"sysweaver/internal/progress"
+)
+
+// Границы автоматического выбора размера части торрента
+const (
+	minPieceSize = 256 << 10
+	maxPieceSize = 16 << 20
+	targetPieces = 2000
+)
+
+// TorrentOptions - параметры торрента
+type TorrentOptions struct {
+	Trackers  []string
+	WebSeeds  []string // Базовые URL (BEP 19); имя файла добавляется к URL с завершающим /
+	PieceSize int64    // 0 - автоматически
+	Private   bool
+	Created   time.Time
+}
+
+// WriteTorrent создает .torrent (BitTorrent v1, один файл) рядом с артефактом
+func WriteTorrent(path string, opts TorrentOptions) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	pieceSize := opts.PieceSize
+	if pieceSize == 0 {
+		pieceSize = autoPieceSize(info.Size())
+	}
+
+	// Хеши SHA-1 всех частей подряд
+	var pieces bytes.Buffer
+	pw := progress.NewWriter(io.Discard, "torrent "+filepath.Base(path), info.Size())
+	chunk := make([]byte, pieceSize)
+	for {
+		n, err := io.ReadFull(file, chunk)
+		if n > 0 {
+			sum := sha1.Sum(chunk[:n])
+			pieces.Write(sum[:])
+			pw.Write(chunk[:n])
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("error hashing %s: %w", path, err)
+		}
+	}
+	pw.Finish()
+
+	infoDict := map[string]interface{}{
+		"name":         filepath.Base(path),
+		"length":       info.Size(),
+		"piece length": pieceSize,
+		"pieces":       pieces.Bytes(),
+	}
+	if opts.Private {
+		infoDict["private"] = 1
+	}
+
+	torrent := map[string]interface{}{
+		"info":          infoDict,
+		"created by":    "SysWeaver",
+		"creation date": opts.Created.Unix(),
+	}
+	if len(opts.Trackers) > 0 {
+		torrent["announce"] = opts.Trackers[0]
+
+		// Каждый трекер - отдельный уровень (BEP 12)
+		tiers := make([]interface{}, 0, len(opts.Trackers))
+		for _, tracker := range opts.Trackers {
+			tiers = append(tiers, []interface{}{tracker})
+		}
+		torrent["announce-list"] = tiers
+	}
+	if len(opts.WebSeeds) > 0 {
+		seeds := make([]interface{}, 0, len(opts.WebSeeds))
+		for _, seed := range opts.WebSeeds {
+			seeds = append(seeds, seed)
+		}
+		torrent["url-list"] = seeds
+	}
+
+	var buf bytes.Buffer
+	if err := bencode(&buf, torrent); err != nil {
+		return "", err
+	}
+
+	dest := path + ".torrent"
+	if err := os.WriteFile(dest, buf.Bytes(), 0644); err != nil {
+		return "", fmt.Errorf("error writing %s: %w", dest, err)
+	}
+
+	return dest, nil
+}
+
+// autoPieceSize подбирает размер части (степень двойки), чтобы частей было не больше targetPieces
+func autoPieceSize(size int64) int64 {
+	pieceSize := int64(minPieceSize)
+	for pieceSize < maxPieceSize && size/pieceSize > targetPieces {
+		pieceSize *= 2
+	}
+	return pieceSize
+}
@@ -0,0 +1,188 @@
+package dnf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"sysweaver/internal/structures"
+)
+
+// Distros - значения base.distro, корневая ФС которых создается dnf
+var Distros = []string{"fedora", "rhel", "centos", "rocky", "almalinux"}
+
+// Группа пакетов по умолчанию: минимальная загружаемая система
+var defaultGroups = []string{"core"}
+
+// Пути базы rpm относительно корня ФС (новый и прежний)
+var rpmDBs = []string{"usr/lib/sysimage/rpm", "var/lib/rpm"}
+
+// Supports сообщает, создается ли корневая ФС дистрибутива через dnf
+func Supports(distro string) bool {
+	return slices.Contains(Distros, distro)
+}
+
+// Options - параметры создания корневой ФС
+type Options struct {
+	Root      string                // Корень устанавливаемой системы
+	Base      structures.BaseConfig // Версия, группы и репозитории
+	Arch      string                // Архитектура (по умолчанию архитектура хоста)
+	Packages  []string              // Пакеты из packages конфигурации
+	LogWriter io.Writer
+	Out       io.Writer // Сообщения о ходе установки; nil - os.Stdout
+}
+
+// Bootstrap устанавливает группы и пакеты в корень opts.Root командой
+// dnf --installroot. Заданные в конфигурации репозитории заменяют
+// репозитории хоста.
+func Bootstrap(opts Options) error {
+	if opts.LogWriter == nil {
+		opts.LogWriter = io.Discard
+	}
+	if opts.Out == nil {
+		opts.Out = os.Stdout
+	}
+
+	releasever := opts.Base.Releasever
+	if releasever == "" {
+		releasever = opts.Base.Version
+	}
+	if releasever == "" {
+		return fmt.Errorf("base.version or base.releasever is required for %s", opts.Base.Distro)
+	}
+
+	args := []string{
+		"--installroot", opts.Root,
+		"--releasever", releasever,
+		"--assumeyes",
+		"--setopt=install_weak_deps=False",
+	}
+	if opts.Arch != "" {
+		args = append(args, "--forcearch", opts.Arch)
+	}
+
+	if len(opts.Base.Repos) > 0 {
+		reposDir, err := os.MkdirTemp("", "sysweaver-dnf-")
+		if err != nil {
+			return fmt.Errorf("error creating dnf repository directory: %w", err)
+		}
+		defer os.RemoveAll(reposDir)
+
+		if err := writeRepos(filepath.Join(reposDir, "sysweaver.repo"), opts.Base.Repos); err != nil {
+			return err
+		}
+		args = append(args, "--setopt=reposdir="+reposDir)
+	}
+
+	groups := opts.Base.Groups
+	if len(groups) == 0 {
+		groups = defaultGroups
+	}
+	args = append(args, "install")
+	for _, group := range groups {
+		args = append(args, "@"+strings.TrimPrefix(group, "@"))
+	}
+	args = append(args, opts.Packages...)
+
+	fmt.Fprintf(opts.Out, "Installing %s %s with dnf (%d groups, %d packages)\n", opts.Base.Distro, releasever, len(groups), len(opts.Packages))
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("dnf", args...)
+	cmd.Stdout = opts.LogWriter
+	cmd.Stderr = io.MultiWriter(opts.LogWriter, &stderr)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("dnf install: %w (%s)", err, lastLine(stderr.String()))
+	}
+	return nil
+}
+
+// writeRepos записывает репозитории в файл формата yum.repos.d
+func writeRepos(path string, repos []structures.DnfRepo) error {
+	var b strings.Builder
+	for _, repo := range repos {
+		if repo.BaseURL == "" && repo.Metalink == "" && repo.Mirrorlist == "" {
+			return fmt.Errorf("repository %s: baseurl, metalink or mirrorlist is required", repo.Name)
+		}
+
+		fmt.Fprintf(&b, "[%s]\nname=%s\nenabled=1\n", repo.Name, repo.Name)
+		if repo.BaseURL != "" {
+			fmt.Fprintf(&b, "baseurl=%s\n", repo.BaseURL)
+		}
+		if repo.Metalink != "" {
+			fmt.Fprintf(&b, "metalink=%s\n", repo.Metalink)
+		}
+		if repo.Mirrorlist != "" {
+			fmt.Fprintf(&b, "mirrorlist=%s\n", repo.Mirrorlist)
+		}
+		if repo.GPGCheck {
+			fmt.Fprintf(&b, "gpgcheck=1\ngpgkey=%s\n", repo.GPGKey)
+		} else {
+			b.WriteString("gpgcheck=0\n")
+		}
+		b.WriteString("\n")
+	}
+
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("error writing dnf repositories: %w", err)
+	}
+	return nil
+}
+
+// Package - пакет из базы rpm
+type Package struct {
+	Name      string
+	Version   string // [epoch:]version-release
+	Arch      string
+	License   string
+	SourceRPM string
+}
+
+// ReadInstalled читает список установленных пакетов из базы rpm корневой ФС.
+// Если базы нет (не rpm-дистрибутив), возвращается пустой список без ошибки.
+func ReadInstalled(root string) ([]Package, error) {
+	found := slices.ContainsFunc(rpmDBs, func(db string) bool {
+		_, err := os.Stat(filepath.Join(root, db))
+		return err == nil
+	})
+	if !found {
+		return nil, nil
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("rpm", "--root", root, "--query", "--all", "--queryformat",
+		`%{NAME}\t%|EPOCH?{%{EPOCH}:}:{}|%{VERSION}-%{RELEASE}\t%{ARCH}\t%{LICENSE}\t%{SOURCERPM}\n`)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error reading rpm database: %w (%s)", err, lastLine(stderr.String()))
+	}
+
+	var packages []Package
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 5 {
+			continue
+		}
+		packages = append(packages, Package{
+			Name:      fields[0],
+			Version:   fields[1],
+			Arch:      fields[2],
+			License:   fields[3],
+			SourceRPM: fields[4],
+		})
+	}
+	slices.SortFunc(packages, func(a, b Package) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return packages, nil
+}
+
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return lines[len(lines)-1]
+}
@@ -0,0 +1,223 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"sysweaver/internal/digest"
+	"sysweaver/internal/logging"
+	"sysweaver/internal/progress"
+)
+
+// Downloader загружает файлы с набора зеркал с повторами, докачкой и
+// обнаружением зависаний. Зеркала перебираются по порядку: первое - основное.
+type Downloader struct {
+	Mirrors      []string      // Базовые URL зеркал
+	Retries      int           // Попыток на одно зеркало
+	StallTimeout time.Duration // Максимальное время без получения данных
+	Digests      []string      // Алгоритмы дайджестов загруженных файлов
+	Client       *http.Client
+	Logger       *slog.Logger // Журнал неудачных попыток; nil - slog.Default()
+	Out          io.Writer    // Сообщения о смене зеркала; nil - os.Stdout
+}
+
+// Result - результат загрузки
+type Result struct {
+	URL     string // Полный URL, с которого получен файл
+	Mirror  string // Зеркало, обслужившее загрузку
+	Size    int64
+	Digests []digest.Digest
+}
+
+// New создает загрузчик с настройками по умолчанию
+func New(mirrors []string) *Downloader {
+	return &Downloader{
+		Mirrors:      mirrors,
+		Retries:      3,
+		StallTimeout: 30 * time.Second,
+		Digests:      digest.Default,
+		Client:       &http.Client{},
+	}
+}
+
+// Fetch загружает path (относительно зеркала) в файл dest.
+// Частично загруженный файл dest.part докачивается запросом Range.
+func (d *Downloader) Fetch(path, dest string) (*Result, error) {
+	if len(d.Mirrors) == 0 {
+		return nil, fmt.Errorf("no mirrors configured")
+	}
+
+	var lastErr error
+	for _, mirror := range d.Mirrors {
+		url := strings.TrimRight(mirror, "/") + "/" + strings.TrimLeft(path, "/")
+
+		for attempt := 1; attempt <= d.Retries; attempt++ {
+			err := d.fetchOnce(url, dest+".part")
+			if err == nil {
+				if err := os.Rename(dest+".part", dest); err != nil {
+					return nil, fmt.Errorf("error saving download: %w", err)
+				}
+
+				info, err := os.Stat(dest)
+				if err != nil {
+					return nil, err
+				}
+				digests, err := digest.File(dest, d.Digests)
+				if err != nil {
+					return nil, err
+				}
+				return &Result{URL: url, Mirror: mirror, Size: info.Size(), Digests: digests}, nil
+			}
+
+			lastErr = err
+			logging.OrDefault(d.Logger).Warn(fmt.Sprintf("download failed (attempt %d/%d)", attempt, d.Retries), "url", url, "error", err)
+
+			// Ошибки 4xx не исправятся повтором - переходим к следующему зеркалу
+			if _, ok := err.(*statusError); ok && err.(*statusError).code < 500 {
+				break
+			}
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+
+		fmt.Fprintf(d.output(), "Mirror %s failed, trying next mirror\n", mirror)
+	}
+
+	return nil, fmt.Errorf("all mirrors failed for %s: %w", path, lastErr)
+}
+
+// statusError - неуспешный HTTP-ответ
+type statusError struct {
+	url  string
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s: HTTP %d", e.url, e.code)
+}
+
+// fetchOnce выполняет одну попытку загрузки с докачкой в partPath
+func (d *Downloader) fetchOnce(url, partPath string) error {
+	var offset int64
+	if info, err := os.Stat(partPath); err == nil {
+		offset = info.Size()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		flags |= os.O_APPEND
+	case http.StatusOK:
+		// Сервер не поддерживает Range - начинаем заново
+		offset = 0
+		flags |= os.O_TRUNC
+	case http.StatusRequestedRangeNotSatisfiable:
+		// Файл уже загружен полностью
+		return nil
+	default:
+		return &statusError{url: url, code: resp.StatusCode}
+	}
+
+	file, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var total int64
+	if resp.ContentLength > 0 {
+		total = offset + resp.ContentLength
+	}
+
+	// Сторож зависания: отменяет запрос, если данные не поступают StallTimeout
+	watchdog := time.AfterFunc(d.StallTimeout, cancel)
+	defer watchdog.Stop()
+
+	body := &stallReader{r: resp.Body, watchdog: watchdog, timeout: d.StallTimeout}
+	pw := progress.NewWriter(file, "download "+lastSegment(url), total)
+	if _, err := io.Copy(pw, body); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("download stalled for %s", d.StallTimeout)
+		}
+		return err
+	}
+	pw.Finish()
+
+	return nil
+}
+
+// stallReader продлевает сторож зависания при каждом чтении
+type stallReader struct {
+	r        io.Reader
+	watchdog *time.Timer
+	timeout  time.Duration
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if n > 0 {
+		s.watchdog.Reset(s.timeout)
+	}
+	return n, err
+}
+
+// Probe проверяет доступность path на зеркалах и возвращает первое рабочее
+func (d *Downloader) Probe(path string) (string, error) {
+	client := *d.Client
+	client.Timeout = 10 * time.Second
+
+	for _, mirror := range d.Mirrors {
+		url := strings.TrimRight(mirror, "/") + "/" + strings.TrimLeft(path, "/")
+		resp, err := client.Head(url)
+		if err != nil {
+			fmt.Fprintf(d.output(), "Mirror %s unavailable: %v\n", mirror, err)
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusOK {
+			return mirror, nil
+		}
+		fmt.Fprintf(d.output(), "Mirror %s unavailable: HTTP %d\n", mirror, resp.StatusCode)
+	}
+
+	return "", fmt.Errorf("no mirror serves %s", path)
+}
+
+// output возвращает получатель сообщений о смене зеркала
+func (d *Downloader) output() io.Writer {
+	if d.Out == nil {
+		return os.Stdout
+	}
+	return d.Out
+}
+
+// lastSegment возвращает имя файла из URL
+func lastSegment(url string) string {
+	if i := strings.LastIndex(url, "/"); i >= 0 {
+		return url[i+1:]
+	}
+	return url
+}
@@ -0,0 +1,189 @@
+package firewall
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"sysweaver/internal/structures"
+)
+
+// Правила firewall: конфигурации превращаются в набор правил nftables,
+// который записывается в файл, загружаемый службой nftables системы:
+// /etc/nftables.nft (Alpine), /etc/sysconfig/nftables.conf (Fedora, RHEL)
+// или /etc/nftables.conf (Debian). Файл начинается с flush ruleset и
+// полностью задает правила системы.
+
+// ServiceName - служба, загружающая правила при старте системы
+const ServiceName = "nftables"
+
+// Файлы правил службы nftables в разных дистрибутивах, по порядку проверки
+var rulesetFiles = []string{"etc/nftables.nft", "etc/sysconfig/nftables.conf", "etc/nftables.conf"}
+
+// Пути утилиты nft
+var nftBinaries = []string{"usr/sbin/nft", "sbin/nft"}
+
+// Configured сообщает, задан ли межсетевой экран в конфигурации
+func Configured(cfg structures.FirewallConfig) bool {
+	return cfg.Policy != "" || len(cfg.Allow) > 0 || len(cfg.Deny) > 0
+}
+
+// Render возвращает набор правил nftables
+func Render(cfg structures.FirewallConfig) (string, error) {
+	policy := cfg.Policy
+	if policy == "" {
+		policy = "drop"
+	}
+
+	var b strings.Builder
+	b.WriteString("#!/usr/sbin/nft -f\n# Generated by sysweaver from the firewall config\n\nflush ruleset\n\n")
+	b.WriteString("table inet filter {\n")
+	b.WriteString("\tchain input {\n")
+	fmt.Fprintf(&b, "\t\ttype filter hook input priority 0; policy %s;\n\n", policy)
+	b.WriteString("\t\tct state established,related accept\n")
+	b.WriteString("\t\tct state invalid drop\n")
+	b.WriteString("\t\tiifname \"lo\" accept\n")
+	// Без ICMPv6 не работает обнаружение соседей IPv6
+	b.WriteString("\t\tmeta l4proto ipv6-icmp accept\n")
+	if !cfg.BlockPing {
+		b.WriteString("\t\ticmp type echo-request accept\n")
+	}
+
+	for _, list := range []struct {
+		name    string
+		rules   []structures.FirewallRule
+		verdict string
+	}{{"deny", cfg.Deny, "drop"}, {"allow", cfg.Allow, "accept"}} {
+		if len(list.rules) > 0 {
+			b.WriteString("\n")
+		}
+		for i, rule := range list.rules {
+			match, err := renderRule(rule)
+			if err != nil {
+				return "", fmt.Errorf("firewall.%s[%d]: %w", list.name, i, err)
+			}
+			fmt.Fprintf(&b, "\t\t%s %s\n", match, list.verdict)
+		}
+	}
+	b.WriteString("\t}\n\n")
+
+	b.WriteString("\tchain forward {\n\t\ttype filter hook forward priority 0; policy drop;\n\t}\n\n")
+	b.WriteString("\tchain output {\n\t\ttype filter hook output priority 0; policy accept;\n\t}\n")
+	b.WriteString("}\n")
+	return b.String(), nil
+}
+
+// renderRule возвращает условие правила в синтаксисе nft
+func renderRule(rule structures.FirewallRule) (string, error) {
+	var parts []string
+
+	if rule.Interface != "" {
+		parts = append(parts, "iifname "+strconv.Quote(rule.Interface))
+	}
+
+	if rule.From != "" {
+		ip := net.ParseIP(rule.From)
+		if ip == nil {
+			parsed, _, err := net.ParseCIDR(rule.From)
+			if err != nil {
+				return "", fmt.Errorf("invalid from %q (expected an address or a CIDR network)", rule.From)
+			}
+			ip = parsed
+		}
+		family := "ip"
+		if ip.To4() == nil {
+			family = "ip6"
+		}
+		parts = append(parts, family+" saddr "+rule.From)
+	}
+
+	protocol := rule.Protocol
+	switch {
+	case rule.Port != "":
+		if protocol == "" {
+			protocol = "tcp"
+		}
+		if protocol != "tcp" && protocol != "udp" {
+			return "", fmt.Errorf("port requires protocol tcp or udp, not %s", protocol)
+		}
+		ports, err := renderPorts(rule.Port)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, protocol+" dport "+ports)
+	case protocol == "icmpv6":
+		parts = append(parts, "meta l4proto ipv6-icmp")
+	case protocol != "":
+		parts = append(parts, "meta l4proto "+protocol)
+	}
+
+	if len(parts) == 0 {
+		return "", fmt.Errorf("rule matches nothing (set port, protocol, from or interface)")
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// renderPorts разбирает порт, список через запятую или диапазоны a-b
+func renderPorts(spec string) (string, error) {
+	var ports []string
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		low, high, isRange := strings.Cut(field, "-")
+		bounds := []string{low}
+		if isRange {
+			bounds = append(bounds, high)
+		}
+		for _, port := range bounds {
+			if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+				return "", fmt.Errorf("invalid port %q", field)
+			}
+		}
+		ports = append(ports, field)
+	}
+	if len(ports) == 1 {
+		return ports[0], nil
+	}
+	return "{ " + strings.Join(ports, ", ") + " }", nil
+}
+
+// Install записывает правила в файл службы nftables корневой ФС root и
+// возвращает его путь внутри системы
+func Install(root string, cfg structures.FirewallConfig) (string, error) {
+	ruleset, err := Render(cfg)
+	if err != nil {
+		return "", err
+	}
+
+	found := false
+	for _, binary := range nftBinaries {
+		if _, err := os.Stat(filepath.Join(root, binary)); err == nil {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return "", fmt.Errorf("firewall requires the nftables package in the system")
+	}
+
+	// Файл правил создан пакетом nftables; без него используется первый путь
+	path := rulesetFiles[0]
+	for _, candidate := range rulesetFiles {
+		if _, err := os.Stat(filepath.Join(root, candidate)); err == nil {
+			path = candidate
+			break
+		}
+	}
+
+	target := filepath.Join(root, path)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return "", err
+	}
+	os.Remove(target)
+	if err := os.WriteFile(target, []byte(ruleset), 0600); err != nil {
+		return "", fmt.Errorf("error writing /%s: %w", path, err)
+	}
+	return "/" + path, nil
+}
@@ -0,0 +1,179 @@
+package firstboot
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"sysweaver/internal/services"
+)
+
+// Скрипты каталога firstboot/ шаблона выполняются один раз, при первой
+// загрузке собранной системы: генерация ключей хоста, расширение диска и
+// другая настройка, зависящая от конкретной машины. Скрипты копируются в
+// /usr/libexec/sysweaver/firstboot и запускаются по порядку имен службой
+// sysweaver-firstboot (OpenRC или systemd). Успешно выполненный скрипт
+// отмечается в /var/lib/sysweaver/firstboot и больше не запускается;
+// после неудачи оставшиеся скрипты повторяются при следующей загрузке.
+// Вывод пишется в /var/log/sysweaver-firstboot.log.
+
+// Dir - каталог скриптов первой загрузки в шаблоне
+const Dir = "firstboot"
+
+// ServiceName - имя службы первой загрузки
+const ServiceName = "sysweaver-firstboot"
+
+// Пути внутри собранной системы. Не /usr/lib/sysweaver: на время сборки там
+// смонтирована библиотека helpers, и записанное туда не попадает в образ.
+const (
+	scriptsDir = "/usr/libexec/sysweaver/firstboot"
+	runnerPath = "/usr/libexec/sysweaver/firstboot.sh"
+	stateDir   = "/var/lib/sysweaver/firstboot"
+	doneFile   = "/var/lib/sysweaver/firstboot.done"
+	logFile    = "/var/log/sysweaver-firstboot.log"
+)
+
+const runner = `#!/bin/sh
+# Generated by sysweaver: runs the first-boot scripts once
+scripts=` + scriptsDir + `
+state=` + stateDir + `
+
+mkdir -p "$state"
+exec >>` + logFile + ` 2>&1
+echo "=== first boot: $(date) ==="
+
+failed=0
+for script in "$scripts"/*; do
+	[ -f "$script" ] || continue
+	name=$(basename "$script")
+	[ -e "$state/$name.done" ] && continue
+	echo "--- $name"
+	if "$script"; then
+		touch "$state/$name.done"
+	else
+		echo "--- $name failed with status $?"
+		failed=1
+	fi
+done
+
+[ "$failed" = 0 ] && touch ` + doneFile + `
+exit "$failed"
+`
+
+const openrcService = `#!/sbin/openrc-run
+# Generated by sysweaver
+
+description="Run sysweaver first-boot scripts"
+
+depend() {
+	need localmount
+	after net
+}
+
+start() {
+	[ -e ` + doneFile + ` ] && return 0
+	ebegin "Running first-boot scripts"
+	` + runnerPath + `
+	eend $?
+}
+`
+
+const systemdService = `# Generated by sysweaver
+[Unit]
+Description=Run sysweaver first-boot scripts
+ConditionPathExists=!` + doneFile + `
+After=local-fs.target network-online.target
+Wants=network-online.target
+
+[Service]
+Type=oneshot
+ExecStart=` + runnerPath + `
+RemainAfterExit=yes
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// Scripts возвращает скрипты firstboot/ шаблона в порядке выполнения
+func Scripts(templateDir string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(templateDir, Dir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading %s/: %w", Dir, err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+			names = append(names, entry.Name())
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// Install копирует скрипты первой загрузки в корневую ФС root и записывает
+// службу для ее системы инициализации. Возвращает службу, которую нужно
+// включить, или nil, если скриптов нет.
+func Install(templateDir, root string) (*services.Service, error) {
+	names, err := Scripts(templateDir)
+	if err != nil || len(names) == 0 {
+		return nil, err
+	}
+
+	initSystem := services.Detect(root)
+	if initSystem == "" {
+		return nil, fmt.Errorf("%s/ requires OpenRC or systemd in the system", Dir)
+	}
+
+	target := filepath.Join(root, scriptsDir)
+	if err := os.RemoveAll(target); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(templateDir, Dir, name))
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(filepath.Join(target, name), data, 0755); err != nil {
+			return nil, fmt.Errorf("error installing first-boot script %s: %w", name, err)
+		}
+	}
+
+	files := map[string]string{runnerPath: runner}
+	switch initSystem {
+	case services.OpenRC:
+		files["/etc/init.d/"+ServiceName] = openrcService
+	case services.Systemd:
+		files["/etc/systemd/system/"+ServiceName+".service"] = systemdService
+	}
+	for path, content := range files {
+		mode := os.FileMode(0755)
+		if strings.HasSuffix(path, ".service") {
+			mode = 0644
+		}
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(root, path)), 0755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(filepath.Join(root, path), []byte(content), mode); err != nil {
+			return nil, fmt.Errorf("error writing %s: %w", path, err)
+		}
+	}
+
+	// Состояние первой загрузки не должно попасть в образ
+	os.RemoveAll(filepath.Join(root, stateDir))
+	os.Remove(filepath.Join(root, doneFile))
+
+	service, err := services.Parse(initSystem, ServiceName)
+	if err != nil {
+		return nil, err
+	}
+	return &service, nil
+}
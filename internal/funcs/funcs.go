@@ -0,0 +1,270 @@
+package funcs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Библиотека функций, доступных в шаблонах значений конфигурации ({{ ... }})
+// и в условиях when скриптов:
+//
+//	env "NAME" ["default"]          переменная окружения; default - если не задана
+//	default "fallback" value        value, если оно не пустое, иначе fallback
+//	file "keys/motd.txt"            содержимое файла из каталога шаблона
+//	sha256sum "text"                SHA256 строки в hex
+//	semver "3.20.1-rc1"             версия: major, minor, patch, prerelease, metadata
+//	semverCompare ">=3.18, <4" v    соответствие версии ограничениям
+//	lower, upper, trim              регистр и пробелы строки
+//	replace "old" "new" "text"      замена всех вхождений
+//
+// В конфигурации функции вызываются синтаксисом text/template:
+//
+//	version: '{{ env "RELEASE" "3.20" }}'
+//	motd_sha: '{{ file "branding/motd" | sha256sum }}'
+//
+// в условиях when - синтаксисом Go:
+//
+//	when: semverCompare(">=3.18", version) && env("CI", "") != ""
+//	when: semver(version).major >= 3
+//
+// default в условиях when недоступна (ключевое слово Go); отсутствующее
+// значение там и так nil.
+//
+// Ограничения semverCompare - операторы =, !=, >, >=, <, <=, ~ (тот же
+// minor, для версии без minor - тот же major) и ^ (тот же major, для 0.x -
+// тот же minor) через запятую (все должны выполняться), группы ограничений
+// разделяются || (должна выполняться одна). Недостающие части версии
+// считаются нулями: 3.18 - это 3.18.0.
+
+// Map возвращает функции; пути file отсчитываются от каталога шаблона dir
+func Map(dir string) map[string]interface{} {
+	return map[string]interface{}{
+		"env":           env,
+		"default":       fallback,
+		"file":          func(path string) (string, error) { return readFile(dir, path) },
+		"sha256sum":     sha256sum,
+		"semver":        semver,
+		"semverCompare": semverCompare,
+		"lower":         strings.ToLower,
+		"upper":         strings.ToUpper,
+		"trim":          strings.TrimSpace,
+		"replace":       func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	}
+}
+
+// env возвращает переменную окружения или значение по умолчанию, если она
+// не задана
+func env(name string, defaults ...string) (string, error) {
+	if len(defaults) > 1 {
+		return "", fmt.Errorf("expected at most one default, got %d", len(defaults))
+	}
+	if value, ok := os.LookupEnv(name); ok || len(defaults) == 0 {
+		return value, nil
+	}
+	return defaults[0], nil
+}
+
+// fallback возвращает value, если оно не пустое (nil, "", false, 0), иначе def
+func fallback(def, value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return def
+	case string:
+		if v == "" {
+			return def
+		}
+	case bool:
+		if !v {
+			return def
+		}
+	case int:
+		if v == 0 {
+			return def
+		}
+	case float64:
+		if v == 0 {
+			return def
+		}
+	}
+	return value
+}
+
+// readFile читает файл шаблона; путь не может выходить за каталог шаблона
+func readFile(dir, path string) (string, error) {
+	if !filepath.IsLocal(path) {
+		return "", fmt.Errorf("%s must be a relative path inside the template directory", path)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, path))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func sha256sum(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// version - разобранная семантическая версия
+type version struct {
+	numbers    [3]int
+	prerelease string
+	metadata   string
+	parts      int // Сколько чисел задано (3.18 - 2)
+}
+
+// parseVersion разбирает версию вида [v]MAJOR[.MINOR[.PATCH]][-PRE][+META]
+func parseVersion(s string) (version, error) {
+	var v version
+	rest := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	rest, v.metadata, _ = strings.Cut(rest, "+")
+	rest, v.prerelease, _ = strings.Cut(rest, "-")
+
+	numbers := strings.Split(rest, ".")
+	if len(numbers) > 3 {
+		return version{}, fmt.Errorf("invalid version %q", s)
+	}
+	for i, number := range numbers {
+		n, err := strconv.Atoi(number)
+		if err != nil || n < 0 {
+			return version{}, fmt.Errorf("invalid version %q", s)
+		}
+		v.numbers[i] = n
+	}
+	v.parts = len(numbers)
+	return v, nil
+}
+
+// semver разбирает версию для обращения к ее частям
+func semver(s string) (map[string]interface{}, error) {
+	v, err := parseVersion(s)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"major":      v.numbers[0],
+		"minor":      v.numbers[1],
+		"patch":      v.numbers[2],
+		"prerelease": v.prerelease,
+		"metadata":   v.metadata,
+	}, nil
+}
+
+// compareVersions сравнивает версии по правилам semver; metadata не учитывается
+func compareVersions(a, b version) int {
+	for i := range a.numbers {
+		if a.numbers[i] != b.numbers[i] {
+			if a.numbers[i] < b.numbers[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	// Версия без prerelease старше версии с ним
+	switch {
+	case a.prerelease == b.prerelease:
+		return 0
+	case a.prerelease == "":
+		return 1
+	case b.prerelease == "":
+		return -1
+	}
+
+	ap, bp := strings.Split(a.prerelease, "."), strings.Split(b.prerelease, ".")
+	for i := 0; i < len(ap) && i < len(bp); i++ {
+		if ap[i] == bp[i] {
+			continue
+		}
+		an, aerr := strconv.Atoi(ap[i])
+		bn, berr := strconv.Atoi(bp[i])
+		switch {
+		case aerr == nil && berr == nil:
+			if an < bn {
+				return -1
+			}
+			return 1
+		case aerr == nil:
+			return -1 // Числовые идентификаторы младше буквенных
+		case berr == nil:
+			return 1
+		case ap[i] < bp[i]:
+			return -1
+		default:
+			return 1
+		}
+	}
+	switch {
+	case len(ap) < len(bp):
+		return -1
+	case len(ap) > len(bp):
+		return 1
+	}
+	return 0
+}
+
+// semverCompare проверяет, что версия s удовлетворяет ограничениям constraints
+func semverCompare(constraints, s string) (bool, error) {
+	v, err := parseVersion(s)
+	if err != nil {
+		return false, err
+	}
+
+	for _, group := range strings.Split(constraints, "||") {
+		matched := true
+		for _, constraint := range strings.Split(group, ",") {
+			ok, err := satisfies(v, strings.TrimSpace(constraint))
+			if err != nil {
+				return false, err
+			}
+			matched = matched && ok
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// satisfies проверяет одно ограничение вида <оператор><версия>
+func satisfies(v version, constraint string) (bool, error) {
+	op := constraint[:len(constraint)-len(strings.TrimLeft(constraint, "=!<>~^"))]
+	target, err := parseVersion(strings.TrimSpace(constraint[len(op):]))
+	if err != nil || constraint == "" {
+		return false, fmt.Errorf("invalid version constraint %q", constraint)
+	}
+	c := compareVersions(v, target)
+
+	switch op {
+	case "", "=", "==":
+		return c == 0, nil
+	case "!=":
+		return c != 0, nil
+	case ">":
+		return c > 0, nil
+	case ">=":
+		return c >= 0, nil
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case "~", "^":
+		// Верхняя граница: следующий minor (~ с minor, ^ для 0.x) или major
+		upper := version{}
+		if (op == "~" && target.parts > 1) || (op == "^" && target.numbers[0] == 0 && target.parts > 1) {
+			upper.numbers = [3]int{target.numbers[0], target.numbers[1] + 1, 0}
+		} else {
+			upper.numbers = [3]int{target.numbers[0] + 1, 0, 0}
+		}
+		// Пререлиз верхней границы младше ее релиза: 4.0.0-rc1 не входит в ^3
+		upper.prerelease = "0"
+		return c >= 0 && compareVersions(v, upper) < 0, nil
+	}
+	return false, fmt.Errorf("invalid version constraint %q", constraint)
+}
@@ -0,0 +1,22 @@
+package helpers
+
+import (
+	_ "embed"
+)
+
+// Библиотека shell-функций для скриптов шаблонов (sw_retry, sw_download,
+// sw_enable_service, sw_add_user и др.). Она монтируется в jail на время
+// сборки и не попадает в собранную систему:
+//
+//	. /usr/lib/sysweaver/helpers.sh
+
+// Dir - каталог библиотеки внутри jail
+const Dir = "/usr/lib/sysweaver"
+
+// Path - путь библиотеки внутри jail
+const Path = Dir + "/helpers.sh"
+
+// Script - содержимое библиотеки
+//
+//go:embed helpers.sh
+var Script []byte
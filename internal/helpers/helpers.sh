@@ -0,0 +1,173 @@
+# SysWeaver helpers for build scripts.
+#
+#   . /usr/lib/sysweaver/helpers.sh
+#
+# POSIX sh (busybox ash, dash, bash). Every function returns non-zero on
+# failure, so scripts running under "set -e" stop on the first error.
+
+# sw_log MESSAGE... - print a message prefixed with the script name
+sw_log() {
+	printf '[%s] %s\n' "${0##*/}" "$*" >&2
+}
+
+# sw_die MESSAGE... - print a message and exit with status 1
+sw_die() {
+	sw_log "error: $*"
+	exit 1
+}
+
+# sw_retry ATTEMPTS DELAY COMMAND... - run COMMAND until it succeeds, up to
+# ATTEMPTS times, sleeping DELAY seconds (doubled after every failure)
+sw_retry() {
+	_sw_attempts=$1 _sw_delay=$2
+	shift 2
+	_sw_try=1
+	while ! "$@"; do
+		if [ "$_sw_try" -ge "$_sw_attempts" ]; then
+			sw_log "command failed after $_sw_try attempts: $*"
+			return 1
+		fi
+		sw_log "attempt $_sw_try/$_sw_attempts failed, retrying in ${_sw_delay}s: $*"
+		sleep "$_sw_delay"
+		_sw_try=$((_sw_try + 1))
+		_sw_delay=$((_sw_delay * 2))
+	done
+}
+
+# sw_download URL DEST SHA256 - download URL to DEST (with retries) and verify
+# its SHA256 digest; DEST is only created if the digest matches
+sw_download() {
+	_sw_url=$1 _sw_dest=$2 _sw_sum=$3
+	[ -n "$_sw_sum" ] || sw_die "sw_download: missing SHA256 for $_sw_url"
+
+	if command -v curl >/dev/null 2>&1; then
+		sw_retry 3 2 curl -fsSL -o "$_sw_dest.part" "$_sw_url" || return 1
+	else
+		sw_retry 3 2 wget -q -O "$_sw_dest.part" "$_sw_url" || return 1
+	fi
+
+	_sw_actual=$(sha256sum "$_sw_dest.part" | cut -d' ' -f1)
+	if [ "$_sw_actual" != "$_sw_sum" ]; then
+		rm -f "$_sw_dest.part"
+		sw_log "checksum mismatch for $_sw_url: expected $_sw_sum, got $_sw_actual"
+		return 1
+	fi
+	mv "$_sw_dest.part" "$_sw_dest"
+}
+
+# sw_enable_service NAME [RUNLEVEL] - enable a service at boot (OpenRC
+# runlevel, default "default"; systemd ignores RUNLEVEL)
+sw_enable_service() {
+	if command -v rc-update >/dev/null 2>&1; then
+		rc-update add "$1" "${2:-default}"
+	elif command -v systemctl >/dev/null 2>&1; then
+		systemctl enable "$1"
+	else
+		sw_log "no service manager found to enable $1"
+		return 1
+	fi
+}
+
+# sw_add_user NAME [SHELL] [GROUPS] - create a user with a home directory
+# unless it exists; GROUPS is a comma-separated list of existing groups
+sw_add_user() {
+	_sw_name=$1 _sw_shell=${2:-/bin/sh} _sw_groups=$3
+
+	if ! id "$_sw_name" >/dev/null 2>&1; then
+		if command -v useradd >/dev/null 2>&1; then
+			useradd -m -s "$_sw_shell" "$_sw_name" || return 1
+		else
+			adduser -D -s "$_sw_shell" "$_sw_name" || return 1
+		fi
+	fi
+
+	_sw_ifs=$IFS
+	IFS=,
+	for _sw_group in $_sw_groups; do
+		if command -v usermod >/dev/null 2>&1; then
+			usermod -a -G "$_sw_group" "$_sw_name" || { IFS=$_sw_ifs; return 1; }
+		else
+			addgroup "$_sw_name" "$_sw_group" || { IFS=$_sw_ifs; return 1; }
+		fi
+	done
+	IFS=$_sw_ifs
+}
+
+# Assertions for scripts/test: every sw_assert* function records a pass or
+# fail line in $SYSWEAVER_TEST_RESULTS and returns 0, so a script reports all
+# of its failed checks in one run. The test stage fails afterwards if any
+# assertion failed, and the results are listed in the build report.
+
+# _sw_result STATUS ASSERTION [DETAIL] - record an assertion result
+_sw_result() {
+	_sw_line=$(printf '%s\t%s\t%s\t%s' "$1" "${0##*/}" "$(printf '%s' "$2" | tr '\t\n' '  ')" "$(printf '%s' "$3" | tr '\t\n' '  ')")
+	printf '%s\n' "$_sw_line" >>"${SYSWEAVER_TEST_RESULTS:-/dev/null}"
+	if [ "$1" = pass ]; then
+		sw_log "ok: $2"
+	else
+		sw_log "FAILED: $2${3:+ ($3)}"
+	fi
+}
+
+# sw_assert DESCRIPTION COMMAND... - check that COMMAND succeeds
+sw_assert() {
+	_sw_desc=$1
+	shift
+	if _sw_out=$("$@" 2>&1); then
+		_sw_result pass "$_sw_desc"
+	else
+		_sw_result fail "$_sw_desc" "exit status $?: $(printf '%s' "$_sw_out" | tail -n 1)"
+	fi
+}
+
+# sw_assert_file PATH - check that PATH exists
+sw_assert_file() {
+	if [ -e "$1" ]; then
+		_sw_result pass "file $1 exists"
+	else
+		_sw_result fail "file $1 exists" "not found"
+	fi
+}
+
+# sw_assert_service NAME - check that a service is enabled at boot (in any
+# OpenRC runlevel, or systemctl is-enabled)
+sw_assert_service() {
+	if command -v rc-update >/dev/null 2>&1; then
+		if ls /etc/runlevels/*/"$1" >/dev/null 2>&1; then
+			_sw_result pass "service $1 enabled"
+		else
+			_sw_result fail "service $1 enabled" "not in any runlevel"
+		fi
+	elif command -v systemctl >/dev/null 2>&1; then
+		_sw_state=$(systemctl is-enabled "$1" 2>&1) || true
+		case $_sw_state in
+		enabled | enabled-runtime | alias | indirect | generated)
+			_sw_result pass "service $1 enabled" ;;
+		*)
+			_sw_result fail "service $1 enabled" "$_sw_state" ;;
+		esac
+	else
+		_sw_result fail "service $1 enabled" "no service manager found"
+	fi
+}
+
+# sw_assert_package NAME - check that a package is installed
+sw_assert_package() {
+	if command -v apk >/dev/null 2>&1; then
+		set -- "$1" apk info -e "$1"
+	elif command -v rpm >/dev/null 2>&1; then
+		set -- "$1" rpm -q "$1"
+	elif command -v dpkg-query >/dev/null 2>&1; then
+		set -- "$1" dpkg-query -W "$1"
+	else
+		_sw_result fail "package $1 installed" "no package manager found"
+		return 0
+	fi
+	_sw_name=$1
+	shift
+	if "$@" >/dev/null 2>&1; then
+		_sw_result pass "package $_sw_name installed"
+	else
+		_sw_result fail "package $_sw_name installed" "not installed"
+	fi
+}
@@ -0,0 +1,40 @@
+package helpers
+
+import (
+	"strings"
+)
+
+// ResultsPath - файл, в который функции sw_assert* записывают результаты
+// проверок: по строке на проверку, поля через табуляцию
+// (pass|fail, скрипт, проверка, подробности)
+const ResultsPath = Dir + "/test-results"
+
+// Result - результат одной проверки sw_assert*
+type Result struct {
+	Script    string
+	Assertion string
+	Passed    bool
+	Detail    string
+}
+
+// ParseResults разбирает содержимое файла результатов проверок.
+// Строки неизвестного формата пропускаются.
+func ParseResults(data []byte) []Result {
+	var results []Result
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.SplitN(line, "\t", 4)
+		if len(fields) < 3 || (fields[0] != "pass" && fields[0] != "fail") {
+			continue
+		}
+		result := Result{
+			Passed:    fields[0] == "pass",
+			Script:    fields[1],
+			Assertion: fields[2],
+		}
+		if len(fields) == 4 {
+			result.Detail = fields[3]
+		}
+		results = append(results, result)
+	}
+	return results
+}
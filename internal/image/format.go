@@ -0,0 +1,102 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+
+	"sysweaver/internal/structures"
+)
+
+// Форматы выходных артефактов секции outputs. Каждый формат - реализация
+// Format, зарегистрированная под именем типа выхода (Register). Сборка
+// находит формат по type выхода (Lookup), поэтому новый формат (squashfs,
+// ova, ...) добавляется регистрацией реализации, без правки switch по типам.
+
+// ErrNotConvertible возвращает Convert форматов, которые создаются не
+// конвертацией образа диска (архивы, ISO, SBOM)
+var ErrNotConvertible = errors.New("format is not converted from a disk image")
+
+// Env - окружение создания артефактов одной сборки
+type Env struct {
+	Config    *structures.BuildConfig
+	OutputDir string   // Директория артефактов (исходные образы и результаты)
+	Rootfs    string   // Корневая ФС собранной системы (jail)
+	Exclude   []string // Пути rootfs, не относящиеся к собранной системе
+
+	TemplateDir string       // Директория шаблона (для относительных путей в конфигурации)
+	LogWriter   io.Writer    // Вывод внешних утилит
+	Out         io.Writer    // Сообщения о ходе создания артефактов; nil - os.Stdout
+	Logger      *slog.Logger // Журнал сборки образов; nil - slog.Default()
+	PluginDirs  []string     // Каталоги внешних плагинов
+
+	// Context - контекст сборки: его отмена прерывает внешние утилиты
+	// (по умолчанию context.Background())
+	Context context.Context
+
+	// Sources возвращает исходные raw-образы для конвертации: созданный в
+	// этой сборке, найденный в OutputDir или промежуточный по partitions
+	Sources func(spec structures.OutputSpec) ([]string, error)
+}
+
+// Format - формат выходного артефакта
+type Format interface {
+	// Validate проверяет параметры выхода (compression, subformat, options)
+	// до начала сборки
+	Validate(spec structures.OutputSpec) error
+
+	// Create создает артефакты выхода и возвращает их пути
+	Create(spec structures.OutputSpec, env Env) ([]string, error)
+
+	// Convert конвертирует raw-образ source в файл dest этого формата
+	Convert(ctx context.Context, source, dest string, spec structures.OutputSpec) error
+}
+
+var (
+	formatsMu sync.RWMutex
+	formats   = make(map[string]Format)
+)
+
+// Register регистрирует формат под именем типа выхода. Повторная
+// регистрация имени - ошибка программы.
+func Register(name string, format Format) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	if _, exists := formats[name]; exists {
+		panic("image: format registered twice: " + name)
+	}
+	formats[name] = format
+}
+
+// Lookup возвращает формат по имени типа выхода
+func Lookup(name string) (Format, error) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	format, ok := formats[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported output type: %s (supported: %s)", name, strings.Join(formatNames(), ", "))
+	}
+	return format, nil
+}
+
+// Formats возвращает имена зарегистрированных форматов
+func Formats() []string {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	return formatNames()
+}
+
+// formatNames возвращает отсортированные имена форматов; вызывается под formatsMu
+func formatNames() []string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,99 @@
+package image
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// /etc/fstab образа генерируется из разметки: разделы с точками монтирования
+// и swap указываются по UUID созданных файловых систем, параметры
+// монтирования берутся из options раздела. Строки fstab из rootfs для других
+// точек монтирования сохраняются после сгенерированных.
+
+const fstabHeader = "# Generated by sysweaver from the partitions config\n"
+
+// writeFstab записывает /etc/fstab в смонтированный образ target
+func writeFstab(layout []layoutEntry, target string, log *slog.Logger) error {
+	var lines []string
+	mounts := make(map[string]bool)
+
+	for _, entry := range layout {
+		p := entry.partition
+		mount, fstype, pass := p.Mount, p.Filesystem, "2"
+		switch {
+		case fstype == "swap":
+			mount, pass = "none", "0"
+		case fstype == "none" || (fstype == "" && entry.blob == "") || !strings.HasPrefix(mount, "/"):
+			continue
+		case fstype == "fat32":
+			fstype = "vfat"
+		}
+		if mount == "/" {
+			pass = "1"
+		}
+		if fstype == "" {
+			// Готовый образ без указанной ФС: тип определяется при монтировании
+			fstype = "auto"
+		}
+		if fstype == "xfs" || fstype == "btrfs" {
+			// fsck для xfs и btrfs при загрузке не выполняется
+			pass = "0"
+		}
+
+		options := p.Options
+		if options == "" {
+			options = "defaults"
+			if fstype == "swap" {
+				options = "sw"
+			}
+		}
+
+		uuid, err := filesystemUUID(entry.device)
+		if err != nil {
+			return fmt.Errorf("partition %s: %w", p.Name, err)
+		}
+		lines = append(lines, strings.Join([]string{"UUID=" + uuid, mount, fstype, options, "0", pass}, "\t"))
+		mounts[mount] = true
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	path := filepath.Join(target, "etc/fstab")
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error reading /etc/fstab: %w", err)
+	}
+	var kept []string
+	for _, line := range strings.Split(string(existing), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || mounts[fields[1]] {
+			continue
+		}
+		kept = append(kept, line)
+	}
+
+	content := fstabHeader + strings.Join(append(lines, kept...), "\n") + "\n"
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return fmt.Errorf("error writing /etc/fstab: %w", err)
+	}
+	log.Info("generated /etc/fstab", "entries", len(lines))
+	return nil
+}
+
+// filesystemUUID возвращает UUID файловой системы раздела
+func filesystemUUID(device string) (string, error) {
+	out, err := exec.Command("blkid", "-c", "/dev/null", "-s", "UUID", "-o", "value", device).Output()
+	uuid := strings.TrimSpace(string(out))
+	if err != nil || uuid == "" {
+		return "", fmt.Errorf("no filesystem UUID on %s", device)
+	}
+	return uuid, nil
+}
@@ -0,0 +1,418 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"sysweaver/internal/logging"
+	"sysweaver/internal/progress"
+	"sysweaver/internal/rootfs"
+	"sysweaver/internal/structures"
+)
+
+// Выравнивание разделов и служебное место под GPT (1 MiB в начале и в конце диска)
+const alignment = 1024 * 1024
+
+// RawOptions - параметры создания raw-образа диска
+type RawOptions struct {
+	Path        string                 // Путь к создаваемому образу
+	Partitions  []structures.Partition // Разметка диска
+	Rootfs      string                 // Корневая ФС для заполнения разделов
+	Exclude     []string               // Пути rootfs, не попадающие в образ
+	TemplateDir string                 // Директория шаблона (для относительных путей image:)
+	LogWriter   io.Writer
+	Logger      *slog.Logger // Журнал этапов создания образа; nil - slog.Default()
+}
+
+// layoutEntry - раздел с вычисленным положением на диске
+type layoutEntry struct {
+	partition structures.Partition
+	index     int    // Номер раздела (с 1)
+	start     int64  // Смещение начала в байтах
+	size      int64  // Размер в байтах
+	blob      string // Абсолютный путь готового образа ФС
+	device    string // Устройство раздела после подключения loop
+}
+
+// CreateRawImage создает raw-образ диска с таблицей разделов GPT.
+// Разделы форматируются и заполняются из rootfs согласно точкам монтирования;
+// разделы с image: получают готовый образ ФС без изменений. Отмена ctx
+// прерывает разметку, форматирование и копирование rootfs; loop-устройство
+// и точки монтирования освобождаются и после нее.
+func CreateRawImage(ctx context.Context, opts RawOptions) error {
+	if len(opts.Partitions) == 0 {
+		return fmt.Errorf("no partitions configured for raw image")
+	}
+	if opts.LogWriter == nil {
+		opts.LogWriter = io.Discard
+	}
+	opts.Logger = logging.OrDefault(opts.Logger)
+
+	layout, totalSize, err := planLayout(opts)
+	if err != nil {
+		return err
+	}
+
+	opts.Logger.Info(fmt.Sprintf("creating raw image %s (%d MiB, %d partitions)", filepath.Base(opts.Path), totalSize/alignment, len(layout)))
+
+	// Разреженный файл нужного размера
+	os.Remove(opts.Path)
+	file, err := os.Create(opts.Path)
+	if err != nil {
+		return fmt.Errorf("error creating image file: %w", err)
+	}
+	if err := file.Truncate(totalSize); err != nil {
+		file.Close()
+		return fmt.Errorf("error allocating image file: %w", err)
+	}
+	file.Close()
+
+	if err := partitionDisk(ctx, opts, layout); err != nil {
+		return err
+	}
+
+	loopDev, err := attachLoop(opts.Path)
+	if err != nil {
+		return err
+	}
+	defer detachLoop(loopDev, opts.LogWriter, opts.Logger)
+
+	for i := range layout {
+		layout[i].device = fmt.Sprintf("%sp%d", loopDev, layout[i].index)
+	}
+
+	for _, entry := range layout {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if entry.blob != "" {
+			if err := writeBlob(entry, opts.Logger); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := makeFilesystem(ctx, entry, opts.LogWriter, opts.Logger); err != nil {
+			return err
+		}
+	}
+
+	return populate(ctx, opts, layout)
+}
+
+// planLayout вычисляет размеры и смещения разделов и проверяет готовые образы
+func planLayout(opts RawOptions) ([]layoutEntry, int64, error) {
+	var layout []layoutEntry
+	offset := int64(alignment)
+
+	for i, p := range opts.Partitions {
+		entry := layoutEntry{partition: p, index: i + 1, start: offset}
+
+		if p.Image != "" {
+			entry.blob = p.Image
+			if !filepath.IsAbs(entry.blob) {
+				entry.blob = filepath.Join(opts.TemplateDir, entry.blob)
+			}
+
+			info, err := os.Stat(entry.blob)
+			if err != nil {
+				return nil, 0, fmt.Errorf("partition %s: image not found: %s", p.Name, p.Image)
+			}
+
+			// Без явного размера раздел занимает размер образа с выравниванием
+			entry.size = alignUp(info.Size())
+			if p.Size != "" {
+				size, err := ParseSize(p.Size)
+				if err != nil {
+					return nil, 0, fmt.Errorf("partition %s: %w", p.Name, err)
+				}
+				if info.Size() > size {
+					return nil, 0, fmt.Errorf("partition %s: image %s (%d bytes) does not fit into partition size %s (%d bytes)",
+						p.Name, p.Image, info.Size(), p.Size, size)
+				}
+				entry.size = alignUp(size)
+			}
+		} else {
+			if p.Size == "" {
+				return nil, 0, fmt.Errorf("partition %s: size is required", p.Name)
+			}
+			size, err := ParseSize(p.Size)
+			if err != nil {
+				return nil, 0, fmt.Errorf("partition %s: %w", p.Name, err)
+			}
+			entry.size = alignUp(size)
+		}
+
+		offset += entry.size
+		layout = append(layout, entry)
+	}
+
+	// Место под резервную копию GPT в конце диска
+	return layout, offset + alignment, nil
+}
+
+// ParseSize разбирает размер вида 512M, 2G, 1048576 (суффиксы K/M/G/T - степени 1024)
+func ParseSize(s string) (int64, error) {
+	value := strings.TrimSpace(strings.ToUpper(s))
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "B"), "I")
+
+	multiplier := int64(1)
+	if n := len(value); n > 0 {
+		switch value[n-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			value = value[:n-1]
+		}
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number <= 0 {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+
+	return int64(number * float64(multiplier)), nil
+}
+
+// alignUp округляет размер вверх до границы выравнивания
+func alignUp(size int64) int64 {
+	return (size + alignment - 1) / alignment * alignment
+}
+
+// partitionDisk создает таблицу разделов GPT через parted
+func partitionDisk(ctx context.Context, opts RawOptions, layout []layoutEntry) error {
+	args := []string{"-s", opts.Path, "mklabel", "gpt"}
+	for _, entry := range layout {
+		name := entry.partition.Name
+		if name == "" {
+			name = fmt.Sprintf("part%d", entry.index)
+		}
+		args = append(args, "mkpart", name,
+			fmt.Sprintf("%dB", entry.start),
+			fmt.Sprintf("%dB", entry.start+entry.size-1))
+
+		for _, flag := range entry.partition.Flags {
+			args = append(args, "set", strconv.Itoa(entry.index), flag, "on")
+		}
+	}
+
+	cmd := exec.CommandContext(ctx, "parted", args...)
+	cmd.Stdout = opts.LogWriter
+	cmd.Stderr = opts.LogWriter
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error partitioning image: %w", err)
+	}
+
+	return nil
+}
+
+// attachLoop подключает образ к loop-устройству с разбором разделов
+func attachLoop(path string) (string, error) {
+	out, err := exec.Command("losetup", "--find", "--show", "--partscan", path).Output()
+	if err != nil {
+		return "", fmt.Errorf("error attaching loop device: %w", err)
+	}
+
+	// Ждем появления устройств разделов
+	exec.Command("udevadm", "settle").Run()
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// detachLoop отключает loop-устройство
+func detachLoop(device string, logWriter io.Writer, log *slog.Logger) {
+	cmd := exec.Command("losetup", "-d", device)
+	cmd.Stdout = logWriter
+	cmd.Stderr = logWriter
+	if err := cmd.Run(); err != nil {
+		log.Warn("failed to detach loop device", "device", device, "error", err)
+	}
+}
+
+// writeBlob записывает готовый образ ФС в раздел
+func writeBlob(entry layoutEntry, log *slog.Logger) error {
+	log.Info("writing prebuilt image to partition", "image", filepath.Base(entry.blob), "partition", entry.partition.Name)
+
+	src, err := os.Open(entry.blob)
+	if err != nil {
+		return fmt.Errorf("error opening partition image: %w", err)
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("error reading partition image: %w", err)
+	}
+
+	dst, err := os.OpenFile(entry.device, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("error opening partition device %s: %w", entry.device, err)
+	}
+	defer dst.Close()
+
+	pw := progress.NewWriter(dst, "dd "+entry.partition.Name, info.Size())
+	if _, err := io.Copy(pw, src); err != nil {
+		return fmt.Errorf("error writing image to partition %s: %w", entry.partition.Name, err)
+	}
+	pw.Finish()
+
+	return dst.Sync()
+}
+
+// makeFilesystem форматирует раздел
+func makeFilesystem(ctx context.Context, entry layoutEntry, logWriter io.Writer, log *slog.Logger) error {
+	label := entry.partition.Name
+	var cmd *exec.Cmd
+
+	switch entry.partition.Filesystem {
+	case "ext2", "ext3", "ext4":
+		cmd = exec.CommandContext(ctx, "mkfs."+entry.partition.Filesystem, "-F", "-q", "-L", label, entry.device)
+	case "vfat", "fat32":
+		cmd = exec.CommandContext(ctx, "mkfs.vfat", "-F", "32", "-n", strings.ToUpper(label), entry.device)
+	case "xfs":
+		cmd = exec.CommandContext(ctx, "mkfs.xfs", "-f", "-L", label, entry.device)
+	case "btrfs":
+		cmd = exec.CommandContext(ctx, "mkfs.btrfs", "-f", "-L", label, entry.device)
+	case "swap":
+		cmd = exec.CommandContext(ctx, "mkswap", "-L", label, entry.device)
+	case "none", "":
+		// Раздел без ФС (например, BIOS boot)
+		return nil
+	default:
+		return fmt.Errorf("unsupported filesystem: %s", entry.partition.Filesystem)
+	}
+
+	log.Info("formatting partition", "partition", label, "filesystem", entry.partition.Filesystem)
+
+	cmd.Stdout = logWriter
+	cmd.Stderr = logWriter
+	if err := progress.Run(cmd, "mkfs "+label, 0, false); err != nil {
+		return fmt.Errorf("error creating %s filesystem on %s: %w", entry.partition.Filesystem, label, err)
+	}
+
+	return nil
+}
+
+// populate монтирует разделы по точкам монтирования, копирует в них rootfs и
+// генерирует /etc/fstab. Если в rootfs включен SELinux, файлы образа
+// размечаются по его политике.
+func populate(ctx context.Context, opts RawOptions, layout []layoutEntry) error {
+	var mounted []layoutEntry
+	for _, entry := range layout {
+		mount := entry.partition.Mount
+		if entry.blob != "" || mount == "" || !strings.HasPrefix(mount, "/") {
+			continue
+		}
+		if entry.partition.Filesystem == "swap" || entry.partition.Filesystem == "none" {
+			continue
+		}
+		mounted = append(mounted, entry)
+	}
+
+	if len(mounted) == 0 || opts.Rootfs == "" {
+		return nil
+	}
+
+	// Сначала корень, затем вложенные точки монтирования
+	sort.Slice(mounted, func(i, j int) bool {
+		return len(mounted[i].partition.Mount) < len(mounted[j].partition.Mount)
+	})
+	if mounted[0].partition.Mount != "/" {
+		return fmt.Errorf("no partition is mounted at /, cannot populate image")
+	}
+
+	mountBase, err := os.MkdirTemp("", "sysweaver-image-")
+	if err != nil {
+		return fmt.Errorf("error creating image mount directory: %w", err)
+	}
+	defer os.Remove(mountBase)
+
+	var active []string
+	defer func() {
+		for i := len(active) - 1; i >= 0; i-- {
+			if err := exec.Command("umount", active[i]).Run(); err != nil {
+				exec.Command("umount", "-l", active[i]).Run()
+			}
+		}
+	}()
+
+	for _, entry := range mounted {
+		target := filepath.Join(mountBase, entry.partition.Mount)
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return fmt.Errorf("error creating mount point %s: %w", target, err)
+		}
+
+		cmd := exec.Command("mount", entry.device, target)
+		cmd.Stdout = opts.LogWriter
+		cmd.Stderr = opts.LogWriter
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("error mounting partition %s: %w", entry.partition.Name, err)
+		}
+		active = append(active, target)
+	}
+
+	opts.Logger.Info("copying rootfs into image partitions")
+
+	if err := extractRootfs(ctx, opts, mountBase); err != nil {
+		return err
+	}
+	if err := writeFstab(layout, mountBase, opts.Logger); err != nil {
+		return err
+	}
+
+	// Метки SELinux назначаются по путям в образе, с учетом всех разделов
+	if contexts := selinuxFileContexts(opts.Rootfs); contexts != "" {
+		return relabel(ctx, mountBase, contexts, opts.LogWriter, opts.Logger)
+	}
+	return nil
+}
+
+// extractRootfs копирует rootfs в смонтированный образ через tar-поток,
+// сохраняя владельцев, права, xattrs и файлы устройств
+func extractRootfs(ctx context.Context, opts RawOptions, target string) error {
+	cmd := exec.CommandContext(ctx, "tar", "-x", "-p", "--numeric-owner", "--xattrs", "--xattrs-include=*", "-C", target, "-f", "-")
+	cmd.Stdout = opts.LogWriter
+	cmd.Stderr = opts.LogWriter
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("error creating tar pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error starting tar: %w", err)
+	}
+
+	tarOpts := rootfs.TarOptions{Exclude: opts.Exclude, Xattrs: true}
+	total, _ := rootfs.Size(opts.Rootfs, tarOpts)
+
+	pw := progress.NewWriter(stdin, "populate", total)
+	writeErr := rootfs.WriteTar(pw, opts.Rootfs, tarOpts)
+	stdin.Close()
+	waitErr := cmd.Wait()
+	if writeErr == nil && waitErr == nil {
+		pw.Finish()
+	}
+
+	if writeErr != nil {
+		return fmt.Errorf("error copying rootfs: %w", writeErr)
+	}
+	if waitErr != nil {
+		return fmt.Errorf("error extracting rootfs into image: %w", waitErr)
+	}
+
+	return nil
+}
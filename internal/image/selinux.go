@@ -0,0 +1,70 @@
+package image
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// selinuxFileContexts возвращает путь к file_contexts политики SELinux
+// относительно корня ФС или "", если SELinux в системе не используется
+func selinuxFileContexts(root string) string {
+	file, err := os.Open(filepath.Join(root, "etc/selinux/config"))
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	mode, policy := "", ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "SELINUX":
+			mode = value
+		case "SELINUXTYPE":
+			policy = value
+		}
+	}
+	if mode == "" || mode == "disabled" || policy == "" {
+		return ""
+	}
+
+	contexts := filepath.Join("etc/selinux", policy, "contexts/files/file_contexts")
+	if _, err := os.Stat(filepath.Join(root, contexts)); err != nil {
+		return ""
+	}
+	return contexts
+}
+
+// relabel присваивает файлам смонтированного образа target метки SELinux по
+// политике из самого образа. Метки rootfs в jail ненадежны: dnf --installroot
+// и скрипты работают на хосте с другой политикой или без SELinux. Без setfiles
+// на хосте образ помечается для переразметки при первой загрузке.
+func relabel(ctx context.Context, target, contexts string, logWriter io.Writer, log *slog.Logger) error {
+	if _, err := exec.LookPath("setfiles"); err != nil {
+		log.Warn("setfiles not found, SELinux labels will be applied on first boot")
+		if err := os.WriteFile(filepath.Join(target, ".autorelabel"), nil, 0644); err != nil {
+			return fmt.Errorf("error scheduling SELinux relabel: %w", err)
+		}
+		return nil
+	}
+
+	log.Info("applying SELinux labels", "contexts", contexts)
+	cmd := exec.CommandContext(ctx, "setfiles", "-F", "-r", target, filepath.Join(target, contexts), target)
+	cmd.Stdout = logWriter
+	cmd.Stderr = logWriter
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error applying SELinux labels: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package imagemount
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Partition - смонтированный раздел образа
+type Partition struct {
+	Device string // Устройство раздела (/dev/loop0p1), пусто для каталога/ISO
+	Dir    string // Точка монтирования
+}
+
+// Mounted - образ, смонтированный только для чтения
+type Mounted struct {
+	Root       string      // Корневая ФС образа
+	Partitions []Partition // Все смонтированные разделы (включая корневой)
+
+	baseDir   string   // Временная директория с точками монтирования
+	mounts    []string // Точки монтирования в порядке создания
+	loopDev   string   // Подключенное loop-устройство
+	nbdDev    string   // Подключенное nbd-устройство
+	logWriter io.Writer
+}
+
+// MountReadOnly монтирует артефакт сборки только для чтения.
+// Поддерживаются каталоги (rootfs), ISO, raw-образы с таблицей разделов или без
+// и форматы qemu (qcow2, vmdk, vhdx, vdi) через qemu-nbd.
+func MountReadOnly(imagePath string, logWriter io.Writer) (*Mounted, error) {
+	info, err := os.Stat(imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("image not found: %s", imagePath)
+	}
+
+	// Каталог используется как есть
+	if info.IsDir() {
+		return &Mounted{
+			Root:       imagePath,
+			Partitions: []Partition{{Dir: imagePath}},
+			logWriter:  logWriter,
+		}, nil
+	}
+
+	baseDir, err := os.MkdirTemp("", "sysweaver-ro-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mount directory: %w", err)
+	}
+
+	m := &Mounted{baseDir: baseDir, logWriter: logWriter}
+
+	switch strings.ToLower(filepath.Ext(imagePath)) {
+	case ".iso":
+		err = m.mountISO(imagePath)
+	case ".qcow2", ".vmdk", ".vhdx", ".vdi":
+		err = m.mountQemu(imagePath)
+	default:
+		err = m.mountRaw(imagePath)
+	}
+
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// mountISO монтирует ISO; если внутри есть squashfs с rootfs, корнем становится он
+func (m *Mounted) mountISO(imagePath string) error {
+	isoDir := filepath.Join(m.baseDir, "iso")
+	if err := m.mount(isoDir, "-o", "ro,loop", imagePath); err != nil {
+		return err
+	}
+	m.Root = isoDir
+	m.Partitions = append(m.Partitions, Partition{Dir: isoDir})
+
+	squashfs, _ := filepath.Glob(filepath.Join(isoDir, "*", "*.squashfs"))
+	rootSquash, _ := filepath.Glob(filepath.Join(isoDir, "*.squashfs"))
+	squashfs = append(rootSquash, squashfs...)
+	if len(squashfs) > 0 {
+		rootDir := filepath.Join(m.baseDir, "squashfs")
+		if err := m.mount(rootDir, "-t", "squashfs", "-o", "ro,loop", squashfs[0]); err != nil {
+			return err
+		}
+		m.Root = rootDir
+		m.Partitions = append(m.Partitions, Partition{Dir: rootDir})
+	}
+
+	return nil
+}
+
+// mountRaw подключает raw-образ через loop-устройство и монтирует его разделы
+func (m *Mounted) mountRaw(imagePath string) error {
+	out, err := exec.Command("losetup", "--find", "--show", "--read-only", "--partscan", imagePath).Output()
+	if err != nil {
+		return fmt.Errorf("failed to attach loop device: %w", err)
+	}
+	m.loopDev = strings.TrimSpace(string(out))
+
+	return m.mountDevicePartitions(m.loopDev)
+}
+
+// mountQemu подключает образ qemu через qemu-nbd и монтирует его разделы
+func (m *Mounted) mountQemu(imagePath string) error {
+	// Модуль nbd может быть не загружен
+	exec.Command("modprobe", "nbd", "max_part=16").Run()
+
+	devices, _ := filepath.Glob("/sys/class/block/nbd*")
+	for _, sysDev := range devices {
+		name := filepath.Base(sysDev)
+		if strings.Contains(name, "p") {
+			continue
+		}
+
+		// Устройство свободно, если у него нет pid-файла
+		if _, err := os.Stat(filepath.Join(sysDev, "pid")); err == nil {
+			continue
+		}
+
+		dev := "/dev/" + name
+		cmd := exec.Command("qemu-nbd", "--read-only", "--connect", dev, imagePath)
+		cmd.Stdout = m.logWriter
+		cmd.Stderr = m.logWriter
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("failed to connect %s via qemu-nbd: %w", imagePath, err)
+		}
+		m.nbdDev = dev
+
+		// Даем ядру время прочитать таблицу разделов
+		exec.Command("udevadm", "settle").Run()
+
+		return m.mountDevicePartitions(dev)
+	}
+
+	return fmt.Errorf("no free nbd device available")
+}
+
+// mountDevicePartitions монтирует все разделы блочного устройства (или само
+// устройство, если таблицы разделов нет) и определяет корневую ФС
+func (m *Mounted) mountDevicePartitions(device string) error {
+	// Glob сортирует имена лексически (p1, p10, p2), поэтому разделы
+	// упорядочиваются по номеру, и директория называется номером раздела
+	type devicePartition struct {
+		device string
+		number int
+	}
+	var partitions []devicePartition
+	matches, _ := filepath.Glob(device + "p*")
+	for _, match := range matches {
+		if number, err := strconv.Atoi(strings.TrimPrefix(match, device+"p")); err == nil {
+			partitions = append(partitions, devicePartition{device: match, number: number})
+		}
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i].number < partitions[j].number })
+	if len(partitions) == 0 {
+		partitions = []devicePartition{{device: device, number: 1}}
+	}
+
+	for _, partition := range partitions {
+		part := partition.device
+		dir := filepath.Join(m.baseDir, fmt.Sprintf("p%d", partition.number))
+		if err := m.mount(dir, "-o", "ro", part); err != nil {
+			// Разделы без ФС (BIOS boot, swap) пропускаем
+			fmt.Fprintf(m.logWriter, "Skipping partition %s: %v\n", part, err)
+			continue
+		}
+		m.Partitions = append(m.Partitions, Partition{Device: part, Dir: dir})
+	}
+
+	if len(m.Partitions) == 0 {
+		return fmt.Errorf("no mountable partitions found in %s", device)
+	}
+
+	// Корень - раздел с /etc, иначе первый смонтированный
+	m.Root = m.Partitions[0].Dir
+	for _, p := range m.Partitions {
+		if info, err := os.Stat(filepath.Join(p.Dir, "etc")); err == nil && info.IsDir() {
+			m.Root = p.Dir
+			break
+		}
+	}
+
+	return nil
+}
+
+// mount создает точку монтирования и монтирует источник
+func (m *Mounted) mount(target string, args ...string) error {
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return fmt.Errorf("failed to create mount point %s: %w", target, err)
+	}
+
+	cmd := exec.Command("mount", append(args, target)...)
+	cmd.Stdout = m.logWriter
+	cmd.Stderr = m.logWriter
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to mount %s: %w", args[len(args)-1], err)
+	}
+
+	m.mounts = append(m.mounts, target)
+	return nil
+}
+
+// Close размонтирует образ и освобождает устройства
+func (m *Mounted) Close() error {
+	var firstErr error
+
+	for i := len(m.mounts) - 1; i >= 0; i-- {
+		if err := exec.Command("umount", m.mounts[i]).Run(); err != nil {
+			exec.Command("umount", "-l", m.mounts[i]).Run()
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to unmount %s: %w", m.mounts[i], err)
+			}
+		}
+		// Удаляем только пустую точку монтирования, содержимое образа не трогаем
+		os.Remove(m.mounts[i])
+	}
+	m.mounts = nil
+
+	if m.loopDev != "" {
+		exec.Command("losetup", "-d", m.loopDev).Run()
+		m.loopDev = ""
+	}
+
+	if m.nbdDev != "" {
+		exec.Command("qemu-nbd", "--disconnect", m.nbdDev).Run()
+		m.nbdDev = ""
+	}
+
+	if m.baseDir != "" {
+		os.Remove(m.baseDir)
+		m.baseDir = ""
+	}
+
+	return firstErr
+}
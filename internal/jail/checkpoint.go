@@ -0,0 +1,144 @@
+package jail
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Контрольная точка стадии хранится в директории вида:
+//
+//	<dir>/upper  - снимок верхнего слоя overlay
+//	<dir>/criu   - образы дерева процессов jail (criu dump)
+
+// SetOverlaySnapshot задает снимок верхнего слоя overlay, из которого
+// будет восстановлено состояние ФС при следующем Start
+func (j *Jail) SetOverlaySnapshot(dir string) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if !j.running {
+		j.snapshotDir = dir
+	}
+}
+
+// Checkpoint сохраняет состояние jail: снимок overlay и, если withProcesses,
+// дерево процессов через CRIU. Jail продолжает работу после сохранения.
+func (j *Jail) Checkpoint(dir string, withProcesses bool) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if !j.running {
+		return fmt.Errorf("jail is not running")
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to clean checkpoint directory: %w", err)
+	}
+
+	upperSnapshot := filepath.Join(dir, "upper")
+	if err := os.MkdirAll(upperSnapshot, 0755); err != nil {
+		return fmt.Errorf("failed to create checkpoint directory: %w", err)
+	}
+
+	// Сначала замораживаем процессы, затем копируем ФС, чтобы снимки были согласованы
+	if withProcesses {
+		pid := j.jailPid()
+		if pid <= 0 {
+			return fmt.Errorf("jail process is not running, nothing to checkpoint")
+		}
+
+		criuDir := filepath.Join(dir, "criu")
+		if err := os.MkdirAll(criuDir, 0700); err != nil {
+			return fmt.Errorf("failed to create criu directory: %w", err)
+		}
+
+		j.logger.Info("checkpointing jail process tree", "pid", pid, "dir", criuDir)
+		dumpCmd := exec.Command("criu", "dump",
+			"--tree", strconv.Itoa(pid),
+			"--images-dir", criuDir,
+			"--leave-running",
+			"--shell-job",
+			"--tcp-established",
+			"--file-locks",
+		)
+		dumpCmd.Stdout = j.logWriter
+		dumpCmd.Stderr = j.logWriter
+		if err := dumpCmd.Run(); err != nil {
+			return fmt.Errorf("criu dump failed: %w", err)
+		}
+	}
+
+	// Снимок сохраняется и после отмены сборки: по нему продолжает --resume
+	j.logger.Info("saving overlay snapshot", "dir", upperSnapshot)
+	cpCmd := exec.CommandContext(context.WithoutCancel(j.ctx), "cp", "-a", j.upperDir+"/.", upperSnapshot)
+	cpCmd.Stdout = j.logWriter
+	cpCmd.Stderr = j.logWriter
+	if err := cpCmd.Run(); err != nil {
+		return fmt.Errorf("failed to save overlay snapshot: %w", err)
+	}
+
+	return nil
+}
+
+// RestoreProcesses восстанавливает дерево процессов jail из контрольной точки.
+// Overlay должен быть уже восстановлен через SetOverlaySnapshot и Start.
+func (j *Jail) RestoreProcesses(dir string) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if !j.running {
+		return fmt.Errorf("jail is not running")
+	}
+
+	criuDir := filepath.Join(dir, "criu")
+	if _, err := os.Stat(criuDir); os.IsNotExist(err) {
+		return fmt.Errorf("no process checkpoint found in %s", dir)
+	}
+
+	pidFile := filepath.Join(dir, "restored.pid")
+	os.Remove(pidFile)
+
+	j.logger.Info("restoring jail process tree", "dir", criuDir)
+	restoreCmd := exec.Command("criu", "restore",
+		"--images-dir", criuDir,
+		"--restore-detached",
+		"--shell-job",
+		"--tcp-established",
+		"--file-locks",
+		"--pidfile", pidFile,
+	)
+	restoreCmd.Stdout = j.logWriter
+	restoreCmd.Stderr = j.logWriter
+	if err := restoreCmd.Run(); err != nil {
+		return fmt.Errorf("criu restore failed: %w", err)
+	}
+
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		return fmt.Errorf("failed to read restored pid: %w", err)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("invalid restored pid: %w", err)
+	}
+
+	j.restoredPid = pid
+	return nil
+}
+
+// jailPid возвращает PID корневого процесса jail (восстановленного или исходного)
+func (j *Jail) jailPid() int {
+	if j.restoredPid > 0 {
+		return j.restoredPid
+	}
+	if j.cmd != nil && j.cmd.Process != nil {
+		return j.cmd.Process.Pid
+	}
+	return 0
+}
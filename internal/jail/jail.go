@@ -1,27 +1,55 @@
 package jail
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"log/slog"
+	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"sysweaver/internal/config"
+	"sysweaver/internal/progress"
+	"sysweaver/internal/rootfs"
+	"sysweaver/internal/secrets"
 	"sysweaver/internal/structures"
 )
 
 type Jail struct {
+	ctx        context.Context // Отмена прерывает команды jail; очистка выполняется и после нее
 	cmd        *exec.Cmd
 	config     structures.JailConfig
 	configPath string
 	running    bool
 	mutex      sync.Mutex
 	logWriter  io.Writer
-	mounts     []string // Для отслеживания смонтированных ФС
+	logger     *slog.Logger         // Журнал сборки (SetLogger)
+	emit       func(progress.Event) // Получатель событий mount_created (SetEventHandler)
+	mounts     []string             // Для отслеживания смонтированных ФС
+	stdin      io.WriteCloser
+
+	// Overlay и контрольные точки
+	workspace   string // Каталог chroot, overlay и контрольных точек вместо путей из jail.yaml
+	upperDir    string // Верхний слой overlay текущего запуска
+	snapshotDir string // Снимок верхнего слоя для восстановления
+	restoredPid int    // PID дерева процессов, восстановленного через CRIU
+
+	// Секреты для скриптов и маскирование их значений в логах
+	secrets  map[string][]byte
+	redactor *secrets.Redactor
+
+	// Файлы, монтируемые в tmpfs внутри jail (путь в chroot -> содержимое),
+	// и переменные окружения скриптов
+	runtimeFiles map[string][]byte
+	scriptEnv    []string
 
 	// Внутренние настройки изоляции
 	pidNamespace bool
@@ -29,31 +57,37 @@ type Jail struct {
 	gidMappings  []structures.IDMapping
 }
 
-func NewJail(configPath string, templatePath string) (*Jail, error) {
+// NewJail создает jail по конфигурации configPath, загружаемой с параметрами
+// loadOpts (переменные, каталоги include, строгость разбора). Отмена ctx
+// прерывает запущенные в jail команды (скрипты, восстановление и экспорт
+// слоев); Stop освобождает ресурсы и после отмены. Вывод команд jail пишется
+// в logWriter; nil - вывод отбрасывается.
+func NewJail(ctx context.Context, configPath string, templatePath string, loadOpts config.Options, logWriter io.Writer) (*Jail, error) {
 	var jailConfig structures.JailConfig
 
-	err := config.LoadConfig(configPath, &jailConfig)
+	err := config.Load(configPath, &jailConfig, loadOpts)
 	if err != nil {
 		return nil, err
 	}
 
-	// Проверка обязательных полей
-	if jailConfig.ChrootDir == "" {
-		return nil, fmt.Errorf("chroot directory not specified in config")
-	}
-
-	if jailConfig.BuilderPath == "" {
-		return nil, fmt.Errorf("builder path not specified in config")
-	}
-
 	// Устанавливаем путь к шаблону из аргумента
 	jailConfig.TemplatePath = templatePath
 
+	if jailConfig.CheckpointDir == "" {
+		jailConfig.CheckpointDir = filepath.Join(os.TempDir(), "sysweaver-checkpoints")
+	}
+	if logWriter == nil {
+		logWriter = io.Discard
+	}
+
 	return &Jail{
+		ctx:          ctx,
 		config:       jailConfig,
 		configPath:   configPath,
 		running:      false,
-		logWriter:    os.Stdout,
+		logWriter:    logWriter,
+		logger:       slog.Default(),
+		emit:         progress.Emit,
 		mounts:       []string{},
 		pidNamespace: true,
 		uidMappings: []structures.IDMapping{
@@ -84,6 +118,9 @@ func (j *Jail) setupMounts() error {
 
 	// Создаем временную директорию для overlay
 	tmpMountBase := filepath.Join(os.TempDir(), "sysweaver-mount")
+	if j.workspace != "" {
+		tmpMountBase = filepath.Join(j.workspace, "mount")
+	}
 
 	// Очищаем, если существует
 	if _, err := os.Stat(tmpMountBase); err == nil {
@@ -100,6 +137,18 @@ func (j *Jail) setupMounts() error {
 		return fmt.Errorf("failed to create upper directory: %w", err)
 	}
 
+	// Восстанавливаем верхний слой из снимка контрольной точки
+	if j.snapshotDir != "" {
+		j.logger.Info("restoring overlay snapshot", "dir", j.snapshotDir)
+		restoreCmd := exec.CommandContext(j.ctx, "cp", "-a", j.snapshotDir+"/.", upperDir)
+		restoreCmd.Stdout = j.logWriter
+		restoreCmd.Stderr = j.logWriter
+		if err := restoreCmd.Run(); err != nil {
+			return fmt.Errorf("failed to restore overlay snapshot: %w", err)
+		}
+	}
+	j.upperDir = upperDir
+
 	if err := os.MkdirAll(workDir, 0755); err != nil {
 		return fmt.Errorf("failed to create work directory: %w", err)
 	}
@@ -111,7 +160,7 @@ func (j *Jail) setupMounts() error {
 		workDir,
 	)
 
-	fmt.Fprintf(j.logWriter, "Mounting overlay with options: %s\n", overlayOptions)
+	j.logger.Debug("mounting overlay", "options", overlayOptions)
 
 	// Используем mount команду для overlay
 	mountCmd := exec.Command("mount", "-t", "overlay", "overlay",
@@ -124,7 +173,7 @@ func (j *Jail) setupMounts() error {
 		return fmt.Errorf("failed to mount overlay: %w", err)
 	}
 
-	j.mounts = append(j.mounts, j.config.ChrootDir)
+	j.addMount(j.config.ChrootDir, "overlay", "overlay")
 
 	// Монтируем специальные файловые системы
 	specialMounts := []struct {
@@ -157,7 +206,7 @@ func (j *Jail) setupMounts() error {
 			return fmt.Errorf("failed to mount %s to %s: %w", m.source, targetDir, err)
 		}
 
-		j.mounts = append(j.mounts, targetDir)
+		j.addMount(targetDir, m.source, m.fstype)
 	}
 
 	// Монтируем шаблон в специальные точки внутри chroot
@@ -165,6 +214,16 @@ func (j *Jail) setupMounts() error {
 		return fmt.Errorf("failed to mount template: %w", err)
 	}
 
+	// Сведения о сборке и секреты - в tmpfs, чтобы они не попали в верхний слой overlay
+	if err := j.mountRuntimeFiles(); err != nil {
+		return err
+	}
+	if len(j.secrets) > 0 {
+		if err := j.mountSecrets(); err != nil {
+			return err
+		}
+	}
+
 	// Дополнительные точки монтирования из конфигурации
 	for _, mountPoint := range j.config.MountPoints {
 		targetDir := filepath.Join(j.config.ChrootDir, mountPoint.Destination)
@@ -212,12 +271,68 @@ func (j *Jail) setupMounts() error {
 			return fmt.Errorf("failed to mount %s to %s: %w", mountPoint.Source, targetDir, err)
 		}
 
-		j.mounts = append(j.mounts, targetDir)
+		j.addMount(targetDir, mountPoint.Source, mountPoint.Type)
+	}
+
+	return nil
+}
+
+// addMount запоминает точку монтирования для размонтирования при остановке
+// и сообщает о ней событием mount_created
+func (j *Jail) addMount(target, source, fstype string) {
+	j.mounts = append(j.mounts, target)
+	j.emit(progress.Event{Type: progress.EventMountCreated, Path: target, Source: source, FSType: fstype})
+}
+
+// mountRuntimeFiles монтирует tmpfs в каталоги файлов из SetRuntimeFile и записывает их
+func (j *Jail) mountRuntimeFiles() error {
+	dirs := make(map[string]bool)
+	for path := range j.runtimeFiles {
+		dirs[filepath.Dir(path)] = true
+	}
+
+	for dir := range dirs {
+		targetDir := filepath.Join(j.config.ChrootDir, dir)
+		if err := os.MkdirAll(targetDir, 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", targetDir, err)
+		}
+
+		mountCmd := exec.Command("mount", "-t", "tmpfs", "-o", "mode=0755,size=16m,nosuid,nodev", "tmpfs", targetDir)
+		mountCmd.Stdout = j.logWriter
+		mountCmd.Stderr = j.logWriter
+		if err := mountCmd.Run(); err != nil {
+			return fmt.Errorf("failed to mount tmpfs on %s: %w", dir, err)
+		}
+		j.addMount(targetDir, "tmpfs", "tmpfs")
 	}
 
+	for path, data := range j.runtimeFiles {
+		if err := os.WriteFile(filepath.Join(j.config.ChrootDir, path), data, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+	}
 	return nil
 }
 
+// mountSecrets монтирует tmpfs с файлами секретов в secrets.Dir
+func (j *Jail) mountSecrets() error {
+	targetDir := filepath.Join(j.config.ChrootDir, secrets.Dir)
+	if err := os.MkdirAll(targetDir, 0700); err != nil {
+		return fmt.Errorf("failed to create secrets directory %s: %w", targetDir, err)
+	}
+
+	j.logger.Debug("mounting secrets", "count", len(j.secrets), "path", secrets.Dir)
+	mountCmd := exec.Command("mount", "-t", "tmpfs", "-o", "mode=0700,size=16m,nosuid,nodev,noexec", "tmpfs", targetDir)
+	mountCmd.Stdout = j.logWriter
+	mountCmd.Stderr = j.logWriter
+	if err := mountCmd.Run(); err != nil {
+		return fmt.Errorf("failed to mount secrets tmpfs: %w", err)
+	}
+	j.addMount(targetDir, "tmpfs", "tmpfs")
+
+	return secrets.Write(targetDir, j.secrets)
+}
+
 // mountTemplate монтирует компоненты шаблона в соответствующие точки
 func (j *Jail) mountTemplate() error {
 	// Важно: проверяем существование шаблона
@@ -226,7 +341,7 @@ func (j *Jail) mountTemplate() error {
 	}
 
 	// Логируем путь к шаблону для диагностики
-	fmt.Fprintf(j.logWriter, "Mounting template from: %s\n", j.config.TemplatePath)
+	j.logger.Debug("mounting template", "template", j.config.TemplatePath)
 
 	// Определяем точки монтирования внутри chroot
 	templateMount := filepath.Join(j.config.ChrootDir, "template")
@@ -241,7 +356,7 @@ func (j *Jail) mountTemplate() error {
 	}
 
 	// Монтируем корень шаблона В РЕЖИМЕ ТОЛЬКО ДЛЯ ЧТЕНИЯ
-	fmt.Fprintf(j.logWriter, "Mounting template root to %s (read-only)\n", templateMount)
+	j.logger.Debug("mounting template root read-only", "path", templateMount)
 	mountCmd := exec.Command("mount", "--bind", j.config.TemplatePath, templateMount)
 	mountCmd.Stdout = j.logWriter
 	mountCmd.Stderr = j.logWriter
@@ -257,7 +372,7 @@ func (j *Jail) mountTemplate() error {
 		return fmt.Errorf("failed to remount template as read-only: %w", err)
 	}
 
-	j.mounts = append(j.mounts, templateMount)
+	j.addMount(templateMount, j.config.TemplatePath, "bind")
 
 	// Проверяем существование scripts директории в шаблоне
 	scriptsSrc := filepath.Join(j.config.TemplatePath, "scripts")
@@ -269,7 +384,7 @@ func (j *Jail) mountTemplate() error {
 	}
 
 	// Монтируем директорию скриптов В РЕЖИМЕ ТОЛЬКО ДЛЯ ЧТЕНИЯ
-	fmt.Fprintf(j.logWriter, "Mounting scripts directory to %s (read-only)\n", scriptsMount)
+	j.logger.Debug("mounting scripts directory read-only", "path", scriptsMount)
 	scriptsCmd := exec.Command("mount", "--bind", scriptsSrc, scriptsMount)
 	scriptsCmd.Stdout = j.logWriter
 	scriptsCmd.Stderr = j.logWriter
@@ -285,7 +400,7 @@ func (j *Jail) mountTemplate() error {
 		return fmt.Errorf("failed to remount scripts as read-only: %w", err)
 	}
 
-	j.mounts = append(j.mounts, scriptsMount)
+	j.addMount(scriptsMount, scriptsSrc, "bind")
 
 	// ВАЖНО: Проверяем что шаблон действительно смонтирован И ЗАЩИЩЕН (read-only)
 	tempFile := filepath.Join(templateMount, "test_readonly.tmp")
@@ -301,13 +416,13 @@ func (j *Jail) mountTemplate() error {
 	// Убедимся что ошибка именно из-за read-only ФС
 	errStr := string(touchOutput)
 	if !strings.Contains(errStr, "Read-only") && !strings.Contains(errStr, "read-only") {
-		fmt.Fprintf(j.logWriter, "Template protection test failed with unexpected error: %s\n", errStr)
+		j.logger.Warn("template protection test failed with unexpected error", "error", errStr)
 	} else {
-		fmt.Fprintf(j.logWriter, "Template protection verified: mounted as read-only\n")
+		j.logger.Debug("template protection verified: mounted as read-only")
 	}
 
 	// Логируем завершение монтирования шаблона
-	fmt.Fprintf(j.logWriter, "Template mounted successfully\n")
+	j.logger.Debug("template mounted")
 
 	return nil
 }
@@ -345,6 +460,14 @@ func (j *Jail) Start() error {
 	j.cmd.Stdout = j.logWriter
 	j.cmd.Stderr = j.logWriter
 
+	// Держим stdin открытым, чтобы процесс jail жил до Stop (нужно для контрольных точек)
+	stdin, err := j.cmd.StdinPipe()
+	if err != nil {
+		j.cleanup()
+		return fmt.Errorf("failed to create stdin pipe: %w", err)
+	}
+	j.stdin = stdin
+
 	// Настраиваем namespaces
 	j.cmd.SysProcAttr = &syscall.SysProcAttr{
 		Chroot: j.config.ChrootDir,
@@ -395,7 +518,7 @@ func (j *Jail) isMounted(path string) bool {
 	// Читаем /proc/mounts для проверки
 	data, err := os.ReadFile("/proc/mounts")
 	if err != nil {
-		fmt.Fprintf(j.logWriter, "Warning: cannot read /proc/mounts: %v\n", err)
+		j.logger.Warn("cannot read /proc/mounts", "error", err)
 		return false
 	}
 
@@ -423,21 +546,21 @@ func (j *Jail) isMounted(path string) bool {
 
 // cleanup размонтирует все файловые системы и восстанавливает системные устройства
 func (j *Jail) cleanup() {
-	fmt.Fprintf(j.logWriter, "Starting cleanup process...\n")
+	j.logger.Debug("starting jail cleanup")
 
 	// Размонтируем в обратном порядке
 	for i := len(j.mounts) - 1; i >= 0; i-- {
 		mountPoint := j.mounts[i]
 
-		fmt.Fprintf(j.logWriter, "Processing mount point: %s\n", mountPoint)
+		j.logger.Debug("processing mount point", "path", mountPoint)
 
 		// Проверяем, смонтирован ли путь
 		if !j.isMounted(mountPoint) {
-			fmt.Fprintf(j.logWriter, "Path %s is not mounted, skipping\n", mountPoint)
+			j.logger.Debug("not mounted, skipping", "path", mountPoint)
 			continue
 		}
 
-		fmt.Fprintf(j.logWriter, "Unmounting %s...\n", mountPoint)
+		j.logger.Debug("unmounting", "path", mountPoint)
 
 		// Сначала пытаемся обычное размонтирование
 		umountCmd := exec.Command("umount", mountPoint)
@@ -445,26 +568,26 @@ func (j *Jail) cleanup() {
 		umountCmd.Stderr = j.logWriter
 
 		if err := umountCmd.Run(); err != nil {
-			fmt.Fprintf(j.logWriter, "Warning: normal unmount failed for %s: %v\n", mountPoint, err)
+			j.logger.Warn("normal unmount failed", "path", mountPoint, "error", err)
 
 			// Принудительное размонтирование
-			fmt.Fprintf(j.logWriter, "Trying forced unmount for %s...\n", mountPoint)
+			j.logger.Debug("trying forced unmount", "path", mountPoint)
 			forceCmd := exec.Command("umount", "-f", mountPoint)
 			forceCmd.Stdout = j.logWriter
 			forceCmd.Stderr = j.logWriter
 
 			if err := forceCmd.Run(); err != nil {
-				fmt.Fprintf(j.logWriter, "Warning: forced unmount failed for %s: %v\n", mountPoint, err)
+				j.logger.Warn("forced unmount failed", "path", mountPoint, "error", err)
 
 				// Ленивое размонтирование как последний шанс
-				fmt.Fprintf(j.logWriter, "Trying lazy unmount for %s...\n", mountPoint)
+				j.logger.Debug("trying lazy unmount", "path", mountPoint)
 				lazyCmd := exec.Command("umount", "-l", mountPoint)
 				lazyCmd.Stdout = j.logWriter
 				lazyCmd.Stderr = j.logWriter
 				lazyCmd.Run() // Игнорируем ошибку для lazy unmount
 			}
 		} else {
-			fmt.Fprintf(j.logWriter, "Successfully unmounted %s\n", mountPoint)
+			j.logger.Debug("unmounted", "path", mountPoint)
 		}
 	}
 
@@ -473,7 +596,7 @@ func (j *Jail) cleanup() {
 
 	// Дополнительная очистка: принудительно размонтируем все что может остаться
 	if j.config.ChrootDir != "" {
-		fmt.Fprintf(j.logWriter, "Performing additional cleanup for %s...\n", j.config.ChrootDir)
+		j.logger.Debug("performing additional cleanup", "path", j.config.ChrootDir)
 
 		// Список возможных mount точек для принудительной очистки
 		possibleMounts := []string{
@@ -488,7 +611,7 @@ func (j *Jail) cleanup() {
 
 		for _, mount := range possibleMounts {
 			if j.isMounted(mount) {
-				fmt.Fprintf(j.logWriter, "Found remaining mount: %s, force unmounting...\n", mount)
+				j.logger.Debug("found remaining mount, force unmounting", "path", mount)
 				exec.Command("umount", "-f", mount).Run()
 				exec.Command("umount", "-l", mount).Run()
 			}
@@ -496,7 +619,7 @@ func (j *Jail) cleanup() {
 	}
 
 	// ВАЖНО: восстановить права на /dev/null и другие устройства
-	fmt.Fprintf(j.logWriter, "Restoring system device permissions...\n")
+	j.logger.Debug("restoring system device permissions")
 
 	// Проверяем права на /dev/null
 	nullInfo, _ := os.Stat("/dev/null")
@@ -504,7 +627,7 @@ func (j *Jail) cleanup() {
 		mode := nullInfo.Mode()
 		if mode&0666 != 0666 {
 			// Права не 666, исправляем
-			fmt.Fprintf(j.logWriter, "Fixing /dev/null permissions...\n")
+			j.logger.Debug("fixing device permissions", "device", "/dev/null")
 			exec.Command("chmod", "666", "/dev/null").Run()
 		}
 	}
@@ -516,26 +639,31 @@ func (j *Jail) cleanup() {
 		if devInfo != nil {
 			mode := devInfo.Mode()
 			if mode&0666 != 0666 {
-				fmt.Fprintf(j.logWriter, "Fixing %s permissions...\n", dev)
+				j.logger.Debug("fixing device permissions", "device", dev)
 				exec.Command("chmod", "666", dev).Run()
 			}
 		}
 	}
 
 	// Очищаем loop устройства созданные скриптами (мера безопасности)
-	fmt.Fprintf(j.logWriter, "Cleaning up loop devices...\n")
+	j.logger.Debug("cleaning up loop devices")
 	j.cleanupLoopDevices()
 
-	// Очищаем временные директории
-	if strings.Contains(j.config.ChrootDir, "sysweaver") {
+	// Очищаем временные директории; в заданном рабочем каталоге остаются
+	// контрольные точки и состояние для --resume
+	if j.workspace != "" {
+		j.logger.Debug("removing jail directories in workspace", "path", j.workspace)
+		os.RemoveAll(j.config.ChrootDir)
+		os.RemoveAll(filepath.Join(j.workspace, "mount"))
+	} else if strings.Contains(j.config.ChrootDir, "sysweaver") {
 		tmpBase := filepath.Dir(j.config.ChrootDir)
 		if strings.Contains(tmpBase, "tmp") {
-			fmt.Fprintf(j.logWriter, "Removing temporary directory: %s\n", tmpBase)
+			j.logger.Debug("removing temporary directory", "path", tmpBase)
 			os.RemoveAll(tmpBase)
 		}
 	}
 
-	fmt.Fprintf(j.logWriter, "Cleanup completed\n")
+	j.logger.Debug("jail cleanup completed")
 }
 
 // cleanupLoopDevices очищает все loop устройства связанные с образами
@@ -544,7 +672,7 @@ func (j *Jail) cleanupLoopDevices() {
 	cmd := exec.Command("losetup", "-a")
 	output, err := cmd.Output()
 	if err != nil {
-		fmt.Fprintf(j.logWriter, "Warning: could not list loop devices: %v\n", err)
+		j.logger.Warn("could not list loop devices", "error", err)
 		return
 	}
 
@@ -561,7 +689,7 @@ func (j *Jail) cleanupLoopDevices() {
 			fields := strings.Split(line, ":")
 			if len(fields) > 0 {
 				loopDev := strings.TrimSpace(fields[0])
-				fmt.Fprintf(j.logWriter, "Detaching loop device: %s\n", loopDev)
+				j.logger.Debug("detaching loop device", "device", loopDev)
 
 				// Отключаем loop устройство
 				detachCmd := exec.Command("losetup", "-d", loopDev)
@@ -582,6 +710,19 @@ func (j *Jail) Stop() error {
 		return fmt.Errorf("jail is not running")
 	}
 
+	// Останавливаем дерево процессов, восстановленное через CRIU
+	if j.restoredPid > 0 {
+		if err := syscall.Kill(j.restoredPid, syscall.SIGKILL); err != nil {
+			j.logger.Warn("failed to kill restored process", "pid", j.restoredPid, "error", err)
+		}
+		j.restoredPid = 0
+	}
+
+	if j.stdin != nil {
+		j.stdin.Close()
+		j.stdin = nil
+	}
+
 	// Останавливаем процесс
 	if j.cmd != nil && j.cmd.Process != nil {
 		if err := j.cmd.Process.Kill(); err != nil {
@@ -591,12 +732,15 @@ func (j *Jail) Stop() error {
 		// Ждем завершения
 		if err := j.cmd.Wait(); err != nil {
 			// Игнорируем ошибку, так как процесс уже убит
-			fmt.Fprintf(j.logWriter, "Error waiting for process to exit: %v\n", err)
+			j.logger.Warn("error waiting for process to exit", "error", err)
 		}
 	}
 
 	// Очищаем монтирование
 	j.cleanup()
+	if j.redactor != nil {
+		j.redactor.Flush()
+	}
 
 	j.running = false
 	return nil
@@ -610,29 +754,90 @@ func (j *Jail) IsRunning() bool {
 	return j.running
 }
 
+// SetRuntimeFile задает файл, доступный в jail только на время сборки.
+// Его каталог монтируется как tmpfs и не попадает в rootfs и артефакты.
+func (j *Jail) SetRuntimeFile(path string, data []byte) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if j.running {
+		return
+	}
+	if j.runtimeFiles == nil {
+		j.runtimeFiles = make(map[string][]byte)
+	}
+	j.runtimeFiles[path] = data
+}
+
+// SetScriptEnv задает дополнительные переменные окружения команд в jail
+func (j *Jail) SetScriptEnv(env []string) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.scriptEnv = env
+}
+
+// SetSecrets задает секреты, доступные скриптам в secrets.Dir.
+// Их значения маскируются в выводе jail.
+func (j *Jail) SetSecrets(values map[string][]byte) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if j.running || len(values) == 0 {
+		return
+	}
+	j.secrets = values
+	j.redactor = secrets.NewRedactor(j.logWriter, values)
+	j.logWriter = j.redactor
+}
+
+// SetLogger задает журнал сборки вместо журнала по умолчанию
+func (j *Jail) SetLogger(logger *slog.Logger) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	j.logger = logger
+}
+
+// SetEventHandler задает получателя событий jail (по умолчанию шина
+// progress.Emit); сборка добавляет к событиям свой ID
+func (j *Jail) SetEventHandler(emit func(progress.Event)) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	j.emit = emit
+}
+
 // SetLogWriter устанавливает writer для вывода логов
 func (j *Jail) SetLogWriter(writer io.Writer) {
 	j.mutex.Lock()
 	defer j.mutex.Unlock()
 
+	if j.secrets != nil {
+		j.redactor = secrets.NewRedactor(writer, j.secrets)
+		writer = j.redactor
+	}
 	j.logWriter = writer
 }
 
-// ExecuteCommand выполняет команду в изолированной среде с live выводом (для verbose режима)
-func (j *Jail) ExecuteCommand(command string, args ...string) ([]byte, error) {
+// GetLogWriter возвращает writer для вывода логов
+func (j *Jail) GetLogWriter() io.Writer {
 	j.mutex.Lock()
 	defer j.mutex.Unlock()
 
-	if !j.running {
-		return nil, fmt.Errorf("jail is not running")
-	}
+	return j.logWriter
+}
 
-	// Выводим информацию о выполняемой команде
-	fmt.Fprintf(j.logWriter, "Chroot command: %s %s\n", command, strings.Join(args, " "))
+// ExecuteCommand выполняет команду в изолированной среде с live выводом (для verbose режима)
+func (j *Jail) ExecuteCommand(command string, args ...string) ([]byte, error) {
+	return j.ExecuteCommandEnv(nil, command, args...)
+}
 
-	// Запускаем команду в chroot
-	cmdArgs := append([]string{j.config.ChrootDir, command}, args...)
-	cmd := exec.Command("chroot", cmdArgs...)
+// ExecuteCommandEnv выполняет команду с live выводом, добавляя env к окружению скриптов
+func (j *Jail) ExecuteCommandEnv(env []string, command string, args ...string) ([]byte, error) {
+	cmd, err := j.chrootCommand(env, command, args...)
+	if err != nil {
+		return nil, err
+	}
 
 	// Настраиваем live вывод через logWriter
 	cmd.Stdout = j.logWriter
@@ -646,8 +851,37 @@ func (j *Jail) ExecuteCommand(command string, args ...string) ([]byte, error) {
 	return nil, nil
 }
 
-// ExecuteCommandWithOutput выполняет команду и возвращает вывод (для обычного режима)
-func (j *Jail) ExecuteCommandWithOutput(command string, args ...string) ([]byte, error) {
+// ExecuteCommandTeeEnv выполняет команду с live выводом и копией вывода в tee
+// и возвращает израсходованные ею ресурсы. Значения секретов в копии
+// маскируются так же, как в live выводе.
+func (j *Jail) ExecuteCommandTeeEnv(env []string, tee io.Writer, command string, args ...string) (Usage, error) {
+	cmd, err := j.chrootCommand(env, command, args...)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	if len(j.secrets) > 0 {
+		redactor := secrets.NewRedactor(tee, j.secrets)
+		defer redactor.Flush()
+		tee = redactor
+	}
+	output := io.MultiWriter(j.logWriter, tee)
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	usage, err := runMeasured(j.ctx, j.logger, cmd)
+	if err != nil {
+		return usage, fmt.Errorf("command failed: %w", err)
+	}
+	return usage, nil
+}
+
+// Сколько ждать закрытия вывода прерванной командой после ее завершения
+const commandWaitDelay = 10 * time.Second
+
+// chrootCommand готовит команду в chroot. Блокировка держится только на время
+// подготовки, чтобы независимые скрипты могли выполняться параллельно.
+func (j *Jail) chrootCommand(env []string, command string, args ...string) (*exec.Cmd, error) {
 	j.mutex.Lock()
 	defer j.mutex.Unlock()
 
@@ -656,19 +890,71 @@ func (j *Jail) ExecuteCommandWithOutput(command string, args ...string) ([]byte,
 	}
 
 	// Выводим информацию о выполняемой команде
-	fmt.Fprintf(j.logWriter, "Chroot command: %s %s\n", command, strings.Join(args, " "))
+	j.logger.Debug("chroot command", "command", command+" "+strings.Join(args, " "))
 
 	// Запускаем команду в chroot
 	cmdArgs := append([]string{j.config.ChrootDir, command}, args...)
-	cmd := exec.Command("chroot", cmdArgs...)
+	cmd := exec.CommandContext(j.ctx, "chroot", cmdArgs...)
+	cmd.Env = append(append(os.Environ(), j.scriptEnv...), env...)
+	// Отвязавшиеся от прерванной команды процессы не должны держать ее вывод
+	cmd.WaitDelay = commandWaitDelay
+	return cmd, nil
+}
+
+// ExecuteCommandWithOutput выполняет команду и возвращает вывод (для обычного режима)
+func (j *Jail) ExecuteCommandWithOutput(command string, args ...string) ([]byte, error) {
+	return j.ExecuteCommandWithOutputEnv(nil, command, args...)
+}
+
+// ExecuteCommandWithOutputEnv выполняет команду и возвращает вывод, добавляя env к окружению скриптов
+func (j *Jail) ExecuteCommandWithOutputEnv(env []string, command string, args ...string) ([]byte, error) {
+	output, _, err := j.ExecuteCommandMeasuredEnv(env, command, args...)
+	return output, err
+}
+
+// ExecuteCommandMeasuredEnv выполняет команду как ExecuteCommandWithOutputEnv
+// и дополнительно возвращает израсходованные ею ресурсы
+func (j *Jail) ExecuteCommandMeasuredEnv(env []string, command string, args ...string) ([]byte, Usage, error) {
+	cmd, err := j.chrootCommand(env, command, args...)
+	if err != nil {
+		return nil, Usage{}, err
+	}
 
 	// Выполняем команду и собираем вывод
-	output, err := cmd.CombinedOutput()
+	var buf bytes.Buffer
+	cmd.Stdout = &buf
+	cmd.Stderr = &buf
+	usage, err := runMeasured(j.ctx, j.logger, cmd)
+	output := buf.Bytes()
+	if j.redactor != nil {
+		output = j.redactor.Redact(output)
+	}
 	if err != nil {
-		return output, fmt.Errorf("command failed: %w", err)
+		return output, usage, fmt.Errorf("command failed: %w", err)
+	}
+
+	return output, usage, nil
+}
+
+// SetWorkspace размещает chroot, слои overlay и контрольные точки в каталоге dir
+// вместо путей из jail.yaml, чтобы несколько сборок могли идти одновременно
+func (j *Jail) SetWorkspace(dir string) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if j.running {
+		return
 	}
+	j.workspace = dir
+	j.config.ChrootDir = filepath.Join(dir, "chroot")
+	j.config.CheckpointDir = filepath.Join(dir, "checkpoints")
+}
 
-	return output, nil
+// UpperDir возвращает верхний слой overlay текущего запуска
+func (j *Jail) UpperDir() string {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.upperDir
 }
 
 // GetChrootDir возвращает путь к директории chroot
@@ -678,6 +964,67 @@ func (j *Jail) GetChrootDir() string {
 	return j.config.ChrootDir
 }
 
+// SystemPaths возвращает пути внутри chroot, которые создает сам jail и которые
+// не относятся к собранной системе (шаблон, скрипты, каталог артефактов)
+func (j *Jail) SystemPaths() []string {
+	paths := []string{"template", "scripts", "output", strings.TrimPrefix(secrets.Dir, "/")}
+	for path := range j.runtimeFiles {
+		paths = append(paths, strings.TrimPrefix(filepath.Dir(path), "/"))
+	}
+	return paths
+}
+
+// Mount - точка монтирования jail (путь Target - внутри chroot)
+type Mount struct {
+	Source  string
+	Target  string
+	Type    string
+	Options string
+}
+
+// Mounts возвращает точки монтирования, которые создаст Start, в порядке монтирования
+func (j *Jail) Mounts() []Mount {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	overlay := "upper: temporary directory"
+	if j.snapshotDir != "" {
+		overlay = "upper: restored from " + j.snapshotDir
+	}
+	mounts := []Mount{
+		{j.config.BuilderPath, "/", "overlay", overlay},
+		{"/proc", "/proc", "proc", ""},
+		{"/sys", "/sys", "sysfs", ""},
+		{"/dev", "/dev", "devtmpfs", ""},
+		{"/dev/pts", "/dev/pts", "devpts", ""},
+		{j.config.TemplatePath, "/template", "bind", "ro"},
+		{filepath.Join(j.config.TemplatePath, "scripts"), "/scripts", "bind", "ro"},
+	}
+
+	dirs := make(map[string]bool)
+	for path := range j.runtimeFiles {
+		dirs[filepath.Dir(path)] = true
+	}
+	for _, dir := range slices.Sorted(maps.Keys(dirs)) {
+		mounts = append(mounts, Mount{"tmpfs", dir, "tmpfs", "mode=0755,size=16m,nosuid,nodev"})
+	}
+	if len(j.secrets) > 0 {
+		mounts = append(mounts, Mount{"tmpfs", secrets.Dir, "tmpfs", "mode=0700,size=16m,nosuid,nodev,noexec"})
+	}
+
+	for _, mountPoint := range j.config.MountPoints {
+		mounts = append(mounts, Mount{mountPoint.Source, mountPoint.Destination, mountPoint.Type, strings.Join(mountPoint.Options, ",")})
+	}
+	return mounts
+}
+
+// GetCheckpointDir возвращает директорию контрольных точек
+func (j *Jail) GetCheckpointDir() string {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.config.CheckpointDir
+}
+
 // IsPidNamespaceEnabled возвращает статус PID namespace
 func (j *Jail) IsPidNamespaceEnabled() bool {
 	j.mutex.Lock()
@@ -728,3 +1075,63 @@ func (j *Jail) SetGIDMappings(mappings []structures.IDMapping) {
 		j.gidMappings = mappings
 	}
 }
+
+// GetBuilderPath возвращает путь к билдеру (нижнему слою overlay)
+func (j *Jail) GetBuilderPath() string {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.config.BuilderPath
+}
+
+// GetBuilderKey возвращает путь к ключу GPG для проверки загружаемого билдера
+func (j *Jail) GetBuilderKey() string {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	key := j.config.BuilderKey
+	if key != "" && !filepath.IsAbs(key) {
+		key = filepath.Join(j.config.TemplatePath, key)
+	}
+	return key
+}
+
+// SetBuilderPath заменяет нижний слой overlay (например, на сохраненный ранее rootfs)
+func (j *Jail) SetBuilderPath(path string) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if !j.running {
+		j.config.BuilderPath = path
+	}
+}
+
+// ExportRootfs копирует корневую ФС jail в указанную директорию.
+// Специальные ФС и смонтированный шаблон не копируются (cp -x).
+func (j *Jail) ExportRootfs(dest string) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if !j.running {
+		return fmt.Errorf("jail is not running")
+	}
+
+	// Удаляем результат предыдущего экспорта
+	if err := os.RemoveAll(dest); err != nil {
+		return fmt.Errorf("failed to clean rootfs directory: %w", err)
+	}
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return fmt.Errorf("failed to create rootfs directory: %w", err)
+	}
+
+	total, _ := rootfs.Size(j.config.ChrootDir, rootfs.TarOptions{})
+
+	cpCmd := exec.CommandContext(j.ctx, "cp", "-a", "-x", j.config.ChrootDir+"/.", dest)
+	cpCmd.Stdout = j.logWriter
+	cpCmd.Stderr = j.logWriter
+
+	if err := progress.Run(cpCmd, "cp rootfs", total, true); err != nil {
+		return fmt.Errorf("failed to copy rootfs: %w", err)
+	}
+
+	return nil
+}
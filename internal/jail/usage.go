@@ -0,0 +1,160 @@
+package jail
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Расход ресурсов команды. Если система использует cgroup v2 и текущая
+// cgroup доступна для записи, команда запускается в отдельной дочерней
+// cgroup: время процессора берется из cpu.stat, пиковая память - из
+// memory.peak (если в cgroup включен контроллер memory), так что учитываются
+// и процессы, отвязавшиеся от команды. Иначе используются данные wait4:
+// время процессора дожидавшихся процессов и наибольший RSS одного процесса.
+// Объем записи - блоки вывода из wait4, то есть данные, записанные на диск
+// (в overlay jail), без tmpfs.
+
+// Usage - ресурсы, израсходованные командой
+type Usage struct {
+	CPU        time.Duration // Пользовательское и системное время процессора
+	PeakMemory int64         // Пиковое потребление памяти, байт
+	Written    int64         // Записано на диск, байт
+}
+
+// Корень иерархии cgroup v2
+const cgroupRoot = "/sys/fs/cgroup"
+
+// Счетчик cgroup, создаваемых процессом
+var cgroupSeq atomic.Int64
+
+// scriptCgroup - временная cgroup команды
+type scriptCgroup struct {
+	dir string
+	fd  *os.File
+	log *slog.Logger
+}
+
+// newScriptCgroup создает дочернюю cgroup текущего процесса; nil - cgroup v2 недоступна
+func newScriptCgroup(log *slog.Logger) *scriptCgroup {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return nil
+	}
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return nil
+	}
+	var current string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			current = path
+		}
+	}
+	if current == "" {
+		return nil
+	}
+
+	dir := filepath.Join(cgroupRoot, current, fmt.Sprintf("sysweaver-%d-%d", os.Getpid(), cgroupSeq.Add(1)))
+	if err := os.Mkdir(dir, 0755); err != nil {
+		log.Debug("script cgroup unavailable", "error", err)
+		return nil
+	}
+	fd, err := os.Open(dir)
+	if err != nil {
+		syscall.Rmdir(dir)
+		return nil
+	}
+	return &scriptCgroup{dir: dir, fd: fd, log: log}
+}
+
+// remove удаляет cgroup; пережившие команду процессы оставляют ее на месте
+func (c *scriptCgroup) remove() {
+	c.fd.Close()
+	if err := syscall.Rmdir(c.dir); err != nil {
+		c.log.Debug("error removing script cgroup", "cgroup", c.dir, "error", err)
+	}
+}
+
+// kill завершает все процессы cgroup (cgroup.kill, Linux 5.14+)
+func (c *scriptCgroup) kill() {
+	if err := os.WriteFile(filepath.Join(c.dir, "cgroup.kill"), []byte("1"), 0644); err != nil {
+		c.log.Debug("error killing script cgroup", "cgroup", c.dir, "error", err)
+	}
+}
+
+// readStat возвращает значение ключа из файла вида "key value" cgroup
+func (c *scriptCgroup) readStat(file, key string) (int64, bool) {
+	f, err := os.Open(filepath.Join(c.dir, file))
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if key == "" && len(fields) == 1 || len(fields) == 2 && fields[0] == key {
+			n, err := strconv.ParseInt(fields[len(fields)-1], 10, 64)
+			return n, err == nil
+		}
+	}
+	return 0, false
+}
+
+// runMeasured выполняет команду, созданную с контекстом ctx, и возвращает
+// израсходованные ею ресурсы. При отмене ctx в cgroup завершаются и
+// процессы, отвязавшиеся от команды.
+func runMeasured(ctx context.Context, log *slog.Logger, cmd *exec.Cmd) (Usage, error) {
+	cgroup := newScriptCgroup(log)
+	if cgroup != nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{UseCgroupFD: true, CgroupFD: int(cgroup.fd.Fd())}
+		cg, started := cgroup, cmd
+		cmd.Cancel = func() error {
+			cg.kill()
+			return started.Process.Kill()
+		}
+		if err := cmd.Start(); err != nil {
+			// Ядро без запуска в cgroup (clone3): команда запускается как обычно
+			log.Debug("starting command in cgroup failed", "error", err)
+			cgroup.remove()
+			cgroup = nil
+			retry := exec.CommandContext(ctx, cmd.Path, cmd.Args[1:]...)
+			retry.Env, retry.Dir, retry.WaitDelay = cmd.Env, cmd.Dir, cmd.WaitDelay
+			retry.Stdin, retry.Stdout, retry.Stderr = cmd.Stdin, cmd.Stdout, cmd.Stderr
+			cmd = retry
+		} else {
+			defer cgroup.remove()
+		}
+	}
+	if cmd.Process == nil {
+		if err := cmd.Start(); err != nil {
+			return Usage{}, err
+		}
+	}
+	err := cmd.Wait()
+
+	var usage Usage
+	if rusage, ok := cmd.ProcessState.SysUsage().(*syscall.Rusage); ok {
+		usage.CPU = time.Duration(rusage.Utime.Nano() + rusage.Stime.Nano())
+		usage.PeakMemory = rusage.Maxrss * 1024 // Maxrss - в КиБ
+		usage.Written = rusage.Oublock * 512
+	}
+	if cgroup != nil {
+		if usec, ok := cgroup.readStat("cpu.stat", "usage_usec"); ok {
+			usage.CPU = time.Duration(usec) * time.Microsecond
+		}
+		if peak, ok := cgroup.readStat("memory.peak", ""); ok {
+			usage.PeakMemory = peak
+		}
+	}
+	return usage, err
+}
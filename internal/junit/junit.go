@@ -0,0 +1,76 @@
+package junit
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+
+	"sysweaver/internal/store"
+)
+
+// Отчет JUnit XML о проверках стадии test для систем CI: скрипт стадии -
+// набор тестов (testsuite), проверка sw_assert* - тест (testcase).
+
+type testSuites struct {
+	XMLName  xml.Name    `xml:"testsuites"`
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Suites   []testSuite `xml:"testsuite"`
+}
+
+type testSuite struct {
+	Name     string     `xml:"name,attr"`
+	Tests    int        `xml:"tests,attr"`
+	Failures int        `xml:"failures,attr"`
+	Cases    []testCase `xml:"testcase"`
+}
+
+type testCase struct {
+	Name      string   `xml:"name,attr"`
+	ClassName string   `xml:"classname,attr"`
+	Failure   *failure `xml:"failure,omitempty"`
+}
+
+type failure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// Write записывает результаты проверок сборки name в файл path
+func Write(path, name string, results []store.TestResult) error {
+	report := testSuites{Name: name}
+	index := make(map[string]int)
+	for _, result := range results {
+		i, ok := index[result.Script]
+		if !ok {
+			i = len(report.Suites)
+			index[result.Script] = i
+			report.Suites = append(report.Suites, testSuite{Name: result.Script})
+		}
+		suite := &report.Suites[i]
+
+		test := testCase{Name: result.Assertion, ClassName: name + "." + result.Script}
+		if !result.Passed {
+			message := result.Detail
+			if message == "" {
+				message = "assertion failed"
+			}
+			test.Failure = &failure{Message: message, Text: result.Assertion + ": " + message}
+			suite.Failures++
+			report.Failures++
+		}
+		suite.Cases = append(suite.Cases, test)
+		suite.Tests++
+		report.Tests++
+	}
+
+	data, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding JUnit report: %w", err)
+	}
+	if err := os.WriteFile(path, append([]byte(xml.Header), append(data, '\n')...), 0644); err != nil {
+		return fmt.Errorf("error writing JUnit report: %w", err)
+	}
+	return nil
+}
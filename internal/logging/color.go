@@ -0,0 +1,106 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// Цветной вывод: отметки состояния (успех, ошибка, предупреждение, пропуск)
+// окрашиваются здесь по выбранной теме, код команд только оборачивает текст
+// в Success, Failure и т.п. Цвета включаются, только если stdout и stderr -
+// терминалы, не задана переменная NO_COLOR (https://no-color.org) и не
+// указан --no-color; иначе функции возвращают текст без изменений.
+
+// Theme - цвета отметок состояния в виде параметров ANSI SGR (например, "1;32")
+type Theme struct {
+	Success string
+	Failure string
+	Warning string
+	Skipped string
+	Debug   string
+}
+
+// Themes - встроенные темы
+var Themes = map[string]Theme{
+	// Обычные цвета для темного и светлого фона
+	"default": {Success: "32", Failure: "31", Warning: "33", Skipped: "36", Debug: "2"},
+	// Яркие полужирные цвета для слабоконтрастных терминалов
+	"bright": {Success: "1;92", Failure: "1;91", Warning: "1;93", Skipped: "1;96", Debug: "37"},
+}
+
+// Тема цветного вывода; nil - без цвета
+var theme atomic.Pointer[Theme]
+
+// ThemeNames возвращает имена встроенных тем
+func ThemeNames() []string {
+	names := make([]string, 0, len(Themes))
+	for name := range Themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsTerminal сообщает, подключен ли файл к терминалу
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0 && os.Getenv("TERM") != "dumb"
+}
+
+// SetupColor включает цветной вывод с темой name, если его не запрещают
+// disabled (--no-color), переменная NO_COLOR или вывод не в терминал
+func SetupColor(name string, disabled bool) error {
+	t, ok := Themes[name]
+	if !ok {
+		return fmt.Errorf("invalid color theme %q (expected %s)", name, strings.Join(ThemeNames(), ", "))
+	}
+	if disabled || os.Getenv("NO_COLOR") != "" || !IsTerminal(os.Stdout) || !IsTerminal(os.Stderr) {
+		theme.Store(nil)
+		return nil
+	}
+	theme.Store(&t)
+	return nil
+}
+
+// Success окрашивает текст как успешный результат
+func Success(s string) string {
+	return paint(func(t *Theme) string { return t.Success }, s)
+}
+
+// Failure окрашивает текст как ошибку
+func Failure(s string) string {
+	return paint(func(t *Theme) string { return t.Failure }, s)
+}
+
+// Warning окрашивает текст как предупреждение
+func Warning(s string) string {
+	return paint(func(t *Theme) string { return t.Warning }, s)
+}
+
+// Skipped окрашивает текст как пропущенный шаг
+func Skipped(s string) string {
+	return paint(func(t *Theme) string { return t.Skipped }, s)
+}
+
+// Result окрашивает текст s по результату result (success или failed)
+func Result(result, s string) string {
+	switch result {
+	case "success":
+		return Success(s)
+	case "failed":
+		return Failure(s)
+	}
+	return s
+}
+
+// paint оборачивает s в цвет темы, если цветной вывод включен
+func paint(color func(*Theme) string, s string) string {
+	t := theme.Load()
+	if t == nil || s == "" {
+		return s
+	}
+	return "\x1b[" + color(t) + "m" + s + "\x1b[0m"
+}
@@ -0,0 +1,189 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Журнал sysweaver - стандартный slog.Default, настроенный Setup.
+// Формат text предназначен для терминала: сообщение уровня info выводится
+// как есть, warn и error - с префиксами Warning: и Error:, debug - с
+// префиксом debug: (префиксы окрашиваются темой, см. color.go). Поля вызова (slog.Warn(msg, "path", path)) дописываются
+// как key=value; контекстные поля из With (сборка, скрипт) выводятся только
+// на уровне debug, чтобы не повторяться в каждой строке. Формат json пишет
+// каждую запись со всеми полями для сервисов и потребителей библиотеки.
+
+// Форматы журнала
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Levels - имена уровней для --log-level
+var Levels = []string{"debug", "info", "warn", "error"}
+
+// ParseLevel возвращает уровень по имени
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if !slices.Contains(Levels, strings.ToLower(name)) {
+		return level, fmt.Errorf("invalid log level %q (expected %s)", name, strings.Join(Levels, ", "))
+	}
+	err := level.UnmarshalText([]byte(name))
+	return level, err
+}
+
+// Setup настраивает журнал по умолчанию: уровень, формат и вывод
+func Setup(w io.Writer, level slog.Level, format string) error {
+	var handler slog.Handler
+	switch format {
+	case "", FormatText:
+		handler = &consoleHandler{w: w, level: level, mu: &sync.Mutex{}}
+	case FormatJSON:
+		handler = slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})
+	default:
+		return fmt.Errorf("invalid log format %q (expected %s or %s)", format, FormatText, FormatJSON)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// OrDefault возвращает журнал l, а если он не задан - журнал по умолчанию.
+// Пакеты, выполняющие часть сборки, принимают журнал сборки явно (поле
+// Logger параметров) и пишут в slog.Default только без него.
+func OrDefault(l *slog.Logger) *slog.Logger {
+	if l != nil {
+		return l
+	}
+	return slog.Default()
+}
+
+// consoleHandler выводит записи в виде строк для терминала
+type consoleHandler struct {
+	w       io.Writer
+	level   slog.Level
+	mu      *sync.Mutex
+	context []slog.Attr // Поля из With
+	group   string
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *consoleHandler) Handle(_ context.Context, record slog.Record) error {
+	var b strings.Builder
+	switch {
+	case record.Level >= slog.LevelError:
+		b.WriteString(Failure("Error:") + " ")
+	case record.Level >= slog.LevelWarn:
+		b.WriteString(Warning("Warning:") + " ")
+	case record.Level < slog.LevelInfo:
+		b.WriteString(paint(func(t *Theme) string { return t.Debug }, "debug:") + " ")
+	}
+	b.WriteString(record.Message)
+
+	if h.level < slog.LevelInfo {
+		for _, attr := range h.context {
+			writeAttr(&b, "", attr)
+		}
+	}
+	record.Attrs(func(attr slog.Attr) bool {
+		writeAttr(&b, h.group, attr)
+		return true
+	})
+	b.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.context = slices.Clone(h.context)
+	for _, attr := range attrs {
+		if h.group != "" {
+			attr.Key = h.group + "." + attr.Key
+		}
+		clone.context = append(clone.context, attr)
+	}
+	return &clone
+}
+
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	if clone.group != "" {
+		name = clone.group + "." + name
+	}
+	clone.group = name
+	return &clone
+}
+
+// writeAttr дописывает поле в виде key=value
+func writeAttr(b *strings.Builder, group string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+	key := attr.Key
+	if group != "" {
+		key = group + "." + key
+	}
+	if attr.Value.Kind() == slog.KindGroup {
+		for _, nested := range attr.Value.Group() {
+			writeAttr(b, key, nested)
+		}
+		return
+	}
+
+	value := attr.Value.String()
+	if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+		value = fmt.Sprintf("%q", value)
+	}
+	fmt.Fprintf(b, " %s=%s", key, value)
+}
+
+// OnWarning оборачивает обработчик журнала: для каждой записи уровня warn
+// вызывается f с ее текстом (сообщение и поля key=value, как в формате text)
+func OnWarning(h slog.Handler, f func(string)) slog.Handler {
+	return &warningHook{Handler: h, f: f}
+}
+
+type warningHook struct {
+	slog.Handler
+	f func(string)
+}
+
+func (h *warningHook) Enabled(ctx context.Context, level slog.Level) bool {
+	return level == slog.LevelWarn || h.Handler.Enabled(ctx, level)
+}
+
+func (h *warningHook) Handle(ctx context.Context, record slog.Record) error {
+	if record.Level == slog.LevelWarn {
+		var b strings.Builder
+		b.WriteString(record.Message)
+		record.Attrs(func(attr slog.Attr) bool {
+			writeAttr(&b, "", attr)
+			return true
+		})
+		h.f(b.String())
+	}
+	if !h.Handler.Enabled(ctx, record.Level) {
+		return nil
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *warningHook) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &warningHook{Handler: h.Handler.WithAttrs(attrs), f: h.f}
+}
+
+func (h *warningHook) WithGroup(name string) slog.Handler {
+	return &warningHook{Handler: h.Handler.WithGroup(name), f: h.f}
+}
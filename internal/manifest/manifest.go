@@ -0,0 +1,211 @@
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"sysweaver/internal/apk"
+	"sysweaver/internal/builder"
+	"sysweaver/internal/digest"
+	"sysweaver/internal/store"
+)
+
+// FileName - имя файла манифеста сборки
+const FileName = "build-manifest.json"
+
+// SchemaVersion - версия формата манифеста
+const SchemaVersion = 1
+
+// Inputs - сведения о сборке, не входящие в запись хранилища
+type Inputs struct {
+	ConfigPath  string
+	BuilderPath string
+	ToolVersion string
+}
+
+// Manifest - машиночитаемое описание сборки для релизных инструментов и аудита
+type Manifest struct {
+	SchemaVersion int       `json:"schema_version"`
+	BuildID       string    `json:"build_id"`
+	Name          string    `json:"name"`
+	Version       string    `json:"version"`
+	StartedAt     time.Time `json:"started_at"`
+	FinishedAt    time.Time `json:"finished_at"`
+	Result        string    `json:"result"`
+	Error         string    `json:"error,omitempty"`
+
+	Template  Template            `json:"template"`
+	Config    Config              `json:"config"`
+	Builder   Builder             `json:"builder"`
+	Host      Host                `json:"host"`
+	Stages    []store.StageRun    `json:"stages,omitempty"`
+	Scripts   []store.ScriptRun   `json:"scripts"`
+	Artifacts []store.Artifact    `json:"artifacts"`
+	Packages  int                 `json:"package_count"`
+	System    *store.System       `json:"system,omitempty"`
+	Downloads []store.Download    `json:"downloads,omitempty"`
+	Published []store.Publication `json:"published,omitempty"`
+}
+
+// Template - шаблон сборки
+type Template struct {
+	Name   string                `json:"name"`
+	Path   string                `json:"path"`
+	Source *store.TemplateSource `json:"source,omitempty"`
+}
+
+// Config - файл конфигурации сборки
+type Config struct {
+	Path   string        `json:"path"`
+	Digest digest.Digest `json:"digest,omitempty"`
+}
+
+// Builder - идентичность rootfs билдера
+type Builder struct {
+	Path string `json:"path"`
+	OS   string `json:"os,omitempty"` // PRETTY_NAME из os-release
+	// Дайджест базы пакетов apk: одинаков для билдеров с одинаковым набором пакетов
+	PackagesDigest digest.Digest `json:"packages_digest,omitempty"`
+	// Источник и проверки билдера, загруженного fetch-builder
+	Fetched *builder.Info `json:"fetched,omitempty"`
+}
+
+// Host - система, на которой выполнялась сборка
+type Host struct {
+	Hostname    string `json:"hostname"`
+	OS          string `json:"os"`
+	Arch        string `json:"arch"`
+	Kernel      string `json:"kernel"`
+	CPUs        int    `json:"cpus"`
+	ToolVersion string `json:"sysweaver_version"`
+	GoVersion   string `json:"go_version"`
+}
+
+// New формирует манифест из записи о сборке
+func New(record *store.Record, inputs Inputs) *Manifest {
+	m := &Manifest{
+		SchemaVersion: SchemaVersion,
+		BuildID:       record.ID,
+		Name:          record.Name,
+		Version:       record.Version,
+		StartedAt:     record.StartedAt,
+		FinishedAt:    record.FinishedAt,
+		Result:        record.Result,
+		Error:         record.Error,
+		Template:      Template{Name: filepath.Base(record.Template), Path: record.Template, Source: record.Source},
+		Config:        Config{Path: inputs.ConfigPath},
+		Builder:       describeBuilder(inputs.BuilderPath),
+		Host:          describeHost(inputs.ToolVersion),
+		Stages:        record.Stages,
+		Scripts:       record.Scripts,
+		Artifacts:     record.Artifacts,
+		Packages:      len(record.Packages),
+		System:        record.System,
+		Downloads:     record.Downloads,
+		Published:     record.Published,
+	}
+
+	if m.Scripts == nil {
+		m.Scripts = []store.ScriptRun{}
+	}
+	if m.Artifacts == nil {
+		m.Artifacts = []store.Artifact{}
+	}
+
+	if digests, err := digest.File(inputs.ConfigPath, []string{digest.SHA256}); err == nil {
+		m.Config.Digest = digests[0]
+	}
+
+	return m
+}
+
+// Write записывает манифест в файл
+func (m *Manifest) Write(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding build manifest: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating directory for build manifest: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing build manifest: %w", err)
+	}
+
+	return nil
+}
+
+// Read читает манифест сборки
+func Read(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading build manifest: %w", err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// describeBuilder определяет систему билдера и дайджест его набора пакетов
+func describeBuilder(path string) Builder {
+	b := Builder{Path: path}
+	if path == "" {
+		return b
+	}
+
+	if data, err := os.ReadFile(filepath.Join(path, "etc/os-release")); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if value, ok := strings.CutPrefix(line, "PRETTY_NAME="); ok {
+				b.OS = strings.Trim(value, `"`)
+			}
+		}
+	}
+
+	if data, err := os.ReadFile(filepath.Join(path, apk.InstalledDB)); err == nil {
+		sum := sha256.Sum256(data)
+		b.PackagesDigest = digest.Digest(digest.SHA256 + ":" + hex.EncodeToString(sum[:]))
+	}
+
+	if info, err := builder.ReadInfo(path); err == nil {
+		b.Fetched = info
+	}
+
+	return b
+}
+
+// describeHost собирает сведения о хосте сборки
+func describeHost(toolVersion string) Host {
+	h := Host{
+		OS:          runtime.GOOS,
+		Arch:        runtime.GOARCH,
+		CPUs:        runtime.NumCPU(),
+		ToolVersion: toolVersion,
+		GoVersion:   runtime.Version(),
+	}
+	h.Hostname, _ = os.Hostname()
+
+	var uts syscall.Utsname
+	if err := syscall.Uname(&uts); err == nil {
+		var b strings.Builder
+		for _, c := range uts.Release {
+			if c == 0 {
+				break
+			}
+			b.WriteByte(byte(c))
+		}
+		h.Kernel = b.String()
+	}
+
+	return h
+}
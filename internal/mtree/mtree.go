@@ -0,0 +1,195 @@
+package mtree
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Манифест содержимого корневой ФС в формате mtree (BSD mtree, как его
+// читают bsdtar и mtree -f): по строке на каждый файл, каталог и ссылку с
+// типом, правами, владельцем, размером и SHA-256. Время изменения не
+// записывается, чтобы манифесты одинаковых сборок совпадали и их можно было
+// сравнивать через diff или проверять систему командой mtree -f.
+
+// Entry - одна запись манифеста
+type Entry struct {
+	Path   string // Путь относительно корня без ведущего /
+	Type   string // file, dir, link, char, block, fifo, socket
+	Mode   fs.FileMode
+	UID    uint32
+	GID    uint32
+	Size   int64  // Для обычных файлов
+	SHA256 string // Для обычных файлов
+	Link   string // Цель символической ссылки
+}
+
+// Collect обходит корневую ФС root (без перехода в другие ФС) и возвращает
+// записи в порядке путей. exclude - служебные пути jail, не попадающие в образ.
+func Collect(root string, exclude []string) ([]Entry, error) {
+	rootInfo, err := os.Lstat(root)
+	if err != nil {
+		return nil, fmt.Errorf("rootfs not found: %w", err)
+	}
+	rootDev := rootInfo.Sys().(*syscall.Stat_t).Dev
+
+	excluded := make(map[string]bool, len(exclude))
+	for _, path := range exclude {
+		excluded[strings.Trim(filepath.ToSlash(path), "/")] = true
+	}
+
+	var entries []Entry
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, _ := filepath.Rel(root, path)
+		rel = filepath.ToSlash(rel)
+		if excluded[rel] {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		stat := info.Sys().(*syscall.Stat_t)
+		entry := Entry{Path: rel, Mode: info.Mode(), UID: stat.Uid, GID: stat.Gid}
+
+		switch mode := info.Mode(); {
+		case mode.IsDir():
+			entry.Type = "dir"
+			// Смонтированные ФС (proc, sys, dev) не относятся к образу
+			if rel != "." && stat.Dev != rootDev {
+				entries = append(entries, entry)
+				return filepath.SkipDir
+			}
+		case mode.IsRegular():
+			entry.Type = "file"
+			entry.Size = info.Size()
+			if entry.SHA256, err = hashFile(path); err != nil {
+				return fmt.Errorf("error hashing %s: %w", rel, err)
+			}
+		case mode&fs.ModeSymlink != 0:
+			entry.Type = "link"
+			if entry.Link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		case mode&fs.ModeCharDevice != 0:
+			entry.Type = "char"
+		case mode&fs.ModeDevice != 0:
+			entry.Type = "block"
+		case mode&fs.ModeNamedPipe != 0:
+			entry.Type = "fifo"
+		case mode&fs.ModeSocket != 0:
+			entry.Type = "socket"
+		default:
+			return nil
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Write записывает манифест корневой ФС root в файл dest и возвращает число записей
+func Write(root string, exclude []string, dest string) (int, error) {
+	entries, err := Collect(root, exclude)
+	if err != nil {
+		return 0, err
+	}
+
+	file, err := os.Create(dest)
+	if err != nil {
+		return 0, fmt.Errorf("error creating %s: %w", filepath.Base(dest), err)
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	fmt.Fprintln(w, "#mtree")
+	for _, entry := range entries {
+		fmt.Fprintln(w, entry.String())
+	}
+	if err := w.Flush(); err != nil {
+		return 0, fmt.Errorf("error writing %s: %w", filepath.Base(dest), err)
+	}
+	return len(entries), file.Close()
+}
+
+// String возвращает строку записи в формате mtree
+func (e Entry) String() string {
+	name := "."
+	if e.Path != "." {
+		name = "./" + e.Path
+	}
+
+	var b strings.Builder
+	b.WriteString(encode(name))
+	fmt.Fprintf(&b, " type=%s mode=%04o uid=%d gid=%d", e.Type, permissions(e.Mode), e.UID, e.GID)
+	switch e.Type {
+	case "file":
+		fmt.Fprintf(&b, " size=%d sha256digest=%s", e.Size, e.SHA256)
+	case "link":
+		b.WriteString(" link=" + encode(e.Link))
+	}
+	return b.String()
+}
+
+// permissions возвращает права доступа вместе с битами setuid, setgid и sticky
+func permissions(mode fs.FileMode) uint32 {
+	perm := uint32(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		perm |= 04000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		perm |= 02000
+	}
+	if mode&fs.ModeSticky != 0 {
+		perm |= 01000
+	}
+	return perm
+}
+
+// encode экранирует пробелы, управляющие и не-ASCII символы восьмеричными
+// последовательностями \ooo, как strvis в mtree
+func encode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || c == '\\' || c == '#' || c == '=' {
+			fmt.Fprintf(&b, "\\%03o", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// hashFile вычисляет SHA-256 файла
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
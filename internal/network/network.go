@@ -0,0 +1,263 @@
+package network
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"sysweaver/internal/structures"
+)
+
+// Сетевая конфигурация из network: конфигурации записывается в корневую ФС
+// в формате сетевой подсистемы системы: /etc/network/interfaces для ifupdown
+// (Alpine) или .network/.netdev в /etc/systemd/network для networkd. Пароли
+// беспроводных сетей берутся из секретов и записываются в конфигурацию
+// wpa_supplicant с правами 0600.
+
+// Бэкенды сетевой конфигурации
+const (
+	Ifupdown = "ifupdown"
+	Networkd = "networkd"
+)
+
+// Пути systemd-networkd в системе; по ним определяется бэкенд по умолчанию
+var networkdBinaries = []string{"usr/lib/systemd/systemd-networkd", "lib/systemd/systemd-networkd"}
+
+const header = "# Generated by sysweaver from the network config\n"
+
+// Detect возвращает бэкенд для корневой ФС root: заданный в конфигурации или
+// networkd, если он установлен, иначе ifupdown
+func Detect(root string, cfg structures.NetworkConfig) string {
+	if cfg.Backend != "" {
+		return cfg.Backend
+	}
+	for _, binary := range networkdBinaries {
+		if _, err := os.Stat(filepath.Join(root, binary)); err == nil {
+			return Networkd
+		}
+	}
+	return Ifupdown
+}
+
+// Apply записывает сетевую конфигурацию в корневую ФС root и возвращает
+// записанные файлы (пути внутри системы). secrets - значения секретов по имени,
+// log - журнал предупреждений о конфигурации.
+func Apply(root string, cfg structures.NetworkConfig, secrets map[string][]byte, log *slog.Logger) ([]string, error) {
+	if err := validate(cfg, log); err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]string)
+	var err error
+	switch backend := Detect(root, cfg); backend {
+	case Ifupdown:
+		err = renderIfupdown(cfg, secrets, files)
+	case Networkd:
+		err = renderNetworkd(cfg, secrets, files)
+	default:
+		err = fmt.Errorf("unsupported network backend %q", backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+
+	for _, path := range paths {
+		mode := os.FileMode(0644)
+		if strings.HasPrefix(path, "/etc/wpa_supplicant/") {
+			mode = 0600
+		}
+		target := filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return nil, err
+		}
+		os.Remove(target)
+		if err := os.WriteFile(target, []byte(files[path]), mode); err != nil {
+			return nil, fmt.Errorf("error writing %s: %w", path, err)
+		}
+	}
+	return paths, nil
+}
+
+// validate проверяет адреса и связи интерфейсов
+func validate(cfg structures.NetworkConfig, log *slog.Logger) error {
+	names := make(map[string]bool)
+	for _, iface := range cfg.Interfaces {
+		if names[iface.Name] {
+			return fmt.Errorf("network interface %s is defined twice", iface.Name)
+		}
+		names[iface.Name] = true
+
+		for _, address := range iface.Addresses {
+			if _, _, err := net.ParseCIDR(address); err != nil {
+				return fmt.Errorf("network interface %s: invalid address %q (expected CIDR such as 10.0.0.5/24)", iface.Name, address)
+			}
+		}
+		for _, ip := range append(slices.Clone(iface.DNS), iface.Gateway) {
+			if ip != "" && net.ParseIP(ip) == nil {
+				return fmt.Errorf("network interface %s: invalid IP address %q", iface.Name, ip)
+			}
+		}
+		if iface.VLAN != nil && (iface.VLAN.ID < 1 || iface.VLAN.ID > 4094) {
+			return fmt.Errorf("network interface %s: VLAN id must be 1-4094", iface.Name)
+		}
+		if iface.DHCP && len(iface.Addresses) > 0 {
+			log.Warn("network interface has both dhcp and static addresses", "interface", iface.Name)
+		}
+	}
+	return nil
+}
+
+// psk возвращает пароль беспроводной сети из секрета
+func psk(iface structures.NetworkInterface, secrets map[string][]byte) (string, error) {
+	if iface.WiFi.PSKSecret == "" {
+		return "", nil
+	}
+	value, ok := secrets[iface.WiFi.PSKSecret]
+	if !ok {
+		return "", fmt.Errorf("network interface %s: secret %q is not defined in secrets", iface.Name, iface.WiFi.PSKSecret)
+	}
+	return strings.TrimSpace(string(value)), nil
+}
+
+func wpaConfigPath(name string) string {
+	return "/etc/wpa_supplicant/wpa_supplicant-" + name + ".conf"
+}
+
+// wpaConfig возвращает конфигурацию wpa_supplicant для одной сети
+func wpaConfig(wifi *structures.WiFiConfig, password string) string {
+	var b strings.Builder
+	b.WriteString(header)
+	b.WriteString("ctrl_interface=/run/wpa_supplicant\n\n")
+	fmt.Fprintf(&b, "network={\n\tssid=%s\n", strconv.Quote(wifi.SSID))
+	if password != "" {
+		fmt.Fprintf(&b, "\tpsk=%s\n", strconv.Quote(password))
+	} else {
+		b.WriteString("\tkey_mgmt=NONE\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// renderIfupdown формирует /etc/network/interfaces
+func renderIfupdown(cfg structures.NetworkConfig, secrets map[string][]byte, files map[string]string) error {
+	var b strings.Builder
+	b.WriteString(header)
+	b.WriteString("auto lo\niface lo inet loopback\n")
+
+	for _, iface := range cfg.Interfaces {
+		method := "manual"
+		switch {
+		case iface.DHCP:
+			method = "dhcp"
+		case len(iface.Addresses) > 0:
+			method = "static"
+		}
+
+		fmt.Fprintf(&b, "\nauto %s\niface %s inet %s\n", iface.Name, iface.Name, method)
+		if !iface.DHCP {
+			for _, address := range iface.Addresses {
+				fmt.Fprintf(&b, "\taddress %s\n", address)
+			}
+			if iface.Gateway != "" {
+				fmt.Fprintf(&b, "\tgateway %s\n", iface.Gateway)
+			}
+		}
+		if iface.MTU > 0 {
+			fmt.Fprintf(&b, "\tmtu %d\n", iface.MTU)
+		}
+		if iface.VLAN != nil {
+			fmt.Fprintf(&b, "\tvlan-id %d\n\tvlan-raw-device %s\n", iface.VLAN.ID, iface.VLAN.Link)
+		}
+		if iface.WiFi != nil {
+			password, err := psk(iface, secrets)
+			if err != nil {
+				return err
+			}
+			// Исполнитель wifi ifupdown-ng запускает wpa_supplicant с этим файлом
+			config := wpaConfigPath(iface.Name)
+			files[config] = wpaConfig(iface.WiFi, password)
+			fmt.Fprintf(&b, "\twifi-config-path %s\n", config)
+		}
+		if len(iface.DNS) > 0 {
+			fmt.Fprintf(&b, "\tdns-nameservers %s\n", strings.Join(iface.DNS, " "))
+		}
+	}
+	files["/etc/network/interfaces"] = b.String()
+	return nil
+}
+
+// renderNetworkd формирует файлы .network и .netdev для systemd-networkd
+func renderNetworkd(cfg structures.NetworkConfig, secrets map[string][]byte, files map[string]string) error {
+	// VLAN-интерфейсы перечисляются в .network родительского интерфейса
+	vlans := make(map[string][]string)
+	defined := make(map[string]bool)
+	for _, iface := range cfg.Interfaces {
+		defined[iface.Name] = true
+		if iface.VLAN != nil {
+			vlans[iface.VLAN.Link] = append(vlans[iface.VLAN.Link], iface.Name)
+		}
+	}
+
+	for _, iface := range cfg.Interfaces {
+		var b strings.Builder
+		b.WriteString(header)
+		fmt.Fprintf(&b, "[Match]\nName=%s\n\n[Network]\n", iface.Name)
+		if iface.DHCP {
+			b.WriteString("DHCP=yes\n")
+		}
+		for _, address := range iface.Addresses {
+			fmt.Fprintf(&b, "Address=%s\n", address)
+		}
+		if iface.Gateway != "" {
+			fmt.Fprintf(&b, "Gateway=%s\n", iface.Gateway)
+		}
+		for _, ns := range iface.DNS {
+			fmt.Fprintf(&b, "DNS=%s\n", ns)
+		}
+		for _, vlan := range vlans[iface.Name] {
+			fmt.Fprintf(&b, "VLAN=%s\n", vlan)
+		}
+		if iface.MTU > 0 {
+			fmt.Fprintf(&b, "\n[Link]\nMTUBytes=%d\n", iface.MTU)
+		}
+		files["/etc/systemd/network/10-"+iface.Name+".network"] = b.String()
+
+		if iface.VLAN != nil {
+			files["/etc/systemd/network/10-"+iface.Name+".netdev"] = fmt.Sprintf("%s[NetDev]\nName=%s\nKind=vlan\n\n[VLAN]\nId=%d\n",
+				header, iface.Name, iface.VLAN.ID)
+		}
+		if iface.WiFi != nil {
+			password, err := psk(iface, secrets)
+			if err != nil {
+				return err
+			}
+			// Файл wpa_supplicant@<интерфейс>.service
+			files[wpaConfigPath(iface.Name)] = wpaConfig(iface.WiFi, password)
+		}
+	}
+
+	// Родительский интерфейс VLAN без своих настроек только несет VLAN
+	for link, names := range vlans {
+		if defined[link] {
+			continue
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s[Match]\nName=%s\n\n[Network]\nLinkLocalAddressing=no\n", header, link)
+		for _, vlan := range names {
+			fmt.Fprintf(&b, "VLAN=%s\n", vlan)
+		}
+		files["/etc/systemd/network/10-"+link+".network"] = b.String()
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+package notify
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"sysweaver/internal/progress"
+	"sysweaver/internal/store"
+	"sysweaver/internal/structures"
+)
+
+// Уведомления о завершении сборки из секции notifications. webhook получает
+// JSON со сведениями о сборке (Payload), slack и matrix - текстовое
+// сообщение. Для упавшей сборки добавляется конец лога первого упавшего
+// скрипта. Ошибка отправки не влияет на результат сборки.
+
+// Число последних строк лога упавшего скрипта в уведомлении
+const excerptLines = 20
+
+// Тайм-аут запроса уведомления
+const requestTimeout = 30 * time.Second
+
+var client = &http.Client{Timeout: requestTimeout}
+
+// Artifact - артефакт сборки в уведомлении
+type Artifact struct {
+	Name    string   `json:"name"`
+	Size    int64    `json:"size"`
+	URL     string   `json:"url,omitempty"`
+	Digests []string `json:"digests,omitempty"`
+}
+
+// Payload - тело уведомления webhook
+type Payload struct {
+	Build        string     `json:"build"`
+	Name         string     `json:"name"`
+	Version      string     `json:"version"`
+	Result       string     `json:"result"`
+	Error        string     `json:"error,omitempty"`
+	Duration     float64    `json:"duration_seconds"`
+	OutputDir    string     `json:"output_dir"`
+	Artifacts    []Artifact `json:"artifacts,omitempty"`
+	FailedScript string     `json:"failed_script,omitempty"`
+	LogExcerpt   string     `json:"log_excerpt,omitempty"`
+}
+
+// Send отправляет уведомления о сборке record во все подходящие по результату цели
+func Send(targets []structures.Notification, record *store.Record) error {
+	var errs []error
+	for _, target := range targets {
+		if len(target.On) > 0 && !slices.Contains(target.On, event(record.Result)) {
+			continue
+		}
+		payload := newPayload(record, target.ArtifactURL)
+
+		var err error
+		switch target.Type {
+		case "webhook":
+			err = sendWebhook(target, payload)
+		case "slack":
+			err = sendSlack(target, payload)
+		case "matrix":
+			err = sendMatrix(target, payload)
+		default:
+			err = fmt.Errorf("unsupported notification type: %s", target.Type)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error sending %s notification: %w", target.Type, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// event возвращает значение on для результата сборки
+func event(result string) string {
+	if result == store.ResultSuccess {
+		return "success"
+	}
+	return "failure"
+}
+
+// newPayload собирает сведения о сборке для уведомления
+func newPayload(record *store.Record, artifactURL string) Payload {
+	p := Payload{
+		Build:     record.ID,
+		Name:      record.Name,
+		Version:   record.Version,
+		Result:    record.Result,
+		Error:     record.Error,
+		Duration:  record.FinishedAt.Sub(record.StartedAt).Seconds(),
+		OutputDir: record.OutputDir,
+	}
+
+	// Адреса загруженных артефактов из секции upload
+	uploaded := make(map[string]string)
+	for _, publication := range record.Published {
+		if strings.HasPrefix(publication.Target, "upload:") {
+			uploaded[lastSegment(publication.ID)] = publication.ID
+		}
+	}
+	for _, artifact := range record.Artifacts {
+		a := Artifact{Name: artifact.Name, Size: artifact.Size, URL: uploaded[artifact.Name]}
+		if artifactURL != "" {
+			a.URL = strings.TrimRight(artifactURL, "/") + "/" + url.PathEscape(artifact.Name)
+		}
+		for _, d := range artifact.Digests {
+			a.Digests = append(a.Digests, string(d))
+		}
+		p.Artifacts = append(p.Artifacts, a)
+	}
+
+	for _, run := range record.Scripts {
+		if run.Skipped == "" && run.ExitCode != 0 {
+			p.FailedScript = run.Stage + "/" + run.Name
+			p.LogExcerpt = tail(run.Log, excerptLines)
+			break
+		}
+	}
+	return p
+}
+
+// Text возвращает текст уведомления для чатов
+func (p Payload) Text() string {
+	var b strings.Builder
+	duration := time.Duration(p.Duration * float64(time.Second)).Round(time.Second)
+	if p.Result == store.ResultSuccess {
+		fmt.Fprintf(&b, "✅ %s %s built in %s (build %s)\n", p.Name, p.Version, duration, p.Build)
+	} else {
+		fmt.Fprintf(&b, "❌ %s %s failed after %s (build %s): %s\n", p.Name, p.Version, duration, p.Build, p.Error)
+	}
+
+	for _, a := range p.Artifacts {
+		location := a.URL
+		if location == "" {
+			location = p.OutputDir + "/" + a.Name
+		}
+		fmt.Fprintf(&b, "• %s (%s) %s\n", a.Name, progress.FormatBytes(a.Size), location)
+	}
+
+	if p.FailedScript != "" {
+		fmt.Fprintf(&b, "Failed script: %s\n", p.FailedScript)
+		if p.LogExcerpt != "" {
+			fmt.Fprintf(&b, "```\n%s\n```\n", p.LogExcerpt)
+		}
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// sendWebhook отправляет сведения о сборке запросом POST в формате JSON
+func sendWebhook(target structures.Notification, p Payload) error {
+	endpoint, err := targetURL(target)
+	if err != nil {
+		return err
+	}
+	return postJSON(http.MethodPost, endpoint, p, target.Headers)
+}
+
+// sendSlack отправляет сообщение во входящий webhook Slack
+func sendSlack(target structures.Notification, p Payload) error {
+	endpoint, err := targetURL(target)
+	if err != nil {
+		return err
+	}
+	return postJSON(http.MethodPost, endpoint, map[string]string{"text": p.Text()}, nil)
+}
+
+// sendMatrix отправляет сообщение в комнату Matrix через Client-Server API
+func sendMatrix(target structures.Notification, p Payload) error {
+	token := os.Getenv(target.TokenEnv)
+	if token == "" {
+		return fmt.Errorf("access token not set: export %s", target.TokenEnv)
+	}
+
+	txn := make([]byte, 8)
+	rand.Read(txn)
+	endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		strings.TrimRight(target.Homeserver, "/"), url.PathEscape(target.Room), hex.EncodeToString(txn))
+
+	message := map[string]string{"msgtype": "m.text", "body": p.Text()}
+	return postJSON(http.MethodPut, endpoint, message, map[string]string{"Authorization": "Bearer " + token})
+}
+
+// targetURL возвращает URL webhook из url или переменной url_env
+func targetURL(target structures.Notification) (string, error) {
+	if target.URLEnv != "" {
+		if value := os.Getenv(target.URLEnv); value != "" {
+			return value, nil
+		}
+		return "", fmt.Errorf("webhook URL not set: export %s", target.URLEnv)
+	}
+	if target.URL == "" {
+		return "", fmt.Errorf("url or url_env is required")
+	}
+	return target.URL, nil
+}
+
+// postJSON отправляет body в формате JSON и проверяет код ответа. URL может
+// содержать секрет (webhook Slack), поэтому в ошибках указывается только хост.
+func postJSON(method, endpoint string, body interface{}, headers map[string]string) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(method, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("invalid URL")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("%s %s: %w", method, req.URL.Host, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s %s: %s", method, req.URL.Host, resp.Status)
+	}
+	return nil
+}
+
+// tail возвращает последние n строк файла
+func tail(path string, n int) string {
+	if path == "" {
+		return ""
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		if len(lines) > n {
+			lines = lines[1:]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// lastSegment возвращает последний элемент пути URL
+func lastSegment(location string) string {
+	if i := strings.LastIndex(location, "/"); i >= 0 {
+		return location[i+1:]
+	}
+	return location
+}
@@ -0,0 +1,367 @@
+package output
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"sysweaver/internal/image"
+	"sysweaver/internal/progress"
+	"sysweaver/internal/rootfs"
+	"sysweaver/internal/structures"
+)
+
+// Типы медиа спецификации OCI
+const (
+	mediaTypeManifest = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeConfig   = "application/vnd.oci.image.config.v1+json"
+	mediaTypeLayer    = "application/vnd.oci.image.layer.v1.tar+gzip"
+)
+
+// ociDescriptor - дескриптор содержимого OCI
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// imageConfig - конфигурация образа (общая для OCI и docker)
+type imageConfig struct {
+	Created      string `json:"created"`
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Config       struct {
+		Entrypoint []string          `json:"Entrypoint,omitempty"`
+		Cmd        []string          `json:"Cmd,omitempty"`
+		Env        []string          `json:"Env,omitempty"`
+		WorkingDir string            `json:"WorkingDir,omitempty"`
+		User       string            `json:"User,omitempty"`
+		Labels     map[string]string `json:"Labels,omitempty"`
+	} `json:"config"`
+	Rootfs struct {
+		Type    string   `json:"type"`
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+	History []map[string]string `json:"history"`
+}
+
+func init() {
+	image.Register("oci", exporter{export: exportContainer})
+	image.Register("docker-archive", exporter{export: exportContainer})
+}
+
+// exportContainer упаковывает корневую ФС в контейнерный образ (OCI layout или docker-archive)
+func exportContainer(spec structures.OutputSpec, opts Options) (string, error) {
+	if opts.Rootfs == "" {
+		return "", fmt.Errorf("%s output requires the built rootfs", spec.Type)
+	}
+
+	container := opts.Config.Container
+	tag := container.Tag
+	if tag == "" {
+		tag = defaultTag(opts.Config)
+	}
+
+	name := spec.Name
+	if name == "" {
+		base := strings.NewReplacer(":", "-", "/", "-").Replace(tag)
+		if spec.Type == "oci" {
+			name = base + ".oci.tar"
+		} else {
+			name = base + ".docker.tar"
+		}
+	}
+	dest := filepath.Join(opts.OutputDir, name)
+
+	fmt.Fprintf(opts.Out, "Exporting rootfs as %s image %s to %s\n", spec.Type, tag, name)
+
+	tmpDir, err := os.MkdirTemp("", "sysweaver-container-")
+	if err != nil {
+		return "", fmt.Errorf("error creating temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Слой: несжатый tar корневой ФС, diff_id - его sha256
+	layerPath := filepath.Join(tmpDir, "layer.tar")
+	diffID, err := writeLayer(layerPath, opts)
+	if err != nil {
+		return "", err
+	}
+
+	config := buildImageConfig(container, diffID)
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return "", fmt.Errorf("error encoding image config: %w", err)
+	}
+
+	if spec.Type == "oci" {
+		err = writeOCIArchive(dest, tmpDir, layerPath, configJSON, tag)
+	} else {
+		err = writeDockerArchive(dest, layerPath, configJSON, diffID, tag)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return dest, nil
+}
+
+// defaultTag возвращает тег образа по умолчанию из имени и версии сборки
+func defaultTag(cfg *structures.BuildConfig) string {
+	name := strings.ToLower(cfg.Name)
+	if name == "" {
+		name = "sysweaver"
+	}
+	version := cfg.Version
+	if version == "" {
+		version = "latest"
+	}
+	return name + ":" + version
+}
+
+// writeLayer записывает слой и возвращает его diff_id
+func writeLayer(path string, opts Options) (string, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("error creating layer: %w", err)
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if err := rootfs.WriteTar(io.MultiWriter(file, hasher), opts.Rootfs, rootfs.TarOptions{Exclude: opts.Exclude}); err != nil {
+		return "", fmt.Errorf("error packing rootfs: %w", err)
+	}
+
+	return "sha256:" + hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// buildImageConfig заполняет конфигурацию образа
+func buildImageConfig(container structures.ContainerConfig, diffID string) imageConfig {
+	var config imageConfig
+	config.Created = time.Now().UTC().Format(time.RFC3339)
+	config.OS = "linux"
+	config.Architecture = container.Architecture
+	if config.Architecture == "" {
+		config.Architecture = runtime.GOARCH
+	}
+
+	config.Config.Entrypoint = container.Entrypoint
+	config.Config.Cmd = container.Cmd
+	config.Config.Env = container.Env
+	config.Config.WorkingDir = container.WorkingDir
+	config.Config.User = container.User
+	config.Config.Labels = container.Labels
+
+	config.Rootfs.Type = "layers"
+	config.Rootfs.DiffIDs = []string{diffID}
+	config.History = []map[string]string{{"created": config.Created, "created_by": "sysweaver"}}
+
+	return config
+}
+
+// writeOCIArchive записывает OCI image layout, упакованный в tar
+func writeOCIArchive(dest, tmpDir, layerPath string, configJSON []byte, tag string) error {
+	// Сжимаем слой
+	gzPath := filepath.Join(tmpDir, "layer.tar.gz")
+	layerDigest, layerSize, err := gzipFile(layerPath, gzPath)
+	if err != nil {
+		return err
+	}
+
+	configDesc := ociDescriptor{MediaType: mediaTypeConfig, Digest: digestOf(configJSON), Size: int64(len(configJSON))}
+	layerDesc := ociDescriptor{MediaType: mediaTypeLayer, Digest: layerDigest, Size: layerSize}
+
+	manifestJSON, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     mediaTypeManifest,
+		"config":        configDesc,
+		"layers":        []ociDescriptor{layerDesc},
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding manifest: %w", err)
+	}
+
+	indexJSON, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"manifests": []ociDescriptor{{
+			MediaType:   mediaTypeManifest,
+			Digest:      digestOf(manifestJSON),
+			Size:        int64(len(manifestJSON)),
+			Annotations: map[string]string{"org.opencontainers.image.ref.name": tag},
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding index: %w", err)
+	}
+
+	archive, err := newArchive(dest)
+	if err != nil {
+		return err
+	}
+	defer archive.close()
+
+	archive.addBytes("oci-layout", []byte(`{"imageLayoutVersion":"1.0.0"}`))
+	archive.addBytes("index.json", indexJSON)
+	archive.addBytes(blobPath(configDesc.Digest), configJSON)
+	archive.addBytes(blobPath(digestOf(manifestJSON)), manifestJSON)
+	archive.addFile(blobPath(layerDigest), gzPath)
+
+	return archive.close()
+}
+
+// writeDockerArchive записывает образ в формате docker save
+func writeDockerArchive(dest, layerPath string, configJSON []byte, diffID, tag string) error {
+	configName := strings.TrimPrefix(digestOf(configJSON), "sha256:") + ".json"
+	layerName := strings.TrimPrefix(diffID, "sha256:") + "/layer.tar"
+
+	manifestJSON, err := json.Marshal([]map[string]interface{}{{
+		"Config":   configName,
+		"RepoTags": []string{tag},
+		"Layers":   []string{layerName},
+	}})
+	if err != nil {
+		return fmt.Errorf("error encoding manifest: %w", err)
+	}
+
+	archive, err := newArchive(dest)
+	if err != nil {
+		return err
+	}
+	defer archive.close()
+
+	archive.addBytes(configName, configJSON)
+	archive.addFile(layerName, layerPath)
+	archive.addBytes("manifest.json", manifestJSON)
+
+	return archive.close()
+}
+
+// gzipFile сжимает файл и возвращает дайджест и размер результата
+func gzipFile(src, dst string) (string, int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", 0, err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return "", 0, err
+	}
+	defer out.Close()
+
+	inInfo, err := in.Stat()
+	if err != nil {
+		return "", 0, err
+	}
+
+	hasher := sha256.New()
+	gz := gzip.NewWriter(io.MultiWriter(out, hasher))
+	pw := progress.NewWriter(gz, "compress layer", inInfo.Size())
+	if _, err := io.Copy(pw, in); err != nil {
+		return "", 0, fmt.Errorf("error compressing layer: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return "", 0, fmt.Errorf("error compressing layer: %w", err)
+	}
+	pw.Finish()
+
+	info, err := out.Stat()
+	if err != nil {
+		return "", 0, err
+	}
+
+	return "sha256:" + hex.EncodeToString(hasher.Sum(nil)), info.Size(), nil
+}
+
+// digestOf возвращает sha256-дайджест данных
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// blobPath возвращает путь блоба в OCI layout
+func blobPath(digest string) string {
+	return "blobs/" + strings.Replace(digest, ":", "/", 1)
+}
+
+// tarArchive - tar-файл артефакта с накоплением первой ошибки
+type tarArchive struct {
+	file   *os.File
+	tw     *tar.Writer
+	err    error
+	closed bool
+}
+
+func newArchive(path string) (*tarArchive, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s: %w", path, err)
+	}
+	return &tarArchive{file: file, tw: tar.NewWriter(file)}, nil
+}
+
+// addBytes добавляет в архив файл с содержимым data
+func (a *tarArchive) addBytes(name string, data []byte) {
+	if a.err != nil {
+		return
+	}
+	header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: time.Unix(0, 0)}
+	if a.err = a.tw.WriteHeader(header); a.err == nil {
+		_, a.err = a.tw.Write(data)
+	}
+}
+
+// addFile добавляет в архив файл с диска
+func (a *tarArchive) addFile(name, path string) {
+	if a.err != nil {
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		a.err = err
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		a.err = err
+		return
+	}
+
+	header := &tar.Header{Name: name, Mode: 0644, Size: info.Size(), ModTime: time.Unix(0, 0)}
+	if a.err = a.tw.WriteHeader(header); a.err == nil {
+		_, a.err = io.Copy(a.tw, file)
+	}
+}
+
+// close завершает архив и возвращает первую возникшую ошибку
+func (a *tarArchive) close() error {
+	if a.closed {
+		return a.err
+	}
+	a.closed = true
+
+	if err := a.tw.Close(); err != nil && a.err == nil {
+		a.err = err
+	}
+	if err := a.file.Close(); err != nil && a.err == nil {
+		a.err = err
+	}
+	if a.err != nil {
+		return fmt.Errorf("error writing archive: %w", a.err)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package output
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"sysweaver/internal/image"
+	"sysweaver/internal/progress"
+	"sysweaver/internal/structures"
+)
+
+// diskFormat описывает формат виртуального диска, получаемый конвертацией из raw
+type diskFormat struct {
+	qemuFormat   string            // Имя формата для qemu-img
+	extension    string            // Расширение выходного файла
+	defaults     map[string]string // Опции qemu-img по умолчанию
+	compressions []string          // Допустимые значения compression
+}
+
+// Форматы дисков гипервизоров
+func init() {
+	// QEMU/KVM; compression: zlib или zstd включает сжатие кластеров
+	image.Register("qcow2", diskFormat{
+		qemuFormat:   "qcow2",
+		extension:    ".qcow2",
+		compressions: []string{"zlib", "zstd"},
+	})
+	// VMware: streamOptimized подходит для упаковки в OVA
+	image.Register("vmdk", diskFormat{
+		qemuFormat: "vmdk",
+		extension:  ".vmdk",
+		defaults: map[string]string{
+			"subformat":    "streamOptimized",
+			"adapter_type": "lsilogic",
+		},
+	})
+	// Hyper-V
+	image.Register("vhdx", diskFormat{
+		qemuFormat: "vhdx",
+		extension:  ".vhdx",
+		defaults: map[string]string{
+			"subformat": "dynamic",
+		},
+	})
+	// VirtualBox
+	image.Register("vdi", diskFormat{
+		qemuFormat: "vdi",
+		extension:  ".vdi",
+		defaults: map[string]string{
+			"static": "off",
+		},
+	})
+}
+
+// Validate проверяет сжатие: его поддерживает только qcow2
+func (f diskFormat) Validate(spec structures.OutputSpec) error {
+	if spec.Compression != "" && !slices.Contains(f.compressions, spec.Compression) {
+		return fmt.Errorf("unsupported %s compression: %s", spec.Type, spec.Compression)
+	}
+	return nil
+}
+
+// Create конвертирует каждый исходный raw-образ в формат диска
+func (f diskFormat) Create(spec structures.OutputSpec, env image.Env) ([]string, error) {
+	sources, err := env.Sources(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	var produced []string
+	for _, source := range sources {
+		dest := filepath.Join(env.OutputDir, destName(spec, source, f.extension, len(sources)))
+
+		fmt.Fprintf(env.Out, "Converting %s to %s (%s)\n", filepath.Base(source), filepath.Base(dest), spec.Type)
+		if err := f.Convert(env.Context, source, dest, spec); err != nil {
+			return produced, err
+		}
+
+		produced = append(produced, dest)
+	}
+	return produced, nil
+}
+
+// destName возвращает имя выходного файла
+func destName(spec structures.OutputSpec, source, extension string, total int) string {
+	// Явное имя применимо только к единственному источнику
+	if spec.Name != "" && total == 1 {
+		return spec.Name
+	}
+
+	base := filepath.Base(source)
+	return strings.TrimSuffix(base, filepath.Ext(base)) + extension
+}
+
+// Convert конвертирует raw-образ в формат гипервизора через qemu-img
+func (f diskFormat) Convert(ctx context.Context, source, dest string, spec structures.OutputSpec) error {
+	options := map[string]string{}
+	for k, v := range f.defaults {
+		options[k] = v
+	}
+	for k, v := range spec.Options {
+		options[k] = v
+	}
+	if spec.Subformat != "" {
+		options["subformat"] = spec.Subformat
+	}
+
+	args := []string{"convert", "-f", "raw", "-O", f.qemuFormat}
+	if spec.Compression != "" && f.qemuFormat == "qcow2" {
+		args = append(args, "-c")
+		options["compression_type"] = spec.Compression
+	}
+	if len(options) > 0 {
+		args = append(args, "-o", joinOptions(options))
+	}
+	args = append(args, source, dest)
+
+	var total int64
+	if info, err := os.Stat(source); err == nil {
+		total = info.Size()
+	}
+
+	// Вывод qemu-img нужен только для сообщения об ошибке
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, "qemu-img", args...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	if err := progress.Run(cmd, "convert "+f.qemuFormat, total, true); err != nil {
+		if detail := strings.TrimSpace(output.String()); detail != "" {
+			return fmt.Errorf("qemu-img convert to %s failed: %w: %s", f.qemuFormat, err, detail)
+		}
+		return fmt.Errorf("qemu-img convert to %s failed: %w", f.qemuFormat, err)
+	}
+
+	return nil
+}
+
+// joinOptions собирает опции qemu-img в строку key=value,... в стабильном порядке
+func joinOptions(options map[string]string) string {
+	keys := make([]string, 0, len(options))
+	for k := range options {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+"="+options[k])
+	}
+	return strings.Join(parts, ",")
+}
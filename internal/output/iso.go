@@ -0,0 +1,145 @@
+package output
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"sysweaver/internal/image"
+	"sysweaver/internal/progress"
+	"sysweaver/internal/rootfs"
+	"sysweaver/internal/structures"
+)
+
+// Путь образа корневой ФС внутри ISO по умолчанию
+const isoSquashfsPath = "live/filesystem.squashfs"
+
+func init() {
+	image.Register("iso", exporter{export: exportISO, compressions: []string{"gzip", "lzo", "lz4", "xz", "zstd", "lzma"}})
+}
+
+// exportISO упаковывает корневую ФС в squashfs и собирает ISO утилитой xorriso.
+// Содержимое каталога iso/ шаблона (загрузчик, конфигурация) добавляется в корень ISO;
+// опция boot задает образ El Torito (например, boot/syslinux/isolinux.bin).
+func exportISO(spec structures.OutputSpec, opts Options) (string, error) {
+	if opts.Rootfs == "" {
+		return "", fmt.Errorf("iso output requires the built rootfs")
+	}
+
+	isoConfig := opts.Config.ISO
+	compression := spec.Compression
+	if compression == "" {
+		compression = isoConfig.Compression
+	}
+	if compression == "" {
+		compression = "xz"
+	}
+
+	label := isoConfig.Label
+	if label == "" {
+		label = strings.ToUpper(strings.NewReplacer(":", "_", ".", "_", "-", "_").Replace(defaultTag(opts.Config)))
+	}
+	if len(label) > 32 {
+		label = label[:32]
+	}
+
+	name := spec.Name
+	if name == "" {
+		name = strings.NewReplacer(":", "-", "/", "-").Replace(defaultTag(opts.Config)) + ".iso"
+	}
+	dest := filepath.Join(opts.OutputDir, name)
+
+	staging, err := os.MkdirTemp(opts.OutputDir, ".iso-")
+	if err != nil {
+		return "", fmt.Errorf("error creating ISO staging directory: %w", err)
+	}
+	defer os.RemoveAll(staging)
+
+	squashfsPath := spec.Options["squashfs"]
+	if squashfsPath == "" {
+		squashfsPath = isoSquashfsPath
+	}
+
+	fmt.Fprintf(opts.Out, "Packing rootfs to %s (%s)\n", squashfsPath, compression)
+	if err := writeSquashfs(filepath.Join(staging, squashfsPath), compression, opts); err != nil {
+		return "", err
+	}
+
+	// Файлы загрузчика и прочее содержимое ISO из шаблона
+	if opts.TemplateDir != "" {
+		overlay := filepath.Join(opts.TemplateDir, "iso")
+		if _, err := os.Stat(overlay); err == nil {
+			cp := exec.CommandContext(opts.Context, "cp", "-a", overlay+"/.", staging)
+			cp.Stdout = opts.LogWriter
+			cp.Stderr = opts.LogWriter
+			if err := cp.Run(); err != nil {
+				return "", fmt.Errorf("error copying ISO files from template: %w", err)
+			}
+		}
+	}
+
+	args := []string{"-as", "mkisofs", "-R", "-J", "-V", label}
+	if isoConfig.Publisher != "" {
+		args = append(args, "-publisher", isoConfig.Publisher)
+	}
+	if boot := spec.Options["boot"]; boot != "" {
+		args = append(args, "-b", boot, "-c", "boot.catalog", "-no-emul-boot", "-boot-load-size", "4", "-boot-info-table")
+	}
+	args = append(args, "-o", dest, staging)
+
+	fmt.Fprintf(opts.Out, "Creating ISO %s (label %s)\n", name, label)
+
+	var total int64
+	if info, err := os.Stat(filepath.Join(staging, squashfsPath)); err == nil {
+		total = info.Size()
+	}
+
+	cmd := exec.CommandContext(opts.Context, "xorriso", args...)
+	cmd.Stdout = opts.LogWriter
+	cmd.Stderr = opts.LogWriter
+	if err := progress.Run(cmd, "iso", total, false); err != nil {
+		return "", fmt.Errorf("xorriso failed: %w", err)
+	}
+
+	return dest, nil
+}
+
+// writeSquashfs создает squashfs из потока tar корневой ФС (mksquashfs -tar)
+func writeSquashfs(dest, compression string, opts Options) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("error creating %s: %w", filepath.Dir(dest), err)
+	}
+
+	cmd := exec.CommandContext(opts.Context, "mksquashfs", "-", dest, "-tar", "-noappend", "-quiet", "-comp", compression)
+	cmd.Stdout = opts.LogWriter
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("error creating mksquashfs pipe: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error starting mksquashfs: %w", err)
+	}
+
+	tarOpts := rootfs.TarOptions{Exclude: opts.Exclude, Xattrs: true, ClampMtime: rootfs.SourceDateEpoch()}
+	total, _ := rootfs.Size(opts.Rootfs, tarOpts)
+
+	pw := progress.NewWriter(stdin, "squashfs", total)
+	produceErr := rootfs.WriteTar(pw, opts.Rootfs, tarOpts)
+	stdin.Close()
+	waitErr := cmd.Wait()
+
+	if produceErr != nil {
+		return fmt.Errorf("error packing rootfs: %w", produceErr)
+	}
+	if waitErr != nil {
+		return fmt.Errorf("mksquashfs failed: %w", waitErr)
+	}
+	pw.Finish()
+
+	return nil
+}
@@ -0,0 +1,36 @@
+package output
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"sysweaver/internal/image"
+	"sysweaver/internal/mtree"
+	"sysweaver/internal/structures"
+)
+
+func init() {
+	image.Register("mtree", exporter{export: exportMtree})
+}
+
+// exportMtree записывает манифест содержимого собранной системы в формате mtree
+func exportMtree(spec structures.OutputSpec, opts Options) (string, error) {
+	if opts.Rootfs == "" {
+		return "", fmt.Errorf("%s output requires the built rootfs", spec.Type)
+	}
+
+	name := spec.Name
+	if name == "" {
+		name = strings.NewReplacer(":", "-", "/", "-").Replace(defaultTag(opts.Config)) + ".mtree"
+	}
+	dest := filepath.Join(opts.OutputDir, name)
+
+	count, err := mtree.Write(opts.Rootfs, opts.Exclude, dest)
+	if err != nil {
+		return "", fmt.Errorf("error writing content manifest: %w", err)
+	}
+	fmt.Fprintf(opts.Out, "Wrote content manifest %s (%d entries)\n", name, count)
+
+	return dest, nil
+}
@@ -0,0 +1,191 @@
+package output
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"sysweaver/internal/image"
+	"sysweaver/internal/structures"
+)
+
+// Options - параметры генерации выходных артефактов (окружение форматов)
+type Options = image.Env
+
+// Validate проверяет выходы секции outputs до начала сборки: тип должен
+// быть зарегистрированным форматом, параметры - допустимыми для него
+func Validate(specs []structures.OutputSpec) error {
+	var errs []error
+	for i, spec := range specs {
+		format, err := image.Lookup(spec.Type)
+		if err == nil {
+			err = format.Validate(spec)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("outputs[%d]: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Generate создает выходные артефакты из секции outputs конфигурации за один проход.
+// Форматы дисков получаются конвертацией raw-образа: созданного в этой же сборке
+// выходом raw, скопированного из jail или, при заданной разметке partitions,
+// промежуточного образа, который заполняется из rootfs один раз для всех форматов.
+// Архивы, контейнерные образы и ISO формируются из корневой ФС Rootfs.
+func Generate(opts Options) ([]string, error) {
+	if err := Validate(opts.Config.Outputs); err != nil {
+		return nil, err
+	}
+	if opts.Context == nil {
+		opts.Context = context.Background()
+	}
+	if opts.Out == nil {
+		opts.Out = os.Stdout
+	}
+
+	g := &generator{opts: opts}
+	defer g.cleanup()
+	opts.Sources = g.sources
+
+	var produced []string
+	for _, spec := range orderOutputs(opts.Config.Outputs) {
+		if err := opts.Context.Err(); err != nil {
+			return produced, err
+		}
+		format, err := image.Lookup(spec.Type)
+		if err != nil {
+			return produced, err
+		}
+
+		artifacts, err := format.Create(spec, opts)
+		if err != nil {
+			return produced, err
+		}
+		// Следующие конвертации используют созданный raw-образ
+		if spec.Type == "raw" && g.raw == "" && len(artifacts) > 0 {
+			g.raw = artifacts[0]
+		}
+		produced = append(produced, artifacts...)
+	}
+
+	return produced, nil
+}
+
+// exporter - формат, создаваемый из корневой ФС одним файлом
+type exporter struct {
+	export       func(structures.OutputSpec, Options) (string, error)
+	compressions []string // Допустимые значения compression (nil - не используется)
+}
+
+// Validate проверяет сжатие выхода
+func (e exporter) Validate(spec structures.OutputSpec) error {
+	if spec.Compression != "" && e.compressions != nil && !slices.Contains(e.compressions, spec.Compression) {
+		return fmt.Errorf("unsupported %s compression: %s", spec.Type, spec.Compression)
+	}
+	return nil
+}
+
+// Create создает артефакт выхода
+func (e exporter) Create(spec structures.OutputSpec, env image.Env) ([]string, error) {
+	dest, err := e.export(spec, env)
+	if err != nil {
+		return nil, err
+	}
+	return []string{dest}, nil
+}
+
+// Convert не поддерживается: артефакт создается из корневой ФС
+func (e exporter) Convert(ctx context.Context, source, dest string, spec structures.OutputSpec) error {
+	return image.ErrNotConvertible
+}
+
+// orderOutputs ставит raw-образы первыми, чтобы конвертации использовали их
+// вместо повторного заполнения разделов; порядок остальных выходов сохраняется
+func orderOutputs(specs []structures.OutputSpec) []structures.OutputSpec {
+	ordered := make([]structures.OutputSpec, len(specs))
+	copy(ordered, specs)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Type == "raw" && ordered[j].Type != "raw"
+	})
+	return ordered
+}
+
+// generator хранит raw-образ, общий для всех конвертаций одной сборки
+type generator struct {
+	opts         Options
+	raw          string // raw-образ, созданный в этой сборке
+	intermediate string // Директория промежуточного raw-образа (удаляется по завершении)
+}
+
+// sources определяет исходные raw-образы для конвертации
+func (g *generator) sources(spec structures.OutputSpec) ([]string, error) {
+	if spec.Source == "" && g.raw != "" {
+		return []string{g.raw}, nil
+	}
+
+	sources, err := resolveSources(spec, g.opts.OutputDir)
+	if err == nil || spec.Source != "" || len(g.opts.Config.Partitions) == 0 || g.opts.Rootfs == "" {
+		return sources, err
+	}
+
+	// Образов нет - создаем промежуточный raw-образ по разметке partitions
+	dir, err := os.MkdirTemp(g.opts.OutputDir, ".raw-")
+	if err != nil {
+		return nil, fmt.Errorf("error creating temporary directory: %w", err)
+	}
+	g.intermediate = dir
+
+	raw, err := exportRaw(structures.OutputSpec{Type: "raw"}, Options{
+		Config:      g.opts.Config,
+		OutputDir:   dir,
+		Rootfs:      g.opts.Rootfs,
+		Exclude:     g.opts.Exclude,
+		TemplateDir: g.opts.TemplateDir,
+		LogWriter:   g.opts.LogWriter,
+		Context:     g.opts.Context,
+	})
+	if err != nil {
+		return nil, err
+	}
+	g.raw = raw
+
+	return []string{raw}, nil
+}
+
+// cleanup удаляет промежуточный raw-образ
+func (g *generator) cleanup() {
+	if g.intermediate != "" {
+		os.RemoveAll(g.intermediate)
+	}
+}
+
+// resolveSources определяет исходные raw-образы для выхода
+func resolveSources(spec structures.OutputSpec, outputDir string) ([]string, error) {
+	if spec.Source != "" {
+		source := filepath.Join(outputDir, spec.Source)
+		if _, err := os.Stat(source); err != nil {
+			return nil, fmt.Errorf("source image for %s output not found: %s", spec.Type, spec.Source)
+		}
+		return []string{source}, nil
+	}
+
+	var sources []string
+	for _, pattern := range []string{"*.img", "*.raw"} {
+		matches, err := filepath.Glob(filepath.Join(outputDir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, matches...)
+	}
+
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no raw images found in %s for %s output", outputDir, spec.Type)
+	}
+
+	return sources, nil
+}
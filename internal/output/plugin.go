@@ -0,0 +1,57 @@
+package output
+
+import (
+	"context"
+	"fmt"
+
+	"sysweaver/internal/image"
+	"sysweaver/internal/plugin"
+	"sysweaver/internal/structures"
+)
+
+// pluginFormat - выход, создаваемый внешним плагином sysweaver-output-<plugin>
+type pluginFormat struct{}
+
+func init() {
+	image.Register("plugin", pluginFormat{})
+}
+
+// Validate проверяет, что задано имя плагина
+func (pluginFormat) Validate(spec structures.OutputSpec) error {
+	if spec.Plugin == "" {
+		return fmt.Errorf("plugin output requires the plugin name")
+	}
+	return nil
+}
+
+// Create создает артефакты плагином; плагин может создать несколько файлов
+func (pluginFormat) Create(spec structures.OutputSpec, opts Options) ([]string, error) {
+	p, err := plugin.Find(opts.PluginDirs, plugin.KindOutput, spec.Plugin)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(opts.Out, "Generating %s output with plugin %s\n", spec.Plugin, p.Path)
+	result, err := p.Run(plugin.Request{
+		Options: spec.Options,
+		Build: plugin.Build{
+			Name:    opts.Config.Name,
+			Version: opts.Config.Version,
+			Arch:    opts.Config.Arch,
+		},
+		OutputDir:   opts.OutputDir,
+		TemplateDir: opts.TemplateDir,
+		Rootfs:      opts.Rootfs,
+		Source:      spec.Source,
+		DestName:    spec.Name,
+	}, opts.LogWriter)
+	if err != nil {
+		return nil, err
+	}
+	return result.Artifacts, nil
+}
+
+// Convert не поддерживается: протокол плагинов не описывает конвертацию
+func (pluginFormat) Convert(ctx context.Context, source, dest string, spec structures.OutputSpec) error {
+	return image.ErrNotConvertible
+}
@@ -0,0 +1,42 @@
+package output
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"sysweaver/internal/image"
+	"sysweaver/internal/structures"
+)
+
+func init() {
+	image.Register("raw", exporter{export: exportRaw})
+}
+
+// exportRaw создает raw-образ диска по разметке partitions из конфигурации
+func exportRaw(spec structures.OutputSpec, opts Options) (string, error) {
+	if opts.Rootfs == "" {
+		return "", fmt.Errorf("raw output requires the built rootfs")
+	}
+
+	name := spec.Name
+	if name == "" {
+		name = strings.NewReplacer(":", "-", "/", "-").Replace(defaultTag(opts.Config)) + ".img"
+	}
+	dest := filepath.Join(opts.OutputDir, name)
+
+	err := image.CreateRawImage(opts.Context, image.RawOptions{
+		Path:        dest,
+		Partitions:  opts.Config.Partitions,
+		Rootfs:      opts.Rootfs,
+		Exclude:     opts.Exclude,
+		TemplateDir: opts.TemplateDir,
+		LogWriter:   opts.LogWriter,
+		Logger:      opts.Logger,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return dest, nil
+}
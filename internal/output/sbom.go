@@ -0,0 +1,52 @@
+package output
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"sysweaver/internal/image"
+	"sysweaver/internal/sbom"
+	"sysweaver/internal/structures"
+)
+
+func init() {
+	image.Register("spdx", exporter{export: exportSBOM})
+	image.Register("cyclonedx", exporter{export: exportSBOM})
+}
+
+// exportSBOM записывает SBOM собранной системы в формате SPDX или CycloneDX
+func exportSBOM(spec structures.OutputSpec, opts Options) (string, error) {
+	if opts.Rootfs == "" {
+		return "", fmt.Errorf("%s output requires the built rootfs", spec.Type)
+	}
+
+	name := spec.Name
+	if name == "" {
+		base := strings.NewReplacer(":", "-", "/", "-").Replace(defaultTag(opts.Config))
+		if spec.Type == "spdx" {
+			name = base + ".spdx.json"
+		} else {
+			name = base + ".cdx.json"
+		}
+	}
+	dest := filepath.Join(opts.OutputDir, name)
+
+	inv, err := sbom.Collect(opts.Rootfs, opts.Config.Name, opts.Config.Version, opts.Exclude)
+	if err != nil {
+		return "", fmt.Errorf("error collecting SBOM: %w", err)
+	}
+
+	fmt.Fprintf(opts.Out, "Writing %s SBOM to %s (%d packages, %d unpackaged files)\n", spec.Type, name, len(inv.Packages), len(inv.Files))
+
+	if spec.Type == "spdx" {
+		err = sbom.WriteSPDX(inv, dest)
+	} else {
+		err = sbom.WriteCycloneDX(inv, dest)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return dest, nil
+}
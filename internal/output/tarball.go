@@ -0,0 +1,132 @@
+package output
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"sysweaver/internal/image"
+	"sysweaver/internal/progress"
+	"sysweaver/internal/rootfs"
+	"sysweaver/internal/structures"
+)
+
+func init() {
+	image.Register("tar", exporter{export: exportTarball, compressions: []string{"gzip", "zstd", "none"}})
+}
+
+// exportTarball упаковывает корневую ФС в воспроизводимый tar.gz или tar.zst.
+// Время файлов ограничивается SOURCE_DATE_EPOCH, если переменная задана.
+func exportTarball(spec structures.OutputSpec, opts Options) (string, error) {
+	if opts.Rootfs == "" {
+		return "", fmt.Errorf("tar output requires the built rootfs")
+	}
+
+	compression := spec.Compression
+	if compression == "" {
+		compression = "gzip"
+	}
+
+	var extension string
+	switch compression {
+	case "gzip":
+		extension = ".tar.gz"
+	case "zstd":
+		extension = ".tar.zst"
+	case "none":
+		extension = ".tar"
+	default:
+		return "", fmt.Errorf("unsupported tar compression: %s", compression)
+	}
+
+	name := spec.Name
+	if name == "" {
+		name = strings.NewReplacer(":", "-", "/", "-").Replace(defaultTag(opts.Config)) + ".rootfs" + extension
+	}
+	dest := filepath.Join(opts.OutputDir, name)
+
+	fmt.Fprintf(opts.Out, "Packing rootfs to %s (%s)\n", name, compression)
+
+	file, err := os.Create(dest)
+	if err != nil {
+		return "", fmt.Errorf("error creating %s: %w", dest, err)
+	}
+	defer file.Close()
+
+	tarOpts := rootfs.TarOptions{
+		Exclude:    opts.Exclude,
+		Xattrs:     true,
+		ClampMtime: rootfs.SourceDateEpoch(),
+	}
+	total, _ := rootfs.Size(opts.Rootfs, tarOpts)
+
+	// Прогресс считается по несжатому потоку
+	writeTar := func(w io.Writer) error {
+		pw := progress.NewWriter(w, "compress "+compression, total)
+		if err := rootfs.WriteTar(pw, opts.Rootfs, tarOpts); err != nil {
+			return err
+		}
+		pw.Finish()
+		return nil
+	}
+
+	switch compression {
+	case "gzip":
+		// Заголовок gzip без имени и времени - результат воспроизводим
+		gz := gzip.NewWriter(file)
+		if err := writeTar(gz); err != nil {
+			return "", fmt.Errorf("error packing rootfs: %w", err)
+		}
+		if err := gz.Close(); err != nil {
+			return "", fmt.Errorf("error compressing rootfs: %w", err)
+		}
+	case "zstd":
+		if err := writeZstd(opts.Context, file, writeTar); err != nil {
+			return "", err
+		}
+	default:
+		if err := writeTar(file); err != nil {
+			return "", fmt.Errorf("error packing rootfs: %w", err)
+		}
+	}
+
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("error writing %s: %w", dest, err)
+	}
+
+	return dest, nil
+}
+
+// writeZstd сжимает поток, формируемый produce, внешней утилитой zstd
+func writeZstd(ctx context.Context, dst io.Writer, produce func(w io.Writer) error) error {
+	cmd := exec.CommandContext(ctx, "zstd", "-q", "-c", "-T0")
+	cmd.Stdout = dst
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("error creating zstd pipe: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error starting zstd: %w", err)
+	}
+
+	produceErr := produce(stdin)
+	stdin.Close()
+	waitErr := cmd.Wait()
+
+	if produceErr != nil {
+		return fmt.Errorf("error packing rootfs: %w", produceErr)
+	}
+	if waitErr != nil {
+		return fmt.Errorf("zstd compression failed: %w", waitErr)
+	}
+
+	return nil
+}
@@ -0,0 +1,299 @@
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"sysweaver/internal/progress"
+)
+
+// Внешние плагины - исполняемые файлы, добавляющие форматы выхода и цели
+// публикации без изменения SysWeaver. Плагин вида kind с именем name - файл
+// sysweaver-<kind>-<name> в одном из каталогов плагинов (Dirs).
+//
+// Протокол (версия 1): SysWeaver запускает плагин без аргументов и пишет в
+// его stdin один JSON-объект Request, после чего закрывает stdin. Плагин
+// пишет в stdout сообщения NDJSON (Message), по объекту на строку:
+//
+//	{"type": "log", "message": "packing OVA"}
+//	{"type": "progress", "phase": "pack", "done": 1048576, "total": 8388608}
+//	{"type": "result", "artifacts": ["demo-1.0.ova"], "id": "https://..."}
+//	{"type": "error", "message": "ovftool not found"}
+//
+// Строки stdout, не являющиеся JSON, и stderr плагина выводятся как журнал.
+// Плагин завершается успешно, только если вывел result и вернул код 0.
+// Пути артефактов в result - абсолютные или относительно output_dir.
+//
+// Хуки (вид hook) вызываются в точках сборки (поле hook запроса). Для хука
+// result не обязателен и может содержать annotations - пометки, которые
+// сохраняются в записи о сборке; сообщение error или ненулевой код возврата
+// запрещает сборку:
+//
+//	{"type": "result", "annotations": {"compliance.cis": "passed"}}
+
+// Версия протокола плагинов
+const Protocol = 1
+
+// Виды плагинов
+const (
+	KindOutput  = "output"
+	KindPublish = "publish"
+	KindHook    = "hook"
+)
+
+// Точки сборки, в которых вызываются хуки
+const (
+	HookPreBuild        = "pre-build"
+	HookPostStage       = "post-stage"
+	HookPreArtifactCopy = "pre-artifact-copy"
+	HookPostBuild       = "post-build"
+)
+
+// Build - сведения о сборке для плагина
+type Build struct {
+	ID       string `json:"id,omitempty"`
+	Template string `json:"template,omitempty"`
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	Arch     string `json:"arch,omitempty"`
+}
+
+// Request - запрос к плагину
+type Request struct {
+	Protocol    int               `json:"protocol"`
+	Kind        string            `json:"kind"`
+	Name        string            `json:"name"`
+	Options     map[string]string `json:"options,omitempty"`
+	Build       Build             `json:"build"`
+	OutputDir   string            `json:"output_dir"`
+	TemplateDir string            `json:"template_dir,omitempty"`
+	Rootfs      string            `json:"rootfs,omitempty"`    // output: корневая ФС собранной системы
+	Source      string            `json:"source,omitempty"`    // output: исходный артефакт (source выхода)
+	DestName    string            `json:"dest_name,omitempty"` // output: имя выходного файла (name выхода)
+	Artifacts   []string          `json:"artifacts,omitempty"` // publish, post-build: файлы в output_dir
+
+	Hook   string `json:"hook,omitempty"`   // hook: точка сборки
+	Stage  string `json:"stage,omitempty"`  // post-stage: завершенная стадия
+	Result string `json:"result,omitempty"` // post-build: success или failed
+	Error  string `json:"error,omitempty"`  // post-build: ошибка сборки
+}
+
+// Message - сообщение плагина
+type Message struct {
+	Type      string   `json:"type"` // log, progress, result или error
+	Message   string   `json:"message,omitempty"`
+	Phase     string   `json:"phase,omitempty"`
+	Done      int64    `json:"done,omitempty"`
+	Total     int64    `json:"total,omitempty"`
+	Artifacts []string `json:"artifacts,omitempty"`
+	ID        string   `json:"id,omitempty"` // publish: идентификатор или адрес опубликованного ресурса
+
+	Annotations map[string]string `json:"annotations,omitempty"` // hook: пометки сборки
+}
+
+// Result - итог работы плагина
+type Result struct {
+	Artifacts   []string // Абсолютные пути созданных артефактов
+	ID          string
+	Annotations map[string]string
+}
+
+// Plugin - найденный плагин
+type Plugin struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// Системные каталоги плагинов
+var systemDirs = []string{"/usr/local/lib/sysweaver/plugins", "/usr/lib/sysweaver/plugins"}
+
+// Dirs возвращает каталоги плагинов в порядке поиска: plugins/ шаблона,
+// каталоги из $SYSWEAVER_PLUGIN_PATH (через :), plugins/ каталога
+// состояния и системные каталоги
+func Dirs(templateDir, stateDir string) []string {
+	var dirs []string
+	if templateDir != "" {
+		dirs = append(dirs, filepath.Join(templateDir, "plugins"))
+	}
+	for _, dir := range filepath.SplitList(os.Getenv("SYSWEAVER_PLUGIN_PATH")) {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	if stateDir != "" {
+		dirs = append(dirs, filepath.Join(stateDir, "plugins"))
+	}
+	return append(dirs, systemDirs...)
+}
+
+// Find ищет плагин вида kind с именем name
+func Find(dirs []string, kind, name string) (Plugin, error) {
+	if name == "" || strings.ContainsAny(name, "/\\") {
+		return Plugin{}, fmt.Errorf("invalid plugin name: %q", name)
+	}
+	file := "sysweaver-" + kind + "-" + name
+	for _, dir := range dirs {
+		path := filepath.Join(dir, file)
+		if isExecutable(path) {
+			return Plugin{Kind: kind, Name: name, Path: path}, nil
+		}
+	}
+	return Plugin{}, fmt.Errorf("%s plugin %q not found (looked for %s in %s)", kind, name, file, strings.Join(dirs, ", "))
+}
+
+// List возвращает все найденные плагины; одноименный плагин из более
+// раннего каталога скрывает остальные
+func List(dirs []string) []Plugin {
+	seen := make(map[string]bool)
+	var plugins []Plugin
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			rest, ok := strings.CutPrefix(e.Name(), "sysweaver-")
+			if !ok || seen[e.Name()] || !isExecutable(filepath.Join(dir, e.Name())) {
+				continue
+			}
+			kind, name, ok := strings.Cut(rest, "-")
+			if !ok || name == "" || (kind != KindOutput && kind != KindPublish && kind != KindHook) {
+				continue
+			}
+			seen[e.Name()] = true
+			plugins = append(plugins, Plugin{Kind: kind, Name: name, Path: filepath.Join(dir, e.Name())})
+		}
+	}
+	sort.Slice(plugins, func(i, j int) bool {
+		if plugins[i].Kind != plugins[j].Kind {
+			return plugins[i].Kind < plugins[j].Kind
+		}
+		return plugins[i].Name < plugins[j].Name
+	})
+	return plugins
+}
+
+// Run запускает плагин с запросом req; журнал плагина пишется в logWriter
+func (p Plugin) Run(req Request, logWriter io.Writer) (Result, error) {
+	if logWriter == nil {
+		logWriter = io.Discard
+	}
+	req.Protocol, req.Kind, req.Name = Protocol, p.Kind, p.Name
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return Result{}, fmt.Errorf("error encoding plugin request: %w", err)
+	}
+
+	cmd := exec.Command(p.Path)
+	// До первой стадии директории вывода может еще не быть
+	if info, err := os.Stat(req.OutputDir); err == nil && info.IsDir() {
+		cmd.Dir = req.OutputDir
+	}
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stderr = logWriter
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return Result{}, err
+	}
+	if err := cmd.Start(); err != nil {
+		return Result{}, fmt.Errorf("error starting plugin %s: %w", p.Path, err)
+	}
+
+	var (
+		result   *Result
+		failures []string
+		phase    *progress.Writer
+		phaseOf  string
+		reported int64
+	)
+	finishPhase := func() {
+		if phase != nil {
+			phase.Finish()
+			phase, phaseOf, reported = nil, "", 0
+		}
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		var msg Message
+		if err := json.Unmarshal(line, &msg); err != nil || msg.Type == "" {
+			fmt.Fprintf(logWriter, "%s\n", line)
+			continue
+		}
+
+		switch msg.Type {
+		case "log":
+			fmt.Fprintln(logWriter, msg.Message)
+		case "progress":
+			if msg.Phase != phaseOf {
+				finishPhase()
+				phase, phaseOf = progress.NewWriter(io.Discard, msg.Phase, msg.Total), msg.Phase
+			}
+			if msg.Done > reported {
+				phase.Add(msg.Done - reported)
+				reported = msg.Done
+			}
+		case "result":
+			finishPhase()
+			result = &Result{ID: msg.ID, Annotations: msg.Annotations}
+			for _, artifact := range msg.Artifacts {
+				if !filepath.IsAbs(artifact) {
+					artifact = filepath.Join(req.OutputDir, artifact)
+				}
+				result.Artifacts = append(result.Artifacts, artifact)
+			}
+		case "error":
+			failures = append(failures, msg.Message)
+		default:
+			fmt.Fprintf(logWriter, "%s\n", line)
+		}
+	}
+	finishPhase()
+	scanErr := scanner.Err()
+	if scanErr != nil {
+		// Дочитываем вывод, чтобы плагин не завис на записи
+		io.Copy(io.Discard, stdout)
+	}
+
+	if err := cmd.Wait(); err != nil {
+		failures = append(failures, err.Error())
+	}
+	if scanErr != nil {
+		failures = append(failures, fmt.Sprintf("error reading plugin output: %v", scanErr))
+	}
+	if len(failures) > 0 {
+		return Result{}, fmt.Errorf("%s plugin %s failed: %s", p.Kind, p.Name, strings.Join(failures, "; "))
+	}
+	if result == nil && p.Kind == KindHook {
+		result = &Result{}
+	}
+	if result == nil {
+		return Result{}, errors.New(p.Kind + " plugin " + p.Name + " finished without a result")
+	}
+
+	for _, artifact := range result.Artifacts {
+		if _, err := os.Stat(artifact); err != nil {
+			return Result{}, fmt.Errorf("%s plugin %s reported a missing artifact: %s", p.Kind, p.Name, artifact)
+		}
+	}
+	return *result, nil
+}
+
+// isExecutable проверяет, что path - исполняемый файл
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular() && info.Mode().Perm()&0111 != 0
+}
@@ -0,0 +1,290 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Интерактивное отображение хода сборки в терминале: внизу экрана держится
+// строка состояния со спиннером, временем сборки, общим счетчиком скриптов
+// N/M, полосой прогресса текущей стадии, выполняемыми скриптами и фазой
+// длительной операции (mkfs, xorriso, сжатие). Обычный вывод сборки
+// (stdout и stderr) проходит через канал и печатается над строкой состояния.
+// Данные берутся из событий сборки (Subscribe) и обновлений прогресса
+// (SetReporter), поэтому при выводе не в терминал ничего не меняется.
+
+// Интервал перерисовки строки состояния
+const redrawInterval = 100 * time.Millisecond
+
+// Кадры спиннера
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// Ширина полосы прогресса в символах
+const barWidth = 20
+
+// Display - строка состояния сборки в терминале
+type Display struct {
+	out *os.File // Терминал
+
+	mu        sync.Mutex
+	started   time.Time
+	total     int // Скриптов в сборке
+	done      int // Завершено или пропущено
+	stage     string
+	stageDone int
+	stageAll  int
+	running   []string // Выполняемые скрипты
+	op        *Update  // Текущая длительная операция
+	frame     int
+	shown     bool // Строка состояния выведена
+	lineOpen  bool // Последний вывод не закончился переводом строки
+
+	stdout, stderr *os.File // Исходные stdout и stderr
+	reporter       Reporter // Исходный получатель прогресса
+	pipes          []*os.File
+	copied         sync.WaitGroup
+	stop           chan struct{}
+	stopped        chan struct{}
+	unsubscribe    func()
+}
+
+// StartDisplay перенаправляет stdout и stderr процесса через строку
+// состояния в терминале out и подписывает ее на события и прогресс
+func StartDisplay(out *os.File) (*Display, error) {
+	d := &Display{
+		out:      out,
+		started:  time.Now(),
+		stdout:   os.Stdout,
+		stderr:   os.Stderr,
+		reporter: currentReporter(),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+
+	for _, target := range []**os.File{&os.Stdout, &os.Stderr} {
+		r, w, err := os.Pipe()
+		if err != nil {
+			d.restore()
+			return nil, fmt.Errorf("error creating output pipe: %w", err)
+		}
+		d.pipes = append(d.pipes, r, w)
+		*target = w
+
+		d.copied.Add(1)
+		go func() {
+			defer d.copied.Done()
+			defer r.Close()
+			io.Copy(d, r)
+		}()
+	}
+
+	d.unsubscribe = Subscribe(d.event)
+	SetReporter(d.report)
+
+	go d.loop()
+	return d, nil
+}
+
+// Stop убирает строку состояния и возвращает stdout и stderr на место
+func (d *Display) Stop() {
+	d.unsubscribe()
+	SetReporter(d.reporter)
+	d.restore()
+
+	// Запись в каналы могла остаться у процессов, переживших сборку
+	done := make(chan struct{})
+	go func() {
+		d.copied.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+	}
+
+	close(d.stop)
+	<-d.stopped
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.clear()
+}
+
+// restore возвращает исходные stdout и stderr и закрывает концы каналов для записи
+func (d *Display) restore() {
+	os.Stdout, os.Stderr = d.stdout, d.stderr
+	for i := 1; i < len(d.pipes); i += 2 {
+		d.pipes[i].Close()
+	}
+}
+
+// Write печатает вывод сборки над строкой состояния
+func (d *Display) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.clear()
+	n, err := d.out.Write(p)
+	d.lineOpen = len(p) > 0 && p[len(p)-1] != '\n'
+	d.draw()
+	return n, err
+}
+
+// loop перерисовывает строку состояния, пока отображение не остановлено
+func (d *Display) loop() {
+	defer close(d.stopped)
+	ticker := time.NewTicker(redrawInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+			d.mu.Lock()
+			d.frame++
+			d.clear()
+			d.draw()
+			d.mu.Unlock()
+		}
+	}
+}
+
+// event обновляет состояние по событию сборки
+func (d *Display) event(e Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch e.Type {
+	case EventBuildPlanned:
+		d.total = e.Scripts
+	case EventStageStarted:
+		d.stage, d.stageDone, d.stageAll = e.Stage, 0, e.Scripts
+	case EventScriptStarted:
+		d.running = append(d.running, e.Script)
+	case EventScriptFinished, EventScriptSkipped:
+		for i, name := range d.running {
+			if name == e.Script {
+				d.running = append(d.running[:i], d.running[i+1:]...)
+				break
+			}
+		}
+		d.done++
+		d.stageDone++
+	case EventStageFinished:
+		d.running = nil
+		d.op = nil
+	}
+}
+
+// report обновляет фазу длительной операции; итог фазы печатается строкой
+func (d *Display) report(u Update) {
+	if u.Finished {
+		d.mu.Lock()
+		d.op = nil
+		d.mu.Unlock()
+		PrintReporter(d)(u)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.op = &u
+}
+
+// clear стирает строку состояния
+func (d *Display) clear() {
+	if d.shown {
+		io.WriteString(d.out, "\r\x1b[K")
+		d.shown = false
+	}
+}
+
+// draw выводит строку состояния, если курсор в начале строки
+func (d *Display) draw() {
+	if d.lineOpen {
+		return
+	}
+	line := d.status()
+	if width := terminalWidth(d.out); width > 0 {
+		line = truncate(line, width-1)
+	}
+	io.WriteString(d.out, line)
+	d.shown = true
+}
+
+// status формирует строку состояния
+func (d *Display) status() string {
+	var b strings.Builder
+	b.WriteString(spinnerFrames[d.frame%len(spinnerFrames)])
+	fmt.Fprintf(&b, " %s", formatElapsed(time.Since(d.started)))
+	if d.total > 0 {
+		fmt.Fprintf(&b, "  [%d/%d]", d.done, d.total)
+	}
+	if d.stage != "" {
+		fmt.Fprintf(&b, "  %s", d.stage)
+		if d.stageAll > 0 {
+			fmt.Fprintf(&b, " %s %d/%d", bar(float64(d.stageDone)*100/float64(d.stageAll)), d.stageDone, d.stageAll)
+		}
+	}
+	if len(d.running) > 0 {
+		fmt.Fprintf(&b, "  %s", strings.Join(d.running, ", "))
+	}
+	if d.op != nil {
+		fmt.Fprintf(&b, "  | %s", d.op.Phase)
+		if p := d.op.Percent(); p >= 0 {
+			fmt.Fprintf(&b, " %s %3.0f%%", bar(p), p)
+		} else if d.op.Done > 0 {
+			fmt.Fprintf(&b, " %s", FormatBytes(d.op.Done))
+		}
+		if d.op.ETA > 0 {
+			fmt.Fprintf(&b, " ETA %s", d.op.ETA.Round(time.Second))
+		}
+	}
+	return b.String()
+}
+
+// bar рисует полосу прогресса для процента p
+func bar(p float64) string {
+	filled := int(p * barWidth / 100)
+	filled = min(max(filled, 0), barWidth)
+	return "[" + strings.Repeat("█", filled) + strings.Repeat("░", barWidth-filled) + "]"
+}
+
+// formatElapsed форматирует время как M:SS или H:MM:SS
+func formatElapsed(d time.Duration) string {
+	s := int(d.Seconds())
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+// truncate обрезает строку до width символов
+func truncate(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+	if width < 1 {
+		return ""
+	}
+	return string(runes[:width-1]) + "…"
+}
+
+// terminalWidth возвращает ширину терминала или 0, если она неизвестна
+func terminalWidth(f *os.File) int {
+	var size struct {
+		rows, cols, xpixel, ypixel uint16
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&size)))
+	if errno != 0 {
+		return 0
+	}
+	return int(size.cols)
+}
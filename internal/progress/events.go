@@ -0,0 +1,226 @@
+package progress
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Шина событий сборки. Emit передает событие всем подписчикам (Subscribe):
+// выводу NDJSON (--progress json, SetEventWriter), строке состояния в
+// терминале (Display) и получателям событий библиотеки pkg/sysweaver.
+// В NDJSON каждое событие - JSON-объект на строке с полем type; поля, не
+// относящиеся к событию, опускаются. События output несут текст хода сборки
+// для человека и в NDJSON не выводятся.
+
+// Типы событий
+const (
+	EventBuildStarted   = "build_started"
+	EventBuildPlanned   = "build_planned"
+	EventBuildFinished  = "build_finished"
+	EventStageStarted   = "stage_started"
+	EventStageFinished  = "stage_finished"
+	EventScriptStarted  = "script_started"
+	EventScriptFinished = "script_finished"
+	EventScriptSkipped  = "script_skipped"
+	EventArtifact       = "artifact"
+	EventProgress       = "progress"
+	EventWarning        = "warning"
+	EventMountCreated   = "mount_created"
+	EventOutput         = "output"
+)
+
+// Event - событие сборки
+type Event struct {
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	Build    string    `json:"build,omitempty"`
+	Stage    string    `json:"stage,omitempty"`
+	Script   string    `json:"script,omitempty"`
+	Result   string    `json:"result,omitempty"`  // success или failed
+	Scripts  int       `json:"scripts,omitempty"` // Число скриптов сборки или стадии
+	Duration float64   `json:"duration_seconds,omitempty"`
+	ExitCode int       `json:"exit_code,omitempty"`
+	Attempts int       `json:"attempts,omitempty"`
+	Path     string    `json:"path,omitempty"`   // Артефакт, файл вывода скрипта или точка монтирования
+	Source   string    `json:"source,omitempty"` // Источник монтирования
+	FSType   string    `json:"fstype,omitempty"` // Тип ФС монтирования (bind для bind-монтирования)
+	Size     int64     `json:"size,omitempty"`   // Размер артефакта
+	Digests  []string  `json:"digests,omitempty"`
+	Phase    string    `json:"phase,omitempty"`   // Фаза длительной операции
+	Done     int64     `json:"done,omitempty"`    // Обработано байт
+	Total    int64     `json:"total,omitempty"`   // Всего байт
+	Percent  float64   `json:"percent,omitempty"` // Процент выполнения фазы
+	Message  string    `json:"message,omitempty"` // Предупреждение, причина пропуска или текст output
+	Error    string    `json:"error,omitempty"`
+}
+
+// subscriber - получатель событий
+type subscriber struct {
+	f func(Event)
+}
+
+var (
+	eventsMu    sync.Mutex
+	subscribers []*subscriber
+	queue       []Event // События, ожидающие доставки
+	delivering  bool    // Очередь разбирается одним из вызовов Emit
+
+	// Подписка вывода NDJSON из SetEventWriter
+	writerUnsubscribe func()
+)
+
+// Subscribe передает события функции f до вызова возвращаемой функции
+// отписки. События доставляются по одному в порядке Emit, получатели -
+// по очереди в порядке подписки, без блокировки шины: f может вызывать Emit
+// (событие встанет в очередь за текущим) и Subscribe.
+func Subscribe(f func(Event)) (unsubscribe func()) {
+	s := &subscriber{f: f}
+	eventsMu.Lock()
+	subscribers = append(subscribers, s)
+	eventsMu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			eventsMu.Lock()
+			defer eventsMu.Unlock()
+			subscribers = slices.DeleteFunc(subscribers, func(other *subscriber) bool { return other == s })
+		})
+	}
+}
+
+// SetEventWriter включает вывод событий в w в формате NDJSON; nil выключает его.
+// События без ID сборки (прогресс длительных операций из EventReporter)
+// получают ID сборки, выводившей события последней.
+func SetEventWriter(w io.Writer) {
+	if writerUnsubscribe != nil {
+		writerUnsubscribe()
+		writerUnsubscribe = nil
+	}
+	if w != nil {
+		encoder := json.NewEncoder(w)
+		var build string
+		writerUnsubscribe = Subscribe(func(e Event) {
+			if e.Type == EventOutput {
+				return
+			}
+			if e.Build == "" {
+				e.Build = build
+			} else {
+				build = e.Build
+			}
+			encoder.Encode(e)
+		})
+	}
+}
+
+// EventsEnabled сообщает, выводятся ли события
+func EventsEnabled() bool {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	return len(subscribers) > 0
+}
+
+// Emit передает событие подписчикам шины. Событие встает в очередь; ее
+// разбирает вызов Emit, заставший шину свободной, вызывая подписчиков вне
+// блокировки, поэтому события из подписчиков и других горутин доставляются
+// после текущего, а не внутри него.
+func Emit(e Event) {
+	eventsMu.Lock()
+	if len(subscribers) == 0 {
+		eventsMu.Unlock()
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	queue = append(queue, e)
+	if delivering {
+		eventsMu.Unlock()
+		return
+	}
+
+	delivering = true
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+		current := slices.Clone(subscribers)
+		eventsMu.Unlock()
+		for _, s := range current {
+			s.f(next)
+		}
+		eventsMu.Lock()
+	}
+	delivering = false
+	eventsMu.Unlock()
+}
+
+// EventReporter передает обновления прогресса длительных операций событиями progress
+func EventReporter(u Update) {
+	if u.Started {
+		return
+	}
+	e := Event{Type: EventProgress, Phase: u.Phase, Done: u.Done, Total: u.Total}
+	if p := u.Percent(); p >= 0 {
+		e.Percent = p
+	}
+	if u.Finished {
+		e.Result = "success"
+	}
+	Emit(e)
+}
+
+// WarningEvents оборачивает обработчик журнала: записи уровня warn и выше
+// дополнительно передаются функции emit событиями warning
+func WarningEvents(h slog.Handler, emit func(Event)) slog.Handler {
+	return warningHandler{Handler: h, emit: emit}
+}
+
+type warningHandler struct {
+	slog.Handler
+	emit    func(Event)
+	context []slog.Attr // Поля из With (build, script)
+}
+
+func (h warningHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= slog.LevelWarn || h.Handler.Enabled(ctx, level)
+}
+
+func (h warningHandler) Handle(ctx context.Context, record slog.Record) error {
+	if record.Level >= slog.LevelWarn {
+		e := Event{Type: EventWarning, Time: record.Time.UTC(), Message: record.Message}
+		set := func(attr slog.Attr) bool {
+			switch attr.Key {
+			case "error":
+				e.Error = attr.Value.String()
+			case "script":
+				e.Script = attr.Value.String()
+			case "stage":
+				e.Stage = attr.Value.String()
+			}
+			return true
+		}
+		for _, attr := range h.context {
+			set(attr)
+		}
+		record.Attrs(set)
+		h.emit(e)
+	}
+	if !h.Handler.Enabled(ctx, record.Level) {
+		return nil
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h warningHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return warningHandler{Handler: h.Handler.WithAttrs(attrs), emit: h.emit, context: append(slices.Clone(h.context), attrs...)}
+}
+
+func (h warningHandler) WithGroup(name string) slog.Handler {
+	return warningHandler{Handler: h.Handler.WithGroup(name), emit: h.emit, context: h.context}
+}
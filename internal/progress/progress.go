@@ -0,0 +1,237 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Update - состояние длительной операции
+type Update struct {
+	Phase    string        // Название фазы (dd, populate, mkfs, compress, convert, ...)
+	Done     int64         // Обработано байт
+	Total    int64         // Всего байт (0 - неизвестно)
+	Rate     float64       // Скорость, байт/с
+	ETA      time.Duration // Оценка оставшегося времени (0 - неизвестно)
+	Started  bool          // Фаза начата (первое обновление, без данных)
+	Finished bool          // Фаза завершена
+}
+
+// Percent возвращает процент выполнения или -1, если общий объем неизвестен
+func (u Update) Percent() float64 {
+	if u.Total <= 0 {
+		return -1
+	}
+	p := float64(u.Done) * 100 / float64(u.Total)
+	if p > 100 {
+		p = 100
+	}
+	return p
+}
+
+// Reporter получает обновления прогресса
+type Reporter func(Update)
+
+// Получатель по умолчанию не задан: библиотека не пишет прогресс в stdout,
+// получателя устанавливает приложение (SetReporter)
+var (
+	reporterMu sync.RWMutex
+	reporter   Reporter
+)
+
+// SetReporter заменяет получатель обновлений прогресса для всех операций;
+// nil - обновления отбрасываются
+func SetReporter(r Reporter) {
+	reporterMu.Lock()
+	defer reporterMu.Unlock()
+	reporter = r
+}
+
+// currentReporter возвращает текущий получатель обновлений
+func currentReporter() Reporter {
+	reporterMu.RLock()
+	defer reporterMu.RUnlock()
+	return reporter
+}
+
+// report передает обновление текущему получателю
+func report(u Update) {
+	if r := currentReporter(); r != nil {
+		r(u)
+	}
+}
+
+// PrintReporter выводит обновления прогресса строками в writer
+func PrintReporter(w io.Writer) Reporter {
+	return func(u Update) {
+		if u.Started {
+			return
+		}
+		line := fmt.Sprintf("  %s: %s", u.Phase, FormatBytes(u.Done))
+		if p := u.Percent(); p >= 0 {
+			line = fmt.Sprintf("  %s: %5.1f%% (%s / %s)", u.Phase, p, FormatBytes(u.Done), FormatBytes(u.Total))
+		}
+		if u.Rate > 0 {
+			line += fmt.Sprintf(", %s/s", FormatBytes(int64(u.Rate)))
+		}
+		if u.ETA > 0 && !u.Finished {
+			line += fmt.Sprintf(", ETA %s", u.ETA.Round(time.Second))
+		}
+		if u.Finished {
+			line += ", done"
+		}
+		fmt.Fprintln(w, line)
+	}
+}
+
+// FormatBytes форматирует размер в двоичных единицах
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// Интервал между обновлениями прогресса
+const interval = 2 * time.Second
+
+// tracker накапливает счетчик байт и отправляет обновления не чаще interval
+type tracker struct {
+	phase    string
+	total    int64
+	started  time.Time
+	lastSent time.Time
+}
+
+func newTracker(phase string, total int64) *tracker {
+	now := time.Now()
+	report(Update{Phase: phase, Total: total, Started: true})
+	return &tracker{phase: phase, total: total, started: now, lastSent: now}
+}
+
+// update отправляет обновление, если прошло достаточно времени или фаза завершена
+func (t *tracker) update(done int64, finished bool) {
+	now := time.Now()
+	if !finished && now.Sub(t.lastSent) < interval {
+		return
+	}
+	t.lastSent = now
+
+	u := Update{Phase: t.phase, Done: done, Total: t.total, Finished: finished}
+	if elapsed := now.Sub(t.started).Seconds(); elapsed > 0 {
+		u.Rate = float64(done) / elapsed
+	}
+	if u.Rate > 0 && t.total > done {
+		u.ETA = time.Duration(float64(t.total-done) / u.Rate * float64(time.Second))
+	}
+
+	report(u)
+}
+
+// Writer считает записанные байты и сообщает о прогрессе
+type Writer struct {
+	w       io.Writer
+	done    int64
+	tracker *tracker
+}
+
+// NewWriter оборачивает writer; total - ожидаемый объем (0 - неизвестен)
+func NewWriter(w io.Writer, phase string, total int64) *Writer {
+	return &Writer{w: w, tracker: newTracker(phase, total)}
+}
+
+func (pw *Writer) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.done += int64(n)
+	pw.tracker.update(pw.done, false)
+	return n, err
+}
+
+// Add учитывает n байт, переданных в обход Write (например, внешней утилитой)
+func (pw *Writer) Add(n int64) {
+	pw.done += n
+	pw.tracker.update(pw.done, false)
+}
+
+// Finish отправляет итоговое обновление фазы
+func (pw *Writer) Finish() {
+	pw.tracker.update(pw.done, true)
+}
+
+// Run запускает внешнюю команду и сообщает о прогрессе по /proc/<pid>/io.
+// Если reads - считаются прочитанные байты (конвертация, копирование),
+// иначе записанные (mkfs, dd).
+func Run(cmd *exec.Cmd, phase string, total int64, reads bool) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	t := newTracker(phase, total)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	var last int64
+
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if n, ok := processIO(cmd.Process.Pid, reads); ok {
+					last = n
+					t.update(n, false)
+				}
+			}
+		}
+	}()
+
+	err := cmd.Wait()
+	close(done)
+	<-stopped
+
+	if err == nil {
+		if total > 0 {
+			last = total
+		}
+		t.update(last, true)
+	}
+
+	return err
+}
+
+// processIO читает счетчик ввода-вывода процесса из /proc/<pid>/io
+func processIO(pid int, reads bool) (int64, bool) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/io", pid))
+	if err != nil {
+		return 0, false
+	}
+
+	// rchar/wchar учитывают и чтение из page cache, в отличие от read_bytes
+	key := "wchar:"
+	if reads {
+		key = "rchar:"
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, key) {
+			n, err := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(line, key)), 10, 64)
+			return n, err == nil
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,157 @@
+package publish
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"sysweaver/internal/structures"
+)
+
+// publishAWS загружает raw-образ в S3, импортирует его как снимок EBS,
+// регистрирует AMI и помечает ее тегами с именем и версией сборки
+func publishAWS(cfg structures.AWSPublish, opts Options) (Result, error) {
+	if cfg.Bucket == "" {
+		return Result{}, fmt.Errorf("bucket is required")
+	}
+	if cfg.Name == "" {
+		cfg.Name = imageName(opts.Config)
+	}
+	if cfg.Architecture == "" {
+		cfg.Architecture = "x86_64"
+	}
+	if cfg.BootMode == "" {
+		cfg.BootMode = "uefi"
+	}
+
+	source, err := findImage(cfg.Source, opts.OutputDir)
+	if err != nil {
+		return Result{}, err
+	}
+
+	aws := awsCLI{tool{name: "aws", logWriter: opts.LogWriter}}
+	if cfg.Region != "" {
+		aws.common = append(aws.common, "--region", cfg.Region)
+	}
+	if cfg.Profile != "" {
+		aws.common = append(aws.common, "--profile", cfg.Profile)
+	}
+	key := path.Join(cfg.Prefix, cfg.Name+".raw")
+	object := fmt.Sprintf("s3://%s/%s", cfg.Bucket, key)
+
+	// 1. Загрузка образа в S3
+	fmt.Fprintf(opts.Out, "Uploading %s to %s\n", filepath.Base(source), object)
+	if err := aws.runProgress("upload s3", source, "s3", "cp", "--only-show-errors", source, object); err != nil {
+		return Result{}, fmt.Errorf("error uploading to %s: %w", object, err)
+	}
+	if !cfg.KeepObject {
+		defer func() {
+			if _, err := aws.run("s3", "rm", object); err != nil {
+				opts.Logger.Warn("error removing uploaded image", "object", object, "error", err)
+			}
+		}()
+	}
+
+	// 2. Импорт снимка EBS
+	fmt.Fprintln(opts.Out, "Importing snapshot from S3...")
+	var task struct {
+		ImportTaskId string
+	}
+	err = aws.runJSON(&task, "ec2", "import-snapshot",
+		"--description", cfg.Name,
+		"--disk-container", fmt.Sprintf("Format=RAW,UserBucket={S3Bucket=%s,S3Key=%s}", cfg.Bucket, key))
+	if err != nil {
+		return Result{}, fmt.Errorf("error starting snapshot import: %w", err)
+	}
+
+	snapshotID, err := aws.waitSnapshotImport(task.ImportTaskId, opts.Out)
+	if err != nil {
+		return Result{}, err
+	}
+	fmt.Fprintf(opts.Out, "Snapshot imported: %s\n", snapshotID)
+
+	// 3. Регистрация AMI
+	var image struct {
+		ImageId string
+	}
+	err = aws.runJSON(&image, "ec2", "register-image",
+		"--name", cfg.Name,
+		"--description", fmt.Sprintf("%s %s built by SysWeaver", opts.Config.Name, opts.Config.Version),
+		"--architecture", cfg.Architecture,
+		"--boot-mode", cfg.BootMode,
+		"--virtualization-type", "hvm",
+		"--ena-support",
+		"--root-device-name", "/dev/xvda",
+		"--block-device-mappings", fmt.Sprintf("DeviceName=/dev/xvda,Ebs={SnapshotId=%s,DeleteOnTermination=true}", snapshotID))
+	if err != nil {
+		return Result{}, fmt.Errorf("error registering AMI: %w", err)
+	}
+
+	// 4. Теги AMI и снимка
+	tags := map[string]string{
+		"Name":    cfg.Name,
+		"Version": opts.Config.Version,
+		"Build":   opts.Config.Name,
+	}
+	for k, v := range cfg.Tags {
+		tags[k] = v
+	}
+	args := []string{"ec2", "create-tags", "--resources", image.ImageId, snapshotID, "--tags"}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		args = append(args, fmt.Sprintf("Key=%s,Value=%s", k, tags[k]))
+	}
+	if _, err := aws.run(args...); err != nil {
+		return Result{}, fmt.Errorf("error tagging AMI: %w", err)
+	}
+
+	fmt.Fprintf(opts.Out, "AMI registered: %s\n", image.ImageId)
+	return Result{ID: image.ImageId}, nil
+}
+
+// awsCLI - утилита aws с общими параметрами региона и профиля
+type awsCLI struct {
+	tool
+}
+
+// waitSnapshotImport ожидает завершения импорта и возвращает ID снимка
+func (a awsCLI) waitSnapshotImport(taskID string, out io.Writer) (string, error) {
+	for {
+		var result struct {
+			ImportSnapshotTasks []struct {
+				SnapshotTaskDetail struct {
+					Status        string
+					StatusMessage string
+					Progress      string
+					SnapshotId    string
+				}
+			}
+		}
+		if err := a.runJSON(&result, "ec2", "describe-import-snapshot-tasks", "--import-task-ids", taskID); err != nil {
+			return "", fmt.Errorf("error checking snapshot import: %w", err)
+		}
+		if len(result.ImportSnapshotTasks) == 0 {
+			return "", fmt.Errorf("snapshot import task %s not found", taskID)
+		}
+
+		detail := result.ImportSnapshotTasks[0].SnapshotTaskDetail
+		switch detail.Status {
+		case "completed":
+			return detail.SnapshotId, nil
+		case "deleted", "deleting":
+			return "", fmt.Errorf("snapshot import %s failed: %s", taskID, detail.StatusMessage)
+		}
+
+		if detail.Progress != "" {
+			fmt.Fprintf(out, "  import %s: %s%% (%s)\n", taskID, detail.Progress, detail.StatusMessage)
+		}
+		time.Sleep(15 * time.Second)
+	}
+}
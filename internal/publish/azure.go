@@ -0,0 +1,140 @@
+package publish
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"sysweaver/internal/structures"
+)
+
+// Размеры VHD в Azure должны быть кратны 1 MiB
+const azureAlignment = 1024 * 1024
+
+// publishAzure конвертирует raw-образ в fixed VHD, загружает его страничным
+// blob в Blob Storage и создает управляемый образ
+func publishAzure(cfg structures.AzurePublish, opts Options) (Result, error) {
+	if cfg.ResourceGroup == "" || cfg.StorageAccount == "" || cfg.Container == "" {
+		return Result{}, fmt.Errorf("resource_group, storage_account and container are required")
+	}
+	if cfg.Name == "" {
+		cfg.Name = imageName(opts.Config)
+	}
+	if cfg.HyperVGeneration == "" {
+		cfg.HyperVGeneration = "V2"
+	}
+	if cfg.ClientSecretEnv == "" {
+		cfg.ClientSecretEnv = "AZURE_CLIENT_SECRET"
+	}
+
+	source, err := findImage(cfg.Source, opts.OutputDir)
+	if err != nil {
+		return Result{}, err
+	}
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return Result{}, err
+	}
+	if info.Size()%azureAlignment != 0 {
+		return Result{}, fmt.Errorf("image size %d is not a multiple of 1 MiB, required for Azure VHD", info.Size())
+	}
+
+	// force_size сохраняет точный размер диска без округления по геометрии CHS
+	vhd := filepath.Join(opts.OutputDir, cfg.Name+".vhd")
+	fmt.Fprintf(opts.Out, "Converting %s to fixed VHD %s\n", filepath.Base(source), filepath.Base(vhd))
+	if err := convertImage(source, vhd, "vpc", []string{"subformat=fixed", "force_size=on"}, opts.LogWriter); err != nil {
+		return Result{}, err
+	}
+
+	az := tool{name: "az", logWriter: opts.LogWriter}
+	if cfg.ClientID != "" {
+		// Отдельная конфигурация az, чтобы вход принципала не затрагивал сессию пользователя
+		configDir, err := os.MkdirTemp("", "sysweaver-az-")
+		if err != nil {
+			return Result{}, fmt.Errorf("error creating temporary directory: %w", err)
+		}
+		defer os.RemoveAll(configDir)
+		az.env = append(az.env, "AZURE_CONFIG_DIR="+configDir)
+
+		secret := os.Getenv(cfg.ClientSecretEnv)
+		if secret == "" {
+			return Result{}, fmt.Errorf("client secret not set: export %s", cfg.ClientSecretEnv)
+		}
+		if _, err := az.run("login", "--service-principal", "--username", cfg.ClientID, "--password", secret, "--tenant", cfg.TenantID); err != nil {
+			return Result{}, fmt.Errorf("error logging in to Azure: %w", err)
+		}
+	}
+	if cfg.Subscription != "" {
+		az.common = append(az.common, "--subscription", cfg.Subscription)
+	}
+
+	blob := cfg.Name + ".vhd"
+	blobURL := fmt.Sprintf("https://%s.blob.core.windows.net/%s/%s", cfg.StorageAccount, cfg.Container, blob)
+	fmt.Fprintf(opts.Out, "Uploading %s to %s\n", filepath.Base(vhd), blobURL)
+	err = az.runProgress("upload blob", vhd, "storage", "blob", "upload",
+		"--account-name", cfg.StorageAccount,
+		"--container-name", cfg.Container,
+		"--name", blob,
+		"--file", vhd,
+		"--type", "page",
+		"--auth-mode", "login",
+		"--overwrite",
+		"--only-show-errors")
+	if err != nil {
+		return Result{}, fmt.Errorf("error uploading to %s: %w", blobURL, err)
+	}
+	if !cfg.KeepObject {
+		defer func() {
+			_, err := az.run("storage", "blob", "delete",
+				"--account-name", cfg.StorageAccount, "--container-name", cfg.Container,
+				"--name", blob, "--auth-mode", "login")
+			if err != nil {
+				opts.Logger.Warn("error removing uploaded image", "blob", blobURL, "error", err)
+			}
+		}()
+	}
+
+	// Образ с тем же именем заменяется; ошибка означает, что его не было
+	az.run("image", "delete", "--resource-group", cfg.ResourceGroup, "--name", cfg.Name)
+
+	tags := map[string]string{
+		"build":   opts.Config.Name,
+		"version": opts.Config.Version,
+	}
+	for k, v := range cfg.Tags {
+		tags[k] = v
+	}
+
+	args := []string{"image", "create",
+		"--resource-group", cfg.ResourceGroup,
+		"--name", cfg.Name,
+		"--source", blobURL,
+		"--os-type", "Linux",
+		"--hyper-v-generation", cfg.HyperVGeneration,
+	}
+	if cfg.Location != "" {
+		args = append(args, "--location", cfg.Location)
+	}
+	args = append(args, "--tags")
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		args = append(args, k+"="+tags[k])
+	}
+
+	fmt.Fprintf(opts.Out, "Creating Azure image %s\n", cfg.Name)
+	var image struct {
+		ID string `json:"id"`
+	}
+	if err := az.runJSON(&image, args...); err != nil {
+		return Result{}, fmt.Errorf("error creating image: %w", err)
+	}
+
+	fmt.Fprintf(opts.Out, "Azure image created: %s\n", image.ID)
+	return Result{ID: image.ID, Artifacts: []string{vhd}}, nil
+}
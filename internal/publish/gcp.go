@@ -0,0 +1,148 @@
+package publish
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"sysweaver/internal/progress"
+	"sysweaver/internal/structures"
+)
+
+// publishGCP упаковывает raw-образ в архив GCE (disk.raw в tar.gz),
+// загружает его в Cloud Storage и создает образ Compute Engine
+func publishGCP(cfg structures.GCPPublish, opts Options) (Result, error) {
+	if cfg.Project == "" || cfg.Bucket == "" {
+		return Result{}, fmt.Errorf("project and bucket are required")
+	}
+	if cfg.Name == "" {
+		cfg.Name = imageName(opts.Config)
+	}
+	if len(cfg.GuestOSFeatures) == 0 {
+		cfg.GuestOSFeatures = []string{"UEFI_COMPATIBLE", "VIRTIO_SCSI_MULTIQUEUE", "GVNIC"}
+	}
+
+	source, err := findImage(cfg.Source, opts.OutputDir)
+	if err != nil {
+		return Result{}, err
+	}
+
+	archive := filepath.Join(opts.OutputDir, cfg.Name+".gce.tar.gz")
+	if err := gceArchive(source, archive, opts); err != nil {
+		return Result{}, err
+	}
+
+	gcloud := tool{name: "gcloud", common: []string{"--project", cfg.Project, "--quiet"}, logWriter: opts.LogWriter}
+	if cfg.CredentialsFile != "" {
+		// Ключ сервисного аккаунта только для этих вызовов, без изменения конфигурации gcloud
+		gcloud.env = append(gcloud.env, "CLOUDSDK_AUTH_CREDENTIAL_FILE_OVERRIDE="+cfg.CredentialsFile)
+	}
+
+	object := fmt.Sprintf("gs://%s/%s", cfg.Bucket, path.Join(cfg.Prefix, filepath.Base(archive)))
+	fmt.Fprintf(opts.Out, "Uploading %s to %s\n", filepath.Base(archive), object)
+	if err := gcloud.runProgress("upload gcs", archive, "storage", "cp", archive, object); err != nil {
+		return Result{}, fmt.Errorf("error uploading to %s: %w", object, err)
+	}
+	if !cfg.KeepObject {
+		defer func() {
+			if _, err := gcloud.run("storage", "rm", object); err != nil {
+				opts.Logger.Warn("error removing uploaded image", "object", object, "error", err)
+			}
+		}()
+	}
+
+	labels := map[string]string{
+		"build":   opts.Config.Name,
+		"version": opts.Config.Version,
+	}
+	for k, v := range cfg.Labels {
+		labels[k] = v
+	}
+
+	args := []string{"compute", "images", "create", cfg.Name,
+		"--source-uri", object,
+		"--guest-os-features", strings.Join(cfg.GuestOSFeatures, ","),
+		"--labels", gcpLabels(labels),
+	}
+	if cfg.Family != "" {
+		args = append(args, "--family", cfg.Family)
+	}
+
+	fmt.Fprintf(opts.Out, "Creating Compute Engine image %s\n", cfg.Name)
+	var images []struct {
+		Name     string `json:"name"`
+		SelfLink string `json:"selfLink"`
+	}
+	if err := gcloud.runJSON(&images, args...); err != nil {
+		return Result{}, fmt.Errorf("error creating image: %w", err)
+	}
+
+	id := cfg.Name
+	if len(images) > 0 && images[0].SelfLink != "" {
+		id = images[0].SelfLink
+	}
+
+	fmt.Fprintf(opts.Out, "GCE image created: %s\n", id)
+	return Result{ID: id, Artifacts: []string{archive}}, nil
+}
+
+// gceArchive упаковывает образ в tar.gz с единственным файлом disk.raw
+// в формате oldgnu с разреженными файлами, как требует Compute Engine
+func gceArchive(source, archive string, opts Options) error {
+	tmpDir, err := os.MkdirTemp(filepath.Dir(archive), ".gce-")
+	if err != nil {
+		return fmt.Errorf("error creating temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Жесткая ссылка вместо копии; при другой ФС - разреженная копия
+	disk := filepath.Join(tmpDir, "disk.raw")
+	if err := os.Link(source, disk); err != nil {
+		cp := exec.Command("cp", "--sparse=always", source, disk)
+		cp.Stdout = opts.LogWriter
+		cp.Stderr = opts.LogWriter
+		if err := cp.Run(); err != nil {
+			return fmt.Errorf("error preparing disk.raw: %w", err)
+		}
+	}
+
+	info, err := os.Stat(disk)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(opts.Out, "Packing %s as GCE archive %s\n", filepath.Base(source), filepath.Base(archive))
+	cmd := exec.Command("tar", "--format=oldgnu", "-S", "-czf", archive, "-C", tmpDir, "disk.raw")
+	cmd.Stdout = opts.LogWriter
+	cmd.Stderr = opts.LogWriter
+
+	if err := progress.Run(cmd, "compress gce", info.Size(), true); err != nil {
+		return fmt.Errorf("error packing GCE archive: %w", err)
+	}
+
+	return nil
+}
+
+// gcpLabels форматирует метки; значения приводятся к допустимому в GCP виду
+func gcpLabels(labels map[string]string) string {
+	sanitize := strings.NewReplacer(".", "-", " ", "-", "/", "-", ":", "-")
+
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, k := range keys {
+		if labels[k] == "" {
+			continue
+		}
+		pairs = append(pairs, strings.ToLower(k)+"="+strings.ToLower(sanitize.Replace(labels[k])))
+	}
+	return strings.Join(pairs, ",")
+}
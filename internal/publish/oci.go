@@ -0,0 +1,160 @@
+package publish
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"sysweaver/internal/structures"
+)
+
+// Артефакты, загружаемые в реестр по умолчанию
+var defaultOCIArtifacts = []string{"*.iso", "*.img", "*.raw", "*.qcow2"}
+
+// Типы медиа слоев по расширению файла
+var ociMediaTypes = map[string]string{
+	".iso":   "application/vnd.sysweaver.image.iso",
+	".img":   "application/vnd.sysweaver.image.raw",
+	".raw":   "application/vnd.sysweaver.image.raw",
+	".qcow2": "application/vnd.sysweaver.image.qcow2",
+	".vmdk":  "application/vnd.sysweaver.image.vmdk",
+	".vhdx":  "application/vnd.sysweaver.image.vhdx",
+	".vdi":   "application/vnd.sysweaver.image.vdi",
+}
+
+// publishOCI загружает артефакты в OCI-реестр утилитой oras и подписывает
+// полученный манифест cosign
+func publishOCI(cfg structures.OCIPublish, opts Options) (Result, error) {
+	if cfg.Repository == "" {
+		return Result{}, fmt.Errorf("repository is required")
+	}
+	if cfg.Tag == "" {
+		cfg.Tag = opts.Config.Version
+	}
+	if cfg.Tag == "" {
+		cfg.Tag = "latest"
+	}
+	if cfg.ArtifactType == "" {
+		cfg.ArtifactType = "application/vnd.sysweaver.image.v1"
+	}
+	if len(cfg.Artifacts) == 0 {
+		cfg.Artifacts = defaultOCIArtifacts
+	}
+
+	files, err := matchArtifacts(opts.OutputDir, cfg.Artifacts)
+	if err != nil {
+		return Result{}, err
+	}
+
+	// Пути передаются относительно директории вывода: oras сохраняет их как имена файлов
+	oras := tool{name: "oras", dir: opts.OutputDir, logWriter: opts.LogWriter}
+	reference := cfg.Repository + ":" + cfg.Tag
+
+	args := []string{"push", reference, "--artifact-type", cfg.ArtifactType}
+	if cfg.Username != "" {
+		password := os.Getenv(cfg.PasswordEnv)
+		if password == "" {
+			return Result{}, fmt.Errorf("registry password not set: export %s", cfg.PasswordEnv)
+		}
+		args = append(args, "--username", cfg.Username, "--password-stdin")
+		oras.stdin = password
+	}
+
+	annotations := map[string]string{
+		"org.opencontainers.image.title":   opts.Config.Name,
+		"org.opencontainers.image.version": opts.Config.Version,
+	}
+	for k, v := range cfg.Annotations {
+		annotations[k] = v
+	}
+	keys := make([]string, 0, len(annotations))
+	for k := range annotations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if annotations[k] != "" {
+			args = append(args, "--annotation", k+"="+annotations[k])
+		}
+	}
+
+	for _, file := range files {
+		mediaType, ok := ociMediaTypes[filepath.Ext(file)]
+		if !ok {
+			mediaType = "application/octet-stream"
+		}
+		args = append(args, file+":"+mediaType)
+	}
+
+	fmt.Fprintf(opts.Out, "Pushing %s to %s\n", strings.Join(files, ", "), reference)
+	out, err := oras.run(args...)
+	if err != nil {
+		return Result{}, fmt.Errorf("error pushing artifacts: %w", err)
+	}
+	fmt.Fprint(opts.LogWriter, string(out))
+
+	manifestDigest := parseORASDigest(string(out))
+	if manifestDigest == "" {
+		return Result{}, fmt.Errorf("oras did not report the manifest digest")
+	}
+
+	// Подписываем по дайджесту, чтобы подпись не зависела от перемещаемого тега
+	pinned := cfg.Repository + "@" + manifestDigest
+	if cfg.Sign {
+		cosign := tool{name: "cosign", logWriter: opts.LogWriter}
+		args := []string{"sign", "--yes"}
+		if cfg.Key != "" {
+			args = append(args, "--key", cfg.Key)
+			fmt.Fprintf(opts.Out, "Signing %s with key %s\n", pinned, cfg.Key)
+		} else {
+			fmt.Fprintf(opts.Out, "Signing %s (keyless)\n", pinned)
+		}
+		args = append(args, pinned)
+
+		if _, err := cosign.run(args...); err != nil {
+			return Result{}, fmt.Errorf("error signing artifact: %w", err)
+		}
+	}
+
+	fmt.Fprintf(opts.Out, "Published %s\n", pinned)
+	return Result{ID: pinned}, nil
+}
+
+// matchArtifacts возвращает имена файлов директории, подходящих под шаблоны
+func matchArtifacts(dir string, patterns []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var files []string
+
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid artifact pattern %q: %w", pattern, err)
+		}
+		for _, match := range matches {
+			name := filepath.Base(match)
+			if !seen[name] {
+				seen[name] = true
+				files = append(files, name)
+			}
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no artifacts matching %s in %s", strings.Join(patterns, ", "), dir)
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// parseORASDigest извлекает дайджест манифеста из вывода oras push
+func parseORASDigest(output string) string {
+	for _, line := range strings.Split(output, "\n") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(line), "Digest:"); ok {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
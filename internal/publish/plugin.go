@@ -0,0 +1,29 @@
+package publish
+
+import (
+	"sysweaver/internal/plugin"
+	"sysweaver/internal/structures"
+)
+
+// publishPlugin публикует артефакты внешним плагином sysweaver-publish-<name>
+func publishPlugin(cfg structures.PluginPublish, opts Options) (Result, error) {
+	p, err := plugin.Find(opts.PluginDirs, plugin.KindPublish, cfg.Name)
+	if err != nil {
+		return Result{}, err
+	}
+
+	result, err := p.Run(plugin.Request{
+		Options: cfg.Options,
+		Build: plugin.Build{
+			Name:    opts.Config.Name,
+			Version: opts.Config.Version,
+			Arch:    opts.Config.Arch,
+		},
+		OutputDir: opts.OutputDir,
+		Artifacts: opts.Artifacts,
+	}, opts.LogWriter)
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{ID: result.ID, Artifacts: result.Artifacts}, nil
+}
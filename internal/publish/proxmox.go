@@ -0,0 +1,320 @@
+package publish
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"sysweaver/internal/progress"
+	"sysweaver/internal/structures"
+)
+
+// publishProxmox конвертирует образ в qcow2, загружает его в хранилище Proxmox
+// и создает (или пересоздает) шаблон ВМ с заданным VMID
+func publishProxmox(cfg structures.ProxmoxPublish, opts Options) (Result, error) {
+	if cfg.URL == "" || cfg.Node == "" || cfg.VMID == 0 {
+		return Result{}, fmt.Errorf("url, node and vmid are required")
+	}
+	if cfg.Storage == "" {
+		cfg.Storage = "local"
+	}
+	if cfg.DiskStorage == "" {
+		cfg.DiskStorage = "local-lvm"
+	}
+	if cfg.TokenEnv == "" {
+		cfg.TokenEnv = "PROXMOX_API_TOKEN"
+	}
+	if cfg.Name == "" {
+		cfg.Name = imageName(opts.Config)
+	}
+	if cfg.Cores == 0 {
+		cfg.Cores = 1
+	}
+	if cfg.Memory == 0 {
+		cfg.Memory = 512
+	}
+	if cfg.Bridge == "" {
+		cfg.Bridge = "vmbr0"
+	}
+
+	token := os.Getenv(cfg.TokenEnv)
+	if token == "" {
+		return Result{}, fmt.Errorf("API token not set: export %s=USER@REALM!TOKENID=SECRET", cfg.TokenEnv)
+	}
+
+	source, err := findImage(cfg.Source, opts.OutputDir)
+	if err != nil {
+		return Result{}, err
+	}
+
+	// Proxmox импортирует диски из хранилища с типом содержимого import
+	tmpDir, err := os.MkdirTemp("", "sysweaver-proxmox-")
+	if err != nil {
+		return Result{}, fmt.Errorf("error creating temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	fileName := cfg.Name + ".qcow2"
+	qcow2 := filepath.Join(tmpDir, fileName)
+	fmt.Fprintf(opts.Out, "Converting %s to qcow2 for Proxmox\n", filepath.Base(source))
+	if err := convertImage(source, qcow2, "qcow2", nil, opts.LogWriter); err != nil {
+		return Result{}, err
+	}
+
+	client := newProxmoxClient(cfg, token)
+
+	// Существующая ВМ с тем же VMID заменяется
+	exists, err := client.vmExists(cfg.VMID)
+	if err != nil {
+		return Result{}, err
+	}
+	if exists {
+		fmt.Fprintf(opts.Out, "Removing existing VM %d\n", cfg.VMID)
+		upid, err := client.request(http.MethodDelete, fmt.Sprintf("/qemu/%d", cfg.VMID), url.Values{
+			"purge":                      {"1"},
+			"destroy-unreferenced-disks": {"1"},
+		})
+		if err != nil {
+			return Result{}, fmt.Errorf("error removing VM %d: %w", cfg.VMID, err)
+		}
+		if err := client.waitTask(upid); err != nil {
+			return Result{}, fmt.Errorf("error removing VM %d: %w", cfg.VMID, err)
+		}
+	}
+
+	fmt.Fprintf(opts.Out, "Uploading %s to storage %s on %s\n", fileName, cfg.Storage, cfg.Node)
+	upid, err := client.upload(cfg.Storage, qcow2)
+	if err != nil {
+		return Result{}, fmt.Errorf("error uploading image: %w", err)
+	}
+	if err := client.waitTask(upid); err != nil {
+		return Result{}, fmt.Errorf("error uploading image: %w", err)
+	}
+
+	volume := fmt.Sprintf("%s:import/%s", cfg.Storage, fileName)
+	defer func() {
+		// Загруженный образ больше не нужен: диск импортирован в disk_storage
+		if _, err := client.request(http.MethodDelete, fmt.Sprintf("/storage/%s/content/%s", cfg.Storage, url.PathEscape(volume)), nil); err != nil {
+			opts.Logger.Warn("error removing uploaded image", "volume", volume, "error", err)
+		}
+	}()
+
+	fmt.Fprintf(opts.Out, "Creating VM %d (%s)\n", cfg.VMID, cfg.Name)
+	upid, err = client.request(http.MethodPost, "/qemu", url.Values{
+		"vmid":    {strconv.Itoa(cfg.VMID)},
+		"name":    {cfg.Name},
+		"cores":   {strconv.Itoa(cfg.Cores)},
+		"memory":  {strconv.Itoa(cfg.Memory)},
+		"ostype":  {"l26"},
+		"scsihw":  {"virtio-scsi-single"},
+		"scsi0":   {fmt.Sprintf("%s:0,import-from=%s", cfg.DiskStorage, volume)},
+		"net0":    {"virtio,bridge=" + cfg.Bridge},
+		"boot":    {"order=scsi0"},
+		"serial0": {"socket"},
+	})
+	if err != nil {
+		return Result{}, fmt.Errorf("error creating VM: %w", err)
+	}
+	if err := client.waitTask(upid); err != nil {
+		return Result{}, fmt.Errorf("error creating VM: %w", err)
+	}
+
+	upid, err = client.request(http.MethodPost, fmt.Sprintf("/qemu/%d/template", cfg.VMID), nil)
+	if err != nil {
+		return Result{}, fmt.Errorf("error converting VM to template: %w", err)
+	}
+	if upid != "" {
+		if err := client.waitTask(upid); err != nil {
+			return Result{}, fmt.Errorf("error converting VM to template: %w", err)
+		}
+	}
+
+	fmt.Fprintf(opts.Out, "Proxmox template %d (%s) is ready on %s\n", cfg.VMID, cfg.Name, cfg.Node)
+	return Result{ID: strconv.Itoa(cfg.VMID)}, nil
+}
+
+// proxmoxClient - клиент API Proxmox VE для одного узла
+type proxmoxClient struct {
+	base  string // https://host:8006/api2/json
+	node  string
+	token string
+	http  *http.Client
+}
+
+func newProxmoxClient(cfg structures.ProxmoxPublish, token string) *proxmoxClient {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.Insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	return &proxmoxClient{
+		base:  strings.TrimRight(cfg.URL, "/") + "/api2/json",
+		node:  cfg.Node,
+		token: token,
+		http:  &http.Client{Transport: transport},
+	}
+}
+
+// request выполняет запрос к API узла и возвращает поле data ответа как строку
+// (для асинхронных операций это UPID задачи)
+func (c *proxmoxClient) request(method, path string, params url.Values) (string, error) {
+	endpoint := c.base + "/nodes/" + c.node + path
+
+	var body io.Reader
+	if method == http.MethodPost {
+		body = strings.NewReader(params.Encode())
+	} else if len(params) > 0 {
+		endpoint += "?" + params.Encode()
+	}
+
+	req, err := http.NewRequest(method, endpoint, body)
+	if err != nil {
+		return "", err
+	}
+	if method == http.MethodPost {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	var data json.RawMessage
+	if err := c.do(req, &data); err != nil {
+		return "", err
+	}
+
+	var s string
+	json.Unmarshal(data, &s)
+	return s, nil
+}
+
+// do выполняет запрос и декодирует поле data ответа
+func (c *proxmoxClient) do(req *http.Request, data interface{}) error {
+	req.Header.Set("Authorization", "PVEAPIToken="+c.token)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	payload, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		// Proxmox передает причину ошибки в строке статуса
+		return fmt.Errorf("%s %s: %s %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(payload)))
+	}
+
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		return fmt.Errorf("error decoding response from %s: %w", req.URL.Path, err)
+	}
+
+	return json.Unmarshal(envelope.Data, data)
+}
+
+// vmExists проверяет, есть ли в кластере ВМ с указанным VMID
+func (c *proxmoxClient) vmExists(vmid int) (bool, error) {
+	req, err := http.NewRequest(http.MethodGet, c.base+"/cluster/resources?type=vm", nil)
+	if err != nil {
+		return false, err
+	}
+
+	var resources []struct {
+		VMID int `json:"vmid"`
+	}
+	if err := c.do(req, &resources); err != nil {
+		return false, fmt.Errorf("error listing VMs: %w", err)
+	}
+
+	for _, r := range resources {
+		if r.VMID == vmid {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// upload загружает файл в хранилище с типом содержимого import.
+// Тело multipart формируется потоково с заранее известной длиной.
+func (c *proxmoxClient) upload(storage, path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	// Заголовок формы до содержимого файла и завершающая граница
+	var head strings.Builder
+	mw := multipart.NewWriter(&head)
+	mw.WriteField("content", "import")
+	if _, err := mw.CreateFormFile("filename", filepath.Base(path)); err != nil {
+		return "", err
+	}
+	headLen := head.Len()
+	mw.Close()
+	prefix := head.String()[:headLen]
+	suffix := head.String()[headLen:]
+
+	body := io.MultiReader(
+		strings.NewReader(prefix),
+		io.TeeReader(file, progress.NewWriter(io.Discard, "upload", info.Size())),
+		strings.NewReader(suffix),
+	)
+
+	req, err := http.NewRequest(http.MethodPost, c.base+"/nodes/"+c.node+"/storage/"+storage+"/upload", body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.ContentLength = int64(len(prefix)) + info.Size() + int64(len(suffix))
+
+	var upid string
+	if err := c.do(req, &upid); err != nil {
+		return "", err
+	}
+	return upid, nil
+}
+
+// waitTask ожидает завершения асинхронной задачи Proxmox
+func (c *proxmoxClient) waitTask(upid string) error {
+	for {
+		req, err := http.NewRequest(http.MethodGet, c.base+"/nodes/"+c.node+"/tasks/"+url.PathEscape(upid)+"/status", nil)
+		if err != nil {
+			return err
+		}
+
+		var status struct {
+			Status     string `json:"status"`
+			ExitStatus string `json:"exitstatus"`
+		}
+		if err := c.do(req, &status); err != nil {
+			return err
+		}
+
+		if status.Status == "stopped" {
+			if status.ExitStatus != "OK" {
+				return fmt.Errorf("task %s failed: %s", upid, status.ExitStatus)
+			}
+			return nil
+		}
+
+		time.Sleep(2 * time.Second)
+	}
+}
@@ -0,0 +1,219 @@
+package publish
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"sysweaver/internal/logging"
+	"sysweaver/internal/progress"
+	"sysweaver/internal/structures"
+)
+
+// Options - параметры публикации артефактов
+type Options struct {
+	Config    *structures.BuildConfig
+	OutputDir string       // Директория артефактов сборки
+	LogWriter io.Writer    // Вывод внешних утилит
+	Out       io.Writer    // Сообщения о ходе публикации; nil - os.Stdout
+	Logger    *slog.Logger // Журнал предупреждений; nil - slog.Default()
+
+	Artifacts  []string // Артефакты сборки для плагинов публикации
+	PluginDirs []string // Каталоги плагинов публикации
+}
+
+// Result - опубликованный ресурс
+type Result struct {
+	Target    string   // Цель публикации (proxmox, aws, ...)
+	ID        string   // Идентификатор созданного ресурса
+	Artifacts []string // Артефакты, подготовленные для публикации в OutputDir
+}
+
+// publisher публикует артефакты в одну цель
+type publisher func(opts Options) (Result, error)
+
+// Publish публикует артефакты во все цели из секции publish конфигурации
+func Publish(opts Options) ([]Result, error) {
+	if opts.LogWriter == nil {
+		opts.LogWriter = io.Discard
+	}
+	if opts.Out == nil {
+		opts.Out = os.Stdout
+	}
+	opts.Logger = logging.OrDefault(opts.Logger)
+
+	var results []Result
+	cfg := opts.Config.Publish
+
+	// Цели в фиксированном порядке: локальные гипервизоры, затем облака
+	targets := []struct {
+		name    string
+		enabled bool
+		publish publisher
+	}{
+		{"proxmox", cfg.Proxmox != nil, func(o Options) (Result, error) { return publishProxmox(*cfg.Proxmox, o) }},
+		{"aws", cfg.AWS != nil, func(o Options) (Result, error) { return publishAWS(*cfg.AWS, o) }},
+		{"gcp", cfg.GCP != nil, func(o Options) (Result, error) { return publishGCP(*cfg.GCP, o) }},
+		{"azure", cfg.Azure != nil, func(o Options) (Result, error) { return publishAzure(*cfg.Azure, o) }},
+		{"oci", cfg.OCI != nil, func(o Options) (Result, error) { return publishOCI(*cfg.OCI, o) }},
+	}
+
+	for _, target := range targets {
+		if !target.enabled {
+			continue
+		}
+
+		fmt.Fprintf(opts.Out, "Publishing to %s...\n", target.name)
+		result, err := target.publish(opts)
+		if err != nil {
+			return results, fmt.Errorf("error publishing to %s: %w", target.name, err)
+		}
+		result.Target = target.name
+		results = append(results, result)
+	}
+
+	for _, p := range cfg.Plugins {
+		target := "plugin:" + p.Name
+		fmt.Fprintf(opts.Out, "Publishing to %s...\n", target)
+		result, err := publishPlugin(p, opts)
+		if err != nil {
+			return results, fmt.Errorf("error publishing to %s: %w", target, err)
+		}
+		result.Target = target
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// findImage возвращает исходный raw-образ: явно указанный или первый *.img/*.raw
+func findImage(source, outputDir string) (string, error) {
+	if source != "" {
+		path := filepath.Join(outputDir, source)
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("source image not found: %s", source)
+		}
+		return path, nil
+	}
+
+	for _, pattern := range []string{"*.img", "*.raw"} {
+		matches, err := filepath.Glob(filepath.Join(outputDir, pattern))
+		if err != nil {
+			return "", err
+		}
+		if len(matches) > 0 {
+			return matches[0], nil
+		}
+	}
+
+	return "", fmt.Errorf("no raw image found in %s", outputDir)
+}
+
+// convertImage конвертирует raw-образ в формат format утилитой qemu-img
+func convertImage(source, dest, format string, options []string, logWriter io.Writer) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"convert", "-f", "raw", "-O", format}
+	if len(options) > 0 {
+		args = append(args, "-o", strings.Join(options, ","))
+	}
+	args = append(args, source, dest)
+
+	cmd := exec.Command("qemu-img", args...)
+	cmd.Stdout = logWriter
+	cmd.Stderr = logWriter
+
+	if err := progress.Run(cmd, "convert "+format, info.Size(), true); err != nil {
+		return fmt.Errorf("error converting %s to %s: %w", filepath.Base(source), format, err)
+	}
+
+	return nil
+}
+
+// imageName возвращает имя ресурса по умолчанию из имени и версии сборки
+func imageName(cfg *structures.BuildConfig) string {
+	name := strings.ToLower(cfg.Name)
+	if name == "" {
+		name = "sysweaver"
+	}
+	if cfg.Version != "" {
+		name += "-" + cfg.Version
+	}
+	return strings.NewReplacer(".", "-", "_", "-", " ", "-").Replace(name)
+}
+
+// tool - внешняя утилита облачного провайдера (aws, gcloud, az)
+type tool struct {
+	name      string
+	common    []string // Параметры, добавляемые к каждому вызову
+	env       []string // Дополнительные переменные окружения
+	dir       string   // Рабочая директория
+	stdin     string   // Данные на stdin (секреты вместо аргументов командной строки)
+	logWriter io.Writer
+}
+
+func (t tool) command(args []string) *exec.Cmd {
+	cmd := exec.Command(t.name, append(args, t.common...)...)
+	cmd.Dir = t.dir
+	if t.stdin != "" {
+		cmd.Stdin = strings.NewReader(t.stdin)
+	}
+	if len(t.env) > 0 {
+		cmd.Env = append(os.Environ(), t.env...)
+	}
+	return cmd
+}
+
+// run выполняет команду и возвращает ее вывод
+func (t tool) run(args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := t.command(args)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w (%s)", t.name, strings.Join(args[:min(2, len(args))], " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// runJSON выполняет команду и декодирует JSON-ответ
+func (t tool) runJSON(v interface{}, args ...string) error {
+	// gcloud использует --format, aws и az - --output
+	format := []string{"--output", "json"}
+	if t.name == "gcloud" {
+		format = []string{"--format=json"}
+	}
+
+	out, err := t.run(append(args, format...)...)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(out, v); err != nil {
+		return fmt.Errorf("error decoding %s response: %w", t.name, err)
+	}
+	return nil
+}
+
+// runProgress выполняет команду, сообщая о прогрессе по чтению файла source
+func (t tool) runProgress(phase, source string, args ...string) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+
+	cmd := t.command(args)
+	cmd.Stdout = t.logWriter
+	cmd.Stderr = os.Stderr
+
+	return progress.Run(cmd, phase, info.Size(), true)
+}
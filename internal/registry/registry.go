@@ -0,0 +1,208 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Реестр шаблонов - YAML-индекс, доступный по HTTP(S) или как локальный файл:
+//
+//	templates:
+//	  - name: alpine-server
+//	    description: Minimal Alpine server with SSH
+//	    maintainer: Infra team <infra@example.com>
+//	    source: https://git.example.com/org/templates.git#v1.4:alpine/server
+//	    outputs: [iso, qcow2]
+//	    tags: [alpine, server]
+//
+// Индексы по HTTP кешируются в <state-dir>/registry и обновляются
+// командой template update; локальные файлы читаются напрямую.
+
+// EnvRegistry - переменная окружения со списком реестров через запятую
+const EnvRegistry = "SYSWEAVER_REGISTRY"
+
+// Entry - шаблон в индексе реестра
+type Entry struct {
+	Name        string   `yaml:"name"`
+	Description string   `yaml:"description,omitempty"`
+	Maintainer  string   `yaml:"maintainer,omitempty"`
+	Source      string   `yaml:"source"` // Каталог или git URL шаблона
+	Outputs     []string `yaml:"outputs,omitempty"`
+	Tags        []string `yaml:"tags,omitempty"`
+
+	Registry string `yaml:"-"` // Реестр, из которого получена запись
+}
+
+// Index - содержимое индексного файла реестра
+type Index struct {
+	Templates []Entry `yaml:"templates"`
+}
+
+// URLs возвращает реестры из флагов, а без них - из SYSWEAVER_REGISTRY
+func URLs(flags []string) []string {
+	if len(flags) > 0 {
+		return flags
+	}
+
+	var urls []string
+	for _, url := range strings.Split(os.Getenv(EnvRegistry), ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// Update загружает индексы реестров заново
+func Update(stateDir string, urls []string) error {
+	if len(urls) == 0 {
+		return errNoRegistries
+	}
+
+	for _, url := range urls {
+		if !isRemote(url) {
+			continue
+		}
+		fmt.Printf("Updating registry %s...\n", url)
+		if err := download(url, cachePath(stateDir, url)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load возвращает шаблоны всех реестров; отсутствующие в кеше индексы загружаются
+func Load(stateDir string, urls []string) ([]Entry, error) {
+	if len(urls) == 0 {
+		return nil, errNoRegistries
+	}
+
+	var entries []Entry
+	for _, url := range urls {
+		path := url
+		if isRemote(url) {
+			path = cachePath(stateDir, url)
+			if _, err := os.Stat(path); os.IsNotExist(err) {
+				if err := download(url, path); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		index, err := readIndex(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading registry %s: %w", url, err)
+		}
+
+		for _, entry := range index.Templates {
+			if entry.Name == "" || entry.Source == "" {
+				slog.Warn("skipping template without name or source", "registry", url)
+				continue
+			}
+			entry.Registry = url
+			entry.Source = resolveSource(url, entry.Source)
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, nil
+}
+
+// Search возвращает шаблоны, у которых имя, описание или теги содержат query
+func Search(entries []Entry, query string) []Entry {
+	query = strings.ToLower(query)
+
+	var found []Entry
+	for _, entry := range entries {
+		text := strings.ToLower(strings.Join(append([]string{entry.Name, entry.Description}, entry.Tags...), " "))
+		if strings.Contains(text, query) {
+			found = append(found, entry)
+		}
+	}
+	return found
+}
+
+// Find возвращает шаблон с именем name; при совпадении имен побеждает первый реестр
+func Find(entries []Entry, name string) (*Entry, error) {
+	for i := range entries {
+		if entries[i].Name == name {
+			return &entries[i], nil
+		}
+	}
+	return nil, fmt.Errorf("template %q not found in registries", name)
+}
+
+var errNoRegistries = fmt.Errorf("no template registries configured (use --registry or %s)", EnvRegistry)
+
+func isRemote(url string) bool {
+	return strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "http://")
+}
+
+// resolveSource разрешает относительный путь источника относительно локального индекса
+func resolveSource(registry, source string) string {
+	if isRemote(registry) || filepath.IsAbs(source) || strings.Contains(source, "://") || strings.HasPrefix(source, "git@") {
+		return source
+	}
+	return filepath.Join(filepath.Dir(registry), source)
+}
+
+// cachePath возвращает путь кешированного индекса реестра
+func cachePath(stateDir, url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(stateDir, "registry", hex.EncodeToString(sum[:])[:16]+".yaml")
+}
+
+func readIndex(path string) (*Index, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var index Index
+	if err := yaml.Unmarshal(data, &index); err != nil {
+		return nil, err
+	}
+	return &index, nil
+}
+
+// download загружает индекс в dest, проверяя, что он разбирается
+func download(url, dest string) error {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return fmt.Errorf("error fetching registry %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error fetching registry %s: HTTP %d", url, resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return fmt.Errorf("error fetching registry %s: %w", url, err)
+	}
+	if err := yaml.Unmarshal(data, &Index{}); err != nil {
+		return fmt.Errorf("invalid registry index %s: %w", url, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("error creating registry cache: %w", err)
+	}
+	if err := os.WriteFile(dest+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("error writing registry cache: %w", err)
+	}
+	return os.Rename(dest+".tmp", dest)
+}
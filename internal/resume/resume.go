@@ -0,0 +1,132 @@
+package resume
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Рабочий каталог сборки хранит то, что нужно для продолжения упавшей сборки
+// с --resume:
+//
+//	<dir>/state.json  - завершенные стадии, скрипты и артефакты
+//	<dir>/jail/upper  - снимок верхнего слоя overlay на момент сбоя
+//
+// Состояние обновляется после каждого успешного скрипта и удаляется после
+// успешного завершения всех стадий.
+
+// StateFile - имя файла состояния в рабочем каталоге
+const StateFile = "state.json"
+
+// SnapshotDir - каталог контрольной точки jail в рабочем каталоге
+const SnapshotDir = "jail"
+
+// State - состояние сборки
+type State struct {
+	Template  string              `json:"template"`
+	Config    string              `json:"config"`
+	Stages    []string            `json:"stages,omitempty"`    // Завершенные стадии
+	Scripts   map[string][]string `json:"scripts,omitempty"`   // Успешные скрипты незавершенных стадий
+	Artifacts []string            `json:"artifacts,omitempty"` // Артефакты завершенных стадий
+	Failed    string              `json:"failed,omitempty"`    // Стадия, на которой сборка упала
+
+	dir string
+}
+
+// New возвращает пустое состояние сборки в каталоге dir
+func New(dir, template, config string) *State {
+	return &State{Template: template, Config: config, dir: dir}
+}
+
+// Load читает состояние из каталога dir; nil - сохраненного состояния нет
+func Load(dir string) (*State, error) {
+	path := filepath.Join(dir, StateFile)
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", path, err)
+	}
+
+	state := &State{dir: dir}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	return state, nil
+}
+
+// Clear удаляет рабочий каталог dir
+func Clear(dir string) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("error removing build workspace: %w", err)
+	}
+	return nil
+}
+
+// Dir возвращает рабочий каталог
+func (s *State) Dir() string {
+	return s.dir
+}
+
+// Snapshot возвращает каталог контрольной точки jail
+func (s *State) Snapshot() string {
+	return filepath.Join(s.dir, SnapshotDir)
+}
+
+// StageDone сообщает, завершена ли стадия
+func (s *State) StageDone(stage string) bool {
+	return slices.Contains(s.Stages, stage)
+}
+
+// ScriptDone сообщает, выполнен ли скрипт стадии
+func (s *State) ScriptDone(stage, name string) bool {
+	return slices.Contains(s.Scripts[stage], name)
+}
+
+// CompleteScript отмечает успешный скрипт и сохраняет состояние
+func (s *State) CompleteScript(stage, name string) error {
+	if s.Scripts == nil {
+		s.Scripts = make(map[string][]string)
+	}
+	if !s.ScriptDone(stage, name) {
+		s.Scripts[stage] = append(s.Scripts[stage], name)
+	}
+	return s.Save()
+}
+
+// CompleteStage отмечает завершенную стадию с ее артефактами и сохраняет состояние
+func (s *State) CompleteStage(stage string, artifacts []string) error {
+	if !s.StageDone(stage) {
+		s.Stages = append(s.Stages, stage)
+	}
+	delete(s.Scripts, stage)
+	s.Artifacts = append(s.Artifacts, artifacts...)
+	s.Failed = ""
+	return s.Save()
+}
+
+// Save записывает состояние в рабочий каталог
+func (s *State) Save() error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("error creating build workspace: %w", err)
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding build state: %w", err)
+	}
+
+	// Через временный файл, чтобы прерванная запись не испортила состояние
+	path := filepath.Join(s.dir, StateFile)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("error writing %s: %w", path, err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("error writing %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,314 @@
+package retention
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"sysweaver/internal/cache"
+	"sysweaver/internal/image"
+	"sysweaver/internal/progress"
+	"sysweaver/internal/store"
+	"sysweaver/internal/structures"
+)
+
+// Очистка каталога состояния по политике retention. Удаляются записи о
+// сборках (builds/<id>) вместе с их артефактами и логами скриптов в
+// директории вывода, а также слои кэша (cache/layers/<key>):
+//
+//   - keep_last - сборки шаблона старше N последних;
+//   - max_age - сборки и слои, не использовавшиеся дольше срока;
+//   - max_size - если общий объем больше, самые старые сборки и слои
+//     вперемешку, пока объем не уложится в предел.
+//
+// Последняя сборка каждого шаблона не удаляется никогда. Файл в директории
+// вывода удаляется, только если на него не ссылается ни одна оставшаяся
+// сборка: при повторной сборке в ту же директорию артефакты перезаписываются.
+
+// Незавершенные сохранения слоев старше этого срока считаются брошенными
+const staleTempAge = 24 * time.Hour
+
+// Виды удаляемых объектов
+const (
+	KindBuild = "build"
+	KindLayer = "layer"
+)
+
+// Policy - разобранная политика хранения
+type Policy struct {
+	KeepLast int
+	MaxAge   time.Duration
+	MaxSize  int64
+}
+
+// NewPolicy разбирает секцию retention конфигурации
+func NewPolicy(cfg structures.RetentionConfig) (Policy, error) {
+	p := Policy{KeepLast: cfg.KeepLast}
+	if cfg.KeepLast < 0 {
+		return p, fmt.Errorf("invalid retention keep_last: %d", cfg.KeepLast)
+	}
+	if cfg.MaxAge != "" {
+		age, err := ParseAge(cfg.MaxAge)
+		if err != nil {
+			return p, fmt.Errorf("invalid retention max_age: %w", err)
+		}
+		p.MaxAge = age
+	}
+	if cfg.MaxSize != "" {
+		size, err := image.ParseSize(cfg.MaxSize)
+		if err != nil {
+			return p, fmt.Errorf("invalid retention max_size: %w", err)
+		}
+		p.MaxSize = size
+	}
+	return p, nil
+}
+
+// Empty сообщает, что политика ничего не ограничивает
+func (p Policy) Empty() bool {
+	return p.KeepLast == 0 && p.MaxAge == 0 && p.MaxSize == 0
+}
+
+// ParseAge разбирает срок: длительность Go (12h, 90m) или число дней (30d)
+func ParseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration: %s", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	age, err := time.ParseDuration(s)
+	if err != nil || age < 0 {
+		return 0, fmt.Errorf("invalid duration: %s", s)
+	}
+	return age, nil
+}
+
+// Removal - удаленный (или удаляемый при dryRun) объект
+type Removal struct {
+	Kind   string `json:"kind"`               // build или layer
+	ID     string `json:"id"`                 // ID сборки или ключ слоя
+	Name   string `json:"template,omitempty"` // Шаблон сборки
+	Size   int64  `json:"size"`
+	Reason string `json:"reason"`
+}
+
+// Result - итог очистки
+type Result struct {
+	Removed []Removal `json:"removed"`
+	Freed   int64     `json:"freed_bytes"` // Освобождено байт
+	Total   int64     `json:"total_bytes"` // Объем оставшихся сборок и слоев
+}
+
+// candidate - сборка или слой, который может быть удален
+type candidate struct {
+	Removal
+	time      time.Time
+	protected bool     // Последняя сборка шаблона
+	files     []string // Файлы сборки в директории вывода
+	removed   bool
+}
+
+// Collect применяет политику к каталогу состояния. При dryRun ничего не
+// удаляется, результат описывает, что было бы удалено.
+func Collect(stateDir string, p Policy, dryRun bool) (*Result, error) {
+	st, err := store.Open(stateDir)
+	if err != nil {
+		return nil, err
+	}
+	records, err := st.List()
+	if err != nil {
+		return nil, err
+	}
+	layerCache := cache.Open(stateDir)
+	layers, err := layerCache.Layers()
+	if err != nil {
+		return nil, err
+	}
+
+	var cutoff time.Time
+	if p.MaxAge > 0 {
+		cutoff = time.Now().Add(-p.MaxAge)
+	}
+
+	// Сборки идут от новых к старым; общий файл учитывается в объеме самой новой сборки
+	var candidates []*candidate
+	counted := make(map[string]bool)
+	perTemplate := make(map[string]int)
+	for _, record := range records {
+		c := &candidate{
+			Removal: Removal{Kind: KindBuild, ID: record.ID, Name: record.Template},
+			time:    record.StartedAt,
+			files:   recordFiles(record),
+		}
+		c.Size = dirSize(st.BuildDir(record.ID))
+		for _, path := range c.files {
+			if !counted[path] {
+				counted[path] = true
+				if info, err := os.Stat(path); err == nil {
+					c.Size += info.Size()
+				}
+			}
+		}
+
+		index := perTemplate[record.Template]
+		perTemplate[record.Template]++
+		switch {
+		case index == 0:
+			c.protected = true
+		case p.KeepLast > 0 && index >= p.KeepLast:
+			c.Reason = fmt.Sprintf("more than %d builds of the template", p.KeepLast)
+		case !cutoff.IsZero() && record.StartedAt.Before(cutoff):
+			c.Reason = "older than max_age"
+		}
+		candidates = append(candidates, c)
+	}
+
+	for _, layer := range layers {
+		c := &candidate{
+			Removal: Removal{Kind: KindLayer, ID: layer.Key, Size: layer.Size},
+			time:    layer.Used,
+		}
+		switch {
+		case strings.HasPrefix(layer.Key, ".tmp-") && time.Since(layer.Used) > staleTempAge:
+			c.Reason = "incomplete layer"
+		case strings.HasPrefix(layer.Key, ".tmp-"):
+			// Слой сохраняется прямо сейчас
+			c.protected = true
+		case !cutoff.IsZero() && layer.Used.Before(cutoff):
+			c.Reason = "unused for longer than max_age"
+		}
+		candidates = append(candidates, c)
+	}
+
+	result := &Result{Removed: []Removal{}}
+	for _, c := range candidates {
+		if c.Reason != "" {
+			c.removed = true
+		} else {
+			result.Total += c.Size
+		}
+	}
+
+	// Предел объема: удаляются самые старые из оставшихся
+	if p.MaxSize > 0 && result.Total > p.MaxSize {
+		sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].time.Before(candidates[j].time) })
+		for _, c := range candidates {
+			if result.Total <= p.MaxSize {
+				break
+			}
+			if c.removed || c.protected {
+				continue
+			}
+			c.removed = true
+			c.Reason = "over max_size"
+			result.Total -= c.Size
+		}
+	}
+
+	// Файлы, на которые ссылаются оставшиеся сборки
+	kept := make(map[string]bool)
+	for _, c := range candidates {
+		if c.Kind == KindBuild && !c.removed {
+			for _, path := range c.files {
+				kept[path] = true
+			}
+		}
+	}
+
+	var errs []error
+	for _, c := range candidates {
+		if !c.removed {
+			continue
+		}
+		result.Removed = append(result.Removed, c.Removal)
+		result.Freed += c.Size
+		if dryRun {
+			continue
+		}
+
+		switch c.Kind {
+		case KindBuild:
+			for _, path := range c.files {
+				if !kept[path] {
+					if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+						errs = append(errs, fmt.Errorf("error removing %s: %w", path, err))
+					}
+				}
+			}
+			if err := st.Remove(c.ID); err != nil {
+				errs = append(errs, err)
+			}
+		case KindLayer:
+			if err := layerCache.Remove(c.ID); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	sort.SliceStable(result.Removed, func(i, j int) bool {
+		return result.Removed[i].Kind < result.Removed[j].Kind
+	})
+	return result, errors.Join(errs...)
+}
+
+// Print выводит в w удаленные сборки и итог очистки; слои - только в журнал log
+func (result *Result) Print(w io.Writer, log *slog.Logger, dryRun bool) {
+	verb := "Removed"
+	if dryRun {
+		verb = "Would remove"
+	}
+
+	builds, layers := 0, 0
+	for _, removal := range result.Removed {
+		if removal.Kind == KindBuild {
+			builds++
+			fmt.Fprintf(w, "  %s build %s (%s, %s): %s\n", verb, removal.ID, removal.Name, progress.FormatBytes(removal.Size), removal.Reason)
+		} else {
+			layers++
+			log.Debug(verb+" cached layer", "key", removal.ID, "size", removal.Size, "reason", removal.Reason)
+		}
+	}
+
+	fmt.Fprintf(w, "%s %d build(s) and %d cached layer(s), %s; %s remaining\n", verb, builds, layers,
+		progress.FormatBytes(result.Freed), progress.FormatBytes(result.Total))
+}
+
+// recordFiles возвращает артефакты и логи скриптов сборки
+func recordFiles(record *store.Record) []string {
+	var files []string
+	for _, artifact := range record.Artifacts {
+		if artifact.Path != "" {
+			files = append(files, artifact.Path)
+		}
+	}
+	for _, run := range record.Scripts {
+		if run.Log != "" {
+			files = append(files, run.Log)
+		}
+	}
+	return files
+}
+
+// dirSize возвращает объем файлов каталога
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,77 @@
+package rootfs
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"sysweaver/internal/structures"
+)
+
+// Параметры sysctl: и модули modules: конфигурации записываются в каталоги
+// конфигурации, которые читают и OpenRC (службы sysctl и modules), и systemd
+// (systemd-sysctl и systemd-modules-load). Файлы перезаписываются целиком,
+// поэтому пустые списки удаляют ранее записанные файлы.
+
+// Файлы, создаваемые в собираемой системе
+const (
+	sysctlFile    = "etc/sysctl.d/90-sysweaver.conf"
+	modulesFile   = "etc/modules-load.d/sysweaver.conf"
+	blacklistFile = "etc/modprobe.d/sysweaver-blacklist.conf"
+)
+
+const kernelHeader = "# Generated by sysweaver from the config\n"
+
+// ApplyKernel записывает параметры sysctl и списки модулей ядра в корневую ФС root
+func ApplyKernel(root string, sysctl map[string]string, modules structures.ModulesConfig) error {
+	var lines []string
+	for key, value := range sysctl {
+		if key == "" || strings.ContainsAny(key, " \t\n=") || strings.ContainsAny(value, "\n") {
+			return fmt.Errorf("invalid sysctl %q", key)
+		}
+		lines = append(lines, key+" = "+value)
+	}
+	slices.Sort(lines)
+	if err := writeKernelFile(root, sysctlFile, lines); err != nil {
+		return err
+	}
+
+	for _, list := range [][]string{modules.Load, modules.Blacklist} {
+		for _, module := range list {
+			if module == "" || strings.ContainsAny(module, " \t\n/") {
+				return fmt.Errorf("invalid kernel module name %q", module)
+			}
+		}
+	}
+	if err := writeKernelFile(root, modulesFile, modules.Load); err != nil {
+		return err
+	}
+
+	var blacklist []string
+	for _, module := range modules.Blacklist {
+		if slices.Contains(modules.Load, module) {
+			return fmt.Errorf("kernel module %s is both loaded and blacklisted", module)
+		}
+		blacklist = append(blacklist, "blacklist "+module)
+	}
+	return writeKernelFile(root, blacklistFile, blacklist)
+}
+
+// writeKernelFile записывает строки в файл или удаляет файл, если строк нет
+func writeKernelFile(root, rel string, lines []string) error {
+	if len(lines) == 0 {
+		path, err := resolveIn(root, rel)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := writeSystemFile(root, rel, kernelHeader+strings.Join(lines, "\n")+"\n"); err != nil {
+		return fmt.Errorf("error writing /%s: %w", rel, err)
+	}
+	return nil
+}
@@ -0,0 +1,312 @@
+package rootfs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Каталог rootfs/ шаблона копируется в корневую ФС после установки пакетов.
+// Файлы получают владельца root:root и права исходного файла (git хранит
+// только бит исполнения); точные права и владельцы задаются в rootfs.yaml
+// рядом с каталогом:
+//
+//	- path: /etc/ssh/sshd_config
+//	  mode: "0600"
+//	- path: /home/admin/.ssh/*
+//	  owner: admin
+//	  group: admin
+//	  mode: "0600"
+//
+// Путь - шаблон path.Match внутри собранной системы; при нескольких
+// совпадениях поля более поздних записей заменяют ранние. Имена пользователей
+// и групп разрешаются по /etc/passwd и /etc/group собранной системы.
+
+// Имена каталога оверлея и файла метаданных в шаблоне
+const (
+	OverlayDir  = "rootfs"
+	OverlayMeta = "rootfs.yaml"
+)
+
+// OverlayEntry - права и владелец файлов оверлея
+type OverlayEntry struct {
+	Path  string `yaml:"path"`
+	Mode  string `yaml:"mode,omitempty"`
+	Owner string `yaml:"owner,omitempty"`
+	Group string `yaml:"group,omitempty"`
+}
+
+// overlayAttrs - итоговые атрибуты файла
+type overlayAttrs struct {
+	mode     os.FileMode
+	uid, gid int
+}
+
+// ApplyOverlay копирует дерево src в корневую ФС root с правами из метаданных
+// meta (файл необязателен) и возвращает число скопированных файлов. Записи
+// метаданных без файлов в src попадают в log предупреждениями.
+func ApplyOverlay(src, meta, root string, logWriter io.Writer, log *slog.Logger) (int, error) {
+	entries, err := readOverlayMeta(meta)
+	if err != nil {
+		return 0, err
+	}
+
+	users := readIDs(filepath.Join(root, "etc/passwd"))
+	groups := readIDs(filepath.Join(root, "etc/group"))
+
+	used := make([]bool, len(entries))
+	count := 0
+
+	err = filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		target := "/" + filepath.ToSlash(rel)
+
+		// Ссылки собранной системы разрешаются внутри root, а не на хосте
+		dest, err := resolveIn(root, filepath.Dir(rel))
+		if err != nil {
+			return err
+		}
+		dest = filepath.Join(dest, info.Name())
+
+		attrs := overlayAttrs{mode: info.Mode().Perm()}
+		matched := false
+		for i, entry := range entries {
+			if ok, _ := path.Match(entry.Path, target); !ok {
+				continue
+			}
+			used[i], matched = true, true
+			if err := entry.apply(&attrs, users, groups); err != nil {
+				return fmt.Errorf("%s: %s: %w", meta, entry.Path, err)
+			}
+		}
+
+		switch {
+		case info.IsDir():
+			// Существующие каталоги системы (и ссылки на каталоги, как /lib -> usr/lib)
+			// сохраняют права, если они не заданы явно
+			if dest, err = resolveIn(root, rel); err != nil {
+				return err
+			}
+			if existing, err := os.Stat(dest); err == nil && existing.IsDir() {
+				if !matched {
+					return nil
+				}
+			} else if err := os.Mkdir(dest, attrs.mode); err != nil {
+				return err
+			}
+
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			os.Remove(dest)
+			if err := os.Symlink(link, dest); err != nil {
+				return err
+			}
+			count++
+			return os.Lchown(dest, attrs.uid, attrs.gid)
+
+		case info.Mode().IsRegular():
+			if err := replaceFile(p, dest); err != nil {
+				return err
+			}
+			count++
+
+		default:
+			return nil
+		}
+
+		fmt.Fprintf(logWriter, "rootfs: %s %04o %d:%d\n", target, attrs.mode, attrs.uid, attrs.gid)
+		if err := os.Lchown(dest, attrs.uid, attrs.gid); err != nil {
+			return err
+		}
+		return os.Chmod(dest, attrs.mode)
+	})
+	if err != nil {
+		return count, fmt.Errorf("error applying rootfs overlay: %w", err)
+	}
+
+	for i, entry := range entries {
+		if !used[i] {
+			log.Warn(OverlayMeta+" entry matches no file in "+OverlayDir+"/", "path", entry.Path)
+		}
+	}
+	return count, nil
+}
+
+// apply применяет поля записи к атрибутам файла
+func (e OverlayEntry) apply(attrs *overlayAttrs, users, groups map[string]int) error {
+	if e.Mode != "" {
+		mode, err := strconv.ParseUint(e.Mode, 8, 32)
+		if err != nil || mode > 07777 {
+			return fmt.Errorf("invalid mode %q", e.Mode)
+		}
+		attrs.mode = os.FileMode(mode).Perm() | modeBits(mode)
+	}
+	if e.Owner != "" {
+		uid, err := lookupID(e.Owner, users)
+		if err != nil {
+			return fmt.Errorf("unknown owner %q", e.Owner)
+		}
+		attrs.uid = uid
+	}
+	if e.Group != "" {
+		gid, err := lookupID(e.Group, groups)
+		if err != nil {
+			return fmt.Errorf("unknown group %q", e.Group)
+		}
+		attrs.gid = gid
+	}
+	return nil
+}
+
+// modeBits переводит биты setuid/setgid/sticky из формата chmod в os.FileMode
+func modeBits(mode uint64) os.FileMode {
+	var bits os.FileMode
+	if mode&04000 != 0 {
+		bits |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		bits |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		bits |= os.ModeSticky
+	}
+	return bits
+}
+
+func readOverlayMeta(file string) ([]OverlayEntry, error) {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", file, err)
+	}
+
+	var entries []OverlayEntry
+	if err := yaml.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", file, err)
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Path, "/") {
+			return nil, fmt.Errorf("%s: path must be absolute: %q", file, entry.Path)
+		}
+		if _, err := path.Match(entry.Path, "/"); err != nil {
+			return nil, fmt.Errorf("%s: invalid pattern %q", file, entry.Path)
+		}
+	}
+	return entries, nil
+}
+
+// readIDs читает имена и числовые идентификаторы из файла формата /etc/passwd
+func readIDs(name string) map[string]int {
+	ids := make(map[string]int)
+
+	file, err := os.Open(name)
+	if err != nil {
+		return ids
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 3 {
+			continue
+		}
+		if id, err := strconv.Atoi(fields[2]); err == nil {
+			ids[fields[0]] = id
+		}
+	}
+	return ids
+}
+
+// lookupID возвращает числовой идентификатор по имени или числу
+func lookupID(name string, ids map[string]int) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	if id, ok := ids[name]; ok {
+		return id, nil
+	}
+	return 0, fmt.Errorf("not found")
+}
+
+// resolveIn разрешает путь rel внутри root, следуя символьным ссылкам так,
+// как их видит chroot: абсолютные ссылки отсчитываются от root
+func resolveIn(root, rel string) (string, error) {
+	var resolved []string
+	pending := strings.Split(filepath.ToSlash(rel), "/")
+
+	for links := 0; len(pending) > 0; {
+		name := pending[0]
+		pending = pending[1:]
+
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) > 0 {
+				resolved = resolved[:len(resolved)-1]
+			}
+			continue
+		}
+
+		current := filepath.Join(root, filepath.Join(resolved...), name)
+		info, err := os.Lstat(current)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			resolved = append(resolved, name)
+			continue
+		}
+
+		if links++; links > 255 {
+			return "", fmt.Errorf("too many levels of symbolic links: %s", rel)
+		}
+		link, err := os.Readlink(current)
+		if err != nil {
+			return "", err
+		}
+		if strings.HasPrefix(link, "/") {
+			resolved = nil
+		}
+		pending = append(strings.Split(link, "/"), pending...)
+	}
+
+	return filepath.Join(root, filepath.Join(resolved...)), nil
+}
+
+// replaceFile копирует src в dst, заменяя существующий файл
+func replaceFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	os.Remove(dst)
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,147 @@
+package rootfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"sysweaver/internal/apk"
+	"sysweaver/internal/structures"
+)
+
+// Настройки system: конфигурации применяются к корневой ФС перед скриптами
+// стадии configure, поэтому скрипты могут их переопределить:
+//
+//	hostname - /etc/hostname и строка 127.0.1.1 в /etc/hosts
+//	timezone - ссылка /etc/localtime на /usr/share/zoneinfo/<зона> и /etc/timezone
+//	locale   - LANG в /etc/locale.conf и, для musl, в /etc/profile.d/locale.sh
+
+// Каталог зон tzdata внутри системы
+const zoneinfoDir = "/usr/share/zoneinfo"
+
+// ApplySystem записывает имя хоста, часовой пояс и локаль в корневую ФС root.
+// Пустые значения пропускаются.
+func ApplySystem(root string, system structures.SystemConfig) error {
+	if system.Hostname != "" {
+		if err := applyHostname(root, system.Hostname); err != nil {
+			return fmt.Errorf("error setting hostname: %w", err)
+		}
+	}
+	if system.Timezone != "" {
+		if err := applyTimezone(root, system.Timezone); err != nil {
+			return fmt.Errorf("error setting timezone: %w", err)
+		}
+	}
+	if system.Locale != "" {
+		if err := applyLocale(root, system.Locale); err != nil {
+			return fmt.Errorf("error setting locale: %w", err)
+		}
+	}
+	return nil
+}
+
+func applyHostname(root, hostname string) error {
+	if strings.ContainsAny(hostname, " \t\n/") {
+		return fmt.Errorf("invalid hostname %q", hostname)
+	}
+	if err := writeSystemFile(root, "etc/hostname", hostname+"\n"); err != nil {
+		return err
+	}
+
+	// Собственное имя разрешается без DNS: заменяем строку 127.0.1.1
+	hostsPath, err := resolveIn(root, "etc/hosts")
+	if err != nil {
+		return err
+	}
+	hosts, err := os.ReadFile(hostsPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var lines []string
+	for _, line := range strings.Split(strings.TrimRight(string(hosts), "\n"), "\n") {
+		if fields := strings.Fields(line); line == "" || (len(fields) > 0 && fields[0] == "127.0.1.1") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "127.0.0.1\tlocalhost localhost.localdomain")
+	}
+	short, _, _ := strings.Cut(hostname, ".")
+	entry := "127.0.1.1\t" + hostname
+	if short != hostname {
+		entry += " " + short
+	}
+	lines = append(lines, entry)
+	return writeSystemFile(root, "etc/hosts", strings.Join(lines, "\n")+"\n")
+}
+
+func applyTimezone(root, zone string) error {
+	if strings.HasPrefix(zone, "/") || strings.Contains(zone, "..") {
+		return fmt.Errorf("invalid timezone %q", zone)
+	}
+
+	target := filepath.Join(zoneinfoDir, zone)
+	resolved, err := resolveIn(root, target)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(resolved)
+	switch {
+	case err == nil && info.Mode().IsRegular():
+		localtime := filepath.Join(root, "etc/localtime")
+		if err := os.Remove(localtime); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Symlink(target, localtime); err != nil {
+			return err
+		}
+	case zone == "UTC":
+		// Без tzdata и /etc/localtime система и так работает в UTC
+		os.Remove(filepath.Join(root, "etc/localtime"))
+	default:
+		return fmt.Errorf("unknown timezone %q: %s not found in the system (is tzdata installed?)", zone, target)
+	}
+	return writeSystemFile(root, "etc/timezone", zone+"\n")
+}
+
+func applyLocale(root, locale string) error {
+	if strings.ContainsAny(locale, " \t\n\"'$`") {
+		return fmt.Errorf("invalid locale %q", locale)
+	}
+	if err := writeSystemFile(root, "etc/locale.conf", "LANG="+locale+"\n"); err != nil {
+		return err
+	}
+
+	// musl не читает locale.conf: Alpine берет LANG из профиля оболочки
+	if _, err := os.Stat(filepath.Join(root, apk.InstalledDB)); err == nil {
+		script := "# Generated by sysweaver from system.locale\nexport LANG=" + locale + "\nexport CHARSET=" + charset(locale) + "\n"
+		if err := writeSystemFile(root, "etc/profile.d/locale.sh", script); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// charset возвращает кодировку локали: en_US.UTF-8 -> UTF-8
+func charset(locale string) string {
+	_, codeset, ok := strings.Cut(locale, ".")
+	if !ok {
+		return "UTF-8"
+	}
+	codeset, _, _ = strings.Cut(codeset, "@")
+	return codeset
+}
+
+// writeSystemFile записывает файл системы, следуя ссылкам внутри root
+func writeSystemFile(root, rel, content string) error {
+	path, err := resolveIn(root, rel)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(content), 0644)
+}
@@ -0,0 +1,263 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// TarOptions - параметры упаковки корневой ФС в tar
+type TarOptions struct {
+	// Пути относительно корня (без ведущего /), которые не попадают в архив.
+	// Каталог из списка исключается вместе с содержимым.
+	Exclude []string
+
+	// Сохранять расширенные атрибуты (security.capability, user.* и т.д.)
+	Xattrs bool
+
+	// Если задано, время изменения файлов ограничивается сверху этим значением,
+	// а время доступа/изменения inode не записывается - архив воспроизводим
+	ClampMtime *time.Time
+}
+
+// SourceDateEpoch возвращает время из переменной SOURCE_DATE_EPOCH или nil
+func SourceDateEpoch() *time.Time {
+	value := os.Getenv("SOURCE_DATE_EPOCH")
+	if value == "" {
+		return nil
+	}
+
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil
+	}
+
+	t := time.Unix(seconds, 0).UTC()
+	return &t
+}
+
+// WriteTar упаковывает корневую ФС root в tar-поток.
+// Как и cp -x, архив не выходит за пределы файловой системы root: точки
+// монтирования (proc, sys, dev, шаблон) сохраняются пустыми каталогами.
+// Сохраняются владельцы, права, симлинки, жесткие ссылки и файлы устройств.
+func WriteTar(w io.Writer, root string, opts TarOptions) error {
+	rootInfo, err := os.Lstat(root)
+	if err != nil {
+		return fmt.Errorf("rootfs not found: %w", err)
+	}
+	rootDev := rootInfo.Sys().(*syscall.Stat_t).Dev
+
+	excluded := make(map[string]bool, len(opts.Exclude))
+	for _, path := range opts.Exclude {
+		excluded[strings.Trim(filepath.ToSlash(path), "/")] = true
+	}
+
+	tw := tar.NewWriter(w)
+	hardlinks := make(map[uint64]string)
+
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+
+		if excluded[rel] {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		stat := info.Sys().(*syscall.Stat_t)
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return fmt.Errorf("error creating tar header for %s: %w", rel, err)
+		}
+		header.Name = rel
+		if info.IsDir() {
+			header.Name += "/"
+		}
+
+		// Имена пользователей хоста не относятся к образу - храним только числовые ID
+		header.Uname = ""
+		header.Gname = ""
+
+		if opts.ClampMtime != nil {
+			header.AccessTime = time.Time{}
+			header.ChangeTime = time.Time{}
+			if header.ModTime.After(*opts.ClampMtime) {
+				header.ModTime = *opts.ClampMtime
+			}
+		}
+
+		if opts.Xattrs && info.Mode()&os.ModeSymlink == 0 {
+			xattrs, err := readXattrs(path)
+			if err != nil {
+				return fmt.Errorf("error reading xattrs of %s: %w", rel, err)
+			}
+			if len(xattrs) > 0 {
+				header.PAXRecords = make(map[string]string, len(xattrs))
+				for name, value := range xattrs {
+					header.PAXRecords["SCHILY.xattr."+name] = value
+				}
+				header.Format = tar.FormatPAX
+			}
+		}
+
+		// Точка монтирования другой ФС - пустой каталог
+		crossesMount := info.IsDir() && stat.Dev != rootDev
+
+		// Повторные жесткие ссылки на обычный файл
+		if info.Mode().IsRegular() && stat.Nlink > 1 {
+			if target, ok := hardlinks[stat.Ino]; ok {
+				header.Typeflag = tar.TypeLink
+				header.Linkname = target
+				header.Size = 0
+			} else {
+				hardlinks[stat.Ino] = rel
+			}
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("error writing tar header for %s: %w", rel, err)
+		}
+
+		if header.Typeflag == tar.TypeReg && header.Size > 0 {
+			file, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(tw, file)
+			file.Close()
+			if err != nil {
+				return fmt.Errorf("error writing %s to tar: %w", rel, err)
+			}
+		}
+
+		if crossesMount {
+			return filepath.SkipDir
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// readXattrs читает расширенные атрибуты файла
+func readXattrs(path string) (map[string]string, error) {
+	size, err := syscall.Listxattr(path, nil)
+	if err != nil {
+		// ФС без поддержки xattr - не ошибка
+		if err == syscall.ENOTSUP || err == syscall.ENODATA {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if size == 0 {
+		return nil, nil
+	}
+
+	buf := make([]byte, size)
+	size, err = syscall.Listxattr(path, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	xattrs := make(map[string]string)
+	for _, name := range strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00") {
+		if name == "" {
+			continue
+		}
+
+		valueSize, err := syscall.Getxattr(path, name, nil)
+		if err != nil {
+			continue
+		}
+		value := make([]byte, valueSize)
+		valueSize, err = syscall.Getxattr(path, name, value)
+		if err != nil {
+			continue
+		}
+		xattrs[name] = string(value[:valueSize])
+	}
+
+	return xattrs, nil
+}
+
+// Size возвращает суммарный размер обычных файлов, которые попадут в архив
+// с указанными параметрами (для оценки прогресса)
+func Size(root string, opts TarOptions) (int64, error) {
+	rootInfo, err := os.Lstat(root)
+	if err != nil {
+		return 0, fmt.Errorf("rootfs not found: %w", err)
+	}
+	rootDev := rootInfo.Sys().(*syscall.Stat_t).Dev
+
+	excluded := make(map[string]bool, len(opts.Exclude))
+	for _, path := range opts.Exclude {
+		excluded[strings.Trim(filepath.ToSlash(path), "/")] = true
+	}
+
+	var total int64
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(root, path)
+		if rel == "." {
+			return nil
+		}
+		if excluded[filepath.ToSlash(rel)] {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() && info.Sys().(*syscall.Stat_t).Dev != rootDev {
+			return filepath.SkipDir
+		}
+		if info.Mode().IsRegular() {
+			total += info.Size()
+		}
+		return nil
+	})
+
+	return total, err
+}
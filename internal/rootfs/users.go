@@ -0,0 +1,370 @@
+package rootfs
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"sysweaver/internal/structures"
+)
+
+// Пользователи из users: конфигурации записываются прямо в /etc/passwd,
+// /etc/shadow и /etc/group корневой ФС, без adduser внутри jail: результат
+// не зависит от утилит дистрибутива. Новый пользователь получает основную
+// группу со своим именем, домашний каталог с содержимым /etc/skel и, при
+// заданных ключах, ~/.ssh/authorized_keys.
+
+// Первый UID/GID обычных пользователей и граница диапазона
+const (
+	firstUID = 1000
+	lastUID  = 59999
+)
+
+// dbFile - файл базы учетных записей: строки, разделенные на поля ':'
+type dbFile struct {
+	path    string
+	mode    os.FileMode
+	entries [][]string
+}
+
+func readDB(root, rel string, mode os.FileMode) (*dbFile, error) {
+	path, err := resolveIn(root, rel)
+	if err != nil {
+		return nil, err
+	}
+	// Права существующего файла сохраняются (shadow в разных дистрибутивах 0640 или 0000)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	db := &dbFile{path: path, mode: mode}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		if line != "" {
+			db.entries = append(db.entries, strings.Split(line, ":"))
+		}
+	}
+	return db, nil
+}
+
+func (db *dbFile) find(name string) []string {
+	for _, entry := range db.entries {
+		if entry[0] == name {
+			return entry
+		}
+	}
+	return nil
+}
+
+// ids возвращает занятые идентификаторы (поле 2)
+func (db *dbFile) ids() map[int]bool {
+	used := make(map[int]bool)
+	for _, entry := range db.entries {
+		if len(entry) > 2 {
+			if id, err := strconv.Atoi(entry[2]); err == nil {
+				used[id] = true
+			}
+		}
+	}
+	return used
+}
+
+func (db *dbFile) write() error {
+	var b strings.Builder
+	for _, entry := range db.entries {
+		b.WriteString(strings.Join(entry, ":"))
+		b.WriteString("\n")
+	}
+	if err := os.MkdirAll(filepath.Dir(db.path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(db.path, []byte(b.String()), db.mode); err != nil {
+		return err
+	}
+	return os.Chmod(db.path, db.mode)
+}
+
+// nextID возвращает свободный идентификатор начиная с firstUID, не занятый
+// ни в одной из баз
+func nextID(dbs ...*dbFile) (int, error) {
+	for id := firstUID; id <= lastUID; id++ {
+		free := true
+		for _, db := range dbs {
+			if db.ids()[id] {
+				free = false
+				break
+			}
+		}
+		if free {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("no free id in %d-%d", firstUID, lastUID)
+}
+
+// ApplyUsers создает или обновляет пользователей в корневой ФС root.
+// passwords - хеши паролей пользователей, заданные через password_secret;
+// предупреждения о пользователях пишутся в log.
+func ApplyUsers(root string, users []structures.User, passwords map[string]string, log *slog.Logger) error {
+	passwd, err := readDB(root, "etc/passwd", 0644)
+	if err != nil {
+		return err
+	}
+	shadow, err := readDB(root, "etc/shadow", 0640)
+	if err != nil {
+		return err
+	}
+	group, err := readDB(root, "etc/group", 0644)
+	if err != nil {
+		return err
+	}
+
+	// Дни с начала эпохи для поля последней смены пароля
+	now := time.Now()
+	if epoch := SourceDateEpoch(); epoch != nil {
+		now = *epoch
+	}
+	lastChange := strconv.FormatInt(now.Unix()/86400, 10)
+
+	for _, user := range users {
+		password := user.Password
+		if hash, ok := passwords[user.Name]; ok {
+			password = strings.TrimSpace(hash)
+		}
+		if password != "" && !strings.HasPrefix(password, "$") {
+			return fmt.Errorf("user %s: password must be a crypt(3) hash such as $6$..., not plain text", user.Name)
+		}
+
+		created, err := applyUser(root, user, passwd, group, log)
+		if err != nil {
+			return fmt.Errorf("user %s: %w", user.Name, err)
+		}
+
+		// "*" - вход по паролю невозможен, но ключи SSH работают; "!" - блокировка
+		field := password
+		if field == "" {
+			field = "*"
+		}
+		if user.Locked && !strings.HasPrefix(field, "!") {
+			field = "!" + field
+		}
+		if entry := shadow.find(user.Name); entry != nil {
+			if user.Password != "" || user.PasswordSecret != "" || user.Locked {
+				entry[1] = field
+				entry[2] = lastChange
+			}
+		} else {
+			shadow.entries = append(shadow.entries, []string{user.Name, field, lastChange, "0", "99999", "7", "", "", ""})
+		}
+
+		entry := passwd.find(user.Name)
+		uid, _ := strconv.Atoi(entry[2])
+		gid, _ := strconv.Atoi(entry[3])
+		if err := setupHome(root, entry[5], uid, gid, created, user.AuthorizedKeys); err != nil {
+			return fmt.Errorf("user %s: %w", user.Name, err)
+		}
+	}
+
+	dbs := []*dbFile{group, passwd, shadow}
+	if gshadow, err := readDB(root, "etc/gshadow", 0640); err == nil && len(gshadow.entries) > 0 {
+		syncGshadow(gshadow, group)
+		dbs = append(dbs, gshadow)
+	}
+	for _, db := range dbs {
+		if err := db.write(); err != nil {
+			return fmt.Errorf("error writing %s: %w", db.path, err)
+		}
+	}
+	return nil
+}
+
+// syncGshadow добавляет в gshadow новые группы и участников групп
+func syncGshadow(gshadow, group *dbFile) {
+	for _, entry := range group.entries {
+		if len(entry) < 4 {
+			continue
+		}
+		if shadowed := gshadow.find(entry[0]); shadowed != nil && len(shadowed) >= 4 {
+			shadowed[3] = entry[3]
+		} else if shadowed == nil {
+			gshadow.entries = append(gshadow.entries, []string{entry[0], "!", "", entry[3]})
+		}
+	}
+}
+
+// applyUser добавляет или обновляет запись passwd и группы пользователя.
+// Возвращает true, если пользователь создан.
+func applyUser(root string, user structures.User, passwd, group *dbFile, log *slog.Logger) (bool, error) {
+	if strings.ContainsAny(user.Name, ":/ \t\n") {
+		return false, fmt.Errorf("invalid user name")
+	}
+
+	entry := passwd.find(user.Name)
+	created := entry == nil
+	if created {
+		uid := user.UID
+		if uid == 0 {
+			var err error
+			if uid, err = nextID(passwd, group); err != nil {
+				return false, err
+			}
+		} else if passwd.ids()[uid] {
+			return false, fmt.Errorf("uid %d is already taken", uid)
+		}
+
+		// Основная группа с именем пользователя; GID совпадает с UID, если свободен
+		gid := uid
+		if primary := group.find(user.Name); primary != nil {
+			gid, _ = strconv.Atoi(primary[2])
+		} else {
+			if group.ids()[gid] {
+				var err error
+				if gid, err = nextID(group); err != nil {
+					return false, err
+				}
+			}
+			group.entries = append(group.entries, []string{user.Name, "x", strconv.Itoa(gid), ""})
+		}
+
+		entry = []string{user.Name, "x", strconv.Itoa(uid), strconv.Itoa(gid), "", "/home/" + user.Name, "/bin/sh"}
+		passwd.entries = append(passwd.entries, entry)
+	} else if len(entry) < 7 {
+		return false, fmt.Errorf("malformed /etc/passwd entry")
+	} else if user.UID != 0 && entry[2] != strconv.Itoa(user.UID) {
+		return false, fmt.Errorf("already exists with uid %s, not %d", entry[2], user.UID)
+	}
+
+	if user.Comment != "" {
+		entry[4] = user.Comment
+	}
+	if user.Home != "" {
+		entry[5] = user.Home
+	}
+	if user.Shell != "" {
+		if _, err := os.Stat(filepath.Join(root, user.Shell)); err != nil {
+			log.Warn("user shell is not installed", "user", user.Name, "shell", user.Shell)
+		}
+		entry[6] = user.Shell
+	}
+
+	for _, name := range user.Groups {
+		supplementary := group.find(name)
+		if supplementary == nil {
+			gid, err := nextID(group)
+			if err != nil {
+				return false, err
+			}
+			supplementary = []string{name, "x", strconv.Itoa(gid), ""}
+			group.entries = append(group.entries, supplementary)
+		}
+		if len(supplementary) < 4 {
+			return false, fmt.Errorf("malformed /etc/group entry %s", name)
+		}
+		var members []string
+		if supplementary[3] != "" {
+			members = strings.Split(supplementary[3], ",")
+		}
+		if !slices.Contains(members, user.Name) {
+			supplementary[3] = strings.Join(append(members, user.Name), ",")
+		}
+	}
+	return created, nil
+}
+
+// setupHome создает домашний каталог нового пользователя из /etc/skel и
+// записывает authorized_keys
+func setupHome(root, home string, uid, gid int, created bool, keys []string) error {
+	dir, err := resolveIn(root, home)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		if created {
+			if skel, err := resolveIn(root, "etc/skel"); err == nil {
+				if _, err := os.Stat(skel); err == nil {
+					if err := copyTree(skel, dir, uid, gid); err != nil {
+						return fmt.Errorf("error copying /etc/skel: %w", err)
+					}
+				}
+			}
+		}
+		if err := os.Lchown(dir, uid, gid); err != nil {
+			return err
+		}
+		if err := os.Chmod(dir, 0700); err != nil {
+			return err
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+	sshDir := filepath.Join(dir, ".ssh")
+	if err := os.MkdirAll(sshDir, 0700); err != nil {
+		return err
+	}
+	authorized := filepath.Join(sshDir, "authorized_keys")
+	var content strings.Builder
+	for _, key := range keys {
+		content.WriteString(strings.TrimSpace(key))
+		content.WriteString("\n")
+	}
+	if err := os.WriteFile(authorized, []byte(content.String()), 0600); err != nil {
+		return err
+	}
+	for _, path := range []string{sshDir, authorized} {
+		if err := os.Lchown(path, uid, gid); err != nil {
+			return err
+		}
+	}
+	return os.Chmod(sshDir, 0700)
+}
+
+// copyTree копирует содержимое src в dst, назначая файлам владельца uid:gid
+func copyTree(src, dst string, uid, gid int) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir():
+			if err := os.MkdirAll(target, info.Mode().Perm()); err != nil {
+				return err
+			}
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			if err := replaceFile(path, target); err != nil {
+				return err
+			}
+			if err := os.Chmod(target, info.Mode().Perm()); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+		return os.Lchown(target, uid, gid)
+	})
+}
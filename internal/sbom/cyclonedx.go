@@ -0,0 +1,102 @@
+package sbom
+
+import (
+	"time"
+)
+
+type cdxDocument struct {
+	BOMFormat    string         `json:"bomFormat"`
+	SpecVersion  string         `json:"specVersion"`
+	SerialNumber string         `json:"serialNumber"`
+	Version      int            `json:"version"`
+	Metadata     cdxMetadata    `json:"metadata"`
+	Components   []cdxComponent `json:"components"`
+}
+
+type cdxMetadata struct {
+	Timestamp string       `json:"timestamp"`
+	Tools     cdxTools     `json:"tools"`
+	Component cdxComponent `json:"component"`
+}
+
+type cdxTools struct {
+	Components []cdxComponent `json:"components"`
+}
+
+type cdxComponent struct {
+	Type        string        `json:"type"`
+	BOMRef      string        `json:"bom-ref,omitempty"`
+	Name        string        `json:"name"`
+	Version     string        `json:"version,omitempty"`
+	Description string        `json:"description,omitempty"`
+	PURL        string        `json:"purl,omitempty"`
+	Licenses    []cdxLicense  `json:"licenses,omitempty"`
+	Hashes      []cdxHash     `json:"hashes,omitempty"`
+	Properties  []cdxProperty `json:"properties,omitempty"`
+}
+
+type cdxLicense struct {
+	Expression string `json:"expression"`
+}
+
+type cdxHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// WriteCycloneDX записывает инвентарь как документ CycloneDX 1.5 (JSON)
+func WriteCycloneDX(inv *Inventory, path string) error {
+	doc := cdxDocument{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + inv.uuid(),
+		Version:      1,
+		Metadata: cdxMetadata{
+			Timestamp: inv.Created.Format(time.RFC3339),
+			Tools:     cdxTools{Components: []cdxComponent{{Type: "application", Name: "sysweaver"}}},
+			Component: cdxComponent{Type: "operating-system", BOMRef: "image", Name: inv.Name, Version: inv.Version},
+		},
+		Components: []cdxComponent{},
+	}
+
+	for _, pkg := range inv.Packages {
+		c := cdxComponent{
+			Type:        "library",
+			BOMRef:      inv.purl(pkg),
+			Name:        pkg.Name,
+			Version:     pkg.Version,
+			Description: pkg.Description,
+			PURL:        inv.purl(pkg),
+		}
+		if pkg.License != "" {
+			c.Licenses = []cdxLicense{{Expression: pkg.License}}
+		}
+		if pkg.Origin != "" {
+			c.Properties = append(c.Properties, cdxProperty{Name: "sysweaver:apk:origin", Value: pkg.Origin})
+		}
+		if pkg.Commit != "" {
+			c.Properties = append(c.Properties, cdxProperty{Name: "sysweaver:apk:commit", Value: pkg.Commit})
+		}
+		doc.Components = append(doc.Components, c)
+	}
+
+	for _, file := range inv.Files {
+		doc.Components = append(doc.Components, cdxComponent{
+			Type:   "file",
+			BOMRef: "file:/" + file.Path,
+			Name:   "/" + file.Path,
+			Hashes: []cdxHash{
+				{Alg: "SHA-1", Content: file.SHA1},
+				{Alg: "SHA-256", Content: file.SHA256},
+			},
+			Properties: []cdxProperty{{Name: "sysweaver:unpackaged", Value: "true"}},
+		})
+	}
+
+	return writeJSON(path, doc)
+}
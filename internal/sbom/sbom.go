@@ -0,0 +1,186 @@
+package sbom
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"sysweaver/internal/apk"
+	"sysweaver/internal/rootfs"
+)
+
+// Пути, не относящиеся к составу системы: база и кэш пакетного менеджера, временные файлы
+var volatilePaths = []string{"lib/apk/db", "var/cache", "tmp", "var/tmp", "run", "proc", "sys", "dev"}
+
+// File - файл корневой ФС, не принадлежащий ни одному пакету
+type File struct {
+	Path   string // Путь относительно корня без ведущего /
+	Size   int64
+	SHA1   string
+	SHA256 string
+}
+
+// Inventory - состав собранной системы
+type Inventory struct {
+	Name     string
+	Version  string
+	Distro   string // ID из os-release (alpine, ...)
+	Created  time.Time
+	Packages []apk.Package
+	Files    []File
+}
+
+// Collect собирает пакеты из базы apk и файлы вне пакетного менеджера.
+// exclude - служебные пути jail, не попадающие в образ.
+func Collect(root, name, version string, exclude []string) (*Inventory, error) {
+	packages, err := apk.ReadInstalled(root)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(packages, func(i, j int) bool { return packages[i].Name < packages[j].Name })
+
+	inv := &Inventory{
+		Name:     name,
+		Version:  version,
+		Distro:   osReleaseID(root),
+		Created:  time.Now().UTC(),
+		Packages: packages,
+	}
+	if epoch := rootfs.SourceDateEpoch(); epoch != nil {
+		inv.Created = epoch.UTC()
+	}
+
+	skip := append(append([]string{}, exclude...), volatilePaths...)
+	inv.Files, err = unownedFiles(root, apk.FileOwners(packages), skip)
+	if err != nil {
+		return nil, err
+	}
+
+	return inv, nil
+}
+
+// unownedFiles обходит корневую ФС (без перехода в другие ФС) и возвращает
+// обычные файлы, отсутствующие в базе пакетов
+func unownedFiles(root string, owners map[string]*apk.Package, exclude []string) ([]File, error) {
+	rootInfo, err := os.Lstat(root)
+	if err != nil {
+		return nil, fmt.Errorf("rootfs not found: %w", err)
+	}
+	rootDev := rootInfo.Sys().(*syscall.Stat_t).Dev
+
+	excluded := make(map[string]bool, len(exclude))
+	for _, path := range exclude {
+		excluded[strings.Trim(filepath.ToSlash(path), "/")] = true
+	}
+
+	var files []File
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(root, path)
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return nil
+		}
+		if excluded[rel] {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if info.Sys().(*syscall.Stat_t).Dev != rootDev {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || owners[rel] != nil {
+			return nil
+		}
+
+		sha1sum, sha256sum, err := hashFile(path)
+		if err != nil {
+			return fmt.Errorf("error hashing %s: %w", rel, err)
+		}
+		files = append(files, File{Path: rel, Size: info.Size(), SHA1: sha1sum, SHA256: sha256sum})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// hashFile вычисляет SHA-1 (обязателен для файлов в SPDX) и SHA-256 файла
+func hashFile(path string) (string, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer file.Close()
+
+	h1, h256 := sha1.New(), sha256.New()
+	if _, err := io.Copy(io.MultiWriter(h1, h256), file); err != nil {
+		return "", "", err
+	}
+
+	return hex.EncodeToString(h1.Sum(nil)), hex.EncodeToString(h256.Sum(nil)), nil
+}
+
+// osReleaseID возвращает ID дистрибутива из /etc/os-release
+func osReleaseID(root string) string {
+	data, err := os.ReadFile(filepath.Join(root, "etc/os-release"))
+	if err != nil {
+		return "alpine"
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, ok := strings.CutPrefix(line, "ID="); ok {
+			return strings.Trim(value, `"`)
+		}
+	}
+	return "alpine"
+}
+
+// purl возвращает package URL пакета apk
+func (inv *Inventory) purl(pkg apk.Package) string {
+	purl := fmt.Sprintf("pkg:apk/%s/%s@%s", inv.Distro, pkg.Name, pkg.Version)
+	if pkg.Arch != "" {
+		purl += "?arch=" + pkg.Arch
+	}
+	return purl
+}
+
+// uuid формирует детерминированный UUID из содержимого инвентаря,
+// чтобы одинаковые сборки давали одинаковые документы
+func (inv *Inventory) uuid() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00", inv.Name, inv.Version, inv.Created.Format(time.RFC3339))
+	for _, pkg := range inv.Packages {
+		fmt.Fprintf(h, "%s\x00%s\x00", pkg.Name, pkg.Version)
+	}
+	for _, file := range inv.Files {
+		fmt.Fprintf(h, "%s\x00%s\x00", file.Path, file.SHA256)
+	}
+
+	b := h.Sum(nil)[:16]
+	b[6] = b[6]&0x0f | 0x40 // версия 4
+	b[8] = b[8]&0x3f | 0x80 // вариант RFC 4122
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,158 @@
+package sbom
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Files             []spdxFile         `json:"files,omitempty"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	SPDXID           string            `json:"SPDXID"`
+	Name             string            `json:"name"`
+	VersionInfo      string            `json:"versionInfo,omitempty"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	LicenseConcluded string            `json:"licenseConcluded"`
+	LicenseDeclared  string            `json:"licenseDeclared"`
+	CopyrightText    string            `json:"copyrightText"`
+	Supplier         string            `json:"supplier,omitempty"`
+	Homepage         string            `json:"homepage,omitempty"`
+	SourceInfo       string            `json:"sourceInfo,omitempty"`
+	Description      string            `json:"description,omitempty"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxFile struct {
+	SPDXID           string         `json:"SPDXID"`
+	FileName         string         `json:"fileName"`
+	Checksums        []spdxChecksum `json:"checksums"`
+	LicenseConcluded string         `json:"licenseConcluded"`
+	CopyrightText    string         `json:"copyrightText"`
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+// WriteSPDX записывает инвентарь как документ SPDX 2.3 (JSON)
+func WriteSPDX(inv *Inventory, path string) error {
+	const noAssertion = "NOASSERTION"
+
+	doc := spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              inv.Name + "-" + inv.Version,
+		DocumentNamespace: "https://sysweaver.dev/spdxdocs/" + inv.Name + "-" + inv.uuid(),
+		CreationInfo: spdxCreationInfo{
+			Created:  inv.Created.Format(time.RFC3339),
+			Creators: []string{"Tool: sysweaver"},
+		},
+	}
+
+	// Корневой пакет - сам образ
+	doc.Packages = append(doc.Packages, spdxPackage{
+		SPDXID:           "SPDXRef-Image",
+		Name:             inv.Name,
+		VersionInfo:      inv.Version,
+		DownloadLocation: noAssertion,
+		LicenseConcluded: noAssertion,
+		LicenseDeclared:  noAssertion,
+		CopyrightText:    noAssertion,
+	})
+	doc.Relationships = append(doc.Relationships, spdxRelationship{"SPDXRef-DOCUMENT", "DESCRIBES", "SPDXRef-Image"})
+
+	for i, pkg := range inv.Packages {
+		license := pkg.License
+		if license == "" {
+			license = noAssertion
+		}
+
+		p := spdxPackage{
+			SPDXID:           fmt.Sprintf("SPDXRef-Package-%d", i),
+			Name:             pkg.Name,
+			VersionInfo:      pkg.Version,
+			DownloadLocation: noAssertion,
+			LicenseConcluded: noAssertion,
+			LicenseDeclared:  license,
+			CopyrightText:    noAssertion,
+			Homepage:         pkg.URL,
+			Description:      pkg.Description,
+			ExternalRefs: []spdxExternalRef{{
+				ReferenceCategory: "PACKAGE-MANAGER",
+				ReferenceType:     "purl",
+				ReferenceLocator:  inv.purl(pkg),
+			}},
+		}
+		if pkg.Maintainer != "" {
+			p.Supplier = "Person: " + pkg.Maintainer
+		}
+		if pkg.Origin != "" {
+			p.SourceInfo = "built from origin package " + pkg.Origin
+		}
+
+		doc.Packages = append(doc.Packages, p)
+		doc.Relationships = append(doc.Relationships, spdxRelationship{"SPDXRef-Image", "CONTAINS", p.SPDXID})
+	}
+
+	for i, file := range inv.Files {
+		f := spdxFile{
+			SPDXID:   fmt.Sprintf("SPDXRef-File-%d", i),
+			FileName: "./" + file.Path,
+			Checksums: []spdxChecksum{
+				{Algorithm: "SHA1", ChecksumValue: file.SHA1},
+				{Algorithm: "SHA256", ChecksumValue: file.SHA256},
+			},
+			LicenseConcluded: noAssertion,
+			CopyrightText:    noAssertion,
+		}
+
+		doc.Files = append(doc.Files, f)
+		doc.Relationships = append(doc.Relationships, spdxRelationship{"SPDXRef-Image", "CONTAINS", f.SPDXID})
+	}
+
+	return writeJSON(path, doc)
+}
+
+// writeJSON записывает документ с отступами
+func writeJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding SBOM: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing SBOM: %w", err)
+	}
+	return nil
+}
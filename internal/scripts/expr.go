@@ -0,0 +1,331 @@
+package scripts
+
+import (
+	"cmp"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"strconv"
+
+	"sysweaver/internal/funcs"
+)
+
+// Условие when - выражение в синтаксисе Go над значениями итоговой конфигурации
+// (тех же, что в /etc/sysweaver/build.json):
+//
+//	iso.enabled
+//	!features.docker
+//	system.hostname == "edge01" || system.hostname == "edge02"
+//	partitions[0].filesystem == "ext4" && len(packages) > 10
+//	semverCompare(">=3.18", version) && env("CI", "") == ""
+//
+// Поддерживаются операторы ==, !=, <, <=, >, >=, &&, ||, !, функция len,
+// функции библиотеки funcs и литералы строк, чисел, true, false и nil.
+// Отсутствующее значение - nil. Ложны nil, false, 0, пустая строка, пустые
+// список и словарь.
+
+// Eval вычисляет условие expr над значениями конфигурации values; пути
+// функции file отсчитываются от каталога шаблона dir
+func Eval(expr string, values map[string]interface{}, dir string) (bool, error) {
+	node, err := parseExpr(expr)
+	if err != nil {
+		return false, err
+	}
+	e := &evaluator{values: values, funcs: funcs.Map(dir)}
+	value, err := e.eval(node)
+	if err != nil {
+		return false, err
+	}
+	return truthy(value), nil
+}
+
+func parseExpr(expr string) (ast.Expr, error) {
+	node, err := parser.ParseExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Проверяем, что выражение использует только поддерживаемые конструкции
+	known := funcs.Map("")
+	var unsupported ast.Node
+	var inspect func(n ast.Node) bool
+	inspect = func(n ast.Node) bool {
+		switch n := n.(type) {
+		case nil, *ast.Ident, *ast.BasicLit, *ast.ParenExpr, *ast.SelectorExpr, *ast.IndexExpr:
+		case *ast.UnaryExpr:
+			if n.Op != token.NOT && n.Op != token.SUB {
+				unsupported = n
+			}
+		case *ast.BinaryExpr:
+			switch n.Op {
+			case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ, token.LAND, token.LOR:
+			default:
+				unsupported = n
+			}
+		case *ast.CallExpr:
+			fun, ok := n.Fun.(*ast.Ident)
+			if !ok || (fun.Name == "len" && len(n.Args) != 1) || (fun.Name != "len" && known[fun.Name] == nil) {
+				unsupported = n
+			}
+			// Имя функции - не обращение к значению конфигурации
+			for _, arg := range n.Args {
+				ast.Inspect(arg, inspect)
+			}
+			return false
+		default:
+			unsupported = n
+		}
+		return unsupported == nil
+	}
+	ast.Inspect(node, inspect)
+	if unsupported != nil {
+		return nil, fmt.Errorf("unsupported expression at column %d", unsupported.Pos())
+	}
+
+	return node, nil
+}
+
+// evaluator вычисляет выражение над значениями конфигурации
+type evaluator struct {
+	values map[string]interface{}
+	funcs  map[string]interface{}
+}
+
+func (e *evaluator) eval(node ast.Expr) (interface{}, error) {
+	switch n := node.(type) {
+	case *ast.ParenExpr:
+		return e.eval(n.X)
+
+	case *ast.BasicLit:
+		switch n.Kind {
+		case token.STRING, token.CHAR:
+			return strconv.Unquote(n.Value)
+		default:
+			return strconv.ParseFloat(n.Value, 64)
+		}
+
+	case *ast.Ident:
+		switch n.Name {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "nil":
+			return nil, nil
+		}
+		return e.values[n.Name], nil
+
+	case *ast.SelectorExpr:
+		base, err := e.eval(n.X)
+		if err != nil {
+			return nil, err
+		}
+		if m, ok := base.(map[string]interface{}); ok {
+			return m[n.Sel.Name], nil
+		}
+		return nil, nil
+
+	case *ast.IndexExpr:
+		base, err := e.eval(n.X)
+		if err != nil {
+			return nil, err
+		}
+		key, err := e.eval(n.Index)
+		if err != nil {
+			return nil, err
+		}
+		switch b := base.(type) {
+		case []interface{}:
+			if i, ok := key.(float64); ok && i >= 0 && int(i) < len(b) {
+				return b[int(i)], nil
+			}
+		case map[string]interface{}:
+			return b[fmt.Sprint(key)], nil
+		}
+		return nil, nil
+
+	case *ast.CallExpr:
+		name := n.Fun.(*ast.Ident).Name
+		args := make([]interface{}, len(n.Args))
+		for i, arg := range n.Args {
+			value, err := e.eval(arg)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = value
+		}
+		if name != "len" {
+			return e.call(name, args)
+		}
+		switch a := args[0].(type) {
+		case string:
+			return float64(len(a)), nil
+		case []interface{}:
+			return float64(len(a)), nil
+		case map[string]interface{}:
+			return float64(len(a)), nil
+		}
+		return float64(0), nil
+
+	case *ast.UnaryExpr:
+		x, err := e.eval(n.X)
+		if err != nil {
+			return nil, err
+		}
+		if n.Op == token.NOT {
+			return !truthy(x), nil
+		}
+		if f, ok := x.(float64); ok {
+			return -f, nil
+		}
+		return nil, fmt.Errorf("cannot negate %v", x)
+
+	case *ast.BinaryExpr:
+		x, err := e.eval(n.X)
+		if err != nil {
+			return nil, err
+		}
+
+		// && и || вычисляются сокращенно
+		switch n.Op {
+		case token.LAND:
+			if !truthy(x) {
+				return false, nil
+			}
+			y, err := e.eval(n.Y)
+			return truthy(y), err
+		case token.LOR:
+			if truthy(x) {
+				return true, nil
+			}
+			y, err := e.eval(n.Y)
+			return truthy(y), err
+		}
+
+		y, err := e.eval(n.Y)
+		if err != nil {
+			return nil, err
+		}
+		switch n.Op {
+		case token.EQL:
+			return equal(x, y), nil
+		case token.NEQ:
+			return !equal(x, y), nil
+		}
+		return compare(n.Op, x, y)
+	}
+
+	return nil, fmt.Errorf("unsupported expression")
+}
+
+// call вызывает функцию библиотеки funcs. Аргументы приводятся к типам
+// параметров (числа - к строкам для строковых параметров), целые результаты -
+// к float64, как числа конфигурации.
+func (e *evaluator) call(name string, args []interface{}) (interface{}, error) {
+	fn := reflect.ValueOf(e.funcs[name])
+	t := fn.Type()
+	if len(args) < t.NumIn()-1 || (!t.IsVariadic() && len(args) != t.NumIn()) {
+		return nil, fmt.Errorf("%s: wrong number of arguments: %d", name, len(args))
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		param := t.In(min(i, t.NumIn()-1))
+		if t.IsVariadic() && i >= t.NumIn()-1 {
+			param = param.Elem()
+		}
+		switch {
+		case param.Kind() == reflect.String:
+			if arg == nil {
+				arg = ""
+			}
+			in[i] = reflect.ValueOf(fmt.Sprint(arg))
+		case arg == nil:
+			in[i] = reflect.Zero(param)
+		default:
+			in[i] = reflect.ValueOf(arg)
+		}
+	}
+
+	out := fn.Call(in)
+	if len(out) == 2 && !out[1].IsNil() {
+		return nil, fmt.Errorf("%s: %w", name, out[1].Interface().(error))
+	}
+	return normalize(out[0].Interface()), nil
+}
+
+// normalize приводит целые числа результата функции к float64
+func normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[key] = normalize(item)
+		}
+		return m
+	}
+	return value
+}
+
+// equal сравнивает значения; значения разных типов сравниваются как строки
+func equal(x, y interface{}) bool {
+	if x == nil || y == nil {
+		return x == nil && y == nil
+	}
+	switch x.(type) {
+	case []interface{}, map[string]interface{}:
+		return false
+	}
+	return fmt.Sprint(x) == fmt.Sprint(y)
+}
+
+// compare сравнивает числа или строки
+func compare(op token.Token, x, y interface{}) (bool, error) {
+	var c int
+	xf, xok := x.(float64)
+	yf, yok := y.(float64)
+	xs, xsok := x.(string)
+	ys, ysok := y.(string)
+
+	switch {
+	case xok && yok:
+		c = cmp.Compare(xf, yf)
+	case xsok && ysok:
+		c = cmp.Compare(xs, ys)
+	default:
+		return false, fmt.Errorf("cannot compare %v and %v", x, y)
+	}
+
+	switch op {
+	case token.LSS:
+		return c < 0, nil
+	case token.LEQ:
+		return c <= 0, nil
+	case token.GTR:
+		return c > 0, nil
+	default:
+		return c >= 0, nil
+	}
+}
+
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	case []interface{}:
+		return len(v) > 0
+	case map[string]interface{}:
+		return len(v) > 0
+	}
+	return true
+}
@@ -0,0 +1,283 @@
+package scripts
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Необязательный scripts.yaml в корне шаблона описывает скрипты стадий:
+//
+//	install:
+//	  10-packages.sh:
+//	    description: Install the base package set
+//	    depends_on: [05-repos.sh]
+//	  50-docker.sh:
+//	    when: features.docker && system.hostname != "builder"
+//	    continue_on_error: true
+//	  60-fetch.sh:
+//	    retries: 3
+//	    retry_delay: 30s
+//	configure:
+//	  zz-final.sh:
+//	    order: 100
+//	  20-network.py:
+//	    interpreter: python3
+//	    env:
+//	      NETWORK_PROFILE: lab
+//
+// Скрипты стадии выполняются по возрастанию order (по умолчанию 0), при
+// равном order - по имени; depends_on переносит скрипт после его
+// зависимостей из той же стадии. Скрипт, зависимость которого пропущена
+// или завершилась ошибкой, пропускается. Выражения when описаны в expr.go.
+//
+// Кроме *.sh выполняются файлы любых расширений, перечисленные в scripts.yaml.
+// Интерпретатор берется из interpreter (например bash, python3, "busybox sh"),
+// затем из строки #! скрипта; без них скрипт выполняется /bin/sh. Переменные
+// env добавляются к окружению скрипта. Без description описанием скрипта
+// служит первая строка комментария в его начале.
+//
+// Упавший скрипт с retries перезапускается до retries раз с паузой retry_delay
+// (по умолчанию 5s) между попытками.
+
+// ManifestFile - имя файла описания скриптов в шаблоне
+const ManifestFile = "scripts.yaml"
+
+// Script - параметры скрипта из scripts.yaml
+type Script struct {
+	Description     string            `yaml:"description"`
+	Order           int               `yaml:"order"`
+	DependsOn       []string          `yaml:"depends_on"`
+	When            string            `yaml:"when"`
+	ContinueOnError bool              `yaml:"continue_on_error"`
+	Interpreter     string            `yaml:"interpreter"`
+	Env             map[string]string `yaml:"env"`
+	Retries         int               `yaml:"retries"`
+	RetryDelay      time.Duration     `yaml:"retry_delay"`
+}
+
+// DefaultRetryDelay - пауза между попытками, если retry_delay не задан
+const DefaultRetryDelay = 5 * time.Second
+
+// Manifest - описания скриптов по стадиям и именам
+type Manifest map[string]map[string]Script
+
+// Step - скрипт стадии в порядке выполнения
+type Step struct {
+	Name string
+	Path string
+	Script
+}
+
+// LoadManifest читает scripts.yaml; отсутствующий файл - пустое описание.
+// Стадии проверяются по списку stages, выражения when - на синтаксис.
+func LoadManifest(path string, stages []string) (Manifest, error) {
+	manifest := Manifest{}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", path, err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&manifest); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+
+	for stage, scripts := range manifest {
+		if !slices.Contains(stages, stage) {
+			return nil, fmt.Errorf("%s: unknown stage %q (available: %s)", path, stage, strings.Join(stages, ", "))
+		}
+		for name, script := range scripts {
+			if script.Retries < 0 || script.RetryDelay < 0 {
+				return nil, fmt.Errorf("%s: %s/%s: retries and retry_delay must not be negative", path, stage, name)
+			}
+			if script.When == "" {
+				continue
+			}
+			if _, err := parseExpr(script.When); err != nil {
+				return nil, fmt.Errorf("%s: %s/%s: invalid when expression: %w", path, stage, name, err)
+			}
+		}
+	}
+
+	return manifest, nil
+}
+
+// Plan возвращает скрипты стадии из каталога dir в порядке выполнения
+func (m Manifest) Plan(stage, dir string) ([]Step, error) {
+	declared := m[stage]
+
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var steps []Step
+	index := make(map[string]int)
+	for _, entry := range entries {
+		_, listed := declared[entry.Name()]
+		if entry.IsDir() || (filepath.Ext(entry.Name()) != ".sh" && !listed) {
+			continue
+		}
+		index[entry.Name()] = len(steps)
+		steps = append(steps, Step{Name: entry.Name(), Path: filepath.Join(dir, entry.Name()), Script: declared[entry.Name()]})
+	}
+
+	for name, script := range declared {
+		if _, ok := index[name]; !ok {
+			return nil, fmt.Errorf("%s: %s/%s: no such script in scripts/%s", ManifestFile, stage, name, stage)
+		}
+		for _, dep := range script.DependsOn {
+			if _, ok := index[dep]; !ok {
+				return nil, fmt.Errorf("%s: %s/%s: unknown dependency %q", ManifestFile, stage, name, dep)
+			}
+		}
+	}
+
+	// Порядок по умолчанию: order, затем имя
+	sort.SliceStable(steps, func(i, j int) bool {
+		if steps[i].Order != steps[j].Order {
+			return steps[i].Order < steps[j].Order
+		}
+		return steps[i].Name < steps[j].Name
+	})
+
+	return orderByDependencies(stage, steps)
+}
+
+// Command возвращает команду запуска скрипта, доступного в chroot по пути chrootPath
+func (s Step) Command(chrootPath string) ([]string, error) {
+	if s.Interpreter != "" {
+		return append(strings.Fields(s.Interpreter), chrootPath), nil
+	}
+
+	file, err := os.Open(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	line, _ := bufio.NewReader(file).ReadString('\n')
+	if shebang, ok := strings.CutPrefix(strings.TrimSpace(line), "#!"); ok {
+		// Как и ядро, передаем остаток строки после интерпретатора одним аргументом
+		interpreter, arg, _ := strings.Cut(strings.TrimSpace(shebang), " ")
+		if interpreter != "" {
+			command := []string{interpreter}
+			if arg = strings.TrimSpace(arg); arg != "" {
+				command = append(command, arg)
+			}
+			return append(command, chrootPath), nil
+		}
+	}
+
+	return []string{"/bin/sh", chrootPath}, nil
+}
+
+// Attempts возвращает число попыток выполнения скрипта
+func (s Step) Attempts() int {
+	return 1 + s.Retries
+}
+
+// Delay возвращает паузу перед повторной попыткой
+func (s Step) Delay() time.Duration {
+	if s.RetryDelay == 0 {
+		return DefaultRetryDelay
+	}
+	return s.RetryDelay
+}
+
+// Summary возвращает описание скрипта: description из scripts.yaml или первую
+// непустую строку комментария в начале файла
+func (s Step) Summary() string {
+	if s.Description != "" {
+		return s.Description
+	}
+
+	file, err := os.Open(s.Path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for first := true; scanner.Scan(); first = false {
+		line := strings.TrimSpace(scanner.Text())
+		if (first && strings.HasPrefix(line, "#!")) || line == "" {
+			continue
+		}
+		text, ok := strings.CutPrefix(line, "#")
+		if !ok {
+			break
+		}
+		if text = strings.TrimSpace(text); text != "" && !strings.HasPrefix(text, "shellcheck ") {
+			return text
+		}
+	}
+	return ""
+}
+
+// Environ возвращает переменные env скрипта в формате NAME=value
+func (s Step) Environ() []string {
+	env := make([]string, 0, len(s.Env))
+	for name, value := range s.Env {
+		env = append(env, name+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// orderByDependencies переставляет скрипты так, чтобы зависимости шли раньше,
+// сохраняя порядок по умолчанию для независимых скриптов
+func orderByDependencies(stage string, steps []Step) ([]Step, error) {
+	done := make(map[string]bool)
+	ordered := make([]Step, 0, len(steps))
+
+	for len(ordered) < len(steps) {
+		progress := false
+		for _, step := range steps {
+			if done[step.Name] || !allDone(step.DependsOn, done) {
+				continue
+			}
+			done[step.Name] = true
+			ordered = append(ordered, step)
+			progress = true
+			break
+		}
+
+		if !progress {
+			var blocked []string
+			for _, step := range steps {
+				if !done[step.Name] {
+					blocked = append(blocked, step.Name)
+				}
+			}
+			return nil, fmt.Errorf("%s: dependency cycle in stage %s: %s", ManifestFile, stage, strings.Join(blocked, ", "))
+		}
+	}
+
+	return ordered, nil
+}
+
+func allDone(names []string, done map[string]bool) bool {
+	for _, name := range names {
+		if !done[name] {
+			return false
+		}
+	}
+	return true
+}
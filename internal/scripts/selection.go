@@ -0,0 +1,97 @@
+package scripts
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Selection - выбор скриптов флагами build --skip, --only, --from и --until.
+// Скрипт задается именем (50-build-iso.sh) или стадией и именем
+// (image/50-build-iso.sh); допускаются шаблоны path.Match (9*.sh).
+type Selection struct {
+	Skip  []string
+	Only  []string
+	From  string
+	Until string
+}
+
+// Empty сообщает, что выбор не задан и выполняются все скрипты
+func (s Selection) Empty() bool {
+	return len(s.Skip) == 0 && len(s.Only) == 0 && s.From == "" && s.Until == ""
+}
+
+// Excluded возвращает скрипты, исключенные выбором, с причиной пропуска
+// (ключ - stage/name). plans - скрипты стадий stages в порядке выполнения;
+// --from и --until отсчитываются по общему порядку всех стадий.
+func (s Selection) Excluded(stages []string, plans map[string][]Step) (map[string]string, error) {
+	type ref struct{ stage, name string }
+	var all []ref
+	for _, stage := range stages {
+		for _, step := range plans[stage] {
+			all = append(all, ref{stage, step.Name})
+		}
+	}
+
+	matches := func(pattern string) func(ref) bool {
+		return func(r ref) bool { return matchScript(pattern, r.stage, r.name) }
+	}
+
+	// Каждый шаблон должен быть корректным и находить хотя бы один скрипт
+	patterns := append(slices.Clone(s.Skip), s.Only...)
+	for _, pattern := range []string{s.From, s.Until} {
+		if pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid script pattern %q", pattern)
+		}
+		if !slices.ContainsFunc(all, matches(pattern)) {
+			return nil, fmt.Errorf("%q matches no script in stages %s", pattern, strings.Join(stages, ", "))
+		}
+	}
+
+	from, until := 0, len(all)-1
+	if s.From != "" {
+		from = slices.IndexFunc(all, matches(s.From))
+	}
+	if s.Until != "" {
+		for i := len(all) - 1; i >= 0; i-- {
+			if matches(s.Until)(all[i]) {
+				until = i
+				break
+			}
+		}
+	}
+	if from > until {
+		return nil, fmt.Errorf("--from %s comes after --until %s", s.From, s.Until)
+	}
+
+	excluded := make(map[string]string)
+	for i, r := range all {
+		key := r.stage + "/" + r.name
+		switch {
+		case i < from:
+			excluded[key] = "before --from " + s.From
+		case i > until:
+			excluded[key] = "after --until " + s.Until
+		case len(s.Only) > 0 && !slices.ContainsFunc(s.Only, func(p string) bool { return matchScript(p, r.stage, r.name) }):
+			excluded[key] = "not selected by --only"
+		case slices.ContainsFunc(s.Skip, func(p string) bool { return matchScript(p, r.stage, r.name) }):
+			excluded[key] = "excluded by --skip"
+		}
+	}
+	return excluded, nil
+}
+
+// matchScript сообщает, соответствует ли скрипт шаблону имени или stage/name
+func matchScript(pattern, stage, name string) bool {
+	if ok, _ := path.Match(pattern, name); ok {
+		return true
+	}
+	ok, _ := path.Match(pattern, stage+"/"+name)
+	return ok
+}
@@ -0,0 +1,269 @@
+package secrets
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+
+	"sysweaver/internal/structures"
+)
+
+// Dir - каталог секретов внутри jail (tmpfs, не попадает в rootfs)
+const Dir = "/run/sysweaver/secrets"
+
+// Замена значения секрета в логах
+const mask = "********"
+
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+
+// Resolve получает значения секретов из их источников
+func Resolve(list []structures.Secret, templateDir string) (map[string][]byte, error) {
+	values := make(map[string][]byte, len(list))
+	for _, secret := range list {
+		if !namePattern.MatchString(secret.Name) {
+			return nil, fmt.Errorf("invalid secret name %q", secret.Name)
+		}
+		if _, ok := values[secret.Name]; ok {
+			return nil, fmt.Errorf("duplicate secret %s", secret.Name)
+		}
+
+		value, err := resolve(secret, templateDir)
+		if err != nil {
+			return nil, fmt.Errorf("error resolving secret %s: %w", secret.Name, err)
+		}
+		values[secret.Name] = value
+	}
+	return values, nil
+}
+
+func resolve(secret structures.Secret, templateDir string) ([]byte, error) {
+	sources := 0
+	for _, source := range []string{secret.Env, secret.Sops, secret.Vault} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return nil, fmt.Errorf("exactly one of env, sops or vault must be set")
+	}
+
+	switch {
+	case secret.Env != "":
+		value, ok := os.LookupEnv(secret.Env)
+		if !ok {
+			return nil, fmt.Errorf("environment variable %s is not set", secret.Env)
+		}
+		return []byte(value), nil
+
+	case secret.Sops != "":
+		path := secret.Sops
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(templateDir, path)
+		}
+		args := []string{"--decrypt"}
+		if secret.Key != "" {
+			args = append(args, "--extract", sopsPath(secret.Key))
+		}
+		return run("sops", append(args, path)...)
+
+	default:
+		if secret.Field == "" {
+			return nil, fmt.Errorf("vault secrets require field")
+		}
+		value, err := run("vault", "kv", "get", "-field="+secret.Field, secret.Vault)
+		return bytes.TrimSuffix(value, []byte("\n")), err
+	}
+}
+
+// sopsPath преобразует a.b.0 в синтаксис --extract: ["a"]["b"][0]
+func sopsPath(key string) string {
+	var b strings.Builder
+	for _, part := range strings.Split(key, ".") {
+		if isIndex(part) {
+			fmt.Fprintf(&b, "[%s]", part)
+		} else {
+			fmt.Fprintf(&b, "[%q]", part)
+		}
+	}
+	return b.String()
+}
+
+func isIndex(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// run выполняет утилиту и возвращает ее вывод; stderr не содержит значения секрета
+func run(name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return output, nil
+}
+
+// Write записывает секреты в каталог dir с правами только для root
+func Write(dir string, values map[string][]byte) error {
+	for name, value := range values {
+		if err := os.WriteFile(filepath.Join(dir, name), value, 0400); err != nil {
+			return fmt.Errorf("error writing secret %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Redactor заменяет значения секретов в потоке логов.
+// Вывод буферизуется до конца строки, чтобы значение не разрывалось между записями.
+type Redactor struct {
+	w       io.Writer
+	values  [][]byte
+	pending []byte
+}
+
+// NewRedactor создает Redactor поверх w
+func NewRedactor(w io.Writer, values map[string][]byte) *Redactor {
+	r := &Redactor{w: w}
+	for _, value := range values {
+		// Короткие значения дали бы ложные замены в обычном выводе
+		if len(bytes.TrimSpace(value)) >= 4 {
+			r.values = append(r.values, bytes.TrimSpace(value))
+		}
+	}
+	return r
+}
+
+func (r *Redactor) Write(p []byte) (int, error) {
+	r.pending = append(r.pending, p...)
+
+	// Отдаем все завершенные строки (\n или \r для индикаторов прогресса)
+	end := bytes.LastIndexAny(r.pending, "\r\n")
+	if end < 0 {
+		return len(p), nil
+	}
+
+	if _, err := r.w.Write(r.Redact(r.pending[:end+1])); err != nil {
+		return 0, err
+	}
+	r.pending = append(r.pending[:0], r.pending[end+1:]...)
+	return len(p), nil
+}
+
+// Flush выводит незавершенную строку
+func (r *Redactor) Flush() error {
+	if len(r.pending) == 0 {
+		return nil
+	}
+	_, err := r.w.Write(r.Redact(r.pending))
+	r.pending = r.pending[:0]
+	return err
+}
+
+// Redact заменяет значения секретов в data
+func (r *Redactor) Redact(data []byte) []byte {
+	for _, value := range r.values {
+		data = bytes.ReplaceAll(data, value, []byte(mask))
+	}
+	return data
+}
+
+// Scan ищет значения секретов в файлах корневой ФС и возвращает пути найденных.
+// Каталоги из exclude (относительно root) и другие файловые системы пропускаются.
+func Scan(root string, values map[string][]byte, exclude []string) ([]string, error) {
+	var needles [][]byte
+	longest := 0
+	for _, value := range values {
+		value = bytes.TrimSpace(value)
+		if len(value) >= 4 {
+			needles = append(needles, value)
+			longest = max(longest, len(value))
+		}
+	}
+	if len(needles) == 0 {
+		return nil, nil
+	}
+
+	rootInfo, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	rootDev := rootInfo.Sys().(*syscall.Stat_t).Dev
+
+	var found []string
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, path)
+		if info.IsDir() {
+			for _, skip := range exclude {
+				if rel == skip {
+					return filepath.SkipDir
+				}
+			}
+			if info.Sys().(*syscall.Stat_t).Dev != rootDev {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		match, err := fileContains(path, needles, longest)
+		if err != nil {
+			return nil
+		}
+		if match {
+			found = append(found, "/"+rel)
+		}
+		return nil
+	})
+	return found, err
+}
+
+// fileContains ищет любую из подстрок в файле, читая его блоками с перекрытием
+func fileContains(path string, needles [][]byte, longest int) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	buf := make([]byte, 0, 1<<20+longest)
+	chunk := make([]byte, 1<<20)
+	for {
+		n, err := file.Read(chunk)
+		buf = append(buf, chunk[:n]...)
+		for _, needle := range needles {
+			if bytes.Contains(buf, needle) {
+				return true, nil
+			}
+		}
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		// Сохраняем хвост, чтобы найти значение на границе блоков
+		if keep := longest - 1; len(buf) > keep {
+			buf = append(buf[:0], buf[len(buf)-keep:]...)
+		}
+	}
+}
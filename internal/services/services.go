@@ -0,0 +1,119 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Службы из services: конфигурации включаются и отключаются командами
+// системы инициализации внутри jail: rc-update для OpenRC, systemctl для
+// systemd. Перед этим проверяется, что скрипт init.d или юнит установлен.
+
+// Системы инициализации, которыми управляются службы
+const (
+	OpenRC  = "openrc"
+	Systemd = "systemd"
+)
+
+// Каталоги юнитов systemd относительно корня ФС
+var unitDirs = []string{"etc/systemd/system", "usr/lib/systemd/system", "lib/systemd/system"}
+
+// Detect определяет систему инициализации корневой ФС root. Возвращает
+// пустую строку, если ни OpenRC, ни systemd не установлены.
+func Detect(root string) string {
+	for _, path := range []string{"usr/lib/systemd/systemd", "lib/systemd/systemd"} {
+		if _, err := os.Stat(filepath.Join(root, path)); err == nil {
+			return Systemd
+		}
+	}
+	for _, path := range []string{"sbin/rc-update", "usr/sbin/rc-update", "sbin/openrc"} {
+		if _, err := os.Stat(filepath.Join(root, path)); err == nil {
+			return OpenRC
+		}
+	}
+	return ""
+}
+
+// Service - служба из списка enable или disable
+type Service struct {
+	Name     string // Имя скрипта init.d или юнита systemd
+	Runlevel string // Уровень запуска OpenRC
+}
+
+// Parse разбирает запись списка: name или name:runlevel (только OpenRC).
+// Для systemd имя без суффикса дополняется .service.
+func Parse(initSystem, entry string) (Service, error) {
+	name, runlevel, _ := strings.Cut(strings.TrimSpace(entry), ":")
+	if name == "" || strings.Contains(name, "/") {
+		return Service{}, fmt.Errorf("invalid service %q", entry)
+	}
+
+	switch initSystem {
+	case OpenRC:
+		if runlevel == "" {
+			runlevel = "default"
+		}
+	case Systemd:
+		if runlevel != "" {
+			return Service{}, fmt.Errorf("service %q: runlevels are not supported by systemd", entry)
+		}
+		if !strings.Contains(name, ".") {
+			name += ".service"
+		}
+	}
+	return Service{Name: name, Runlevel: runlevel}, nil
+}
+
+// Check проверяет, что служба установлена в корневой ФС root
+func Check(root, initSystem string, service Service) error {
+	switch initSystem {
+	case OpenRC:
+		if _, err := os.Stat(filepath.Join(root, "etc/init.d", service.Name)); err != nil {
+			return fmt.Errorf("service %s not found in /etc/init.d", service.Name)
+		}
+	case Systemd:
+		// Экземпляры шаблонов (getty@tty1.service) описываются юнитом getty@.service
+		unit := service.Name
+		if prefix, suffix, ok := strings.Cut(unit, "@"); ok {
+			if dot := strings.LastIndex(suffix, "."); dot >= 0 {
+				unit = prefix + "@" + suffix[dot:]
+			}
+		}
+		for _, dir := range unitDirs {
+			if _, err := os.Lstat(filepath.Join(root, dir, unit)); err == nil {
+				return nil
+			}
+		}
+		return fmt.Errorf("unit %s not found in /%s", service.Name, strings.Join(unitDirs, ", /"))
+	}
+	return nil
+}
+
+// EnableCommand возвращает команду включения службы
+func EnableCommand(initSystem string, service Service) []string {
+	if initSystem == OpenRC {
+		return []string{"rc-update", "add", service.Name, service.Runlevel}
+	}
+	return []string{"systemctl", "enable", service.Name}
+}
+
+// Enabled сообщает, включена ли служба OpenRC на своем уровне запуска.
+// Для systemd всегда true: systemctl disable не считает ошибкой
+// отключенную службу.
+func Enabled(root, initSystem string, service Service) bool {
+	if initSystem != OpenRC {
+		return true
+	}
+	_, err := os.Lstat(filepath.Join(root, "etc/runlevels", service.Runlevel, service.Name))
+	return err == nil
+}
+
+// DisableCommand возвращает команду отключения службы
+func DisableCommand(initSystem string, service Service) []string {
+	if initSystem == OpenRC {
+		return []string{"rc-update", "del", service.Name, service.Runlevel}
+	}
+	return []string{"systemctl", "disable", service.Name}
+}
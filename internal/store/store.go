@@ -0,0 +1,278 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"sysweaver/internal/digest"
+)
+
+// Результаты сборки
+const (
+	ResultSuccess = "success"
+	ResultFailed  = "failed"
+)
+
+// Artifact - артефакт сборки
+type Artifact struct {
+	Name    string          `json:"name"`
+	Path    string          `json:"path"`
+	Size    int64           `json:"size"`
+	Digests []digest.Digest `json:"digests,omitempty"`
+}
+
+// System - имя хоста, часовой пояс и локаль собранной системы
+type System struct {
+	Hostname string `json:"hostname,omitempty"`
+	Timezone string `json:"timezone,omitempty"`
+	Locale   string `json:"locale,omitempty"`
+}
+
+// PackageRef - пакет, установленный в собранную систему
+type PackageRef struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Origin  string `json:"origin,omitempty"`
+	License string `json:"license,omitempty"`
+}
+
+// Download - загруженный файл и зеркало, с которого он получен
+type Download struct {
+	Artifact string          `json:"artifact"`
+	URL      string          `json:"url,omitempty"`
+	Mirror   string          `json:"mirror"`
+	Size     int64           `json:"size,omitempty"`
+	Digests  []digest.Digest `json:"digests,omitempty"`
+}
+
+// Publication - ресурс, созданный публикацией артефактов
+type Publication struct {
+	Target string `json:"target"`
+	ID     string `json:"id"`
+}
+
+// StageRun - выполнение стадии сборки
+type StageRun struct {
+	Name      string    `json:"name"`
+	StartedAt time.Time `json:"started_at"`
+	Duration  float64   `json:"duration_seconds"`
+	Result    string    `json:"result"`
+}
+
+// ScriptRun - выполнение одного скрипта стадии
+type ScriptRun struct {
+	Stage     string    `json:"stage"`
+	Name      string    `json:"name"`
+	StartedAt time.Time `json:"started_at"`
+	Duration  float64   `json:"duration_seconds"`
+	ExitCode  int       `json:"exit_code"`
+	Attempts  int       `json:"attempts,omitempty"` // Число попыток, если скрипт выполнялся
+	Skipped   string    `json:"skipped,omitempty"`  // Причина, по которой скрипт не выполнялся
+	Log       string    `json:"log,omitempty"`      // Файл с полным выводом скрипта
+
+	// Расход ресурсов выполненного скрипта
+	CPU        float64 `json:"cpu_seconds,omitempty"`
+	PeakMemory int64   `json:"peak_memory_bytes,omitempty"`
+	Written    int64   `json:"written_bytes,omitempty"`
+}
+
+// TestResult - результат проверки sw_assert* скрипта стадии test
+type TestResult struct {
+	Script    string `json:"script"`
+	Assertion string `json:"assertion"`
+	Passed    bool   `json:"passed"`
+	Detail    string `json:"detail,omitempty"`
+}
+
+// TemplateSource - git-репозиторий, из которого получен шаблон
+type TemplateSource struct {
+	URL    string `json:"url"`
+	Ref    string `json:"ref,omitempty"`
+	Commit string `json:"commit"`
+	Subdir string `json:"subdir,omitempty"`
+}
+
+// Record - запись о сборке в локальном хранилище артефактов
+type Record struct {
+	ID         string          `json:"id"`
+	Template   string          `json:"template"`
+	Source     *TemplateSource `json:"source,omitempty"`      // Для шаблонов из git-репозитория
+	Layers     []string        `json:"layers,omitempty"`      // Каталоги базовых шаблонов и самого шаблона
+	ConfigHash string          `json:"config_hash,omitempty"` // SHA256 итоговой конфигурации сборки
+	Name       string          `json:"name"`
+	Version    string          `json:"version"`
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt time.Time       `json:"finished_at"`
+	Result     string          `json:"result"`
+	Error      string          `json:"error,omitempty"`
+	OutputDir  string          `json:"output_dir"`
+	Artifacts  []Artifact      `json:"artifacts"`
+	Packages   []PackageRef    `json:"packages"`
+	System     *System         `json:"system,omitempty"` // Примененные настройки system конфигурации
+	Stages     []StageRun      `json:"stages,omitempty"`
+	Scripts    []ScriptRun     `json:"scripts,omitempty"`
+	Tests      []TestResult    `json:"tests,omitempty"` // Проверки sw_assert* скриптов стадии test
+	Downloads  []Download      `json:"downloads,omitempty"`
+	Published  []Publication   `json:"published,omitempty"`
+	Warnings   []string        `json:"warnings,omitempty"` // Предупреждения, выведенные во время сборки
+
+	Annotations map[string]string `json:"annotations,omitempty"` // Пометки хуков сборки
+}
+
+// Store - локальное хранилище записей о сборках.
+// Каждая сборка хранится в builds/<id>/record.json внутри директории хранилища.
+type Store struct {
+	dir string
+}
+
+// DefaultDir возвращает директорию состояния SysWeaver по умолчанию:
+// $SYSWEAVER_STATE_DIR, /var/lib/sysweaver для root или ~/.local/share/sysweaver
+func DefaultDir() string {
+	if dir := os.Getenv("SYSWEAVER_STATE_DIR"); dir != "" {
+		return dir
+	}
+
+	if os.Geteuid() == 0 {
+		return "/var/lib/sysweaver"
+	}
+
+	if dataHome := os.Getenv("XDG_DATA_HOME"); dataHome != "" {
+		return filepath.Join(dataHome, "sysweaver")
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "sysweaver-state")
+	}
+	return filepath.Join(home, ".local", "share", "sysweaver")
+}
+
+// Open открывает (и при необходимости создает) хранилище в директории состояния
+func Open(stateDir string) (*Store, error) {
+	dir := filepath.Join(stateDir, "store")
+	if err := os.MkdirAll(filepath.Join(dir, "builds"), 0755); err != nil {
+		return nil, fmt.Errorf("error creating artifact store: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// NewRecord создает запись о начатой сборке с уникальным идентификатором
+func NewRecord(template, name, version string) *Record {
+	now := time.Now()
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+
+	return &Record{
+		ID:        now.Format("20060102-150405") + "-" + hex.EncodeToString(suffix),
+		Template:  template,
+		Name:      name,
+		Version:   version,
+		StartedAt: now,
+	}
+}
+
+// Finish фиксирует результат сборки
+func (r *Record) Finish(err error) {
+	r.FinishedAt = time.Now()
+	if err != nil {
+		r.Result = ResultFailed
+		r.Error = err.Error()
+	} else {
+		r.Result = ResultSuccess
+	}
+}
+
+// BuildDir возвращает директорию сборки в хранилище
+func (s *Store) BuildDir(id string) string {
+	return filepath.Join(s.dir, "builds", id)
+}
+
+// Save сохраняет запись о сборке
+func (s *Store) Save(r *Record) error {
+	dir := s.BuildDir(r.ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error creating build directory in store: %w", err)
+	}
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding build record: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "record.json"), data, 0644); err != nil {
+		return fmt.Errorf("error writing build record: %w", err)
+	}
+
+	return nil
+}
+
+// Get возвращает запись о сборке по идентификатору
+func (s *Store) Get(id string) (*Record, error) {
+	if id == "" || filepath.Base(id) != id {
+		return nil, fmt.Errorf("invalid build ID: %q", id)
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.BuildDir(id), "record.json"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("build %s not found in artifact store", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading build record: %w", err)
+	}
+
+	var r Record
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("error decoding build record %s: %w", id, err)
+	}
+	return &r, nil
+}
+
+// Remove удаляет запись о сборке вместе с ее директорией в хранилище
+func (s *Store) Remove(id string) error {
+	if id == "" || filepath.Base(id) != id {
+		return fmt.Errorf("invalid build ID: %q", id)
+	}
+	if err := os.RemoveAll(s.BuildDir(id)); err != nil {
+		return fmt.Errorf("error removing build %s from store: %w", id, err)
+	}
+	return nil
+}
+
+// List возвращает все записи о сборках, начиная с самых новых
+func (s *Store) List() ([]*Record, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, "builds"))
+	if err != nil {
+		return nil, fmt.Errorf("error reading artifact store: %w", err)
+	}
+
+	var records []*Record
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(s.BuildDir(entry.Name()), "record.json"))
+		if err != nil {
+			// Незавершенные или поврежденные записи пропускаем
+			continue
+		}
+
+		var r Record
+		if err := json.Unmarshal(data, &r); err != nil {
+			continue
+		}
+		records = append(records, &r)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].StartedAt.After(records[j].StartedAt)
+	})
+
+	return records, nil
+}
@@ -0,0 +1,34 @@
+package structures
+
+// BaseConfig - базовый дистрибутив собираемой системы. Для alpine корневая ФС
+// берется из rootfs билдера; для fedora, rhel, centos, rocky и almalinux она
+// создается утилитой dnf хоста (dnf --installroot) перед стадией prepare:
+//
+//	base:
+//	  distro: fedora
+//	  version: "40"
+//	  repos:
+//	    - name: fedora
+//	      metalink: https://mirrors.fedoraproject.org/metalink?repo=fedora-$releasever&arch=$basearch
+//	      gpgcheck: true
+//	      gpgkey: file:///etc/pki/rpm-gpg/RPM-GPG-KEY-fedora-$releasever-$basearch
+type BaseConfig struct {
+	Distro  string `yaml:"distro"`
+	Version string `yaml:"version"`
+
+	// dnf: $releasever (по умолчанию version), группы пакетов (по умолчанию core)
+	// и репозитории; без repos используются репозитории хоста
+	Releasever string    `yaml:"releasever"`
+	Groups     []string  `yaml:"groups"`
+	Repos      []DnfRepo `yaml:"repos"`
+}
+
+// DnfRepo - репозиторий dnf; задается baseurl, metalink или mirrorlist
+type DnfRepo struct {
+	Name       string `yaml:"name" validate:"required"`
+	BaseURL    string `yaml:"baseurl" validate:"url"`
+	Metalink   string `yaml:"metalink" validate:"url"`
+	Mirrorlist string `yaml:"mirrorlist" validate:"url"`
+	GPGCheck   bool   `yaml:"gpgcheck"`
+	GPGKey     string `yaml:"gpgkey"`
+}
@@ -0,0 +1,28 @@
+package structures
+
+// BrandingConfig - оформление собираемой системы: поля /etc/os-release,
+// шаблоны /etc/motd и /etc/issue и оформление загрузчика. Пути файлов
+// задаются относительно шаблона:
+//
+//	branding:
+//	  name: Acme Edge OS
+//	  id: acme-edge
+//	  home_url: https://acme.example.com
+//	  motd: branding/motd.tmpl
+//	  issue: branding/issue.tmpl
+//	  splash: branding/splash.png
+//	  grub_theme: branding/grub-theme
+type BrandingConfig struct {
+	Name       string `yaml:"name"`        // NAME; без него os-release не меняется
+	ID         string `yaml:"id"`          // ID; прежний ID переносится в ID_LIKE
+	Version    string `yaml:"version"`     // VERSION и VERSION_ID, по умолчанию version конфигурации
+	PrettyName string `yaml:"pretty_name"` // PRETTY_NAME, по умолчанию "<name> <version>"
+	HomeURL    string `yaml:"home_url" validate:"url"`
+
+	// Шаблоны text/template с данными сборки ({{.Name}}, {{.Version}}, {{.BuildID}}, ...)
+	Motd  string `yaml:"motd"`
+	Issue string `yaml:"issue"`
+
+	Splash    string `yaml:"splash"`     // Фоновое изображение GRUB (GRUB_BACKGROUND)
+	GrubTheme string `yaml:"grub_theme"` // Каталог темы GRUB с theme.txt (GRUB_THEME)
+}
@@ -0,0 +1,29 @@
+package structures
+
+// DistributeConfig - файлы для распространения крупных артефактов по многим площадкам:
+//
+//	distribute:
+//	  artifacts: ["*.iso", "*.qcow2"]
+//	  torrent:
+//	    trackers: [udp://tracker.example.com:6969/announce]
+//	    webseeds: [https://mirror.example.com/images/]
+//	  metalink:
+//	    mirrors: [https://mirror1.example.com/images, https://mirror2.example.com/images]
+type DistributeConfig struct {
+	Artifacts []string        `yaml:"artifacts"` // Шаблоны имен (по умолчанию образы дисков, ISO и архивы)
+	Torrent   *TorrentConfig  `yaml:"torrent"`
+	Metalink  *MetalinkConfig `yaml:"metalink"`
+}
+
+// TorrentConfig - параметры .torrent файлов
+type TorrentConfig struct {
+	Trackers  []string `yaml:"trackers" validate:"url"`
+	WebSeeds  []string `yaml:"webseeds" validate:"url"`    // HTTP-источники (BEP 19)
+	PieceSize string   `yaml:"piece_size" validate:"size"` // Размер части (по умолчанию подбирается по размеру файла)
+	Private   bool     `yaml:"private"`
+}
+
+// MetalinkConfig - параметры документов Metalink 4
+type MetalinkConfig struct {
+	Mirrors []string `yaml:"mirrors" validate:"url"` // Базовые URL зеркал в порядке приоритета
+}
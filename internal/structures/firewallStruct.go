@@ -0,0 +1,31 @@
+package structures
+
+// FirewallConfig - правила nftables собираемой системы. Входящие соединения
+// кроме установленных, loopback, ICMPv6 и разрешенных правилами allow
+// отбрасываются (policy: accept оставляет их открытыми, тогда имеют смысл
+// правила deny); транзитный трафик запрещен, исходящий разрешен:
+//
+//	firewall:
+//	  allow:
+//	    - port: 22
+//	      from: 10.0.0.0/8
+//	    - port: 80,443
+//	    - port: 51820
+//	      protocol: udp
+//	  deny:
+//	    - from: 192.0.2.0/24
+type FirewallConfig struct {
+	Policy    string         `yaml:"policy" validate:"oneof=drop accept"` // Политика входящих, по умолчанию drop
+	BlockPing bool           `yaml:"block_ping"`                          // Не отвечать на ICMP echo по IPv4
+	Allow     []FirewallRule `yaml:"allow"`
+	Deny      []FirewallRule `yaml:"deny"` // Проверяются раньше allow
+}
+
+// FirewallRule - правило для входящего трафика; заданные поля должны
+// совпасть все
+type FirewallRule struct {
+	Port      string `yaml:"port"`                                          // Порт, список 80,443 или диапазон 8000-8100
+	Protocol  string `yaml:"protocol" validate:"oneof=tcp udp icmp icmpv6"` // По умолчанию tcp, если задан порт
+	From      string `yaml:"from"`                                          // Адрес или сеть источника
+	Interface string `yaml:"interface"`                                     // Входящий интерфейс
+}
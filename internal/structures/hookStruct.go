@@ -0,0 +1,37 @@
+package structures
+
+// HooksConfig - хуки сборки. Команды pre_build, post_build и on_failure
+// выполняются на хосте (не в jail) через sh -c в каталоге шаблона, со
+// сведениями о сборке в переменных SYSWEAVER_*. ${NAME} в командах не
+// подставляется из vars при загрузке конфигурации, а раскрывается оболочкой.
+// Плагины - внешние программы
+// sysweaver-hook-<name>, вызываемые в точках сборки:
+//
+//	hooks:
+//	  pre_build:
+//	    - mount -t nfs nas:/images /mnt/images
+//	  post_build:
+//	    - cp -r "${SYSWEAVER_OUTPUT_DIR}" /mnt/images/
+//	  on_failure:
+//	    - umount /mnt/images
+//	  plugins:
+//	    - name: cis-benchmark
+//	      on: [post-stage, post-build]
+//	      options:
+//	        profile: level1
+type HooksConfig struct {
+	PreBuild  []string `yaml:"pre_build"`  // До начала сборки; ошибка прерывает сборку
+	PostBuild []string `yaml:"post_build"` // После успешной сборки; ошибка делает сборку неудавшейся
+	OnFailure []string `yaml:"on_failure"` // После неудавшейся сборки; ошибки - только предупреждения
+
+	Plugins []HookPlugin `yaml:"plugins"`
+}
+
+// HookPlugin - плагин хука. Хук получает сведения о сборке и может
+// запретить ее (например, проверка соответствия требованиям) или добавить
+// пометки в запись о сборке.
+type HookPlugin struct {
+	Name    string            `yaml:"name" validate:"required"`
+	On      []string          `yaml:"on" validate:"oneof=pre-build post-stage pre-artifact-copy post-build"` // Точки сборки (по умолчанию все)
+	Options map[string]string `yaml:"options"`                                                               // Передаются хуку как есть
+}
@@ -2,17 +2,24 @@ package structures
 
 // JailConfig содержит настройки для изолированной среды
 type JailConfig struct {
-	ChrootDir    string       `yaml:"chroot_dir"`
+	ChrootDir    string       `yaml:"chroot_dir" validate:"required"`
 	Environment  []string     `yaml:"environment"`
-	BuilderPath  string       `yaml:"builder_path"`
+	BuilderPath  string       `yaml:"builder_path" validate:"required"`
 	TemplatePath string       `yaml:"template_path"`
 	MountPoints  []MountPoint `yaml:"mount_points"`
 	LogPath      string       `yaml:"log_path"`
+
+	// Открытый ключ GPG (путь относительно шаблона) для проверки подписи
+	// билдера, загружаемого по ссылке builder_path: alpine:3.20
+	BuilderKey string `yaml:"builder_key"`
+
+	// Директория контрольных точек стадий (снимки overlay и образы CRIU)
+	CheckpointDir string `yaml:"checkpoint_dir"`
 }
 
 type MountPoint struct {
-	Source      string   `yaml:"source"`
-	Destination string   `yaml:"destination"`
+	Source      string   `yaml:"source" validate:"required"`
+	Destination string   `yaml:"destination" validate:"required"`
 	Type        string   `yaml:"type"`
 	Options     []string `yaml:"options"`
 }
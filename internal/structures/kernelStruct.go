@@ -0,0 +1,12 @@
+package structures
+
+// ModulesConfig - модули ядра, загружаемые при старте системы и запрещенные
+// к загрузке:
+//
+//	modules:
+//	  load: [wireguard, br_netfilter]
+//	  blacklist: [pcspkr]
+type ModulesConfig struct {
+	Load      []string `yaml:"load"`      // /etc/modules-load.d/sysweaver.conf
+	Blacklist []string `yaml:"blacklist"` // /etc/modprobe.d/sysweaver-blacklist.conf
+}
@@ -1,28 +1,84 @@
 package structures
 
 type BuildConfig struct {
-	Name    string `yaml:"name"`
-	Version string `yaml:"version"`
-	Base    struct {
-		Distro  string `yaml:"distro"`
-		Version string `yaml:"version"`
-	} `yaml:"base"`
-	System struct {
-		Hostname string `yaml:"hostname"`
-		Timezone string `yaml:"timezone"`
-		Locale   string `yaml:"locale"`
-	} `yaml:"system"`
-	Partitions []struct {
-		Name       string   `yaml:"name"`
-		Size       string   `yaml:"size"`
-		Filesystem string   `yaml:"filesystem"`
-		Mount      string   `yaml:"mount"`
-		Flags      []string `yaml:"flags"`
-	} `yaml:"partitions"`
-	ISO struct {
+	SchemaVersion int `yaml:"schemaVersion"` // Версия схемы (sysweaver migrate-config)
+
+	Name       string         `yaml:"name" validate:"required"`
+	Version    string         `yaml:"version"`
+	Arch       string         `yaml:"arch"` // Архитектура собираемой системы (x86_64, aarch64, ...)
+	Base       BaseConfig     `yaml:"base"`
+	System     SystemConfig   `yaml:"system"`
+	Branding   BrandingConfig `yaml:"branding"`
+	Partitions []Partition    `yaml:"partitions"`
+	ISO        struct {
 		Label       string `yaml:"label"`
 		Publisher   string `yaml:"publisher"`
-		Compression string `yaml:"compression"`
+		Compression string `yaml:"compression" validate:"oneof=gzip lzo lz4 xz zstd lzma"`
 	} `yaml:"iso"`
-	Packages []string `yaml:"packages"`
+	Packages   []string            `yaml:"packages"`
+	Outputs    []OutputSpec        `yaml:"outputs"`
+	Container  ContainerConfig     `yaml:"container"`
+	Publish    PublishConfig       `yaml:"publish"`
+	Upload     []UploadDestination `yaml:"upload"`
+	Distribute DistributeConfig    `yaml:"distribute"`
+
+	// Уведомления о завершении сборки (webhook, Slack, Matrix)
+	Notifications []Notification `yaml:"notifications"`
+
+	// Хранение старых сборок, их артефактов и кэша слоев
+	Retention RetentionConfig `yaml:"retention"`
+
+	// Хуки сборки: команды на хосте и плагины точек сборки
+	Hooks HooksConfig `yaml:"hooks"`
+
+	// Параметры ядра (/etc/sysctl.d) и модули ядра собираемой системы
+	Sysctl  map[string]string `yaml:"sysctl"`
+	Modules ModulesConfig     `yaml:"modules"`
+
+	// Пользователи и группы собираемой системы
+	Users []User `yaml:"users"`
+
+	// Сетевые интерфейсы собираемой системы
+	Network NetworkConfig `yaml:"network"`
+
+	// Службы, включаемые и отключаемые в собираемой системе
+	Services ServicesConfig `yaml:"services"`
+
+	// Межсетевой экран nftables собираемой системы
+	Firewall FirewallConfig `yaml:"firewall"`
+
+	// Секреты для скриптов; в артефакты и логи не попадают
+	Secrets []Secret `yaml:"secrets"`
+
+	// Зеркала Alpine (базовые URL до каталога alpine/) в порядке приоритета.
+	// При недоступности основного зеркала загрузки переключаются на следующие.
+	Mirrors []string `yaml:"mirrors" validate:"url"`
+
+	// Комбинации архитектур и профилей для sysweaver build --matrix
+	Matrix MatrixConfig `yaml:"matrix"`
+
+	// Алгоритмы дайджестов артефактов (sha256, sha512, blake3), по умолчанию sha256
+	Digests []string `yaml:"digests" validate:"oneof=sha256 sha512 blake3"`
+}
+
+// SystemConfig - базовые настройки собираемой системы, применяемые перед
+// скриптами стадии configure
+type SystemConfig struct {
+	Hostname string `yaml:"hostname"`
+	Timezone string `yaml:"timezone"` // Зона из tzdata: Europe/Moscow, UTC
+	Locale   string `yaml:"locale"`   // Значение LANG: en_US.UTF-8
+}
+
+// Partition описывает раздел диска в raw-образе
+type Partition struct {
+	Name       string   `yaml:"name" validate:"required"`
+	Size       string   `yaml:"size" validate:"size"`
+	Filesystem string   `yaml:"filesystem" validate:"oneof=ext2 ext3 ext4 vfat fat32 xfs btrfs swap none"`
+	Mount      string   `yaml:"mount"`
+	Options    string   `yaml:"options"` // Параметры монтирования в /etc/fstab, по умолчанию defaults
+	Flags      []string `yaml:"flags"`
+
+	// Готовый образ ФС (путь относительно шаблона), записываемый в раздел как есть
+	// вместо mkfs и заполнения из rootfs
+	Image string `yaml:"image"`
 }
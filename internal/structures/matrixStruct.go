@@ -0,0 +1,19 @@
+package structures
+
+// MatrixConfig - комбинации матричной сборки (sysweaver build --matrix):
+//
+//	matrix:
+//	  arch: [x86_64, aarch64]
+//	  profile: [minimal, desktop]
+//	  exclude:
+//	    - {arch: aarch64, profile: desktop}
+//	  parallel: 2
+//
+// Каждая комбинация arch × profile собирается отдельной сборкой: arch
+// задает значение arch конфигурации, profile добавляется к --profile.
+type MatrixConfig struct {
+	Arch     []string            `yaml:"arch"`
+	Profile  []string            `yaml:"profile"`
+	Exclude  []map[string]string `yaml:"exclude"`  // Исключаемые комбинации (ключи arch и profile)
+	Parallel int                 `yaml:"parallel"` // Число одновременных сборок (по умолчанию 1)
+}
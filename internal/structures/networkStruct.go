@@ -0,0 +1,47 @@
+package structures
+
+// NetworkConfig - сетевая конфигурация собираемой системы. Записывается в
+// /etc/network/interfaces (ifupdown) или /etc/systemd/network (networkd):
+//
+//	network:
+//	  interfaces:
+//	    - name: eth0
+//	      dhcp: true
+//	    - name: eth0.20
+//	      vlan: {id: 20, link: eth0}
+//	      addresses: [10.20.0.5/24]
+//	      gateway: 10.20.0.1
+//	      dns: [10.20.0.1]
+//	    - name: wlan0
+//	      dhcp: true
+//	      wifi: {ssid: site-net, psk_secret: wifi-psk}
+type NetworkConfig struct {
+	// ifupdown или networkd; по умолчанию networkd, если он есть в системе
+	Backend    string             `yaml:"backend" validate:"oneof=ifupdown networkd"`
+	Interfaces []NetworkInterface `yaml:"interfaces"`
+}
+
+// NetworkInterface - настройки сетевого интерфейса
+type NetworkInterface struct {
+	Name      string   `yaml:"name" validate:"required"`
+	DHCP      bool     `yaml:"dhcp"`
+	Addresses []string `yaml:"addresses"` // Статические адреса в формате CIDR
+	Gateway   string   `yaml:"gateway"`
+	DNS       []string `yaml:"dns"`
+	MTU       int      `yaml:"mtu"`
+
+	VLAN *VLANConfig `yaml:"vlan"`
+	WiFi *WiFiConfig `yaml:"wifi"`
+}
+
+// VLANConfig - интерфейс VLAN поверх интерфейса link
+type VLANConfig struct {
+	ID   int    `yaml:"id" validate:"required"`
+	Link string `yaml:"link" validate:"required"`
+}
+
+// WiFiConfig - параметры подключения к беспроводной сети через wpa_supplicant
+type WiFiConfig struct {
+	SSID      string `yaml:"ssid" validate:"required"`
+	PSKSecret string `yaml:"psk_secret"` // Имя секрета с паролем сети; без него сеть открытая
+}
@@ -0,0 +1,33 @@
+package structures
+
+// Notification - уведомление о завершении сборки:
+//
+//	notifications:
+//	  - type: webhook
+//	    url: https://ci.example.com/hooks/sysweaver
+//	  - type: slack
+//	    url_env: SLACK_WEBHOOK_URL
+//	    on: [failure]
+//	  - type: matrix
+//	    homeserver: https://matrix.example.com
+//	    room: "!builds:example.com"
+//	    token_env: MATRIX_TOKEN
+type Notification struct {
+	Type string   `yaml:"type" validate:"required,oneof=webhook slack matrix"` // webhook, slack или matrix
+	On   []string `yaml:"on" validate:"oneof=success failure"`                 // Результаты сборки для уведомления (по умолчанию оба)
+
+	// webhook и slack: URL для POST; url_env - имя переменной окружения с URL,
+	// если он сам является секретом (входящие webhook Slack)
+	URL     string            `yaml:"url" validate:"url"`
+	URLEnv  string            `yaml:"url_env"`
+	Headers map[string]string `yaml:"headers"` // Дополнительные заголовки webhook
+
+	// matrix: сообщение в комнату от имени пользователя с токеном доступа
+	Homeserver string `yaml:"homeserver" validate:"required_if=type matrix,url"`
+	Room       string `yaml:"room" validate:"required_if=type matrix"`
+	TokenEnv   string `yaml:"token_env" validate:"required_if=type matrix"` // Переменная окружения с токеном
+
+	// Базовый URL, по которому доступны артефакты (ссылка - URL/имя артефакта);
+	// без него ссылками служат адреса из секции upload
+	ArtifactURL string `yaml:"artifact_url" validate:"url"`
+}
@@ -0,0 +1,57 @@
+package structures
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// OutputSpec описывает один выходной артефакт сборки.
+// Тип - имя формата из реестра image.Formats (проверяется output.Validate).
+// Встроенные типы: raw, qcow2, vmdk, vhdx, vdi (образы дисков), iso, tar, oci, docker-archive,
+// spdx, cyclonedx (SBOM), mtree (манифест содержимого: права, владелец, размер
+// и SHA-256 каждого файла), plugin (внешний плагин sysweaver-output-<plugin>,
+// получающий options).
+// В config.yaml допускается как короткая форма (`- vmdk`), так и полная:
+//
+//	outputs:
+//	  - type: vmdk
+//	    source: alpine-custom.img
+//	    subformat: streamOptimized
+type OutputSpec struct {
+	Type        string            `yaml:"type" validate:"required"`
+	Name        string            `yaml:"name"`                                      // Имя выходного файла (по умолчанию - имя источника с новым расширением)
+	Source      string            `yaml:"source"`                                    // Исходный артефакт из /output (по умолчанию - все *.img/*.raw)
+	Subformat   string            `yaml:"subformat"`                                 // Подформат диска (streamOptimized, fixed, ...)
+	Compression string            `yaml:"compression"`                               // Сжатие архивов (gzip, zstd)
+	Options     map[string]string `yaml:"options"`                                   // Дополнительные опции формата
+	Plugin      string            `yaml:"plugin" validate:"required_if=type plugin"` // Имя плагина для type: plugin
+}
+
+// UnmarshalYAML поддерживает короткую запись выхода в виде строки
+func (o *OutputSpec) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		o.Type = value.Value
+		return nil
+	}
+
+	if value.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: output must be a string or a mapping", value.Line)
+	}
+
+	// Псевдоним типа без UnmarshalYAML, чтобы избежать рекурсии
+	type plain OutputSpec
+	return value.Decode((*plain)(o))
+}
+
+// ContainerConfig - параметры контейнерного образа для выходов oci и docker-archive
+type ContainerConfig struct {
+	Tag          string            `yaml:"tag"` // Ссылка на образ (по умолчанию name:version)
+	Architecture string            `yaml:"architecture"`
+	Entrypoint   []string          `yaml:"entrypoint"`
+	Cmd          []string          `yaml:"cmd"`
+	Env          []string          `yaml:"env"`
+	WorkingDir   string            `yaml:"working_dir"`
+	User         string            `yaml:"user"`
+	Labels       map[string]string `yaml:"labels"`
+}
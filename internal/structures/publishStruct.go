@@ -0,0 +1,113 @@
+package structures
+
+// PublishConfig - публикация артефактов после сборки.
+// Каждая заданная секция - отдельная цель публикации:
+//
+//	publish:
+//	  proxmox:
+//	    url: https://pve.example.com:8006
+//	    node: pve1
+//	    vmid: 9000
+//	  plugins:
+//	    - name: vsphere
+//	      options:
+//	        datastore: ds1
+type PublishConfig struct {
+	Proxmox *ProxmoxPublish `yaml:"proxmox"`
+	AWS     *AWSPublish     `yaml:"aws"`
+	GCP     *GCPPublish     `yaml:"gcp"`
+	Azure   *AzurePublish   `yaml:"azure"`
+	OCI     *OCIPublish     `yaml:"oci"`
+
+	// Внешние плагины публикации sysweaver-publish-<name>, после встроенных целей
+	Plugins []PluginPublish `yaml:"plugins"`
+}
+
+// PluginPublish - публикация внешним плагином
+type PluginPublish struct {
+	Name    string            `yaml:"name" validate:"required"`
+	Options map[string]string `yaml:"options"` // Передаются плагину как есть
+}
+
+// ProxmoxPublish - загрузка образа в Proxmox VE и создание шаблона ВМ
+type ProxmoxPublish struct {
+	URL         string `yaml:"url" validate:"required,url"` // Адрес API (https://host:8006)
+	Node        string `yaml:"node" validate:"required"`    // Узел кластера
+	Storage     string `yaml:"storage"`                     // Хранилище для загрузки образа (по умолчанию local)
+	DiskStorage string `yaml:"disk_storage"`                // Хранилище дисков ВМ (по умолчанию local-lvm)
+	TokenEnv    string `yaml:"token_env"`                   // Переменная окружения с API-токеном USER@REALM!ID=SECRET
+	Insecure    bool   `yaml:"insecure"`                    // Не проверять TLS-сертификат
+
+	Source string `yaml:"source"` // Исходный raw-образ из output (по умолчанию первый *.img/*.raw)
+	VMID   int    `yaml:"vmid" validate:"required"`
+	Name   string `yaml:"name"`   // Имя шаблона (по умолчанию name-version сборки)
+	Cores  int    `yaml:"cores"`  // По умолчанию 1
+	Memory int    `yaml:"memory"` // MiB, по умолчанию 512
+	Bridge string `yaml:"bridge"` // Сетевой мост (по умолчанию vmbr0)
+}
+
+// AWSPublish - импорт образа в EC2 и регистрация AMI.
+// Учетные данные берутся из окружения утилиты aws (AWS_PROFILE, ключи, роль).
+type AWSPublish struct {
+	Region  string `yaml:"region"`
+	Profile string `yaml:"profile"`
+	Bucket  string `yaml:"bucket" validate:"required"` // S3-бакет для загрузки образа
+	Prefix  string `yaml:"prefix"`                     // Префикс ключа в бакете
+
+	Source       string            `yaml:"source"`                                      // Исходный raw-образ из output (по умолчанию первый *.img/*.raw)
+	Name         string            `yaml:"name"`                                        // Имя AMI (по умолчанию name-version сборки)
+	Architecture string            `yaml:"architecture" validate:"oneof=x86_64 arm64"`  // x86_64 или arm64 (по умолчанию x86_64)
+	BootMode     string            `yaml:"boot_mode" validate:"oneof=uefi legacy-bios"` // uefi или legacy-bios (по умолчанию uefi)
+	Tags         map[string]string `yaml:"tags"`                                        // Дополнительные теги AMI и снимка
+	KeepObject   bool              `yaml:"keep_object"`                                 // Не удалять образ из S3 после импорта
+}
+
+// GCPPublish - образ Compute Engine из архива disk.raw в Cloud Storage
+type GCPPublish struct {
+	Project         string `yaml:"project" validate:"required"`
+	Bucket          string `yaml:"bucket" validate:"required"` // Бакет Cloud Storage для загрузки архива
+	Prefix          string `yaml:"prefix"`
+	CredentialsFile string `yaml:"credentials_file"` // JSON-ключ сервисного аккаунта (по умолчанию - текущая авторизация gcloud)
+
+	Source          string            `yaml:"source"`            // Исходный raw-образ из output (по умолчанию первый *.img/*.raw)
+	Name            string            `yaml:"name"`              // Имя образа (по умолчанию name-version сборки)
+	Family          string            `yaml:"family"`            // Семейство образов
+	GuestOSFeatures []string          `yaml:"guest_os_features"` // По умолчанию UEFI_COMPATIBLE, VIRTIO_SCSI_MULTIQUEUE, GVNIC
+	Labels          map[string]string `yaml:"labels"`
+	KeepObject      bool              `yaml:"keep_object"` // Не удалять архив из бакета после создания образа
+}
+
+// AzurePublish - управляемый образ Azure из fixed VHD в Blob Storage
+type AzurePublish struct {
+	Subscription   string `yaml:"subscription"`
+	ResourceGroup  string `yaml:"resource_group" validate:"required"`
+	Location       string `yaml:"location"`
+	StorageAccount string `yaml:"storage_account" validate:"required"`
+	Container      string `yaml:"container" validate:"required"`
+
+	// Сервисный принципал; если не задан, используется текущая авторизация az
+	TenantID        string `yaml:"tenant_id"`
+	ClientID        string `yaml:"client_id"`
+	ClientSecretEnv string `yaml:"client_secret_env"` // По умолчанию AZURE_CLIENT_SECRET
+
+	Source           string            `yaml:"source"`             // Исходный raw-образ из output (по умолчанию первый *.img/*.raw)
+	Name             string            `yaml:"name"`               // Имя образа (по умолчанию name-version сборки)
+	HyperVGeneration string            `yaml:"hyper_v_generation"` // V1 или V2 (по умолчанию V2)
+	Tags             map[string]string `yaml:"tags"`
+	KeepObject       bool              `yaml:"keep_object"` // Не удалять VHD из контейнера после создания образа
+}
+
+// OCIPublish - загрузка образов в OCI-реестр как артефактов (ORAS) с подписью cosign.
+// Авторизация в реестре - через конфигурацию docker/oras (oras login) или username/password_env.
+type OCIPublish struct {
+	Repository   string            `yaml:"repository" validate:"required"` // registry.example.com/images/alpine
+	Tag          string            `yaml:"tag"`                            // По умолчанию версия сборки
+	Artifacts    []string          `yaml:"artifacts"`                      // Шаблоны файлов из output (по умолчанию *.iso, *.img, *.raw, *.qcow2)
+	ArtifactType string            `yaml:"artifact_type"`                  // По умолчанию application/vnd.sysweaver.image.v1
+	Annotations  map[string]string `yaml:"annotations"`
+	Username     string            `yaml:"username"`
+	PasswordEnv  string            `yaml:"password_env"` // Переменная окружения с паролем или токеном реестра
+
+	Sign bool   `yaml:"sign"` // Подписать артефакт cosign
+	Key  string `yaml:"key"`  // Ключ cosign (файл или URI KMS); пусто - keyless подпись через OIDC
+}
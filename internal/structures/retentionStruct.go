@@ -0,0 +1,14 @@
+package structures
+
+// RetentionConfig - политика хранения сборок и кэша слоев в каталоге
+// состояния (sysweaver gc и автоматически после каждой сборки):
+//
+//	retention:
+//	  keep_last: 10   # последних сборок каждого шаблона
+//	  max_age: 30d    # сборки и слои старше удаляются
+//	  max_size: 200G  # общий объем сборок, их артефактов и кэша слоев
+type RetentionConfig struct {
+	KeepLast int    `yaml:"keep_last"`                   // Сколько последних сборок шаблона хранить (0 - все)
+	MaxAge   string `yaml:"max_age" validate:"duration"` // Наибольший возраст: 72h, 30d
+	MaxSize  string `yaml:"max_size" validate:"size"`    // Наибольший общий объем: 500M, 200G
+}
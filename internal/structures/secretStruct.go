@@ -0,0 +1,25 @@
+package structures
+
+// Secret - секрет, доступный скриптам как файл /run/sysweaver/secrets/<name>.
+// Источник задается одним из полей env, sops или vault:
+//
+//	secrets:
+//	  - name: registry-token
+//	    env: REGISTRY_TOKEN
+//	  - name: wifi-psk
+//	    sops: secrets.enc.yaml
+//	    key: wifi.psk
+//	  - name: root-password
+//	    vault: secret/data/images/edge
+//	    field: root_password
+type Secret struct {
+	Name string `yaml:"name" validate:"required"`
+
+	Env string `yaml:"env"` // Переменная окружения хоста
+
+	Sops string `yaml:"sops"` // Файл, зашифрованный sops (путь относительно шаблона)
+	Key  string `yaml:"key"`  // Путь к значению в файле (a.b.c), по умолчанию файл целиком
+
+	Vault string `yaml:"vault"` // Путь секрета KV в Vault (адрес и токен - из VAULT_ADDR/VAULT_TOKEN)
+	Field string `yaml:"field"` // Поле секрета Vault
+}
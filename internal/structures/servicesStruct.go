@@ -0,0 +1,14 @@
+package structures
+
+// ServicesConfig - службы, включаемые и отключаемые в собираемой системе
+// после скриптов стадии configure. Для OpenRC можно указать уровень запуска
+// через двоеточие (по умолчанию default); для systemd без суффикса
+// подразумевается .service:
+//
+//	services:
+//	  enable: [sshd, chronyd, "hwclock:boot"]
+//	  disable: [crond]
+type ServicesConfig struct {
+	Enable  []string `yaml:"enable"`
+	Disable []string `yaml:"disable"`
+}
@@ -0,0 +1,39 @@
+package structures
+
+// UploadDestination - место, куда загружаются артефакты после сборки:
+//
+//	upload:
+//	  - type: s3
+//	    bucket: images
+//	    prefix: alpine/
+//	  - type: http
+//	    url: https://dav.example.com/images
+//	  - type: sftp
+//	    host: mirror.example.com
+//	    path: /srv/images
+type UploadDestination struct {
+	Type      string   `yaml:"type" validate:"required,oneof=s3 http sftp"` // s3, http или sftp
+	Artifacts []string `yaml:"artifacts"`                                   // Шаблоны имен артефактов (по умолчанию все)
+	Retries   int      `yaml:"retries"`                                     // Попыток на файл или часть (по умолчанию 3)
+
+	// s3: учетные данные берутся из окружения утилиты aws
+	Bucket      string `yaml:"bucket" validate:"required_if=type s3"`
+	Prefix      string `yaml:"prefix"`
+	Region      string `yaml:"region"`
+	Profile     string `yaml:"profile"`
+	EndpointURL string `yaml:"endpoint_url" validate:"url"` // S3-совместимое хранилище (MinIO, Ceph, ...)
+	PartSize    string `yaml:"part_size" validate:"size"`   // Размер части multipart-загрузки (по умолчанию 64M)
+
+	// http: PUT в каталог URL (WebDAV, реестры артефактов)
+	URL         string            `yaml:"url" validate:"required_if=type http,url"`
+	Username    string            `yaml:"username"`
+	PasswordEnv string            `yaml:"password_env"` // Переменная окружения с паролем
+	Headers     map[string]string `yaml:"headers"`
+
+	// sftp: через утилиту sftp с авторизацией по ключу
+	Host         string `yaml:"host" validate:"required_if=type sftp"`
+	Port         int    `yaml:"port"`
+	User         string `yaml:"user"`
+	Path         string `yaml:"path" validate:"required_if=type sftp"`
+	IdentityFile string `yaml:"identity_file"`
+}
@@ -0,0 +1,33 @@
+package structures
+
+// User - пользователь собираемой системы. Записи создаются в /etc/passwd,
+// /etc/shadow и /etc/group перед скриптами стадии configure; существующий
+// пользователь (например, root) обновляется:
+//
+//	users:
+//	  - name: admin
+//	    uid: 1000
+//	    groups: [wheel]
+//	    shell: /bin/ash
+//	    password: "$6$..."
+//	    authorized_keys:
+//	      - ssh-ed25519 AAAA... admin@example.com
+//	  - name: root
+//	    password_secret: root-password
+//	    locked: true
+type User struct {
+	Name    string   `yaml:"name" validate:"required"`
+	UID     int      `yaml:"uid"`     // 0 - следующий свободный UID начиная с 1000
+	Groups  []string `yaml:"groups"`  // Дополнительные группы; отсутствующие создаются
+	Shell   string   `yaml:"shell"`   // По умолчанию /bin/sh
+	Home    string   `yaml:"home"`    // По умолчанию /home/<name>
+	Comment string   `yaml:"comment"` // Поле GECOS
+
+	// Хеш пароля в формате crypt(3) ($6$..., $y$...) или имя секрета с ним.
+	// Без пароля вход возможен только по ключам SSH.
+	Password       string `yaml:"password"`
+	PasswordSecret string `yaml:"password_secret"`
+	Locked         bool   `yaml:"locked"` // Запретить вход по паролю
+
+	AuthorizedKeys []string `yaml:"authorized_keys"` // Строки ~/.ssh/authorized_keys
+}
@@ -0,0 +1,185 @@
+package template
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sysweaver/internal/store"
+)
+
+// Шаблон можно указать URL git-репозитория:
+//
+//	https://git.example.com/org/templates.git#v1.4:alpine/server
+//
+// После # указываются ссылка (ветка, тег или коммит; по умолчанию HEAD)
+// и, через двоеточие, путь к шаблону внутри репозитория. Репозиторий
+// хранится зеркалом в <state-dir>/templates и обновляется при каждой сборке;
+// шаблон извлекается в отдельный каталог для каждого коммита. Сообщения
+// выводятся в stderr, чтобы не смешиваться с выводом config resolve.
+
+// Префиксы URL удаленных шаблонов
+var remotePrefixes = []string{"https://", "http://", "ssh://", "git://", "file://", "git@"}
+
+var commitPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// IsRemote сообщает, указан ли шаблон URL git-репозитория; существующий каталог - не URL
+func IsRemote(arg string) bool {
+	if _, err := os.Stat(arg); err == nil {
+		return false
+	}
+	return isURL(arg)
+}
+
+// isURL сообщает, похожа ли ссылка на URL git-репозитория
+func isURL(arg string) bool {
+	url, _, _ := strings.Cut(arg, "#")
+	for _, prefix := range remotePrefixes {
+		if strings.HasPrefix(url, prefix) {
+			return true
+		}
+	}
+	return strings.HasSuffix(url, ".git")
+}
+
+// ParseRemote разбирает URL шаблона на репозиторий, ссылку и подкаталог
+func ParseRemote(arg string) store.TemplateSource {
+	url, fragment, _ := strings.Cut(arg, "#")
+	ref, subdir, _ := strings.Cut(fragment, ":")
+	return store.TemplateSource{URL: url, Ref: ref, Subdir: strings.Trim(subdir, "/")}
+}
+
+// Fetch клонирует (или обновляет) репозиторий шаблона в кеше stateDir,
+// извлекает нужный коммит и возвращает каталог шаблона и его источник
+func Fetch(arg, stateDir string) (string, *store.TemplateSource, error) {
+	return fetch(arg, stateDir, "", slog.Default())
+}
+
+// fetch получает шаблон arg; непустой commit заменяет ссылку из URL
+func fetch(arg, stateDir, commit string, log *slog.Logger) (string, *store.TemplateSource, error) {
+	source := ParseRemote(arg)
+
+	sum := sha256.Sum256([]byte(source.URL))
+	cacheDir := filepath.Join(stateDir, "templates", hex.EncodeToString(sum[:])[:16])
+	repo := filepath.Join(cacheDir, "repo")
+
+	ref := source.Ref
+	if commit != "" {
+		ref = commit
+	} else if ref == "" {
+		ref = "HEAD"
+	}
+
+	if _, err := os.Stat(repo); os.IsNotExist(err) {
+		log.Info("cloning template repository", "url", source.URL)
+		if err := os.MkdirAll(cacheDir, 0755); err != nil {
+			return "", nil, fmt.Errorf("error creating template cache: %w", err)
+		}
+		if _, err := git("", "clone", "--mirror", "--quiet", source.URL, repo); err != nil {
+			os.RemoveAll(repo)
+			return "", nil, fmt.Errorf("error cloning template repository %s: %w", source.URL, err)
+		}
+	} else if _, err := resolveCommit(repo, ref); err != nil || !commitPattern.MatchString(ref) {
+		// Коммит, уже имеющийся в кеше, не требует обновления
+		log.Info("updating template repository", "url", source.URL)
+		if _, err := git(repo, "fetch", "--prune", "--quiet", "origin"); err != nil {
+			log.Warn("error updating template repository, using cached copy", "url", source.URL, "error", err)
+		}
+	}
+
+	commit, err := resolveCommit(repo, ref)
+	if err != nil {
+		return "", nil, fmt.Errorf("template ref %q not found in %s", ref, source.URL)
+	}
+	source.Commit = commit
+
+	checkout := filepath.Join(cacheDir, commit)
+	if _, err := os.Stat(checkout); os.IsNotExist(err) {
+		if err := extract(repo, commit, checkout); err != nil {
+			return "", nil, fmt.Errorf("error checking out template %s at %s: %w", source.URL, commit, err)
+		}
+	}
+
+	dir := filepath.Join(checkout, filepath.FromSlash(source.Subdir))
+	if dir != checkout && !strings.HasPrefix(dir, checkout+string(filepath.Separator)) {
+		return "", nil, fmt.Errorf("template path %q is outside the repository", source.Subdir)
+	}
+	log.Info("using template "+source.URL, "commit", commit[:12])
+
+	return dir, &source, nil
+}
+
+// resolveCommit возвращает полный хеш коммита, на который указывает ref
+func resolveCommit(repo, ref string) (string, error) {
+	out, err := git(repo, "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// extract извлекает дерево коммита в dest; каталог появляется только целиком
+func extract(repo, commit, dest string) error {
+	tmp, err := os.MkdirTemp(filepath.Dir(dest), ".checkout-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := os.Chmod(tmp, 0755); err != nil {
+		return err
+	}
+
+	archive := exec.Command("git", "--git-dir", repo, "archive", "--format=tar", commit)
+	untar := exec.Command("tar", "-x", "-C", tmp)
+
+	pipe, err := archive.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	untar.Stdin = pipe
+
+	var stderr bytes.Buffer
+	archive.Stderr = &stderr
+	untar.Stderr = &stderr
+
+	if err := untar.Start(); err != nil {
+		return err
+	}
+	if err := archive.Run(); err != nil {
+		untar.Wait()
+		return fmt.Errorf("git archive: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if err := untar.Wait(); err != nil {
+		return fmt.Errorf("tar: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return os.Rename(tmp, dest)
+}
+
+// git выполняет команду git в репозитории repo (пустой repo - без репозитория)
+func git(repo string, args ...string) (string, error) {
+	if repo != "" {
+		args = append([]string{"--git-dir", repo}, args...)
+	}
+
+	cmd := exec.Command("git", args...)
+	// Без интерактивных запросов учетных данных
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", err
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,144 @@
+package template
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Базовые шаблоны и дополнения можно указывать git URL (extends: и layers:).
+// Разрешенные коммиты и контрольные суммы их файлов записываются в
+// sysweaver.lock рядом с config.yaml шаблона. Пока запись есть в lock-файле,
+// сборка использует зафиксированный коммит и проверяет контрольную сумму;
+// --update заново разрешает ссылки и перезаписывает lock-файл.
+
+// LockFile - имя lock-файла шаблона
+const LockFile = "sysweaver.lock"
+
+const lockVersion = 1
+
+const lockHeader = "# Generated by sysweaver. Commit this file; refresh it with 'sysweaver build --update'.\n"
+
+// Lock - содержимое lock-файла
+type Lock struct {
+	Version int            `yaml:"version"`
+	Sources []LockedSource `yaml:"sources"`
+}
+
+// LockedSource - зафиксированная версия удаленного слоя
+type LockedSource struct {
+	Ref    string `yaml:"ref"` // Ссылка в том виде, как она записана в extends или layers
+	Commit string `yaml:"commit"`
+	Digest string `yaml:"digest"` // sha256 файлов шаблона в подкаталоге
+}
+
+// readLock читает lock-файл; отсутствующий файл - пустой lock
+func readLock(path string) (*Lock, error) {
+	lock := &Lock{Version: lockVersion}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return lock, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", path, err)
+	}
+	if err := yaml.Unmarshal(data, lock); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	if lock.Version > lockVersion {
+		return nil, fmt.Errorf("%s has version %d, this sysweaver supports up to %d", path, lock.Version, lockVersion)
+	}
+	return lock, nil
+}
+
+// find возвращает запись для ссылки ref
+func (l *Lock) find(ref string) *LockedSource {
+	for i := range l.Sources {
+		if l.Sources[i].Ref == ref {
+			return &l.Sources[i]
+		}
+	}
+	return nil
+}
+
+// equal сообщает, совпадают ли записи двух lock-файлов
+func (l *Lock) equal(other *Lock) bool {
+	if len(l.Sources) != len(other.Sources) {
+		return false
+	}
+	for _, source := range l.Sources {
+		if locked := other.find(source.Ref); locked == nil || *locked != source {
+			return false
+		}
+	}
+	return true
+}
+
+// write записывает lock-файл с записями, отсортированными по ссылке
+func (l *Lock) write(path string) error {
+	sort.Slice(l.Sources, func(i, j int) bool { return l.Sources[i].Ref < l.Sources[j].Ref })
+
+	var buf bytes.Buffer
+	buf.WriteString(lockHeader)
+
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(l); err != nil {
+		return fmt.Errorf("error encoding %s: %w", LockFile, err)
+	}
+	encoder.Close()
+
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("error writing %s: %w", path, err)
+	}
+	return nil
+}
+
+// treeDigest вычисляет контрольную сумму дерева: пути, права, содержимое и ссылки
+func treeDigest(root string) (string, error) {
+	h := sha256.New()
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "link %s %s\n", filepath.ToSlash(rel), link)
+
+		case info.Mode().IsRegular():
+			fmt.Fprintf(h, "file %s %o %d\n", filepath.ToSlash(rel), info.Mode().Perm(), info.Size())
+			file, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			if _, err := io.Copy(h, file); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("error computing checksum of %s: %w", root, err)
+	}
+
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,490 @@
+package template
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sysweaver/internal/config"
+	"sysweaver/internal/logging"
+	"sysweaver/internal/store"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Наследование шаблонов задается в config.yaml шаблона:
+//
+//	extends: ../alpine-base
+//
+// Базовый шаблон отдает свои скрипты, оверлеи, jail.yaml и config.yaml.
+// Файлы с тем же относительным путем в наследнике заменяют базовые
+// (пустой скрипт с тем же именем отключает базовый), остальные добавляются.
+// Конфигурация базового шаблона ложится слоем под config.yaml наследника;
+// списки дописываются ключами с суффиксом + (packages+:).
+//
+// Кроме наследования шаблон подключает независимые дополнения (addons):
+//
+//	layers: [docker-host, wifi]
+//
+// Дополнение устроено как шаблон: config.yaml (обычно packages+:), скрипты
+// стадий и оверлеи. Имя ищется в layers/ объявившего шаблона или дополнения,
+// затем в layers/ шаблонов цепочки наследования от самого шаблона к корневому,
+// затем в $XDG_DATA_HOME/sysweaver/layers (~/.local/share/sysweaver/layers) и
+// /usr/share/sysweaver/layers; имя с / - путь относительно шаблона.
+// Дополнение может подключать другие дополнения, но не может наследоваться.
+// Базовый шаблон и дополнение можно указать git URL (см. git.go); их версии
+// фиксируются в sysweaver.lock (см. lock.go).
+//
+// Слои накладываются в порядке: базовые шаблоны от корневого, дополнения
+// в порядке объявления (сначала объявленные базовыми шаблонами, зависимости
+// дополнения - перед ним), затем сам шаблон. Каждое дополнение входит один раз.
+// Скрипты дополнений с тем же именем заменяют друг друга, поэтому их имена
+// лучше начинать с имени дополнения (50-docker-host.sh).
+
+// Имена файлов и ключей шаблона
+const (
+	ConfigFile    = "config.yaml"
+	CUEConfigFile = "config.cue" // Конфигурация на CUE, если config.yaml нет
+	JailFile      = "jail.yaml"
+
+	extendsKey = "extends"
+	layersKey  = "layers"
+	layersDir  = "layers"
+)
+
+// Kinds слоев шаблона
+const (
+	KindBase     = "base"
+	KindAddon    = "addon"
+	KindTemplate = "template"
+)
+
+// Layer - каталог, участвующий в сборке шаблона
+type Layer struct {
+	Dir  string
+	Kind string
+}
+
+// Template - шаблон с разрешенной цепочкой наследования
+type Template struct {
+	Dir     string
+	Layers  []Layer                // В порядке наложения: базовые шаблоны, дополнения, сам шаблон
+	Source  *store.TemplateSource  // Репозиторий для шаблонов, указанных URL
+	Remotes []store.TemplateSource // Удаленные базовые шаблоны и дополнения
+
+	lock        *Lock // Разрешенные версии удаленных слоев
+	lockChanged bool
+}
+
+// Options - параметры загрузки шаблона
+type Options struct {
+	StateDir string // Каталог состояния с кешем git-репозиториев
+	Update   bool   // Разрешать удаленные слои заново, игнорируя sysweaver.lock
+
+	Logger *slog.Logger // Журнал загрузки репозиториев; nil - slog.Default()
+}
+
+// Open загружает шаблон из каталога или, для URL, из git-репозитория в кеше
+func Open(arg string, opts Options) (*Template, error) {
+	if !IsRemote(arg) {
+		return Load(arg, opts)
+	}
+
+	dir, source, err := fetch(arg, opts.StateDir, "", logging.OrDefault(opts.Logger))
+	if err != nil {
+		return nil, err
+	}
+	t, err := Load(dir, opts)
+	if err != nil {
+		return nil, err
+	}
+	t.Source = source
+	return t, nil
+}
+
+// Load читает шаблон и рекурсивно разрешает его базовые шаблоны
+func Load(dir string, opts Options) (*Template, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving template path: %w", err)
+	}
+
+	existing, err := readLock(filepath.Join(dir, LockFile))
+	if err != nil {
+		return nil, err
+	}
+	locked := existing
+	if opts.Update {
+		locked = &Lock{Version: lockVersion}
+	}
+
+	r := &resolver{
+		opts:   opts,
+		locked: locked,
+		lock:   &Lock{Version: lockVersion},
+		seen:   make(map[string]bool),
+	}
+
+	// Цепочка наследования от корневого шаблона к самому шаблону
+	chain, err := r.chain(dir, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		r.search = append(r.search, filepath.Join(chain[i].dir, layersDir))
+	}
+	r.search = append(r.search, SearchDirs()...)
+
+	t := &Template{Dir: dir}
+	for _, layer := range chain[:len(chain)-1] {
+		t.Layers = append(t.Layers, Layer{Dir: layer.dir, Kind: KindBase})
+	}
+	for _, layer := range chain {
+		for _, name := range layer.directives.Layers {
+			if err := r.addon(layer.dir, name, nil); err != nil {
+				return nil, err
+			}
+		}
+	}
+	t.Layers = append(t.Layers, r.addons...)
+	t.Layers = append(t.Layers, Layer{Dir: dir, Kind: KindTemplate})
+
+	t.Remotes = r.remotes
+	t.lock = r.lock
+	t.lockChanged = !r.lock.equal(existing)
+	return t, nil
+}
+
+// resolver разрешает базовые шаблоны и дополнения
+type resolver struct {
+	opts    Options
+	locked  *Lock // Прочитанный lock-файл
+	lock    *Lock // Lock-файл по фактически разрешенным слоям
+	remotes []store.TemplateSource
+	addons  []Layer
+	seen    map[string]bool
+	search  []string // Каталоги поиска дополнений по имени
+}
+
+// remote получает удаленный слой ref: зафиксированный в lock-файле коммит
+// берется из кеша и сверяется с контрольной суммой
+func (r *resolver) remote(ref string) (string, error) {
+	locked := r.locked.find(ref)
+
+	var commit string
+	if locked != nil {
+		commit = locked.Commit
+	}
+	dir, source, err := fetch(ref, r.opts.StateDir, commit, logging.OrDefault(r.opts.Logger))
+	if err != nil {
+		return "", err
+	}
+
+	digest, err := treeDigest(dir)
+	if err != nil {
+		return "", err
+	}
+	if locked != nil && locked.Digest != digest {
+		return "", fmt.Errorf("checksum mismatch for %s at %s: %s has %s, got %s (run with --update to accept the new content)",
+			ref, source.Commit, LockFile, locked.Digest, digest)
+	}
+
+	if r.lock.find(ref) == nil {
+		r.lock.Sources = append(r.lock.Sources, LockedSource{Ref: ref, Commit: source.Commit, Digest: digest})
+		r.remotes = append(r.remotes, *source)
+	}
+	return dir, nil
+}
+
+// chainLayer - шаблон цепочки наследования с его директивами
+type chainLayer struct {
+	dir        string
+	directives directives
+}
+
+// chain возвращает базовые шаблоны dir и сам dir в порядке наложения
+func (r *resolver) chain(dir string, stack []string) ([]chainLayer, error) {
+	if err := checkCycle("template inheritance", stack, dir); err != nil {
+		return nil, err
+	}
+	stack = append(stack, dir)
+
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("template not found: %s", dir)
+	}
+
+	directives, err := readDirectives(ConfigPath(dir))
+	if err != nil {
+		return nil, err
+	}
+
+	var chain []chainLayer
+	if directives.Extends != "" {
+		base := relativeTo(dir, directives.Extends)
+		if isURL(directives.Extends) {
+			if base, err = r.remote(directives.Extends); err != nil {
+				return nil, err
+			}
+		}
+		if chain, err = r.chain(base, stack); err != nil {
+			return nil, err
+		}
+	}
+	return append(chain, chainLayer{dir: dir, directives: directives}), nil
+}
+
+// addon добавляет дополнение name, объявленное в from, вместе с его зависимостями
+func (r *resolver) addon(from, name string, stack []string) error {
+	dir, err := r.find(from, name)
+	if err != nil {
+		return err
+	}
+	if err := checkCycle("template layer", stack, dir); err != nil {
+		return err
+	}
+	if r.seen[dir] {
+		return nil
+	}
+	stack = append(stack, dir)
+
+	directives, err := readDirectives(ConfigPath(dir))
+	if err != nil {
+		return err
+	}
+	if directives.Extends != "" {
+		return fmt.Errorf("template layer %s: layers cannot use %s", dir, extendsKey)
+	}
+
+	for _, dep := range directives.Layers {
+		if err := r.addon(dir, dep, stack); err != nil {
+			return err
+		}
+	}
+
+	r.seen[dir] = true
+	r.addons = append(r.addons, Layer{Dir: dir, Kind: KindAddon})
+	return nil
+}
+
+// find ищет каталог дополнения name, объявленного в from
+func (r *resolver) find(from, name string) (string, error) {
+	if isURL(name) {
+		return r.remote(name)
+	}
+
+	var candidates []string
+	if strings.ContainsRune(name, '/') || strings.HasPrefix(name, ".") {
+		candidates = []string{relativeTo(from, name)}
+	} else {
+		for _, dir := range append([]string{filepath.Join(from, layersDir)}, r.search...) {
+			candidate := filepath.Join(dir, name)
+			if !slices.Contains(candidates, candidate) {
+				candidates = append(candidates, candidate)
+			}
+		}
+	}
+
+	for _, dir := range candidates {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("template layer %q not found (searched %s)", name, strings.Join(candidates, ", "))
+}
+
+// SearchDirs возвращает общие каталоги дополнений в порядке поиска
+func SearchDirs() []string {
+	var dirs []string
+	if data := os.Getenv("XDG_DATA_HOME"); data != "" {
+		dirs = append(dirs, filepath.Join(data, "sysweaver", layersDir))
+	} else if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, filepath.Join(home, ".local", "share", "sysweaver", layersDir))
+	}
+	return append(dirs, filepath.Join("/usr/share/sysweaver", layersDir))
+}
+
+// relativeTo разрешает путь из директивы относительно каталога шаблона
+func relativeTo(dir, path string) string {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	return filepath.Clean(path)
+}
+
+func checkCycle(what string, stack []string, dir string) error {
+	for _, parent := range stack {
+		if parent == dir {
+			return fmt.Errorf("%s cycle: %s -> %s", what, strings.Join(stack, " -> "), dir)
+		}
+	}
+	return nil
+}
+
+// directives - ключи config.yaml, которые обрабатывает шаблон, а не конфигурация
+type directives struct {
+	Extends string   `yaml:"extends"`
+	Layers  []string `yaml:"layers"`
+}
+
+// ConfigPath возвращает файл конфигурации каталога шаблона: config.yaml
+// или config.cue, если config.yaml нет
+func ConfigPath(dir string) string {
+	path := filepath.Join(dir, ConfigFile)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		cue := filepath.Join(dir, CUEConfigFile)
+		if _, err := os.Stat(cue); err == nil {
+			return cue
+		}
+	}
+	return path
+}
+
+// readDirectives читает директивы шаблона из config.yaml; файл необязателен
+func readDirectives(path string) (directives, error) {
+	var d directives
+
+	data, err := config.ReadSource(path)
+	if os.IsNotExist(err) {
+		return d, nil
+	}
+	if err != nil {
+		return d, fmt.Errorf("error reading %s: %w", path, err)
+	}
+
+	// Остальные ключи игнорируются - их проверяет загрузка конфигурации
+	if err := yaml.Unmarshal(data, &d); err != nil {
+		return d, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	return d, nil
+}
+
+// WriteLock обновляет sysweaver.lock шаблона, если версии удаленных слоев
+// изменились, и сообщает, был ли файл изменен. Lock-файл шаблона из
+// git-репозитория не перезаписывается.
+func (t *Template) WriteLock() (bool, error) {
+	if !t.lockChanged || t.Source != nil {
+		return false, nil
+	}
+
+	path := filepath.Join(t.Dir, LockFile)
+	if len(t.lock.Sources) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return false, fmt.Errorf("error removing %s: %w", path, err)
+		}
+		return true, nil
+	}
+	return true, t.lock.write(path)
+}
+
+// Composed сообщает, состоит ли шаблон из нескольких слоев
+func (t *Template) Composed() bool {
+	return len(t.Layers) > 1
+}
+
+// ConfigLayers возвращает config.yaml слоев под самим шаблоном в порядке наложения
+func (t *Template) ConfigLayers() []string {
+	var paths []string
+	for _, layer := range t.Layers {
+		if layer.Dir != t.Dir {
+			paths = append(paths, ConfigPath(layer.Dir))
+		}
+	}
+	return paths
+}
+
+// Dirs возвращает каталоги всех слоев
+func (t *Template) Dirs() []string {
+	dirs := make([]string, 0, len(t.Layers))
+	for _, layer := range t.Layers {
+		dirs = append(dirs, layer.Dir)
+	}
+	return dirs
+}
+
+// Compose собирает файлы всех слоев в dest: каждый следующий слой
+// заменяет файлы предыдущих с тем же путем. config.yaml не копируется -
+// конфигурации слоев объединяет загрузка конфигурации.
+func (t *Template) Compose(dest string) error {
+	for _, layer := range t.Layers {
+		if err := copyTree(layer.Dir, dest, skipped, true); err != nil {
+			return fmt.Errorf("error composing template layer %s: %w", layer.Dir, err)
+		}
+	}
+	return nil
+}
+
+// Пути в корне слоя, которые не входят в собранный шаблон
+var skipped = map[string]bool{ConfigFile: true, CUEConfigFile: true, layersDir: true, ".git": true}
+
+// Copy копирует шаблон src в dst целиком, включая config.yaml
+func Copy(src, dst string) error {
+	return copyTree(src, dst, map[string]bool{".git": true}, false)
+}
+
+// copyTree копирует дерево src в dst кроме путей skip в его корне;
+// с link файлы по возможности связываются жесткими ссылками
+func copyTree(src, dst string, skip map[string]bool, link bool) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if skip[rel] {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			os.Remove(target)
+			return os.Symlink(link, target)
+
+		case info.Mode().IsRegular():
+			// Ссылка вместо копии: файл следующего слоя заменяет ее, не меняя исходный
+			os.Remove(target)
+			if link {
+				if err := os.Link(path, target); err == nil {
+					return nil
+				}
+			}
+			return copyFile(path, target, info.Mode())
+		}
+		return nil
+	})
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
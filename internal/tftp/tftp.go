@@ -0,0 +1,249 @@
+package tftp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Коды операций TFTP (RFC 1350, RFC 2347)
+const (
+	opRRQ   = 1
+	opWRQ   = 2
+	opDATA  = 3
+	opACK   = 4
+	opERROR = 5
+	opOACK  = 6
+)
+
+// Коды ошибок TFTP
+const (
+	errNotFound     = 1
+	errAccess       = 2
+	errIllegalOp    = 4
+	errOptionDenied = 8
+)
+
+const (
+	defaultBlockSize = 512
+	maxBlockSize     = 65464
+	retransmits      = 5
+	timeout          = time.Second
+)
+
+// Server - TFTP-сервер только для чтения (загрузка PXE-артефактов).
+// Поддерживаются опции blksize и tsize, которые используют PXE-загрузчики.
+type Server struct {
+	Root   string
+	Logger *slog.Logger // Журнал запросов; nil - без журнала
+}
+
+// ListenAndServe принимает запросы на адресе addr (например, :69)
+func (s *Server) ListenAndServe(addr string) error {
+	if s.Logger == nil {
+		s.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %w", addr, err)
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+
+		packet := make([]byte, n)
+		copy(packet, buf[:n])
+		go s.handle(packet, peer)
+	}
+}
+
+// handle обрабатывает запрос в отдельном сокете, как требует протокол
+func (s *Server) handle(packet []byte, peer net.Addr) {
+	conn, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		s.Logger.Warn("tftp: error opening transfer socket", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	if len(packet) < 4 {
+		return
+	}
+
+	switch binary.BigEndian.Uint16(packet) {
+	case opRRQ:
+	case opWRQ:
+		sendError(conn, peer, errAccess, "server is read-only")
+		return
+	default:
+		sendError(conn, peer, errIllegalOp, "illegal operation")
+		return
+	}
+
+	fields := strings.Split(strings.TrimRight(string(packet[2:]), "\x00"), "\x00")
+	if len(fields) < 2 {
+		sendError(conn, peer, errIllegalOp, "malformed request")
+		return
+	}
+	name := fields[0]
+	options := make(map[string]string)
+	for i := 2; i+1 < len(fields); i += 2 {
+		options[strings.ToLower(fields[i])] = fields[i+1]
+	}
+
+	file, size, err := s.open(name)
+	if err != nil {
+		s.Logger.Info("tftp: file not found", "peer", peer.String(), "file", name, "error", err)
+		sendError(conn, peer, errNotFound, "file not found")
+		return
+	}
+	defer file.Close()
+
+	s.Logger.Info("tftp: GET", "peer", peer.String(), "file", name)
+	if err := s.transfer(conn, peer, file, size, options); err != nil {
+		s.Logger.Warn("tftp: transfer failed", "peer", peer.String(), "file", name, "error", err)
+	}
+}
+
+// open открывает файл внутри корня, не допуская выхода за его пределы
+func (s *Server) open(name string) (*os.File, int64, error) {
+	clean := path.Clean("/" + strings.ReplaceAll(name, "\\", "/"))
+
+	// os.Root следит и за симлинками: абсолютные ссылки экспортированного
+	// rootfs не должны открывать файлы хоста
+	root, err := os.OpenRoot(s.Root)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer root.Close()
+
+	file, err := root.Open(filepath.FromSlash(strings.TrimPrefix(clean, "/")))
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		file.Close()
+		return nil, 0, fmt.Errorf("not a regular file")
+	}
+	return file, info.Size(), nil
+}
+
+// transfer передает файл блоками с подтверждениями и повторами
+func (s *Server) transfer(conn net.PacketConn, peer net.Addr, file io.Reader, size int64, options map[string]string) error {
+	blockSize := defaultBlockSize
+
+	// Согласование опций (OACK); клиент подтверждает его ACK с номером 0
+	if len(options) > 0 {
+		var oack []byte
+		oack = binary.BigEndian.AppendUint16(oack, opOACK)
+
+		if v, ok := options["blksize"]; ok {
+			requested, err := strconv.Atoi(v)
+			if err != nil || requested < 8 {
+				sendError(conn, peer, errOptionDenied, "invalid blksize")
+				return fmt.Errorf("invalid blksize %q", v)
+			}
+			blockSize = min(requested, maxBlockSize)
+			oack = appendOption(oack, "blksize", strconv.Itoa(blockSize))
+		}
+		if _, ok := options["tsize"]; ok {
+			oack = appendOption(oack, "tsize", strconv.FormatInt(size, 10))
+		}
+
+		if len(oack) > 2 {
+			if err := exchange(conn, peer, oack, 0); err != nil {
+				return err
+			}
+		}
+	}
+
+	buf := make([]byte, 4+blockSize)
+	var block uint16 = 1
+	for {
+		n, err := io.ReadFull(file, buf[4:])
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			sendError(conn, peer, errNotFound, "read error")
+			return err
+		}
+
+		binary.BigEndian.PutUint16(buf[0:], opDATA)
+		binary.BigEndian.PutUint16(buf[2:], block)
+		if err := exchange(conn, peer, buf[:4+n], block); err != nil {
+			return err
+		}
+
+		// Последний блок короче blksize (возможно, пустой)
+		if n < blockSize {
+			return nil
+		}
+		block++ // Переполнение до 0 допускается клиентами с большими файлами
+	}
+}
+
+// exchange отправляет пакет и ждет ACK с номером block, повторяя отправку по таймауту
+func exchange(conn net.PacketConn, peer net.Addr, packet []byte, block uint16) error {
+	ack := make([]byte, 516)
+	for attempt := 0; attempt < retransmits; attempt++ {
+		if _, err := conn.WriteTo(packet, peer); err != nil {
+			return err
+		}
+
+		deadline := time.Now().Add(timeout)
+		for {
+			conn.SetReadDeadline(deadline)
+			n, from, err := conn.ReadFrom(ack)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break
+				}
+				return err
+			}
+			if from.String() != peer.String() || n < 4 {
+				continue
+			}
+
+			switch binary.BigEndian.Uint16(ack) {
+			case opACK:
+				if binary.BigEndian.Uint16(ack[2:]) == block {
+					return nil
+				}
+			case opERROR:
+				return fmt.Errorf("client error: %s", strings.TrimRight(string(ack[4:n]), "\x00"))
+			}
+		}
+	}
+	return fmt.Errorf("timeout waiting for ACK of block %d", block)
+}
+
+func appendOption(packet []byte, name, value string) []byte {
+	packet = append(packet, name...)
+	packet = append(packet, 0)
+	packet = append(packet, value...)
+	return append(packet, 0)
+}
+
+func sendError(conn net.PacketConn, peer net.Addr, code uint16, message string) {
+	var packet []byte
+	packet = binary.BigEndian.AppendUint16(packet, opERROR)
+	packet = binary.BigEndian.AppendUint16(packet, code)
+	packet = append(packet, message...)
+	packet = append(packet, 0)
+	conn.WriteTo(packet, peer)
+}
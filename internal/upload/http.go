@@ -0,0 +1,75 @@
+package upload
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"sysweaver/internal/progress"
+	"sysweaver/internal/structures"
+)
+
+// uploadHTTP загружает файл запросом PUT в каталог dest.URL (WebDAV и совместимые)
+func uploadHTTP(dest structures.UploadDestination, path string, opts Options) (string, error) {
+	if dest.URL == "" {
+		return "", fmt.Errorf("url is required")
+	}
+
+	password := ""
+	if dest.Username != "" {
+		password = os.Getenv(dest.PasswordEnv)
+		if password == "" {
+			return "", fmt.Errorf("password not set: export %s", dest.PasswordEnv)
+		}
+	}
+
+	url := strings.TrimRight(dest.URL, "/") + "/" + filepath.Base(path)
+
+	err := retry(opts.Logger, dest.Retries, "upload to "+url, func(int) error {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+
+		pw := progress.NewWriter(io.Discard, "upload "+filepath.Base(path), info.Size())
+		req, err := http.NewRequest(http.MethodPut, url, io.TeeReader(file, pw))
+		if err != nil {
+			return err
+		}
+		req.ContentLength = info.Size()
+		req.Header.Set("Content-Type", "application/octet-stream")
+		for k, v := range dest.Headers {
+			req.Header.Set(k, v)
+		}
+		if dest.Username != "" {
+			req.SetBasicAuth(dest.Username, password)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("PUT %s: %s", url, resp.Status)
+		}
+		pw.Finish()
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return url, nil
+}
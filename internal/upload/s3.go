@@ -0,0 +1,287 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"sysweaver/internal/image"
+	"sysweaver/internal/progress"
+	"sysweaver/internal/structures"
+)
+
+// Размер части multipart-загрузки по умолчанию
+const defaultPartSize = 64 << 20
+
+// s3Part - загруженная часть multipart-загрузки
+type s3Part struct {
+	PartNumber int    `json:"PartNumber"`
+	ETag       string `json:"ETag"`
+}
+
+// s3UploadState - состояние незавершенной multipart-загрузки для возобновления
+type s3UploadState struct {
+	Bucket   string   `json:"bucket"`
+	Key      string   `json:"key"`
+	UploadID string   `json:"upload_id"`
+	PartSize int64    `json:"part_size"`
+	Parts    []s3Part `json:"parts"`
+}
+
+// uploadS3 загружает файл в S3. Файлы больше размера части загружаются
+// multipart-загрузкой, состояние которой сохраняется: повторный запуск сборки
+// или повтор после ошибки продолжает загрузку с первой недостающей части.
+func uploadS3(dest structures.UploadDestination, local string, opts Options) (string, error) {
+	if dest.Bucket == "" {
+		return "", fmt.Errorf("bucket is required")
+	}
+
+	partSize := int64(defaultPartSize)
+	if dest.PartSize != "" {
+		size, err := image.ParseSize(dest.PartSize)
+		if err != nil {
+			return "", err
+		}
+		// Минимальный размер части S3 - 5 MiB
+		if size < 5<<20 {
+			return "", fmt.Errorf("part_size must be at least 5M")
+		}
+		partSize = size
+	}
+
+	info, err := os.Stat(local)
+	if err != nil {
+		return "", err
+	}
+
+	s3 := s3CLI{dest: dest, logWriter: opts.LogWriter, log: opts.Logger}
+	key := strings.TrimLeft(path.Join(dest.Prefix, filepath.Base(local)), "/")
+	location := fmt.Sprintf("s3://%s/%s", dest.Bucket, key)
+
+	if info.Size() <= partSize {
+		err := retry(opts.Logger, dest.Retries, "upload to "+location, func(int) error {
+			_, err := s3.run("s3api", "put-object", "--bucket", dest.Bucket, "--key", key, "--body", local)
+			return err
+		})
+		return location, err
+	}
+
+	statePath := s3StatePath(opts.StateDir, dest, key, info)
+	state, err := s3.resume(statePath, key)
+	if err != nil {
+		return "", err
+	}
+	if state == nil {
+		var created struct {
+			UploadId string
+		}
+		if err := s3.runJSON(&created, "s3api", "create-multipart-upload", "--bucket", dest.Bucket, "--key", key); err != nil {
+			return "", fmt.Errorf("error starting multipart upload: %w", err)
+		}
+		state = &s3UploadState{Bucket: dest.Bucket, Key: key, UploadID: created.UploadId, PartSize: partSize}
+		if err := saveS3State(statePath, state); err != nil {
+			return "", err
+		}
+	} else {
+		fmt.Fprintf(opts.Out, "Resuming multipart upload of %s (%d parts done)\n", key, len(state.Parts))
+		partSize = state.PartSize
+	}
+
+	done := make(map[int]bool, len(state.Parts))
+	for _, part := range state.Parts {
+		done[part.PartNumber] = true
+	}
+
+	file, err := os.Open(local)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	tmpDir, err := os.MkdirTemp("", "sysweaver-s3-")
+	if err != nil {
+		return "", fmt.Errorf("error creating temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	pw := progress.NewWriter(io.Discard, "upload "+filepath.Base(local), info.Size())
+	parts := int((info.Size() + partSize - 1) / partSize)
+
+	for number := 1; number <= parts; number++ {
+		offset := int64(number-1) * partSize
+		size := min(partSize, info.Size()-offset)
+		if done[number] {
+			pw.Add(size)
+			continue
+		}
+
+		// Часть выгружается во временный файл: aws s3api upload-part принимает только файл
+		partPath := filepath.Join(tmpDir, "part")
+		if err := writePart(file, partPath, offset, size); err != nil {
+			return "", err
+		}
+
+		var uploaded struct {
+			ETag string
+		}
+		err := retry(opts.Logger, dest.Retries, fmt.Sprintf("upload of part %d/%d", number, parts), func(int) error {
+			return s3.runJSON(&uploaded, "s3api", "upload-part",
+				"--bucket", dest.Bucket, "--key", key,
+				"--upload-id", state.UploadID,
+				"--part-number", strconv.Itoa(number),
+				"--body", partPath)
+		})
+		if err != nil {
+			return "", fmt.Errorf("error uploading part %d (upload can be resumed): %w", number, err)
+		}
+
+		state.Parts = append(state.Parts, s3Part{PartNumber: number, ETag: uploaded.ETag})
+		if err := saveS3State(statePath, state); err != nil {
+			return "", err
+		}
+		pw.Add(size)
+	}
+	pw.Finish()
+
+	// Завершение загрузки: части в порядке номеров
+	completed := make([]s3Part, parts)
+	for _, part := range state.Parts {
+		completed[part.PartNumber-1] = part
+	}
+	manifest, err := json.Marshal(map[string][]s3Part{"Parts": completed})
+	if err != nil {
+		return "", err
+	}
+	manifestPath := filepath.Join(tmpDir, "parts.json")
+	if err := os.WriteFile(manifestPath, manifest, 0644); err != nil {
+		return "", err
+	}
+
+	err = retry(opts.Logger, dest.Retries, "completion of multipart upload", func(int) error {
+		_, err := s3.run("s3api", "complete-multipart-upload",
+			"--bucket", dest.Bucket, "--key", key,
+			"--upload-id", state.UploadID,
+			"--multipart-upload", "file://"+manifestPath)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("error completing multipart upload: %w", err)
+	}
+
+	os.Remove(statePath)
+	return location, nil
+}
+
+// s3CLI - утилита aws с параметрами места назначения
+type s3CLI struct {
+	dest      structures.UploadDestination
+	logWriter io.Writer
+	log       *slog.Logger
+}
+
+func (s s3CLI) run(args ...string) ([]byte, error) {
+	if s.dest.Region != "" {
+		args = append(args, "--region", s.dest.Region)
+	}
+	if s.dest.Profile != "" {
+		args = append(args, "--profile", s.dest.Profile)
+	}
+	if s.dest.EndpointURL != "" {
+		args = append(args, "--endpoint-url", s.dest.EndpointURL)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("aws", args...)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("aws %s: %w (%s)", args[1], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+func (s s3CLI) runJSON(v interface{}, args ...string) error {
+	out, err := s.run(append(args, "--output", "json")...)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(out, v); err != nil {
+		return fmt.Errorf("error decoding aws response: %w", err)
+	}
+	return nil
+}
+
+// resume загружает сохраненное состояние и сверяет список частей с S3.
+// Возвращает nil, если незавершенной загрузки нет или она больше не существует.
+func (s s3CLI) resume(statePath, key string) (*s3UploadState, error) {
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		return nil, nil
+	}
+
+	var state s3UploadState
+	if err := json.Unmarshal(data, &state); err != nil || state.UploadID == "" {
+		os.Remove(statePath)
+		return nil, nil
+	}
+
+	var listed struct {
+		Parts []s3Part
+	}
+	if err := s.runJSON(&listed, "s3api", "list-parts", "--bucket", state.Bucket, "--key", key, "--upload-id", state.UploadID); err != nil {
+		// Загрузка прервана или удалена политикой жизненного цикла - начинаем заново
+		s.log.Warn("previous multipart upload cannot be resumed", "key", key, "error", err)
+		os.Remove(statePath)
+		return nil, nil
+	}
+
+	state.Parts = listed.Parts
+	return &state, nil
+}
+
+// s3StatePath возвращает путь к состоянию загрузки; файл идентифицируется
+// местом назначения, размером и временем изменения, чтобы не продолжить загрузку другого файла
+func s3StatePath(stateDir string, dest structures.UploadDestination, key string, info os.FileInfo) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%d\x00%d", dest.EndpointURL, dest.Bucket, key, info.Size(), info.ModTime().UnixNano())
+	return filepath.Join(stateDir, "uploads", hex.EncodeToString(h.Sum(nil))[:16]+".json")
+}
+
+func saveS3State(path string, state *s3UploadState) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating upload state directory: %w", err)
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("error saving upload state: %w", err)
+	}
+	return nil
+}
+
+// writePart копирует часть файла во временный файл
+func writePart(file *os.File, dest string, offset, size int64) error {
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, io.NewSectionReader(file, offset, size)); err != nil {
+		return fmt.Errorf("error preparing upload part: %w", err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,80 @@
+package upload
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"sysweaver/internal/progress"
+	"sysweaver/internal/structures"
+)
+
+// uploadSFTP загружает файл утилитой sftp в пакетном режиме.
+// Повторные попытки дописывают файл (reput), а не передают его заново.
+func uploadSFTP(dest structures.UploadDestination, local string, opts Options) (string, error) {
+	if dest.Host == "" || dest.Path == "" {
+		return "", fmt.Errorf("host and path are required")
+	}
+
+	target := dest.Host
+	if dest.User != "" {
+		target = dest.User + "@" + dest.Host
+	}
+	remote := path.Join(dest.Path, filepath.Base(local))
+
+	info, err := os.Stat(local)
+	if err != nil {
+		return "", err
+	}
+
+	err = retry(opts.Logger, dest.Retries, "sftp upload to "+target, func(attempt int) error {
+		put := "put"
+		if attempt > 1 {
+			put = "reput"
+		}
+
+		// Префикс "-" у mkdir игнорирует ошибку существующей директории
+		var batch bytes.Buffer
+		fmt.Fprintf(&batch, "-mkdir %s\n", quoteSFTP(dest.Path))
+		fmt.Fprintf(&batch, "%s %s %s\n", put, quoteSFTP(local), quoteSFTP(remote))
+
+		args := []string{"-b", "-", "-o", "BatchMode=yes"}
+		if dest.Port != 0 {
+			args = append(args, "-P", strconv.Itoa(dest.Port))
+		}
+		if dest.IdentityFile != "" {
+			args = append(args, "-i", dest.IdentityFile)
+		}
+		args = append(args, target)
+
+		var stderr bytes.Buffer
+		cmd := exec.Command("sftp", args...)
+		cmd.Stdin = &batch
+		cmd.Stdout = opts.LogWriter
+		cmd.Stderr = &stderr
+
+		if err := progress.Run(cmd, "upload "+filepath.Base(local), info.Size(), true); err != nil {
+			return fmt.Errorf("%w (%s)", err, strings.TrimSpace(stderr.String()))
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	location := "sftp://" + target
+	if dest.Port != 0 {
+		location += ":" + strconv.Itoa(dest.Port)
+	}
+	return location + remote, nil
+}
+
+// quoteSFTP заключает путь в кавычки для пакетного файла sftp
+func quoteSFTP(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
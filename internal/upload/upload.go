@@ -0,0 +1,115 @@
+package upload
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"sysweaver/internal/logging"
+	"sysweaver/internal/structures"
+)
+
+// Options - параметры загрузки артефактов
+type Options struct {
+	Destinations []structures.UploadDestination
+	Artifacts    []string     // Пути артефактов сборки
+	StateDir     string       // Директория состояния (незавершенные multipart-загрузки)
+	LogWriter    io.Writer    // Вывод внешних утилит
+	Out          io.Writer    // Сообщения о ходе загрузки; nil - os.Stdout
+	Logger       *slog.Logger // Журнал повторов; nil - slog.Default()
+}
+
+// Result - загруженный артефакт
+type Result struct {
+	Type     string // s3, http, sftp
+	Artifact string
+	Location string // URL загруженного файла
+}
+
+// uploader загружает один файл в место назначения
+type uploader func(dest structures.UploadDestination, path string, opts Options) (string, error)
+
+var uploaders = map[string]uploader{
+	"s3":   uploadS3,
+	"http": uploadHTTP,
+	"sftp": uploadSFTP,
+}
+
+// Upload загружает артефакты во все места назначения
+func Upload(opts Options) ([]Result, error) {
+	if opts.LogWriter == nil {
+		opts.LogWriter = io.Discard
+	}
+	if opts.Out == nil {
+		opts.Out = os.Stdout
+	}
+	opts.Logger = logging.OrDefault(opts.Logger)
+
+	var results []Result
+	for _, dest := range opts.Destinations {
+		upload, ok := uploaders[dest.Type]
+		if !ok {
+			return results, fmt.Errorf("unsupported upload type: %s", dest.Type)
+		}
+		if dest.Retries <= 0 {
+			dest.Retries = 3
+		}
+
+		files, err := selectArtifacts(opts.Artifacts, dest.Artifacts)
+		if err != nil {
+			return results, err
+		}
+
+		for _, path := range files {
+			fmt.Fprintf(opts.Out, "Uploading %s (%s)\n", filepath.Base(path), dest.Type)
+			location, err := upload(dest, path, opts)
+			if err != nil {
+				return results, fmt.Errorf("error uploading %s to %s: %w", filepath.Base(path), dest.Type, err)
+			}
+			fmt.Fprintf(opts.Out, "Uploaded %s\n", location)
+			results = append(results, Result{Type: dest.Type, Artifact: filepath.Base(path), Location: location})
+		}
+	}
+
+	return results, nil
+}
+
+// selectArtifacts отбирает артефакты по шаблонам имен
+func selectArtifacts(artifacts, patterns []string) ([]string, error) {
+	if len(patterns) == 0 {
+		return artifacts, nil
+	}
+
+	var selected []string
+	for _, path := range artifacts {
+		for _, pattern := range patterns {
+			ok, err := filepath.Match(pattern, filepath.Base(path))
+			if err != nil {
+				return nil, fmt.Errorf("invalid artifact pattern %q: %w", pattern, err)
+			}
+			if ok {
+				selected = append(selected, path)
+				break
+			}
+		}
+	}
+	return selected, nil
+}
+
+// retry выполняет fn до attempts раз с растущей паузой
+func retry(log *slog.Logger, attempts int, what string, fn func(attempt int) error) error {
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = fn(attempt); err == nil {
+			return nil
+		}
+		if attempt < attempts {
+			log.Warn(fmt.Sprintf("%s failed (attempt %d/%d)", what, attempt, attempts), "error", err)
+			time.Sleep(time.Duration(attempt*attempt) * time.Second)
+		}
+	}
+	return err
+}
@@ -0,0 +1,2661 @@
+package sysweaver
+
+import (
+	"bufio"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"sysweaver/internal/apk"
+	"sysweaver/internal/branding"
+	"sysweaver/internal/builder"
+	"sysweaver/internal/buildinfo"
+	"sysweaver/internal/cache"
+	"sysweaver/internal/config"
+	"sysweaver/internal/digest"
+	"sysweaver/internal/distribute"
+	"sysweaver/internal/dnf"
+	"sysweaver/internal/download"
+	"sysweaver/internal/firewall"
+	"sysweaver/internal/firstboot"
+	"sysweaver/internal/helpers"
+	"sysweaver/internal/jail"
+	"sysweaver/internal/junit"
+	"sysweaver/internal/logging"
+	"sysweaver/internal/manifest"
+	"sysweaver/internal/network"
+	"sysweaver/internal/notify"
+	"sysweaver/internal/output"
+	"sysweaver/internal/plugin"
+	"sysweaver/internal/progress"
+	"sysweaver/internal/publish"
+	"sysweaver/internal/resume"
+	"sysweaver/internal/retention"
+	"sysweaver/internal/rootfs"
+	"sysweaver/internal/scripts"
+	"sysweaver/internal/secrets"
+	"sysweaver/internal/services"
+	"sysweaver/internal/store"
+	"sysweaver/internal/structures"
+	"sysweaver/internal/template"
+	"sysweaver/internal/upload"
+)
+
+// Стадии сборки в порядке выполнения
+const (
+	stagePrepare   = "prepare"
+	stageInstall   = "install"
+	stageConfigure = "configure"
+	stageImage     = "image"
+	stageTest      = "test"
+	stageCleanup   = "cleanup"
+)
+
+var buildStages = []string{stagePrepare, stageInstall, stageConfigure, stageImage, stageTest, stageCleanup}
+
+// runBuild выполняет сборку шаблона, сохраняет запись о ней в хранилище
+// артефактов и возвращает ее
+func (b *build) runBuild(templateArg string) (record *store.Record, err error) {
+	// Получаем шаблон (при необходимости из git) и разрешаем цепочку базовых шаблонов
+	tmpl, err := template.Open(templateArg, template.Options{StateDir: b.opts.StateDir, Update: b.opts.UpdateLock, Logger: b.log})
+	if err != nil {
+		return record, configError(err)
+	}
+	// В режиме --dry-run lock-файл не перезаписывается
+	if !b.opts.DryRun {
+		if written, err := tmpl.WriteLock(); err != nil {
+			return record, err
+		} else if written {
+			b.printf("Updated %s\n", filepath.Join(tmpl.Dir, template.LockFile))
+		}
+	}
+	templatePath := tmpl.Dir
+
+	// Определяем, какие стадии нужно выполнить
+	stages, err := b.selectStages()
+	if err != nil {
+		return record, &ConfigError{err}
+	}
+
+	// Если configPath не указан, используем config.yaml (или config.cue) из шаблона
+	if b.opts.Config == "" {
+		b.opts.Config = template.ConfigPath(templatePath)
+	}
+
+	b.printf("Building image from template: %s\n", templatePath)
+	b.printf("Using config: %s\n", b.opts.Config)
+	b.printf("Output will be saved to: %s\n", b.opts.Output)
+	b.printf("Stages: %s\n", strings.Join(stages, ", "))
+	if len(b.opts.Profiles) > 0 {
+		b.printf("Profiles: %s\n", strings.Join(b.opts.Profiles, ", "))
+	}
+
+	// Загружаем общую конфигурацию
+	var buildConfig structures.BuildConfig
+	if err := config.Load(b.opts.Config, &buildConfig, b.configOptions(tmpl)); err != nil {
+		return record, configError(fmt.Errorf("error loading build config: %w", err))
+	}
+	// Параметры выходов проверяются их форматами до долгой сборки
+	if err := output.Validate(buildConfig.Outputs); err != nil {
+		return record, &ConfigError{fmt.Errorf("invalid outputs: %w", err)}
+	}
+
+	// Файлы слоев шаблона собираются во временный каталог, который монтируется в jail
+	templateDir := templatePath
+	if tmpl.Composed() {
+		b.printf("Template layers: %s\n", strings.Join(tmpl.Dirs(), " -> "))
+
+		composed, err := os.MkdirTemp("", "sysweaver-template-")
+		if err != nil {
+			return record, fmt.Errorf("error creating template directory: %w", err)
+		}
+		defer os.RemoveAll(composed)
+
+		if err := tmpl.Compose(composed); err != nil {
+			return record, &ConfigError{err}
+		}
+		templateDir = composed
+	}
+
+	if b.opts.DryRun {
+		return record, b.printBuildPlan(templateDir, stages, &buildConfig)
+	}
+
+	// Запись о сборке в локальном хранилище артефактов
+	record = store.NewRecord(templatePath, buildConfig.Name, buildConfig.Version)
+	record.OutputDir, _ = filepath.Abs(b.opts.Output)
+	record.Source = tmpl.Source
+	if tmpl.Composed() {
+		record.Layers = tmpl.Dirs()
+	}
+	// События и записи журнала сборки несут ее ID (журнал - в формате json и
+	// на уровне debug); предупреждения сохраняются в записи о сборке и
+	// повторяются в итоговой сводке
+	b.id = record.ID
+	var warningsMu sync.Mutex
+	b.log = slog.New(logging.OnWarning(b.log.With("build", record.ID).Handler(), func(warning string) {
+		warningsMu.Lock()
+		defer warningsMu.Unlock()
+		record.Warnings = append(record.Warnings, warning)
+	}))
+	b.emit(progress.Event{Type: progress.EventBuildStarted, Path: record.OutputDir})
+	manifestInputs := manifest.Inputs{ConfigPath: b.opts.Config, ToolVersion: b.opts.ToolVersion}
+	var hooks *buildHooks
+	defer func() {
+		// Запрет хука post-build или ошибка post_build делают успешную сборку неудавшейся
+		if hookErr := hooks.postBuild(err); hookErr != nil {
+			if err == nil {
+				err = hookErr
+			} else {
+				b.log.Warn(hookErr.Error())
+			}
+		}
+		b.saveBuildRecord(record, err)
+		b.writeBuildManifest(record, manifestInputs)
+		b.applyRetention(buildConfig.Retention)
+		b.printBuildSummary(record)
+		b.emitBuildFinished(record, err)
+	}()
+
+	// Уведомления отправляются получателем события build_finished
+	if len(buildConfig.Notifications) > 0 {
+		b.subscribe(func(e Event) {
+			if e.Type != EventBuildFinished {
+				return
+			}
+			if err := notify.Send(buildConfig.Notifications, record); err != nil {
+				b.log.Warn(err.Error())
+			}
+		})
+	}
+
+	// Хуки сборки из секции hooks
+	if hooks, err = b.newBuildHooks(buildConfig.Hooks, templatePath, plugin.Dirs(templateDir, b.opts.StateDir), record, buildConfig.Arch); err != nil {
+		return record, err
+	}
+	if err := hooks.preBuild(templateDir); err != nil {
+		return record, err
+	}
+
+	// Загружаем конфигурацию jail из шаблона
+	jailConfigPath := filepath.Join(templateDir, template.JailFile)
+
+	// Создаем Jail; вывод его команд (с --verbose - и скриптов) идет событиями output
+	j, err := jail.NewJail(b.ctx, jailConfigPath, templateDir, b.loadOptions(), b)
+	if err != nil {
+		return record, &ConfigError{fmt.Errorf("error creating jail: %w", err)}
+	}
+	j.SetLogger(b.log)
+	j.SetEventHandler(b.emit)
+	if b.opts.Workspace != "" {
+		workspace, err := filepath.Abs(b.opts.Workspace)
+		if err != nil {
+			return record, fmt.Errorf("error resolving workspace path: %w", err)
+		}
+		j.SetWorkspace(workspace)
+	}
+
+	// builder_path: alpine:3.20 - билдер из каталога состояния
+	if err := b.resolveBuilder(j, buildConfig.Arch, buildConfig.Mirrors, true); err != nil {
+		return record, &HostError{err}
+	}
+
+	// Собранный ранее rootfs заменяет билдер в качестве нижнего слоя overlay
+	if b.opts.ReuseRootfs != "" {
+		rootfs, err := filepath.Abs(b.opts.ReuseRootfs)
+		if err != nil {
+			return record, fmt.Errorf("error resolving rootfs path: %w", err)
+		}
+		b.printf("Reusing rootfs: %s\n", rootfs)
+		j.SetBuilderPath(rootfs)
+	}
+	if _, err := os.Stat(j.GetBuilderPath()); os.IsNotExist(err) {
+		return record, &HostError{fmt.Errorf("builder path does not exist: %s", j.GetBuilderPath())}
+	}
+
+	// Рабочий каталог сборки с состоянием для --resume
+	workspace := filepath.Join(j.GetCheckpointDir(), "workspace")
+	state := resume.New(workspace, templatePath, b.opts.Config)
+	if b.opts.Resume {
+		if state, err = b.loadResumeState(workspace, templatePath, b.opts.Config); err != nil {
+			return record, &ConfigError{err}
+		}
+		stages = slices.DeleteFunc(slices.Clone(stages), state.StageDone)
+		if len(stages) == 0 {
+			return record, &ConfigError{fmt.Errorf("all stages of the interrupted build have completed, nothing to resume")}
+		}
+		b.printf("Resuming failed build from stage %s: %s\n", stages[0], workspace)
+		j.SetOverlaySnapshot(filepath.Join(state.Snapshot(), "upper"))
+	} else if err := resume.Clear(workspace); err != nil {
+		return record, err
+	}
+
+	// При возобновлении восстанавливаем контрольную точку предыдущей стадии
+	var resumeDir string
+	if b.opts.Checkpoint && b.opts.ReuseRootfs == "" && !b.opts.Resume {
+		if prev := previousStage(stages[0]); prev != "" {
+			dir := filepath.Join(j.GetCheckpointDir(), prev)
+			if _, err := os.Stat(filepath.Join(dir, "upper")); err == nil {
+				b.printf("Resuming from checkpoint of stage %s: %s\n", prev, dir)
+				j.SetOverlaySnapshot(filepath.Join(dir, "upper"))
+				resumeDir = dir
+			} else {
+				b.log.Warn("no checkpoint found, starting from a clean overlay", "stage", prev)
+			}
+		}
+	}
+
+	manifestInputs.BuilderPath = j.GetBuilderPath()
+
+	// ВАЖНО: Гарантируем очистку ресурсов при выходе, независимо от результата
+	cleanup := func() {
+		if j != nil && j.IsRunning() {
+			b.println("Cleaning up resources...")
+			if stopErr := j.Stop(); stopErr != nil {
+				b.log.Warn("error during cleanup", "error", stopErr)
+			}
+		}
+	}
+
+	// Используем defer для гарантированного выполнения cleanup
+	// Не пропускаем cleanup даже в ручном режиме, чтобы предотвратить утечку ресурсов
+	defer cleanup()
+
+	// Секреты монтируются в jail при старте и маскируются в его выводе
+	secretValues, err := secrets.Resolve(buildConfig.Secrets, templateDir)
+	if err != nil {
+		return record, &ConfigError{err}
+	}
+	j.SetSecrets(secretValues)
+
+	// Итоговая конфигурация доступна скриптам в /etc/sysweaver/build.json и SYSWEAVER_*
+	buildJSON, err := buildinfo.JSON(&buildConfig)
+	if err != nil {
+		return record, err
+	}
+	j.SetRuntimeFile(buildinfo.Path, buildJSON)
+	j.SetRuntimeFile(helpers.Path, helpers.Script)
+	j.SetRuntimeFile(helpers.ResultsPath, nil)
+	configSum := sha256.Sum256(buildJSON)
+	record.ConfigHash = hex.EncodeToString(configSum[:])
+
+	// Порядок, зависимости и условия скриптов из scripts.yaml
+	scriptManifest, err := scripts.LoadManifest(filepath.Join(templateDir, scripts.ManifestFile), buildStages)
+	if err != nil {
+		return record, &ConfigError{err}
+	}
+	var configValues map[string]interface{}
+	if err := json.Unmarshal(buildJSON, &configValues); err != nil {
+		return record, fmt.Errorf("error decoding build config: %w", err)
+	}
+	j.SetScriptEnv(buildinfo.Env(&buildConfig, b.opts.Profiles))
+
+	// Скрипты, исключенные флагами --skip, --only, --from и --until
+	deselected, err := b.selectScripts(scriptManifest, templateDir, stages)
+	if err != nil {
+		return record, &ConfigError{err}
+	}
+	plannedScripts, err := b.countScripts(scriptManifest, templateDir, stages)
+	if err != nil {
+		return record, &ConfigError{err}
+	}
+
+	// Кэш слоев: неизмененное начало конвейера скриптов восстанавливается из кэша
+	var layers *scriptLayers
+	if b.opts.Cache || b.opts.CacheRemote != "" {
+		if layers, err = b.planScriptLayers(j, scriptManifest, templateDir, stages, buildJSON, resumeDir != ""); err != nil {
+			return record, err
+		}
+	}
+
+	// Создаем директорию output внутри chroot
+	outputDirInChroot := filepath.Join(j.GetChrootDir(), "output")
+	if err := os.MkdirAll(outputDirInChroot, 0755); err != nil {
+		return record, fmt.Errorf("error creating output directory in chroot: %w", err)
+	}
+
+	// Запускаем изолированную среду
+	if err := j.Start(); err != nil {
+		return record, &JailError{fmt.Errorf("error starting jail: %w", err)}
+	}
+	if err := layers.start(j); err != nil {
+		return record, &JailError{err}
+	}
+
+	// Корневая ФС dnf-дистрибутивов создается перед скриптами стадии prepare;
+	// восстановленные из кэша слои и сохраненное состояние jail уже содержат ее
+	if dnf.Supports(buildConfig.Base.Distro) && slices.Contains(stages, stagePrepare) && !b.opts.Resume && !layers.restoredAny() {
+		err := dnf.Bootstrap(dnf.Options{
+			Root:      j.GetChrootDir(),
+			Base:      buildConfig.Base,
+			Arch:      buildConfig.Arch,
+			Packages:  buildConfig.Packages,
+			LogWriter: j.GetLogWriter(),
+			Out:       b,
+		})
+		if err != nil {
+			return record, &JailError{fmt.Errorf("error bootstrapping %s: %w", buildConfig.Base.Distro, err)}
+		}
+	}
+
+	// Направляем apk на первое доступное зеркало из списка
+	if len(buildConfig.Mirrors) > 0 && !dnf.Supports(buildConfig.Base.Distro) {
+		if err := b.selectMirror(j.GetChrootDir(), buildConfig.Mirrors, record); err != nil {
+			return record, err
+		}
+	}
+
+	if resumeDir != "" {
+		if _, err := os.Stat(filepath.Join(resumeDir, "criu")); err == nil {
+			if err := j.RestoreProcesses(resumeDir); err != nil {
+				return record, &JailError{fmt.Errorf("error restoring checkpoint: %w", err)}
+			}
+		}
+	}
+
+	// Версии пакетов из packages.lock; --update-lock разрешает их заново
+	lockPath := filepath.Join(filepath.Dir(b.opts.Config), apk.LockFile)
+	var lockedPackages *apk.LockedSet
+	if !b.opts.UpdatePackageLock && !dnf.Supports(buildConfig.Base.Distro) {
+		if lockedPackages, err = apk.ReadLock(lockPath, b.packageLockKey(&buildConfig)); err != nil {
+			return record, err
+		}
+	}
+
+	runner := &stageRunner{
+		build:             b,
+		jail:              j,
+		templateDir:       templateDir,
+		outputDirInChroot: outputDirInChroot,
+		config:            &buildConfig,
+		configValues:      configValues,
+		scripts:           scriptManifest,
+		secrets:           secretValues,
+		record:            record,
+		state:             state,
+		deselected:        deselected,
+		stepping:          b.opts.Step,
+		layers:            layers,
+		lockedPackages:    lockedPackages,
+		hooks:             hooks,
+	}
+
+	// Артефакты стадий, завершенных до возобновления
+	artifacts := slices.Clone(state.Artifacts)
+	for _, stage := range stages {
+		if err := b.canceled(); err != nil {
+			return record, err
+		}
+		b.printf("\n=== Stage: %s ===\n", stage)
+		run := store.StageRun{Name: stage, StartedAt: time.Now()}
+		b.emit(progress.Event{Type: progress.EventStageStarted, Stage: stage, Scripts: plannedScripts[stage]})
+
+		stageArtifacts, err := runner.run(stage)
+		artifacts = append(artifacts, stageArtifacts...)
+
+		run.Duration = time.Since(run.StartedAt).Seconds()
+		run.Result = store.ResultSuccess
+		if err != nil {
+			run.Result = store.ResultFailed
+		}
+		record.Stages = append(record.Stages, run)
+		b.emitStageFinished(run, err)
+		if err != nil {
+			b.saveResumeState(j, state, stage)
+			// Прерванная отменой команда - не ошибка скрипта или образа
+			if cancelErr := b.canceled(); cancelErr != nil {
+				return record, fmt.Errorf("stage %s interrupted: %w", stage, cancelErr)
+			}
+			// Ошибки встроенных шагов стадий относятся к среде сборки
+			if !classified(err) {
+				err = &JailError{err}
+			}
+			return record, fmt.Errorf("stage %s failed: %w", stage, err)
+		}
+		if err := state.CompleteStage(stage, stageArtifacts); err != nil {
+			b.log.Warn(err.Error())
+		}
+		if err := hooks.run(plugin.HookPostStage, plugin.Request{Stage: stage, TemplateDir: templateDir, Rootfs: j.GetChrootDir(), Artifacts: artifacts}); err != nil {
+			return record, err
+		}
+
+		b.printf("\n%s\n", logging.Success(fmt.Sprintf("✅ Stage %s completed in %s", stage, stageDuration(run))))
+
+		if b.opts.Checkpoint {
+			b.saveCheckpoint(j, stage)
+		}
+	}
+
+	// Все стадии завершены: возобновлять нечего
+	if err := resume.Clear(workspace); err != nil {
+		b.log.Warn(err.Error())
+	}
+
+	// Запоминаем состав пакетов собранной системы
+	record.Packages = b.collectPackages(j.GetChrootDir())
+
+	// Фиксируем версии пакетов, если записи для этой сборки еще нет
+	if (lockedPackages == nil || b.opts.UpdatePackageLock) && slices.Contains(stages, stageInstall) && !dnf.Supports(buildConfig.Base.Distro) {
+		if err := b.writePackageLock(j.GetChrootDir(), lockPath, b.packageLockKey(&buildConfig), buildConfig.Packages); err != nil {
+			return record, err
+		}
+	}
+
+	if b.opts.Manual {
+		// Если включен ручной режим, даем пользователю возможность войти в jail
+		b.println("\nEntering manual mode. Type 'exit' to quit and continue.")
+		b.enterManualShell(j)
+		b.println("Exited from manual mode, continuing...")
+	}
+
+	// Если стадия образа пропущена, сохраняем rootfs для последующего --reuse-rootfs
+	if b.opts.SkipImage {
+		// Секреты не должны попасть в сохраненный rootfs
+		if len(secretValues) > 0 {
+			if err := b.checkSecretLeaks(j, secretValues, &buildConfig); err != nil {
+				return record, err
+			}
+		}
+
+		rootfsDir := filepath.Join(b.opts.Output, "rootfs")
+		b.printf("\nSaving rootfs to %s\n", rootfsDir)
+
+		if err := j.ExportRootfs(rootfsDir); err != nil {
+			return record, &ImageError{fmt.Errorf("error saving rootfs: %w", err)}
+		}
+
+		b.println("Build completed successfully (image stage skipped)!")
+		return record, nil
+	}
+
+	// Публикуем артефакты в цели из секции publish
+	published, publishErr := publish.Publish(publish.Options{
+		Config:    &buildConfig,
+		OutputDir: b.opts.Output,
+		LogWriter: j.GetLogWriter(),
+		Out:       b,
+		Logger:    b.log,
+
+		Artifacts:  artifacts,
+		PluginDirs: plugin.Dirs(templateDir, b.opts.StateDir),
+	})
+	for _, p := range published {
+		record.Published = append(record.Published, store.Publication{Target: p.Target, ID: p.ID})
+		artifacts = append(artifacts, p.Artifacts...)
+	}
+
+	// SHA256SUMS создается всегда, файлы других алгоритмов - по секции digests
+	algos := digest.Include(buildConfig.Digests, digest.SHA256)
+	record.Artifacts, err = describeArtifacts(artifacts, algos)
+	if err != nil {
+		return record, &ImageError{err}
+	}
+
+	sums, err := writeChecksums(b.opts.Output, record.Artifacts, algos)
+	if err != nil {
+		return record, &ImageError{err}
+	}
+	b.emitArtifacts(record.Artifacts)
+
+	sumArtifacts, err := describeArtifacts(sums, nil)
+	if err != nil {
+		return record, &ImageError{err}
+	}
+	record.Artifacts = append(record.Artifacts, sumArtifacts...)
+	b.emitArtifacts(sumArtifacts)
+
+	// Торренты и Metalink для распространения крупных артефактов
+	distributed, err := distribute.Generate(buildConfig.Distribute, record.Artifacts, buildConfig.Version, b)
+	if err != nil {
+		return record, &ImageError{fmt.Errorf("error generating distribution files: %w", err)}
+	}
+	distArtifacts, err := describeArtifacts(distributed, nil)
+	if err != nil {
+		return record, &ImageError{err}
+	}
+	record.Artifacts = append(record.Artifacts, distArtifacts...)
+	b.emitArtifacts(distArtifacts)
+	if publishErr != nil {
+		return record, &PublishError{publishErr}
+	}
+
+	// Загружаем артефакты в места назначения из секции upload
+	if len(buildConfig.Upload) > 0 {
+		paths := make([]string, 0, len(record.Artifacts))
+		for _, artifact := range record.Artifacts {
+			paths = append(paths, artifact.Path)
+		}
+
+		uploaded, err := upload.Upload(upload.Options{
+			Destinations: buildConfig.Upload,
+			Artifacts:    paths,
+			StateDir:     b.opts.StateDir,
+			LogWriter:    j.GetLogWriter(),
+			Out:          b,
+			Logger:       b.log,
+		})
+		for _, u := range uploaded {
+			record.Published = append(record.Published, store.Publication{Target: "upload:" + u.Type, ID: u.Location})
+		}
+		if err != nil {
+			return record, &PublishError{err}
+		}
+	}
+	b.println("Build completed successfully!")
+	return record, nil
+}
+
+// checkSecretLeaks ищет значения секретов в файлах собранной системы
+func (b *build) checkSecretLeaks(j *jail.Jail, values map[string][]byte, cfg *structures.BuildConfig) error {
+	// Хеши паролей из password_secret и пароли сетей из psk_secret попадают
+	// в систему намеренно
+	values = maps.Clone(values)
+	for _, user := range cfg.Users {
+		delete(values, user.PasswordSecret)
+	}
+	for _, iface := range cfg.Network.Interfaces {
+		if iface.WiFi != nil {
+			delete(values, iface.WiFi.PSKSecret)
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	b.println("Checking rootfs for leaked secrets...")
+	leaked, err := secrets.Scan(j.GetChrootDir(), values, j.SystemPaths())
+	if err != nil {
+		return fmt.Errorf("error scanning rootfs for secrets: %w", err)
+	}
+	if len(leaked) > 0 {
+		return fmt.Errorf("secret values found in rootfs files: %s", strings.Join(leaked, ", "))
+	}
+	return nil
+}
+
+// saveBuildRecord сохраняет запись о сборке; ошибки хранилища не влияют на результат сборки
+func (b *build) saveBuildRecord(record *store.Record, buildErr error) {
+	record.Finish(buildErr)
+
+	st, err := store.Open(b.opts.StateDir)
+	if err != nil {
+		b.log.Warn(err.Error())
+		return
+	}
+
+	if err := st.Save(record); err != nil {
+		b.log.Warn(err.Error())
+		return
+	}
+
+	b.printf("Build recorded as %s\n", record.ID)
+}
+
+// writeBuildManifest записывает build-manifest.json в директорию вывода и в хранилище
+func (b *build) writeBuildManifest(record *store.Record, inputs manifest.Inputs) {
+	m := manifest.New(record, inputs)
+
+	paths := []string{filepath.Join(b.opts.Output, manifest.FileName)}
+	if st, err := store.Open(b.opts.StateDir); err == nil {
+		paths = append(paths, filepath.Join(st.BuildDir(record.ID), manifest.FileName))
+	}
+
+	for _, path := range paths {
+		if err := m.Write(path); err != nil {
+			b.log.Warn(err.Error())
+		}
+	}
+}
+
+// exitCode возвращает код завершения скрипта (-1, если процесс не был запущен)
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// collectPackages возвращает список пакетов, установленных в корневую ФС
+// (база apk или rpm)
+func (b *build) collectPackages(root string) []store.PackageRef {
+	packages, err := apk.ReadInstalled(root)
+	if err != nil {
+		b.log.Warn(err.Error())
+		return nil
+	}
+
+	refs := make([]store.PackageRef, 0, len(packages))
+	for _, pkg := range packages {
+		refs = append(refs, store.PackageRef{
+			Name:    pkg.Name,
+			Version: pkg.Version,
+			Origin:  pkg.Origin,
+			License: pkg.License,
+		})
+	}
+	if len(refs) > 0 {
+		return refs
+	}
+
+	rpms, err := dnf.ReadInstalled(root)
+	if err != nil {
+		b.log.Warn(err.Error())
+		return nil
+	}
+	for _, pkg := range rpms {
+		refs = append(refs, store.PackageRef{
+			Name:    pkg.Name,
+			Version: pkg.Version,
+			Origin:  pkg.SourceRPM,
+			License: pkg.License,
+		})
+	}
+	return refs
+}
+
+// emitStageFinished выводит событие завершения стадии
+func (b *build) emitStageFinished(run store.StageRun, err error) {
+	e := progress.Event{Type: progress.EventStageFinished, Stage: run.Name, Result: run.Result, Duration: run.Duration}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	b.emit(e)
+}
+
+// emitArtifacts выводит события о полученных артефактах
+func (b *build) emitArtifacts(artifacts []store.Artifact) {
+	for _, artifact := range artifacts {
+		e := progress.Event{Type: progress.EventArtifact, Path: artifact.Path, Size: artifact.Size}
+		for _, d := range artifact.Digests {
+			e.Digests = append(e.Digests, string(d))
+		}
+		b.emit(e)
+	}
+}
+
+// emitBuildFinished выводит событие завершения сборки
+func (b *build) emitBuildFinished(record *store.Record, err error) {
+	e := progress.Event{Type: progress.EventBuildFinished, Result: store.ResultSuccess}
+	if !record.StartedAt.IsZero() {
+		e.Duration = time.Since(record.StartedAt).Seconds()
+	}
+	if err != nil {
+		e.Result = store.ResultFailed
+		e.Error = err.Error()
+	}
+	b.emit(e)
+}
+
+// describeArtifacts собирает имена, размеры и дайджесты артефактов
+func describeArtifacts(paths []string, algos []string) ([]store.Artifact, error) {
+	var artifacts []store.Artifact
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+
+		digests, err := digest.File(path, algos)
+		if err != nil {
+			return nil, fmt.Errorf("error computing artifact digests: %w", err)
+		}
+
+		abs, _ := filepath.Abs(path)
+		artifacts = append(artifacts, store.Artifact{
+			Name:    filepath.Base(path),
+			Path:    abs,
+			Size:    info.Size(),
+			Digests: digests,
+		})
+	}
+	return artifacts, nil
+}
+
+// writeChecksums записывает файлы контрольных сумм всех артефактов в директорию
+// вывода. Артефакты указываются путем относительно нее, чтобы одноименные
+// файлы из разных подкаталогов не совпадали и sha256sum -c находил их.
+func writeChecksums(dir string, artifacts []store.Artifact, algos []string) ([]string, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(artifacts))
+	files := make(map[string][]digest.Digest)
+	for _, artifact := range artifacts {
+		name := artifact.Name
+		if rel, err := filepath.Rel(root, artifact.Path); err == nil && filepath.IsLocal(rel) {
+			name = filepath.ToSlash(rel)
+		}
+		names = append(names, name)
+		files[name] = artifact.Digests
+	}
+	sort.Strings(names)
+
+	var paths []string
+	for _, algo := range algos {
+		path, err := digest.WriteSums(dir, algo, names, files)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
+
+// printArtifactSummary выводит артефакты сборки с размерами и дайджестами
+func (b *build) printArtifactSummary(artifacts []store.Artifact) {
+	if len(artifacts) == 0 {
+		return
+	}
+
+	b.println("\nArtifacts:")
+	for _, artifact := range artifacts {
+		b.printf("  %s (%s)\n", artifact.Name, progress.FormatBytes(artifact.Size))
+		for _, d := range artifact.Digests {
+			b.printf("    %s\n", d)
+		}
+	}
+}
+
+// selectStages возвращает список стадий с учетом флагов --skip-image, --reuse-rootfs и --scripts-from
+func (b *build) selectStages() ([]string, error) {
+	if b.opts.SkipImage && b.opts.ReuseRootfs != "" {
+		return nil, fmt.Errorf("--skip-image and --reuse-rootfs cannot be used together")
+	}
+	if b.opts.DryRun && b.opts.Resume {
+		return nil, fmt.Errorf("--dry-run cannot be used with --resume")
+	}
+	if b.opts.CachePush && b.opts.CacheRemote == "" {
+		return nil, fmt.Errorf("--cache-push requires --cache-remote")
+	}
+	if b.opts.Resume && (b.opts.ScriptsFrom != "" || b.opts.ReuseRootfs != "") {
+		return nil, fmt.Errorf("--resume cannot be used with --scripts-from or --reuse-rootfs")
+	}
+
+	image := slices.Index(buildStages, stageImage)
+
+	start := 0
+	if b.opts.ScriptsFrom != "" {
+		start = slices.Index(buildStages, b.opts.ScriptsFrom)
+		if start < 0 {
+			return nil, fmt.Errorf("unknown stage %q (available: %s)", b.opts.ScriptsFrom, strings.Join(buildStages, ", "))
+		}
+	}
+
+	// Сохраненный rootfs уже прошел стадии до image
+	if b.opts.ReuseRootfs != "" {
+		if b.opts.ScriptsFrom != "" && start < image {
+			return nil, fmt.Errorf("--reuse-rootfs starts at the %s stage, got --scripts-from %s", stageImage, b.opts.ScriptsFrom)
+		}
+		start = max(start, image)
+	}
+
+	end := len(buildStages)
+	if b.opts.SkipImage {
+		end = image
+	}
+
+	var stages []string
+	if start < end {
+		stages = buildStages[start:end]
+	}
+
+	if len(stages) == 0 {
+		return nil, fmt.Errorf("no stages left to run")
+	}
+
+	return stages, nil
+}
+
+// previousStage возвращает стадию, предшествующую указанной, или пустую строку
+func previousStage(stage string) string {
+	for i, s := range buildStages {
+		if s == stage && i > 0 {
+			return buildStages[i-1]
+		}
+	}
+	return ""
+}
+
+// saveCheckpoint сохраняет контрольную точку стадии. Ошибка CRIU не прерывает
+// сборку: в этом случае сохраняется только снимок overlay.
+func (b *build) saveCheckpoint(j *jail.Jail, stage string) {
+	dir := filepath.Join(j.GetCheckpointDir(), stage)
+	b.printf("Saving checkpoint for stage %s to %s\n", stage, dir)
+
+	if err := j.Checkpoint(dir, true); err != nil {
+		b.log.Warn("process checkpoint failed, saving overlay snapshot only", "error", err)
+		if err := j.Checkpoint(dir, false); err != nil {
+			b.log.Warn("error saving checkpoint", "error", err)
+		}
+	}
+}
+
+// selectScripts возвращает скрипты стадий, исключенные флагами --skip, --only,
+// --from и --until, с причиной пропуска (ключ - stage/name)
+func (b *build) selectScripts(manifest scripts.Manifest, templateDir string, stages []string) (map[string]string, error) {
+	selection := scripts.Selection{Skip: b.opts.Skip, Only: b.opts.Only, From: b.opts.From, Until: b.opts.Until}
+	if selection.Empty() {
+		return nil, nil
+	}
+
+	plans := make(map[string][]scripts.Step)
+	for _, stage := range stages {
+		steps, err := manifest.Plan(stage, filepath.Join(templateDir, "scripts", stage))
+		if err != nil {
+			return nil, fmt.Errorf("error getting scripts: %w", err)
+		}
+		plans[stage] = steps
+	}
+	return selection.Excluded(stages, plans)
+}
+
+// countScripts возвращает число скриптов каждой стадии и сообщает общее
+// число событием build_planned
+func (b *build) countScripts(manifest scripts.Manifest, templateDir string, stages []string) (map[string]int, error) {
+	counts := make(map[string]int)
+	total := 0
+	for _, stage := range stages {
+		steps, err := manifest.Plan(stage, filepath.Join(templateDir, "scripts", stage))
+		if err != nil {
+			return nil, fmt.Errorf("error getting scripts: %w", err)
+		}
+		counts[stage] = len(steps)
+		total += len(steps)
+	}
+	b.emit(progress.Event{Type: progress.EventBuildPlanned, Scripts: total})
+	return counts, nil
+}
+
+// printBuildPlan выводит план сборки для --dry-run: итоговую конфигурацию,
+// точки монтирования jail, скрипты стадий с вычисленными условиями и
+// ожидаемые артефакты. Jail не запускается, каталог вывода не создается.
+func (b *build) printBuildPlan(templateDir string, stages []string, cfg *structures.BuildConfig) error {
+	b.println("\nDry run: nothing will be mounted, executed or written")
+
+	b.println("\nConfig:")
+	b.printf("  name       %s %s\n", cfg.Name, cfg.Version)
+	b.printf("  base       %s %s\n", cfg.Base.Distro, cfg.Base.Version)
+	if dnf.Supports(cfg.Base.Distro) && slices.Contains(stages, stagePrepare) {
+		repos := "host repositories"
+		if len(cfg.Base.Repos) > 0 {
+			names := make([]string, 0, len(cfg.Base.Repos))
+			for _, repo := range cfg.Base.Repos {
+				names = append(names, repo.Name)
+			}
+			repos = strings.Join(names, ", ")
+		}
+		b.printf("  bootstrap  dnf --installroot from %s\n", repos)
+	}
+	b.printf("  hostname   %s\n", cfg.System.Hostname)
+	if cfg.System.Timezone != "" {
+		b.printf("  timezone   %s\n", cfg.System.Timezone)
+	}
+	if cfg.System.Locale != "" {
+		b.printf("  locale     %s\n", cfg.System.Locale)
+	}
+	b.printf("  packages   %s\n", strings.Join(cfg.Packages, " "))
+	if !dnf.Supports(cfg.Base.Distro) {
+		key := b.packageLockKey(cfg)
+		locked, err := apk.ReadLock(filepath.Join(filepath.Dir(b.opts.Config), apk.LockFile), key)
+		switch {
+		case err != nil:
+			return err
+		case locked == nil || b.opts.UpdatePackageLock:
+			b.printf("  lock       %s: %s entry written after the build\n", apk.LockFile, key)
+		default:
+			b.printf("  lock       %s: %d versions pinned for %s\n", apk.LockFile, len(locked.Packages), key)
+		}
+	}
+	b.println("  (sysweaver config resolve prints the full config)")
+
+	j, err := jail.NewJail(b.ctx, filepath.Join(templateDir, template.JailFile), templateDir, b.loadOptions(), b)
+	if err != nil {
+		return fmt.Errorf("error creating jail: %w", err)
+	}
+	j.SetLogger(b.log)
+	if err := b.resolveBuilder(j, cfg.Arch, cfg.Mirrors, false); err != nil {
+		return err
+	}
+	if b.opts.ReuseRootfs != "" {
+		rootfs, err := filepath.Abs(b.opts.ReuseRootfs)
+		if err != nil {
+			return fmt.Errorf("error resolving rootfs path: %w", err)
+		}
+		j.SetBuilderPath(rootfs)
+	}
+	buildJSON, err := buildinfo.JSON(cfg)
+	if err != nil {
+		return err
+	}
+	j.SetRuntimeFile(buildinfo.Path, buildJSON)
+	j.SetRuntimeFile(helpers.Path, helpers.Script)
+
+	b.println("\nMounts:")
+	for _, m := range j.Mounts() {
+		line := fmt.Sprintf("  %-24s %-9s %s", m.Target, m.Type, m.Source)
+		if m.Options != "" {
+			line += " (" + m.Options + ")"
+		}
+		b.println(line)
+	}
+	if len(cfg.Secrets) > 0 {
+		names := make([]string, 0, len(cfg.Secrets))
+		for _, secret := range cfg.Secrets {
+			names = append(names, secret.Name)
+		}
+		b.printf("  %-24s %-9s secrets %s\n", secrets.Dir, "tmpfs", strings.Join(names, ", "))
+	}
+
+	manifest, err := scripts.LoadManifest(filepath.Join(templateDir, scripts.ManifestFile), buildStages)
+	if err != nil {
+		return err
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(buildJSON, &values); err != nil {
+		return fmt.Errorf("error decoding build config: %w", err)
+	}
+	deselected, err := b.selectScripts(manifest, templateDir, stages)
+	if err != nil {
+		return err
+	}
+
+	b.println("\nScripts:")
+	for _, stage := range stages {
+		steps, err := manifest.Plan(stage, filepath.Join(templateDir, "scripts", stage))
+		if err != nil {
+			return fmt.Errorf("error getting scripts: %w", err)
+		}
+
+		b.printf("  %s:\n", stage)
+		if stage == stageInstall && len(cfg.Packages) > 0 && !dnf.Supports(cfg.Base.Distro) {
+			b.printf("    first: apk add %s\n", strings.Join(cfg.Packages, " "))
+		}
+		if stage == stageConfigure && cfg.System != (structures.SystemConfig{}) {
+			b.println("    first: apply system hostname, timezone and locale")
+		}
+		if stage == stageConfigure && branding.Configured(cfg.Branding) {
+			b.println("    first: apply branding to os-release, motd, issue and GRUB")
+		}
+		if stage == stageConfigure && (len(cfg.Sysctl) > 0 || len(cfg.Modules.Load) > 0 || len(cfg.Modules.Blacklist) > 0) {
+			b.println("    first: write sysctl and kernel module config")
+		}
+		if stage == stageConfigure && len(cfg.Users) > 0 {
+			names := make([]string, 0, len(cfg.Users))
+			for _, user := range cfg.Users {
+				names = append(names, user.Name)
+			}
+			b.printf("    first: create users %s\n", strings.Join(names, ", "))
+		}
+		if stage == stageConfigure && len(cfg.Network.Interfaces) > 0 {
+			backend := cfg.Network.Backend
+			if backend == "" {
+				backend = "detected"
+			}
+			b.printf("    first: write %s network config for %d interfaces\n", backend, len(cfg.Network.Interfaces))
+		}
+		if len(steps) == 0 {
+			b.println("    (no scripts)")
+		}
+
+		// Скрипты, которые не будут выполнены: зависящие от них тоже пропускаются
+		incomplete := make(map[string]bool)
+		for _, step := range steps {
+			status := "run"
+			if reason := deselected[stage+"/"+step.Name]; reason != "" {
+				status = "skip: " + reason
+			} else if dep := firstIncomplete(step.DependsOn, incomplete); dep != "" {
+				status = fmt.Sprintf("skip: dependency %s is skipped", dep)
+				incomplete[step.Name] = true
+			} else if step.When != "" {
+				ok, err := scripts.Eval(step.When, values, templateDir)
+				if err != nil {
+					return fmt.Errorf("error evaluating when of script %s: %w", step.Name, err)
+				}
+				if ok {
+					status = fmt.Sprintf("run (when %q is true)", step.When)
+				} else {
+					status = fmt.Sprintf("skip: when %q is false", step.When)
+					incomplete[step.Name] = true
+				}
+			}
+			b.printf("    %-32s %s\n", step.Name, status)
+		}
+
+		switch stage {
+		case stageInstall:
+			if _, err := os.Stat(filepath.Join(templateDir, rootfs.OverlayDir)); err == nil {
+				b.printf("    then: copy the %s/ overlay\n", rootfs.OverlayDir)
+			}
+		case stageConfigure:
+			if len(cfg.Services.Enable) > 0 {
+				b.printf("    then: enable services %s\n", strings.Join(cfg.Services.Enable, ", "))
+			}
+			if len(cfg.Services.Disable) > 0 {
+				b.printf("    then: disable services %s\n", strings.Join(cfg.Services.Disable, ", "))
+			}
+			if firewall.Configured(cfg.Firewall) {
+				b.printf("    then: write nftables firewall rules (%d allow, %d deny) and enable %s\n", len(cfg.Firewall.Allow), len(cfg.Firewall.Deny), firewall.ServiceName)
+			}
+			if names, _ := firstboot.Scripts(templateDir); len(names) > 0 {
+				b.printf("    then: install %s/ scripts for the first boot: %s\n", firstboot.Dir, strings.Join(names, ", "))
+			}
+		case stageImage:
+			b.printf("    then: collect /output into %s\n", b.opts.Output)
+		}
+	}
+
+	b.println("\nArtifacts:")
+	b.printf("  %s/<stage>/<script>.log for every script\n", filepath.Join(b.opts.Output, scriptLogsDir))
+	if b.opts.SkipImage {
+		b.printf("  %s (saved rootfs)\n", filepath.Join(b.opts.Output, "rootfs"))
+		return nil
+	}
+	if slices.Contains(stages, stageImage) {
+		b.printf("  files the image stage leaves in /output, copied to %s\n", b.opts.Output)
+		for _, spec := range cfg.Outputs {
+			line := "  output " + spec.Type
+			if spec.Name != "" {
+				line += " " + spec.Name
+			}
+			if spec.Source != "" {
+				line += " from " + spec.Source
+			}
+			b.println(line)
+		}
+	}
+	for _, algo := range digest.Include(cfg.Digests, digest.SHA256) {
+		b.printf("  %s\n", filepath.Join(b.opts.Output, digest.SumsFile(algo)))
+	}
+	if cfg.Distribute.Torrent != nil {
+		b.println("  .torrent files for distributed artifacts")
+	}
+	if cfg.Distribute.Metalink != nil {
+		b.println("  .meta4 files for distributed artifacts")
+	}
+
+	publish := cfg.Publish
+	for _, target := range []struct {
+		name    string
+		enabled bool
+	}{
+		{"proxmox", publish.Proxmox != nil},
+		{"aws", publish.AWS != nil},
+		{"gcp", publish.GCP != nil},
+		{"azure", publish.Azure != nil},
+		{"oci", publish.OCI != nil},
+	} {
+		if target.enabled {
+			b.printf("  publish to %s\n", target.name)
+		}
+	}
+	for _, dest := range cfg.Upload {
+		target := dest.URL
+		switch dest.Type {
+		case "s3":
+			target = "s3://" + dest.Bucket + "/" + dest.Prefix
+		case "sftp":
+			target = dest.Host
+		}
+		b.printf("  upload to %s %s\n", dest.Type, target)
+	}
+	return nil
+}
+
+// scriptLayers - кэш слоев скриптов сборки. Слои сохраняются, пока цепочка
+// шагов не прервана: после пропущенного выбором или упавшего скрипта
+// состояние системы уже не определяется ключами.
+type scriptLayers struct {
+	*build
+	cache    *cache.Cache
+	remote   cache.Remote      // Общее хранилище слоев (--cache-remote)
+	push     bool              // Выгружать сохраненные слои в общее хранилище
+	keys     map[string]string // stage/name -> ключ слоя
+	restored map[string]bool   // Скрипты, восстановленные из кэша
+	snapshot string            // Временный каталог восстановленных слоев
+	upper    string            // Верхний слой overlay jail
+	index    cache.Index       // Состояние верхнего слоя после последнего сохраненного слоя
+	saved    int
+	stopped  bool
+}
+
+// planScriptLayers вычисляет ключи скриптов выбранных стадий и восстанавливает
+// слои совпадающего начала конвейера в снимок верхнего слоя jail
+func (b *build) planScriptLayers(j *jail.Jail, manifest scripts.Manifest, templateDir string, stages []string, buildJSON []byte, fromCheckpoint bool) (*scriptLayers, error) {
+	var reason string
+	switch {
+	case b.opts.Jobs > 1:
+		reason = "--jobs runs scripts in parallel"
+	case b.opts.Step:
+		reason = "--step allows changes outside of scripts"
+	case b.opts.Resume || fromCheckpoint:
+		reason = "the build starts from a saved jail state"
+	}
+	if reason != "" {
+		b.log.Warn("layer cache disabled: " + reason)
+		return nil, nil
+	}
+
+	layers := &scriptLayers{
+		build:    b,
+		cache:    cache.Open(b.opts.StateDir),
+		push:     b.opts.CachePush,
+		keys:     make(map[string]string),
+		restored: make(map[string]bool),
+	}
+	if b.opts.CacheRemote != "" {
+		remote, err := cache.OpenRemote(b.opts.CacheRemote)
+		if err != nil {
+			return nil, err
+		}
+		layers.remote = remote
+	}
+
+	key, err := cache.BaseKey(j.GetBuilderPath(), buildJSON, templateDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var restore []string
+	hit := true
+	for _, stage := range stages {
+		steps, err := manifest.Plan(stage, filepath.Join(templateDir, "scripts", stage))
+		if err != nil {
+			return nil, fmt.Errorf("error getting scripts: %w", err)
+		}
+		for _, step := range steps {
+			if key, err = cache.StepKey(key, stage, step); err != nil {
+				return nil, err
+			}
+			layers.keys[stage+"/"+step.Name] = key
+			if hit = hit && layers.fetch(stage, step.Name, key); hit {
+				restore = append(restore, key)
+				layers.restored[stage+"/"+step.Name] = true
+			}
+		}
+	}
+
+	if len(restore) == 0 {
+		b.println("Layer cache: no cached scripts")
+		return layers, nil
+	}
+
+	layers.snapshot, err = os.MkdirTemp("", "sysweaver-layers-")
+	if err != nil {
+		return nil, fmt.Errorf("error creating layer directory: %w", err)
+	}
+	b.printf("Layer cache: restoring %d of %d scripts from %s\n", len(restore), len(layers.keys), layers.cache.Dir())
+	if err := layers.cache.Restore(restore, layers.snapshot); err != nil {
+		os.RemoveAll(layers.snapshot)
+		return nil, err
+	}
+	j.SetOverlaySnapshot(layers.snapshot)
+	return layers, nil
+}
+
+// start запоминает состояние верхнего слоя запущенного jail
+func (l *scriptLayers) start(j *jail.Jail) error {
+	if l == nil {
+		return nil
+	}
+	if l.snapshot != "" {
+		os.RemoveAll(l.snapshot)
+	}
+
+	l.upper = j.UpperDir()
+	index, err := cache.Scan(l.upper)
+	if err != nil {
+		return err
+	}
+	l.index = index
+	return nil
+}
+
+// restoredStage сообщает, восстановлены ли из кэша скрипты стадии
+func (l *scriptLayers) restoredStage(stage string) bool {
+	if l == nil {
+		return false
+	}
+	for key := range l.restored {
+		if strings.HasPrefix(key, stage+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// restoredAny сообщает, восстановлено ли из кэша начало конвейера
+func (l *scriptLayers) restoredAny() bool {
+	return l != nil && len(l.restored) > 0
+}
+
+// cached сообщает, восстановлен ли результат скрипта из кэша
+func (l *scriptLayers) cached(stage, name string) bool {
+	return l != nil && l.restored[stage+"/"+name]
+}
+
+// save сохраняет изменения, сделанные скриптом, как его слой
+func (l *scriptLayers) save(stage, name string) {
+	if l == nil || l.stopped {
+		return
+	}
+	index, err := l.cache.Store(l.keys[stage+"/"+name], l.upper, l.index)
+	if err != nil {
+		l.log.Warn("layer cache disabled for the rest of the build", "error", err)
+		l.stopped = true
+		return
+	}
+	l.index = index
+	l.saved++
+
+	if l.push && l.remote != nil {
+		if err := l.cache.Push(l.remote, l.keys[stage+"/"+name]); err != nil {
+			l.log.Warn(err.Error())
+		}
+	}
+}
+
+// fetch проверяет наличие слоя в локальном кэше и загружает недостающий слой
+// из общего хранилища. Ошибки загрузки не прерывают сборку: скрипт выполняется.
+func (l *scriptLayers) fetch(stage, name, key string) bool {
+	if l.cache.Has(key) {
+		return true
+	}
+	if l.remote == nil {
+		return false
+	}
+
+	found, err := l.cache.Fetch(l.remote, key)
+	if err != nil {
+		l.log.Warn(err.Error())
+		return false
+	}
+	if found {
+		l.printf("Layer cache: pulled %s/%s from %s\n", stage, name, l.remote)
+	}
+	return found
+}
+
+// stop прерывает цепочку: следующие слои не сохраняются
+func (l *scriptLayers) stop() {
+	if l != nil && !l.stopped {
+		l.stopped = true
+		if l.saved > 0 {
+			l.printf("Layer cache: %d layers saved, later scripts are not cached\n", l.saved)
+		}
+	}
+}
+
+// loadResumeState читает состояние упавшей сборки для --resume
+func (b *build) loadResumeState(workspace, templatePath, configPath string) (*resume.State, error) {
+	state, err := resume.Load(workspace)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, fmt.Errorf("no failed build to resume in %s", workspace)
+	}
+	if state.Template != templatePath {
+		return nil, fmt.Errorf("the failed build in %s is of template %s, not %s", workspace, state.Template, templatePath)
+	}
+	if state.Config != configPath {
+		b.log.Warn("the failed build used another config", "failed", state.Config, "config", configPath)
+	}
+	return state, nil
+}
+
+// saveResumeState сохраняет состояние упавшей сборки и снимок overlay для --resume
+func (b *build) saveResumeState(j *jail.Jail, state *resume.State, stage string) {
+	state.Failed = stage
+	if err := state.Save(); err != nil {
+		b.log.Warn(err.Error())
+		return
+	}
+	if err := j.Checkpoint(state.Snapshot(), false); err != nil {
+		b.log.Warn("error saving build state", "error", err)
+		return
+	}
+	b.printf("Build state saved to %s; rerun with --resume to continue from the failed script\n", state.Dir())
+}
+
+// stageRunner - общие для всех стадий параметры сборки
+type stageRunner struct {
+	*build
+	jail              *jail.Jail
+	templateDir       string
+	outputDirInChroot string
+	config            *structures.BuildConfig
+	configValues      map[string]interface{} // Значения конфигурации для условий when
+	scripts           scripts.Manifest
+	secrets           map[string][]byte
+	record            *store.Record
+	state             *resume.State     // Выполненные скрипты для --resume
+	deselected        map[string]string // Скрипты, исключенные --skip, --only, --from и --until
+	stepping          bool              // Пауза перед каждым скриптом (--step)
+	layers            *scriptLayers     // Кэш слоев скриптов (--cache)
+	lockedPackages    *apk.LockedSet    // Версии пакетов из packages.lock
+	hooks             *buildHooks       // Хуки сборки (pre-artifact-copy)
+	mutex             sync.Mutex        // Защищает record и state при параллельном выполнении скриптов
+}
+
+// run выполняет скрипты стадии и встроенные шаги, привязанные к ней:
+// оверлей rootfs/ после install, сбор артефактов и outputs на стадии image.
+// Возвращает пути артефактов, созданных стадией.
+func (r *stageRunner) run(stage string) ([]string, error) {
+	j := r.jail
+
+	// Секреты не должны попасть в артефакты: скрипт мог скопировать их в rootfs
+	if stage == stageImage && len(r.secrets) > 0 {
+		if err := r.checkSecretLeaks(j, r.secrets, r.config); err != nil {
+			return nil, err
+		}
+	}
+
+	// Пакеты из packages устанавливаются до скриптов стадии install;
+	// восстановленные из кэша слои скриптов уже содержат их
+	if stage == stageInstall && !r.layers.restoredStage(stage) {
+		if err := r.installPackages(j, r.config, r.lockedPackages); err != nil {
+			return nil, err
+		}
+	}
+
+	// Имя хоста, часовой пояс, локаль из system, оформление из branding,
+	// параметры ядра из sysctl и modules, пользователи из users и сеть из
+	// network применяются до скриптов стадии configure, чтобы скрипты могли
+	// их переопределить
+	if stage == stageConfigure {
+		if err := r.applySystem(); err != nil {
+			return nil, err
+		}
+		if err := r.applyBranding(); err != nil {
+			return nil, err
+		}
+		// Восстановленные из кэша слои уже содержат sysctl.d и modules-load.d
+		if !r.layers.restoredStage(stage) {
+			if err := r.applyKernelConfig(j, r.config); err != nil {
+				return nil, err
+			}
+		}
+		if err := r.applyUsers(); err != nil {
+			return nil, err
+		}
+		if err := r.applyNetwork(); err != nil {
+			return nil, err
+		}
+	}
+
+	err := r.runScripts(stage)
+	// Результаты проверок собираются и после ошибки скрипта стадии test
+	if stage == stageTest {
+		if testErr := r.collectTestResults(); err == nil {
+			err = testErr
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch stage {
+	case stageInstall:
+		// Файлы rootfs/ шаблона накладываются поверх установленных пакетов
+		return nil, r.applyRootfsOverlay(j, r.templateDir)
+
+	case stageConfigure:
+		// Службы включаются после скриптов: скрипты могли установить свои
+		if err := r.applyServices(j, r.config.Services); err != nil {
+			return nil, err
+		}
+		if err := r.installFirewall(j, r.config.Firewall); err != nil {
+			return nil, err
+		}
+		return nil, r.installFirstboot(j, r.templateDir)
+
+	case stageImage:
+		if err := r.hooks.run(plugin.HookPreArtifactCopy, plugin.Request{TemplateDir: r.templateDir, Rootfs: j.GetChrootDir()}); err != nil {
+			return nil, err
+		}
+
+		// Копируем готовые образы из chroot в указанную директорию вывода
+		artifacts, err := r.copyArtifacts(r.outputDirInChroot, r.opts.Output)
+		if err != nil {
+			return nil, &ImageError{err}
+		}
+
+		// Конвертируем образы в дополнительные форматы из секции outputs
+		if len(r.config.Outputs) > 0 {
+			r.println("\nGenerating configured outputs...")
+			produced, err := output.Generate(output.Options{
+				Config:    r.config,
+				OutputDir: r.opts.Output,
+				Rootfs:    j.GetChrootDir(),
+				Exclude:   j.SystemPaths(),
+
+				TemplateDir: r.templateDir,
+				LogWriter:   j.GetLogWriter(),
+				Out:         r.build,
+				Logger:      r.log,
+				PluginDirs:  plugin.Dirs(r.templateDir, r.opts.StateDir),
+				Context:     r.ctx,
+			})
+			if err != nil {
+				return artifacts, &ImageError{fmt.Errorf("error generating outputs: %w", err)}
+			}
+			for _, path := range produced {
+				r.printf("Generated %s\n", path)
+			}
+			artifacts = append(artifacts, produced...)
+		}
+		return artifacts, nil
+	}
+
+	return nil, nil
+}
+
+// collectTestResults читает результаты проверок sw_assert* скриптов стадии
+// test, записывает их в запись о сборке и выводит отчет. Возвращает ошибку,
+// если хотя бы одна проверка не прошла.
+func (r *stageRunner) collectTestResults() error {
+	data, err := os.ReadFile(filepath.Join(r.jail.GetChrootDir(), helpers.ResultsPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("error reading test results: %w", err)
+	}
+	results := helpers.ParseResults(data)
+
+	var failed []store.TestResult
+	for _, result := range results {
+		test := store.TestResult{
+			Script:    result.Script,
+			Assertion: result.Assertion,
+			Passed:    result.Passed,
+			Detail:    result.Detail,
+		}
+		r.record.Tests = append(r.record.Tests, test)
+		if !test.Passed {
+			failed = append(failed, test)
+		}
+	}
+
+	// Отчет для CI записывается и без проверок, и при их ошибках
+	if r.opts.JUnitReport != "" {
+		if err := junit.Write(r.opts.JUnitReport, r.config.Name, r.record.Tests); err != nil {
+			return err
+		}
+		r.printf("JUnit report written to %s\n", r.opts.JUnitReport)
+	}
+	if len(results) == 0 {
+		return nil
+	}
+
+	r.printf("\nTests: %d passed, %d failed\n", len(results)-len(failed), len(failed))
+	for _, test := range failed {
+		line := logging.Failure("  ✗") + fmt.Sprintf(" %-28s %s", test.Script, test.Assertion)
+		if test.Detail != "" {
+			line += ": " + test.Detail
+		}
+		r.println(line)
+	}
+	if len(failed) > 0 {
+		return &ScriptError{Stage: stageTest, ExitCode: -1, Err: fmt.Errorf("%d of %d test assertions failed", len(failed), len(results))}
+	}
+	return nil
+}
+
+// stageDuration возвращает длительность стадии, округленную до секунд
+func stageDuration(run store.StageRun) time.Duration {
+	return time.Duration(run.Duration * float64(time.Second)).Round(time.Second)
+}
+
+// Причина пропуска скриптов, восстановленных из кэша слоев
+const skipCached = "restored from the layer cache"
+
+// printBuildSummary выводит итоговую сводку сборки (в том числе неудачной):
+// результат и общее время, стадии, скрипты с попаданиями в кэш, самые
+// затратные скрипты, артефакты с размерами и дайджестами и предупреждения,
+// которые иначе теряются в выводе скриптов
+func (b *build) printBuildSummary(record *store.Record) {
+	b.printf("\n=== Build summary ===\n")
+	b.printf("Build %s %s in %s\n", record.ID, logging.Result(record.Result, record.Result),
+		record.FinishedAt.Sub(record.StartedAt).Round(time.Second))
+
+	if len(record.Stages) > 0 {
+		b.println("\nStages:")
+		for _, run := range record.Stages {
+			b.printf("  %-10s %s %s\n", run.Name, logging.Result(run.Result, fmt.Sprintf("%-8s", run.Result)), stageDuration(run))
+		}
+	}
+
+	var skipped, failed []store.ScriptRun
+	executed, cached := 0, 0
+	logged := false
+	for _, run := range record.Scripts {
+		switch {
+		case run.Skipped == skipCached:
+			cached++
+		case run.Skipped != "":
+			skipped = append(skipped, run)
+		default:
+			executed++
+			if run.ExitCode != 0 {
+				failed = append(failed, run)
+			}
+		}
+		logged = logged || run.Log != ""
+	}
+	if len(record.Scripts) > 0 {
+		b.printf("\nScripts: %d run, %d failed, %d skipped, %d restored from the layer cache\n",
+			executed, len(failed), len(skipped), cached)
+	}
+	if len(skipped) > 0 {
+		b.println("\nSkipped scripts:")
+		for _, run := range skipped {
+			b.printf("  %-36s %s\n", run.Stage+"/"+run.Name, run.Skipped)
+		}
+	}
+	if len(failed) > 0 {
+		b.println("\nFailed scripts:")
+		for _, run := range failed {
+			b.printf("  %s %s\n", logging.Failure(fmt.Sprintf("%-36s", run.Stage+"/"+run.Name)), run.Log)
+		}
+	}
+	b.printTopScripts(record.Scripts)
+	b.printArtifactSummary(record.Artifacts)
+
+	if len(record.Warnings) > 0 {
+		b.printf("\nWarnings (%d):\n", len(record.Warnings))
+		for _, warning := range record.Warnings {
+			b.printf("  %s %s\n", logging.Warning("!"), warning)
+		}
+	}
+	if logged {
+		b.printf("\nScript logs: %s\n", filepath.Join(record.OutputDir, scriptLogsDir))
+	}
+}
+
+// Число скриптов в таблице самых затратных
+const topScripts = 5
+
+// printTopScripts выводит самые долгие скрипты сборки с расходом ресурсов,
+// чтобы было видно, что оптимизировать в шаблоне
+func (b *build) printTopScripts(runs []store.ScriptRun) {
+	var executed []store.ScriptRun
+	for _, run := range runs {
+		if run.Skipped == "" {
+			executed = append(executed, run)
+		}
+	}
+	if len(executed) == 0 {
+		return
+	}
+	slices.SortStableFunc(executed, func(x, y store.ScriptRun) int {
+		return cmp.Compare(y.Duration, x.Duration)
+	})
+	if len(executed) > topScripts {
+		executed = executed[:topScripts]
+	}
+
+	b.println("\nTop scripts:")
+	b.printf("  %-36s %9s %9s %11s %11s\n", "SCRIPT", "TIME", "CPU", "PEAK MEM", "WRITTEN")
+	for _, run := range executed {
+		b.printf("  %-36s %9s %9s %11s %11s\n", run.Stage+"/"+run.Name,
+			formatSeconds(run.Duration), formatSeconds(run.CPU),
+			progress.FormatBytes(run.PeakMemory), progress.FormatBytes(run.Written))
+	}
+}
+
+// formatSeconds форматирует длительность в секундах с точностью до десятых
+func formatSeconds(seconds float64) string {
+	return time.Duration(seconds * float64(time.Second)).Round(100 * time.Millisecond).String()
+}
+
+// applySystem применяет настройки system конфигурации к корневой ФС и
+// записывает их в запись о сборке
+func (r *stageRunner) applySystem() error {
+	system := r.config.System
+	if system == (structures.SystemConfig{}) {
+		return nil
+	}
+
+	if !r.layers.restoredStage(stageConfigure) {
+		var applied []string
+		for _, setting := range []struct{ name, value string }{
+			{"hostname", system.Hostname},
+			{"timezone", system.Timezone},
+			{"locale", system.Locale},
+		} {
+			if setting.value != "" {
+				applied = append(applied, setting.name+" "+setting.value)
+			}
+		}
+		r.printf("Applying system settings: %s\n", strings.Join(applied, ", "))
+		if err := rootfs.ApplySystem(r.jail.GetChrootDir(), system); err != nil {
+			return err
+		}
+	}
+
+	r.record.System = &store.System{
+		Hostname: system.Hostname,
+		Timezone: system.Timezone,
+		Locale:   system.Locale,
+	}
+	return nil
+}
+
+// applyBranding применяет оформление из branding конфигурации: os-release,
+// motd, issue и оформление GRUB
+func (r *stageRunner) applyBranding() error {
+	if !branding.Configured(r.config.Branding) || r.layers.restoredStage(stageConfigure) {
+		return nil
+	}
+
+	date := r.record.StartedAt
+	if epoch := rootfs.SourceDateEpoch(); epoch != nil {
+		date = *epoch
+	}
+	data := branding.NewData(r.config, r.record.ID, date, r.opts.Profiles)
+
+	r.printf("Applying branding: %s\n", data.PrettyName)
+	if err := branding.Apply(r.jail.GetChrootDir(), r.templateDir, r.config.Branding, data); err != nil {
+		return fmt.Errorf("error applying branding: %w", err)
+	}
+	return nil
+}
+
+// applyKernelConfig записывает параметры sysctl и списки модулей ядра.
+// В OpenRC службы sysctl и modules, применяющие их, включаются на уровне boot.
+func (b *build) applyKernelConfig(j *jail.Jail, cfg *structures.BuildConfig) error {
+	if len(cfg.Sysctl) == 0 && len(cfg.Modules.Load) == 0 && len(cfg.Modules.Blacklist) == 0 {
+		return nil
+	}
+
+	root := j.GetChrootDir()
+	b.printf("Writing kernel config: %d sysctl settings, %d modules to load, %d blacklisted\n",
+		len(cfg.Sysctl), len(cfg.Modules.Load), len(cfg.Modules.Blacklist))
+	if err := rootfs.ApplyKernel(root, cfg.Sysctl, cfg.Modules); err != nil {
+		return err
+	}
+
+	if services.Detect(root) != services.OpenRC {
+		return nil
+	}
+	var enable []string
+	for _, name := range []string{"sysctl", "modules"} {
+		service := services.Service{Name: name, Runlevel: "boot"}
+		if services.Check(root, services.OpenRC, service) == nil && !services.Enabled(root, services.OpenRC, service) {
+			enable = append(enable, name+":boot")
+		}
+	}
+	return b.applyServices(j, structures.ServicesConfig{Enable: enable})
+}
+
+// applyUsers создает пользователей из users конфигурации в корневой ФС
+func (r *stageRunner) applyUsers() error {
+	users := r.config.Users
+	if len(users) == 0 || r.layers.restoredStage(stageConfigure) {
+		return nil
+	}
+
+	// Хеши паролей из секретов
+	passwords := make(map[string]string)
+	names := make([]string, 0, len(users))
+	for _, user := range users {
+		names = append(names, user.Name)
+		if user.PasswordSecret == "" {
+			continue
+		}
+		value, ok := r.secrets[user.PasswordSecret]
+		if !ok {
+			return fmt.Errorf("user %s: secret %q is not defined in secrets", user.Name, user.PasswordSecret)
+		}
+		passwords[user.Name] = string(value)
+	}
+
+	r.printf("Creating users: %s\n", strings.Join(names, ", "))
+	return rootfs.ApplyUsers(r.jail.GetChrootDir(), users, passwords, r.log)
+}
+
+// applyNetwork записывает сетевую конфигурацию из network конфигурации
+func (r *stageRunner) applyNetwork() error {
+	cfg := r.config.Network
+	if len(cfg.Interfaces) == 0 || r.layers.restoredStage(stageConfigure) {
+		return nil
+	}
+
+	root := r.jail.GetChrootDir()
+	r.printf("Writing %s network config for %d interfaces\n", network.Detect(root, cfg), len(cfg.Interfaces))
+	files, err := network.Apply(root, cfg, r.secrets, r.log)
+	if err != nil {
+		return fmt.Errorf("error writing network config: %w", err)
+	}
+	if r.opts.Verbose {
+		for _, file := range files {
+			r.printf("  %s\n", file)
+		}
+	}
+	return nil
+}
+
+// applyServices включает и отключает службы из services конфигурации
+func (b *build) applyServices(j *jail.Jail, cfg structures.ServicesConfig) error {
+	if len(cfg.Enable) == 0 && len(cfg.Disable) == 0 {
+		return nil
+	}
+
+	root := j.GetChrootDir()
+	initSystem := services.Detect(root)
+	if initSystem == "" {
+		return fmt.Errorf("services: neither OpenRC nor systemd is installed in the system")
+	}
+
+	for _, list := range []struct {
+		entries []string
+		enable  bool
+	}{{cfg.Disable, false}, {cfg.Enable, true}} {
+		for _, entry := range list.entries {
+			service, err := services.Parse(initSystem, entry)
+			if err != nil {
+				return fmt.Errorf("services: %w", err)
+			}
+			if err := services.Check(root, initSystem, service); err != nil {
+				return fmt.Errorf("services: %w", err)
+			}
+
+			command := services.EnableCommand(initSystem, service)
+			if !list.enable {
+				if !services.Enabled(root, initSystem, service) {
+					continue
+				}
+				command = services.DisableCommand(initSystem, service)
+			}
+			b.printf("Running %s\n", strings.Join(command, " "))
+			output, err := j.ExecuteCommandWithOutput(command[0], command[1:]...)
+			if b.opts.Verbose || err != nil {
+				b.printf("%s", output)
+			}
+			if err != nil {
+				return fmt.Errorf("error running %s: %w", strings.Join(command, " "), err)
+			}
+		}
+	}
+	return nil
+}
+
+// installFirewall записывает правила nftables из firewall конфигурации и
+// включает службу nftables
+func (b *build) installFirewall(j *jail.Jail, cfg structures.FirewallConfig) error {
+	if !firewall.Configured(cfg) {
+		return nil
+	}
+
+	root := j.GetChrootDir()
+	path, err := firewall.Install(root, cfg)
+	if err != nil {
+		return err
+	}
+	b.printf("Wrote firewall rules to %s (%d allow, %d deny)\n", path, len(cfg.Allow), len(cfg.Deny))
+
+	return b.applyServices(j, structures.ServicesConfig{Enable: []string{firewall.ServiceName}})
+}
+
+// installFirstboot устанавливает скрипты firstboot/ шаблона и включает
+// службу, которая выполнит их при первой загрузке
+func (b *build) installFirstboot(j *jail.Jail, templateDir string) error {
+	service, err := firstboot.Install(templateDir, j.GetChrootDir())
+	if err != nil || service == nil {
+		return err
+	}
+
+	initSystem := services.Detect(j.GetChrootDir())
+	command := services.EnableCommand(initSystem, *service)
+	b.printf("Installed %s/ scripts, running %s\n", firstboot.Dir, strings.Join(command, " "))
+	output, err := j.ExecuteCommandWithOutput(command[0], command[1:]...)
+	if b.opts.Verbose || err != nil {
+		b.printf("%s", output)
+	}
+	if err != nil {
+		return fmt.Errorf("error enabling %s: %w", firstboot.ServiceName, err)
+	}
+	return nil
+}
+
+// installPackages устанавливает пакеты из packages конфигурации через apk.
+// Версии из packages.lock попадают в world, поэтому пакеты, установленные
+// скриптами, тоже получают зафиксированные версии. В dnf-дистрибутивах
+// пакеты уже установлены при создании корневой ФС.
+func (b *build) installPackages(j *jail.Jail, cfg *structures.BuildConfig, locked *apk.LockedSet) error {
+	if (len(cfg.Packages) == 0 && locked == nil) || dnf.Supports(cfg.Base.Distro) {
+		return nil
+	}
+
+	for _, entry := range cfg.Packages {
+		if _, err := apk.ParseConstraint(entry); err != nil {
+			return fmt.Errorf("error in packages: %w", err)
+		}
+	}
+
+	packages := cfg.Packages
+	if locked != nil {
+		var changed []string
+		packages, changed = locked.Pin(cfg.Packages)
+		if len(changed) > 0 {
+			b.log.Warn("packages changed since "+apk.LockFile+" was written (refresh it with --update-lock)", "packages", strings.Join(changed, " "))
+		}
+		b.printf("Installing %d packages from config with %d versions pinned by %s\n", len(cfg.Packages), len(locked.Packages), apk.LockFile)
+	} else {
+		b.printf("Installing %d packages from config: %s\n", len(cfg.Packages), strings.Join(cfg.Packages, " "))
+	}
+	output, err := j.ExecuteCommandWithOutput("apk", append([]string{"add", "--no-progress"}, packages...)...)
+	if b.opts.Verbose || err != nil {
+		b.printf("%s", output)
+	}
+	if err != nil {
+		return fmt.Errorf("error installing packages: %w", err)
+	}
+	return nil
+}
+
+// packageLockKey возвращает ключ записи packages.lock для сборки
+func (b *build) packageLockKey(cfg *structures.BuildConfig) string {
+	arch := cfg.Arch
+	if arch == "" {
+		arch = builder.HostArch()
+	}
+	return apk.LockKey(arch, b.opts.Profiles)
+}
+
+// writePackageLock записывает версии установленных пакетов в packages.lock
+func (b *build) writePackageLock(root, path, key string, requested []string) error {
+	installed, err := apk.ReadInstalled(root)
+	if err != nil {
+		return err
+	}
+	if len(installed) == 0 {
+		return nil
+	}
+	if err := apk.UpdateLock(path, key, requested, installed); err != nil {
+		return err
+	}
+	b.printf("Pinned %d package versions for %s in %s\n", len(installed), key, path)
+	return nil
+}
+
+// applyRootfsOverlay копирует rootfs/ шаблона в корневую ФС jail с правами из rootfs.yaml
+func (b *build) applyRootfsOverlay(j *jail.Jail, templateDir string) error {
+	overlay := filepath.Join(templateDir, rootfs.OverlayDir)
+	if _, err := os.Stat(overlay); os.IsNotExist(err) {
+		return nil
+	}
+
+	b.printf("Applying %s/ overlay...\n", rootfs.OverlayDir)
+	count, err := rootfs.ApplyOverlay(overlay, filepath.Join(templateDir, rootfs.OverlayMeta), j.GetChrootDir(), j.GetLogWriter(), b.log)
+	if err != nil {
+		return err
+	}
+	b.printf("Copied %d files from %s/\n", count, rootfs.OverlayDir)
+	return nil
+}
+
+// runScripts выполняет скрипты стадии из scripts/<stage> шаблона в порядке,
+// заданном scripts.yaml, с учетом зависимостей и условий when
+func (r *stageRunner) runScripts(stage string) error {
+	// Собираем скрипты из шаблона
+	scriptsDir := filepath.Join(r.templateDir, "scripts", stage)
+	if _, err := os.Stat(scriptsDir); os.IsNotExist(err) {
+		r.printf("No scripts for stage %s, skipping\n", stage)
+		return nil
+	}
+
+	steps, err := r.scripts.Plan(stage, scriptsDir)
+	if err != nil {
+		return fmt.Errorf("error getting scripts: %w", err)
+	}
+
+	// Добавляем информацию о общем числе скриптов
+	r.printf("Found %d %s scripts\n", len(steps), stage)
+
+	if r.opts.Jobs > 1 && len(steps) > 1 && !r.stepping {
+		err = r.runScriptsParallel(stage, steps)
+	} else {
+		err = r.runScriptsSequential(stage, steps)
+	}
+
+	// Если мы в ручном режиме, позволяем пользователю исследовать состояние
+	if err != nil && r.opts.Manual {
+		r.println("\nEntering manual mode for debugging. Type 'exit' to quit.")
+		r.enterManualShell(r.jail)
+		r.println("Exited from manual mode, continuing with cleanup...")
+	}
+
+	// Возвращаем ошибку - cleanup будет выполнен через defer
+	return err
+}
+
+// runScriptsSequential выполняет скрипты стадии по одному в порядке плана
+func (r *stageRunner) runScriptsSequential(stage string, steps []scripts.Step) error {
+	// Скрипты, которые не были выполнены успешно: зависящие от них пропускаются
+	incomplete := make(map[string]bool)
+
+	for i, step := range steps {
+		if err := r.canceled(); err != nil {
+			return err
+		}
+		// Добавляем информацию о прогрессе
+		r.printf("==============================\n")
+		r.printf("Executing script [%d/%d]: %s\n", i+1, len(steps), step.Name)
+		r.printf("==============================\n")
+
+		if r.layers.cached(stage, step.Name) {
+			r.println(logging.Skipped("⏭  Skipped: " + skipCached))
+			r.recordSkip(stage, step, skipCached)
+			continue
+		}
+		if reason := r.deselectReason(stage, step); reason != "" {
+			r.println(logging.Skipped("⏭  Skipped: " + reason))
+			r.recordSkip(stage, step, reason)
+			r.layers.stop()
+			continue
+		}
+		reason, err := r.skipReason(step, incomplete)
+		if err != nil {
+			return err
+		}
+		if reason != "" {
+			r.println(logging.Skipped("⏭  Skipped: " + reason))
+			r.recordSkip(stage, step, reason)
+			r.layers.save(stage, step.Name)
+			incomplete[step.Name] = true
+			continue
+		}
+		if r.stepping {
+			run, err := r.promptStep(stage, step)
+			if err != nil {
+				return err
+			}
+			if !run {
+				r.println(logging.Skipped("⏭  Skipped at the --step prompt"))
+				r.recordSkip(stage, step, "skipped at the --step prompt")
+				continue
+			}
+		}
+
+		if r.opts.Verbose {
+			// В verbose режиме - live вывод
+			r.println("--- Live output ---")
+		}
+		result := r.executeScript(stage, step, r.opts.Verbose)
+		r.printScriptResult(result, r.opts.Verbose, r.opts.Verbose)
+
+		if result.err == nil {
+			r.layers.save(stage, step.Name)
+		} else {
+			r.layers.stop()
+		}
+		if result.err != nil {
+			if !step.ContinueOnError {
+				return &ScriptError{Stage: stage, Script: step.Name, ExitCode: exitCode(result.err), Err: fmt.Errorf("error executing script %s: %v", step.Name, result.err)}
+			}
+			r.log.Warn("script failed, continuing (continue_on_error)", "script", step.Name)
+			incomplete[step.Name] = true
+		}
+	}
+
+	return nil
+}
+
+// stepInput читает ответы пользователя в режиме --step
+var stepInput = bufio.NewReader(os.Stdin)
+
+// promptStep показывает скрипт перед выполнением и спрашивает, что с ним делать.
+// Возвращает false, если скрипт нужно пропустить.
+func (r *stageRunner) promptStep(stage string, step scripts.Step) (bool, error) {
+	r.printf("⏸  Next: %s/%s\n", stage, step.Name)
+	if summary := step.Summary(); summary != "" {
+		r.printf("   %s\n", summary)
+	}
+	if len(step.DependsOn) > 0 {
+		r.printf("   depends on: %s\n", strings.Join(step.DependsOn, ", "))
+	}
+
+	for {
+		r.printf("[r]un, [s]kip, s[h]ell in the jail first, [c]ontinue without pausing, [q]uit? [r] ")
+		answer, err := stepInput.ReadString('\n')
+		if err != nil {
+			return false, fmt.Errorf("build stopped at the --step prompt: %w", err)
+		}
+
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "", "r", "run":
+			return true, nil
+		case "s", "skip":
+			return false, nil
+		case "h", "shell":
+			r.println("Entering the jail shell. Type 'exit' to return to the prompt.")
+			r.enterManualShell(r.jail)
+		case "c", "continue":
+			r.stepping = false
+			return true, nil
+		case "q", "quit":
+			return false, fmt.Errorf("build stopped at the --step prompt before %s", step.Name)
+		default:
+			r.println("Unknown answer")
+		}
+	}
+}
+
+// runScriptsParallel выполняет независимые скрипты стадии одновременно, не более
+// --jobs сразу. Скрипт запускается, когда завершены его зависимости и все
+// предшествующие ему в плане скрипты с меньшим order. Вывод скрипта собирается
+// и печатается целиком после его завершения. После ошибки новые скрипты не
+// запускаются, уже запущенные дожидаются завершения.
+func (r *stageRunner) runScriptsParallel(stage string, steps []scripts.Step) error {
+	type finishedScript struct {
+		index  int
+		result scriptResult
+	}
+
+	incomplete := make(map[string]bool)
+	finished := make(map[string]bool)
+	started := make([]bool, len(steps))
+	results := make(chan finishedScript)
+	running, completed := 0, 0
+	var failure error
+
+	r.printf("Running up to %d scripts in parallel\n", r.opts.Jobs)
+
+	for {
+		// Запускаем готовые скрипты, пока есть свободные места; пропуск скрипта
+		// может сделать готовыми зависящие от него, поэтому повторяем проход
+		for launched := true; launched && failure == nil; {
+			launched = false
+			for i, step := range steps {
+				if started[i] || running >= r.opts.Jobs || !scriptReady(steps, i, finished) {
+					continue
+				}
+				if err := r.canceled(); err != nil {
+					failure = err
+					break
+				}
+				started[i], launched = true, true
+
+				if reason := r.deselectReason(stage, step); reason != "" {
+					completed++
+					r.println(logging.Skipped(fmt.Sprintf("⏭  [%d/%d] %s skipped: %s", completed, len(steps), step.Name, reason)))
+					r.recordSkip(stage, step, reason)
+					finished[step.Name] = true
+					continue
+				}
+				reason, err := r.skipReason(step, incomplete)
+				if err != nil {
+					failure = err
+					break
+				}
+				if reason != "" {
+					completed++
+					r.println(logging.Skipped(fmt.Sprintf("⏭  [%d/%d] %s skipped: %s", completed, len(steps), step.Name, reason)))
+					r.recordSkip(stage, step, reason)
+					incomplete[step.Name], finished[step.Name] = true, true
+					continue
+				}
+
+				running++
+				r.printf("▶  Started %s\n", step.Name)
+				go func(i int, step scripts.Step) {
+					results <- finishedScript{i, r.executeScript(stage, step, false)}
+				}(i, step)
+			}
+		}
+
+		if running == 0 {
+			break
+		}
+
+		done := <-results
+		running--
+		completed++
+		step := steps[done.index]
+		finished[step.Name] = true
+
+		r.printf("==============================\n")
+		r.printf("Finished script [%d/%d]: %s\n", completed, len(steps), step.Name)
+		r.printf("==============================\n")
+		r.printScriptResult(done.result, false, r.opts.Verbose)
+
+		if done.result.err != nil {
+			if step.ContinueOnError {
+				r.log.Warn("script failed, continuing (continue_on_error)", "script", step.Name)
+				incomplete[step.Name] = true
+			} else if failure == nil {
+				failure = &ScriptError{Stage: stage, Script: step.Name, ExitCode: exitCode(done.result.err), Err: fmt.Errorf("error executing script %s: %v", step.Name, done.result.err)}
+				if running > 0 {
+					r.printf("Waiting for %d running scripts to finish...\n", running)
+				}
+			}
+		}
+	}
+	if failure != nil {
+		return failure
+	}
+
+	// Скрипт, зависимости которого так и не завершились, не должен молча
+	// выпасть из сборки
+	var blocked []string
+	for i, step := range steps {
+		if !started[i] {
+			blocked = append(blocked, fmt.Sprintf("%s (waiting for %s)", step.Name, strings.Join(pendingDependencies(steps, i, finished), ", ")))
+		}
+	}
+	if len(blocked) > 0 {
+		return fmt.Errorf("scripts of stage %s were never started: %s", stage, strings.Join(blocked, "; "))
+	}
+	return nil
+}
+
+// scriptReady сообщает, можно ли запустить скрипт steps[i]: его зависимости
+// и предшествующие скрипты с меньшим order завершены
+func scriptReady(steps []scripts.Step, i int, finished map[string]bool) bool {
+	return len(pendingDependencies(steps, i, finished)) == 0
+}
+
+// pendingDependencies возвращает незавершенные зависимости скрипта steps[i]
+// и предшествующие ему скрипты с меньшим order
+func pendingDependencies(steps []scripts.Step, i int, finished map[string]bool) []string {
+	var pending []string
+	for _, dep := range steps[i].DependsOn {
+		if !finished[dep] {
+			pending = append(pending, dep)
+		}
+	}
+	for _, prev := range steps[:i] {
+		if prev.Order < steps[i].Order && !finished[prev.Name] && !slices.Contains(pending, prev.Name) {
+			pending = append(pending, prev.Name)
+		}
+	}
+	return pending
+}
+
+// deselectReason возвращает причину пропуска скрипта, который не нужно
+// выполнять в этой сборке: он выполнен до --resume или исключен выбором
+// скриптов. Такой пропуск не мешает зависящим от скрипта.
+func (r *stageRunner) deselectReason(stage string, step scripts.Step) string {
+	if r.state.ScriptDone(stage, step.Name) {
+		return "completed before the build was resumed"
+	}
+	return r.deselected[stage+"/"+step.Name]
+}
+
+// recordSkip добавляет пропущенный скрипт в запись о сборке
+func (r *stageRunner) recordSkip(stage string, step scripts.Step, reason string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.record.Scripts = append(r.record.Scripts, store.ScriptRun{
+		Stage:     stage,
+		Name:      step.Name,
+		StartedAt: time.Now(),
+		Skipped:   reason,
+	})
+	r.emit(progress.Event{Type: progress.EventScriptSkipped, Stage: stage, Script: step.Name, Message: reason})
+}
+
+// skipReason возвращает причину пропуска скрипта: незавершенная зависимость
+// или ложное условие when; пустая строка - скрипт нужно выполнить
+func (r *stageRunner) skipReason(step scripts.Step, incomplete map[string]bool) (string, error) {
+	if dep := firstIncomplete(step.DependsOn, incomplete); dep != "" {
+		return fmt.Sprintf("dependency %s did not complete", dep), nil
+	}
+	if step.When != "" {
+		ok, err := scripts.Eval(step.When, r.configValues, r.templateDir)
+		if err != nil {
+			return "", &ConfigError{fmt.Errorf("error evaluating when of script %s: %w", step.Name, err)}
+		}
+		if !ok {
+			return fmt.Sprintf("when %q is false", step.When), nil
+		}
+	}
+	return "", nil
+}
+
+// scriptResult - результат выполнения скрипта
+type scriptResult struct {
+	output   []byte
+	duration time.Duration
+	attempts int
+	log      string // Файл с полным выводом
+	err      error
+}
+
+// executeScript выполняет скрипт в jail и добавляет его в запись о сборке.
+// При live вывод скрипта идет сразу в лог, иначе собирается в результат.
+func (r *stageRunner) executeScript(stage string, step scripts.Step, live bool) scriptResult {
+	// Замеряем время выполнения
+	startTime := time.Now()
+
+	// Путь к скрипту внутри chroot и интерпретатор из scripts.yaml или строки #!
+	chrootScriptPath := "/scripts/" + stage + "/" + step.Name
+	command, err := step.Command(chrootScriptPath)
+	if err != nil {
+		return scriptResult{err: fmt.Errorf("error reading script: %w", err)}
+	}
+
+	log := r.log.With("script", stage+"/"+step.Name)
+	log.Debug("running script", "command", strings.Join(command, " "))
+	r.emit(progress.Event{Type: progress.EventScriptStarted, Stage: stage, Script: step.Name})
+
+	// Полный вывод пишется в файл независимо от --verbose
+	logPath, _ := filepath.Abs(filepath.Join(r.opts.Output, scriptLogsDir, stage, step.Name+".log"))
+	var logOut io.Writer = io.Discard
+	if logFile, err := createScriptLog(logPath); err != nil {
+		log.Warn("error creating script log", "error", err)
+		logPath = ""
+	} else {
+		defer logFile.Close()
+		logOut = logFile
+	}
+
+	// Упавший скрипт перезапускается согласно retries из scripts.yaml
+	var output []byte
+	var usage jail.Usage // Суммарно по всем попыткам, память - наибольшая
+	attempt := 1
+	for ; ; attempt++ {
+		if attempt > 1 {
+			fmt.Fprintf(logOut, "--- attempt %d/%d\n", attempt, step.Attempts())
+		}
+		var used jail.Usage
+		if live {
+			used, err = r.jail.ExecuteCommandTeeEnv(step.Environ(), logOut, command[0], command[1:]...)
+		} else {
+			output, used, err = r.jail.ExecuteCommandMeasuredEnv(step.Environ(), command[0], command[1:]...)
+			logOut.Write(output)
+		}
+		usage.CPU += used.CPU
+		usage.Written += used.Written
+		usage.PeakMemory = max(usage.PeakMemory, used.PeakMemory)
+		if err != nil {
+			fmt.Fprintf(logOut, "--- %v\n", err)
+		}
+		if err == nil || attempt >= step.Attempts() {
+			break
+		}
+		log.Warn(fmt.Sprintf("%s failed (attempt %d/%d), retrying in %s", step.Name, attempt, step.Attempts(), step.Delay()), "error", err)
+
+		// Отмена сборки прерывает ожидание повтора; остается ошибка последней попытки
+		timer := time.NewTimer(step.Delay())
+		select {
+		case <-timer.C:
+			continue
+		case <-r.ctx.Done():
+			timer.Stop()
+		}
+		break
+	}
+
+	// Вычисляем время выполнения
+	duration := time.Since(startTime)
+
+	r.mutex.Lock()
+	r.record.Scripts = append(r.record.Scripts, store.ScriptRun{
+		Stage:      stage,
+		Name:       step.Name,
+		StartedAt:  startTime,
+		Duration:   duration.Seconds(),
+		ExitCode:   exitCode(err),
+		Attempts:   attempt,
+		Log:        logPath,
+		CPU:        usage.CPU.Seconds(),
+		PeakMemory: usage.PeakMemory,
+		Written:    usage.Written,
+	})
+	if err == nil {
+		if err := r.state.CompleteScript(stage, step.Name); err != nil {
+			r.log.Warn(err.Error())
+		}
+	}
+	r.mutex.Unlock()
+
+	finished := progress.Event{
+		Type:     progress.EventScriptFinished,
+		Stage:    stage,
+		Script:   step.Name,
+		Result:   store.ResultSuccess,
+		Duration: duration.Seconds(),
+		ExitCode: exitCode(err),
+		Attempts: attempt,
+		Path:     logPath,
+	}
+	if err != nil {
+		finished.Result = store.ResultFailed
+		finished.Error = err.Error()
+	}
+	r.emit(finished)
+
+	return scriptResult{output: output, duration: duration, attempts: attempt, log: logPath, err: err}
+}
+
+// scriptLogsDir - каталог полных выводов скриптов в директории вывода:
+// logs/<стадия>/<скрипт>.log
+const scriptLogsDir = "logs"
+
+// createScriptLog создает файл вывода скрипта
+func createScriptLog(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return os.Create(path)
+}
+
+// printScriptResult выводит итог скрипта и его собранный вывод: при ошибке
+// или с full - полностью, иначе кратко. После live вывода повторять нечего.
+func (b *build) printScriptResult(result scriptResult, live, full bool) {
+	if result.attempts > 1 {
+		b.printf("Attempts: %d\n", result.attempts)
+	}
+	if result.err != nil {
+		b.println(logging.Failure(fmt.Sprintf("❌ Script failed (%.2f seconds): %v", result.duration.Seconds(), result.err)))
+		if result.log != "" {
+			b.printf("Full output: %s\n", result.log)
+		}
+		if !live {
+			b.println("--- Output begin ---")
+			b.println(string(result.output))
+			b.println("--- Output end ---")
+		}
+		return
+	}
+
+	// Если скрипт выполнился успешно, выводим время
+	b.println(logging.Success(fmt.Sprintf("✅ Script completed successfully in %.2f seconds", result.duration.Seconds())))
+
+	switch {
+	case live:
+	case full:
+		if len(result.output) > 0 {
+			b.println("--- Output begin ---")
+			b.println(string(result.output))
+			b.println("--- Output end ---")
+		}
+	default:
+		b.printOutputPreview(result.output)
+	}
+}
+
+// firstIncomplete возвращает первую зависимость, не выполненную успешно
+func firstIncomplete(deps []string, incomplete map[string]bool) string {
+	for _, dep := range deps {
+		if incomplete[dep] {
+			return dep
+		}
+	}
+	return ""
+}
+
+// printOutputPreview показывает краткий вывод или полный в зависимости от размера
+func (b *build) printOutputPreview(output []byte) {
+	if len(output) < 500 {
+		if len(output) > 0 {
+			b.println("--- Output begin ---")
+			b.println(string(output))
+			b.println("--- Output end ---")
+		}
+		return
+	}
+
+	// Если вывод длинный, показываем только начало и конец
+	lines := strings.Split(string(output), "\n")
+	if len(lines) <= 10 {
+		b.println("--- Output begin ---")
+		b.println(string(output))
+		b.println("--- Output end ---")
+		return
+	}
+
+	b.println("--- Output preview (use --verbose for full output) ---")
+	for _, line := range lines[:5] {
+		b.println(line)
+	}
+	b.println("...")
+	for _, line := range lines[len(lines)-5:] {
+		b.println(line)
+	}
+	b.println("--- End of preview ---")
+}
+
+// enterManualShell запускает интерактивную оболочку внутри jail
+func (b *build) enterManualShell(j *jail.Jail) {
+	shellCmd := exec.Command("sudo", "chroot", j.GetChrootDir(), "/bin/sh")
+	shellCmd.Stdin = os.Stdin
+	shellCmd.Stdout = os.Stdout
+	shellCmd.Stderr = os.Stderr
+
+	if err := shellCmd.Run(); err != nil {
+		b.printf("Error in interactive shell: %v\n", err)
+	}
+}
+
+// copyArtifacts копирует файлы из /output внутри chroot в директорию вывода
+// и возвращает пути скопированных артефактов
+func (b *build) copyArtifacts(outputDirInChroot, outputPath string) ([]string, error) {
+	b.println("\nCopying built images from jail...")
+
+	// Создаем директорию для вывода, если она не существует
+	if err := os.MkdirAll(outputPath, 0755); err != nil {
+		return nil, fmt.Errorf("error creating output directory: %w", err)
+	}
+
+	// Ищем файлы в /output внутри chroot
+	outputFiles, err := filepath.Glob(filepath.Join(outputDirInChroot, "*"))
+	if err != nil {
+		return nil, fmt.Errorf("error searching for output files: %w", err)
+	}
+
+	if len(outputFiles) == 0 {
+		b.log.Warn("no output files found in /output inside the jail")
+		return nil, nil
+	}
+
+	// Копируем каждый файл
+	var copied []string
+	for _, file := range outputFiles {
+		fileName := filepath.Base(file)
+		destPath := filepath.Join(outputPath, fileName)
+
+		b.printf("Copying %s to %s\n", fileName, destPath)
+
+		if err := copyFile(file, destPath); err != nil {
+			return copied, err
+		}
+
+		b.printf("Successfully copied %s\n", fileName)
+		copied = append(copied, destPath)
+	}
+
+	return copied, nil
+}
+
+// copyFile копирует один файл
+func copyFile(src, dst string) error {
+	input, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("error opening source file: %w", err)
+	}
+	defer input.Close()
+
+	output, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("error creating destination file: %w", err)
+	}
+	defer output.Close()
+
+	if _, err := io.Copy(output, input); err != nil {
+		return fmt.Errorf("error copying file: %w", err)
+	}
+
+	return nil
+}
+
+// selectMirror проверяет зеркала по индексу первого репозитория apk и
+// переписывает /etc/apk/repositories на первое рабочее из них
+func (b *build) selectMirror(root string, mirrors []string, record *store.Record) error {
+	repos, err := apk.Repositories(root)
+	if err != nil {
+		return err
+	}
+	if len(repos) == 0 {
+		b.log.Warn("no Alpine repositories configured in the builder, mirrors ignored")
+		return nil
+	}
+
+	arch := apk.Arch(root)
+	if arch == "" {
+		arch = "x86_64"
+	}
+
+	loader := download.New(mirrors)
+	loader.Out = b
+	mirror, err := loader.Probe(repos[0] + "/" + arch + "/APKINDEX.tar.gz")
+	if err != nil {
+		return fmt.Errorf("error selecting Alpine mirror: %w", err)
+	}
+
+	b.printf("Using Alpine mirror: %s\n", mirror)
+	if err := apk.SetMirror(root, mirror); err != nil {
+		return err
+	}
+
+	for _, repo := range repos {
+		record.Downloads = append(record.Downloads, store.Download{
+			Artifact: "apk:" + repo,
+			URL:      strings.TrimRight(mirror, "/") + "/" + repo,
+			Mirror:   mirror,
+		})
+	}
+
+	return nil
+}
+
+// resolveBuilder заменяет ссылку на управляемый билдер (alpine:3.20), образ
+// контейнера (docker://alpine:3.20) или файл образа диска в builder_path путем
+// к его rootfs. Если fetch задан, отсутствующий билдер или билдер без проверки
+// подписи при заданном builder_key загружается заново, а образы извлекаются.
+func (b *build) resolveBuilder(j *jail.Jail, arch string, mirrors []string, fetch bool) error {
+	name := j.GetBuilderPath()
+	if builder.IsImage(name) {
+		if !fetch {
+			return nil
+		}
+		path, err := builder.ExportImage(b.opts.StateDir, name, arch, b)
+		if err != nil {
+			return fmt.Errorf("error exporting builder image %s: %w", name, err)
+		}
+		j.SetBuilderPath(path)
+		return nil
+	}
+	if builder.IsImageFile(name) {
+		if !fetch {
+			return nil
+		}
+		path, err := builder.ImportImage(b.opts.StateDir, name, j.GetLogWriter(), b)
+		if err != nil {
+			return fmt.Errorf("error importing builder image %s: %w", name, err)
+		}
+		j.SetBuilderPath(path)
+		return nil
+	}
+	if !builder.IsRef(name) {
+		return nil
+	}
+	ref, _ := builder.ParseRef(name)
+
+	if arch == "" {
+		arch = builder.HostArch()
+	}
+	path := builder.Path(b.opts.StateDir, ref, arch)
+	key := j.GetBuilderKey()
+
+	info, err := builder.ReadInfo(path)
+	if err != nil {
+		return err
+	}
+	if fetch && (info == nil || key != "" && !slices.Contains(info.Verified, "gpg")) {
+		b.printf("Builder %s (%s) not fetched or not verified, fetching it\n", ref, arch)
+		if _, err := builder.Fetch(b.opts.StateDir, ref, arch, builder.FetchOptions{Mirrors: mirrors, Key: key, Logger: b.log, Out: b}); err != nil {
+			return fmt.Errorf("error fetching builder %s: %w", ref, err)
+		}
+	}
+
+	j.SetBuilderPath(path)
+	return nil
+}
+
+// canceled возвращает ошибку, если контекст сборки отменен
+func (b *build) canceled() error {
+	if b.ctx == nil {
+		return nil
+	}
+	if err := b.ctx.Err(); err != nil {
+		return fmt.Errorf("build canceled: %w", err)
+	}
+	return nil
+}
+
+// loadOptions возвращает общие параметры загрузки файлов шаблона (config.yaml
+// и jail.yaml): переменные, каталоги include, строгость разбора и журнал
+func (b *build) loadOptions() config.Options {
+	return config.Options{
+		Vars:               b.opts.Vars,
+		IncludeDirs:        b.opts.IncludeDirs,
+		AllowUnknownFields: b.opts.AllowUnknownFields,
+		Logger:             b.log,
+	}
+}
+
+// configOptions возвращает параметры загрузки конфигурации сборки: слои
+// системы, пользователя и шаблона, профили и переопределения
+func (b *build) configOptions(tmpl *template.Template) config.Options {
+	opts := b.loadOptions()
+	opts.Layers = append(config.DefaultLayers(), tmpl.ConfigLayers()...)
+	opts.Profiles = b.opts.Profiles
+	opts.Overrides = b.opts.Overrides
+	return opts
+}
+
+// applyRetention очищает каталог состояния по секции retention конфигурации
+// сборки; ошибки очистки не влияют на результат сборки
+func (b *build) applyRetention(cfg structures.RetentionConfig) {
+	policy, err := retention.NewPolicy(cfg)
+	if err != nil {
+		b.log.Warn(err.Error())
+		return
+	}
+	if policy.Empty() {
+		return
+	}
+
+	result, err := retention.Collect(b.opts.StateDir, policy, false)
+	if err != nil {
+		b.log.Warn("error applying retention policy", "error", err)
+	}
+	if result != nil && len(result.Removed) > 0 {
+		result.Print(b, b.log, false)
+	}
+}
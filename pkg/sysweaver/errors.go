@@ -0,0 +1,78 @@
+package sysweaver
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// Категории ошибок сборки. Build возвращает ошибку одного из этих типов
+// (обернутую, поэтому проверять нужно через errors.As), если причину удалось
+// отнести к категории; sysweaver build завершается с кодом своей категории:
+//
+//	var scriptErr *sysweaver.ScriptError
+//	if errors.As(err, &scriptErr) {
+//		log.Printf("script %s/%s failed", scriptErr.Stage, scriptErr.Script)
+//	}
+//
+// Ошибки хуков, отмена сборки и прочие ошибки категории не имеют.
+
+// ConfigError - ошибка шаблона или конфигурации: файл не читается, значения
+// не проходят проверку, неверный выбор стадий или скриптов
+type ConfigError struct{ Err error }
+
+// HostError - на хосте нет необходимого для сборки: билдера, утилиты
+type HostError struct{ Err error }
+
+// JailError - ошибка среды сборки: запуск jail, монтирование, встроенные
+// шаги стадий (установка пакетов, настройка системы)
+type JailError struct{ Err error }
+
+// ScriptError - ошибка скрипта шаблона или проверок стадии test
+type ScriptError struct {
+	Stage    string
+	Script   string // Пусто для проверок sw_assert стадии test
+	ExitCode int    // Код завершения скрипта (-1, если он не был запущен)
+	Err      error
+}
+
+// ImageError - ошибка создания артефактов: образов, outputs, контрольных сумм
+type ImageError struct{ Err error }
+
+// PublishError - ошибка публикации или загрузки артефактов
+type PublishError struct{ Err error }
+
+func (e *ConfigError) Error() string  { return e.Err.Error() }
+func (e *ConfigError) Unwrap() error  { return e.Err }
+func (e *HostError) Error() string    { return e.Err.Error() }
+func (e *HostError) Unwrap() error    { return e.Err }
+func (e *JailError) Error() string    { return e.Err.Error() }
+func (e *JailError) Unwrap() error    { return e.Err }
+func (e *ScriptError) Error() string  { return e.Err.Error() }
+func (e *ScriptError) Unwrap() error  { return e.Err }
+func (e *ImageError) Error() string   { return e.Err.Error() }
+func (e *ImageError) Unwrap() error   { return e.Err }
+func (e *PublishError) Error() string { return e.Err.Error() }
+func (e *PublishError) Unwrap() error { return e.Err }
+
+// configError относит ошибку чтения шаблона или конфигурации к ConfigError,
+// а отсутствие программы фронтенда конфигурации (cue) - к HostError
+func configError(err error) error {
+	if errors.Is(err, exec.ErrNotFound) {
+		return &HostError{err}
+	}
+	return &ConfigError{err}
+}
+
+// classified сообщает, отнесена ли ошибка к одной из категорий
+func classified(err error) bool {
+	var (
+		configErr  *ConfigError
+		hostErr    *HostError
+		jailErr    *JailError
+		scriptErr  *ScriptError
+		imageErr   *ImageError
+		publishErr *PublishError
+	)
+	return errors.As(err, &configErr) || errors.As(err, &hostErr) || errors.As(err, &jailErr) ||
+		errors.As(err, &scriptErr) || errors.As(err, &imageErr) || errors.As(err, &publishErr)
+}
@@ -0,0 +1,155 @@
+package sysweaver
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"sysweaver/internal/plugin"
+	"sysweaver/internal/store"
+	"sysweaver/internal/structures"
+)
+
+// hook - плагин хука из секции hooks
+type hook struct {
+	structures.HookPlugin
+	plugin plugin.Plugin
+}
+
+// buildHooks вызывает хуки сборки: команды на хосте и плагины. Пометки
+// плагинов сохраняются в записи о сборке.
+type buildHooks struct {
+	*build
+	config structures.HooksConfig
+	hooks  []hook
+	record *store.Record
+	arch   string
+	dir    string // Каталог шаблона - рабочий каталог команд
+}
+
+// newBuildHooks находит плагины всех хуков до начала сборки, чтобы
+// отсутствующий хук не обнаружился на последней стадии
+func (b *build) newBuildHooks(cfg structures.HooksConfig, dir string, pluginDirs []string, record *store.Record, arch string) (*buildHooks, error) {
+	h := &buildHooks{build: b, config: cfg, record: record, arch: arch, dir: dir}
+	for _, p := range cfg.Plugins {
+		found, err := plugin.Find(pluginDirs, plugin.KindHook, p.Name)
+		if err != nil {
+			return nil, err
+		}
+		h.hooks = append(h.hooks, hook{HookPlugin: p, plugin: found})
+	}
+	return h, nil
+}
+
+// preBuild выполняет команды pre_build и плагины точки pre-build
+func (h *buildHooks) preBuild(templateDir string) error {
+	if err := h.runCommands("pre_build", h.config.PreBuild, nil); err != nil {
+		return err
+	}
+	return h.run(plugin.HookPreBuild, plugin.Request{TemplateDir: templateDir})
+}
+
+// postBuild вызывает плагины точки post-build, затем команды post_build
+// успешной или on_failure неудавшейся сборки. Возвращает запрет плагина или
+// ошибку post_build; ошибки on_failure выводятся предупреждениями.
+func (h *buildHooks) postBuild(buildErr error) error {
+	if h == nil {
+		return nil
+	}
+	req := postBuildRequest(h.record, buildErr)
+	err := h.run(plugin.HookPostBuild, req)
+	if buildErr == nil && err != nil {
+		buildErr = err
+		req = postBuildRequest(h.record, err)
+	}
+
+	env := []string{"SYSWEAVER_RESULT=" + req.Result, "SYSWEAVER_ERROR=" + req.Error, "SYSWEAVER_ARTIFACTS=" + strings.Join(req.Artifacts, " ")}
+	if buildErr != nil {
+		if cmdErr := h.runCommands("on_failure", h.config.OnFailure, env); cmdErr != nil {
+			h.log.Warn(cmdErr.Error())
+		}
+		return err
+	}
+	return h.runCommands("post_build", h.config.PostBuild, env)
+}
+
+// runCommands выполняет команды хука name на хосте по порядку; первая
+// неудавшаяся команда прерывает выполнение остальных
+func (h *buildHooks) runCommands(name string, commands []string, extraEnv []string) error {
+	if h == nil || len(commands) == 0 {
+		return nil
+	}
+
+	env := append(os.Environ(),
+		"SYSWEAVER_HOOK="+name,
+		"SYSWEAVER_BUILD_ID="+h.record.ID,
+		"SYSWEAVER_TEMPLATE="+h.record.Template,
+		"SYSWEAVER_NAME="+h.record.Name,
+		"SYSWEAVER_VERSION="+h.record.Version,
+		"SYSWEAVER_ARCH="+h.arch,
+		"SYSWEAVER_OUTPUT_DIR="+h.record.OutputDir,
+		"SYSWEAVER_STATE_DIR="+h.opts.StateDir,
+	)
+	env = append(env, extraEnv...)
+
+	for _, command := range commands {
+		h.printf("Running %s hook: %s\n", name, command)
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Dir = h.dir
+		cmd.Env = env
+		cmd.Stdout = h.build
+		cmd.Stderr = h.build
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s hook %q failed: %w", name, command, err)
+		}
+	}
+	return nil
+}
+
+// run вызывает плагины точки point по порядку; первый запрет прерывает вызов
+// остальных и возвращается как ошибка
+func (h *buildHooks) run(point string, req plugin.Request) error {
+	if h == nil {
+		return nil
+	}
+	req.Hook = point
+	req.Build = plugin.Build{ID: h.record.ID, Template: h.record.Template, Name: h.record.Name, Version: h.record.Version, Arch: h.arch}
+	if req.OutputDir == "" {
+		req.OutputDir = h.record.OutputDir
+	}
+
+	for _, hk := range h.hooks {
+		if len(hk.On) > 0 && !slices.Contains(hk.On, point) {
+			continue
+		}
+		req.Options = hk.Options
+
+		h.printf("Running %s hook %s\n", point, hk.Name)
+		result, err := hk.plugin.Run(req, h.build)
+		if err != nil {
+			return fmt.Errorf("%s hook %s rejected the build: %w", point, hk.Name, err)
+		}
+		// Пометки более позднего хука заменяют одноименные пометки предыдущих
+		for key, value := range result.Annotations {
+			if h.record.Annotations == nil {
+				h.record.Annotations = make(map[string]string)
+			}
+			h.record.Annotations[key] = value
+		}
+	}
+	return nil
+}
+
+// postBuildRequest описывает итог сборки для хуков post-build
+func postBuildRequest(record *store.Record, buildErr error) plugin.Request {
+	req := plugin.Request{Result: store.ResultSuccess}
+	if buildErr != nil {
+		req.Result, req.Error = store.ResultFailed, buildErr.Error()
+	}
+	for _, artifact := range record.Artifacts {
+		req.Artifacts = append(req.Artifacts, artifact.Path)
+	}
+	return req
+}
@@ -0,0 +1,247 @@
+// Package sysweaver собирает образы Linux по шаблонам SysWeaver. Это та же
+// сборка, что выполняет sysweaver build, для программ на Go, встраивающих
+// SysWeaver вместо запуска CLI:
+//
+//	var b sysweaver.Builder
+//	b.Events = func(e sysweaver.Event) { log.Println(e.Type, e.Stage, e.Script) }
+//	result, err := b.Build(ctx, sysweaver.Options{Template: "./templates/server", Output: "./out"})
+//
+// Builder.Events получает события одной сборки; они же проходят через общую
+// шину, где Subscribe получает события всех сборок процесса (например, для
+// метрик или аудита монтирований). Ход сборки, который sysweaver build
+// печатает в stdout (сообщения стадий, вывод команд jail, хуков и плагинов),
+// тоже приходит событиями - EventOutput с текстом в Message; сама сборка в
+// stdout не пишет.
+//
+// Параметры, контекст и журнал принадлежат сборке: Build пишет в
+// Builder.Logger, не меняя журнал slog по умолчанию, и не ждет других сборок
+// процесса. Общим для процесса остается терминал интерактивных режимов Step
+// и Manual. Прогресс длительных операций (mkfs, сжатие, загрузки) сборка
+// не выводит: строки прогресса печатает только CLI.
+package sysweaver
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"sysweaver/internal/progress"
+	"sysweaver/internal/store"
+)
+
+// Result - запись о сборке: результат, стадии, скрипты, артефакты, пакеты
+type Result = store.Record
+
+// Artifact - артефакт сборки
+type Artifact = store.Artifact
+
+// Event - событие сборки (то же, что выводит --progress json)
+type Event = progress.Event
+
+// Типы событий
+const (
+	EventBuildStarted   = progress.EventBuildStarted
+	EventBuildPlanned   = progress.EventBuildPlanned
+	EventBuildFinished  = progress.EventBuildFinished
+	EventStageStarted   = progress.EventStageStarted
+	EventStageFinished  = progress.EventStageFinished
+	EventScriptStarted  = progress.EventScriptStarted
+	EventScriptFinished = progress.EventScriptFinished
+	EventScriptSkipped  = progress.EventScriptSkipped
+	EventArtifact       = progress.EventArtifact
+	EventProgress       = progress.EventProgress
+	EventWarning        = progress.EventWarning
+	EventMountCreated   = progress.EventMountCreated
+	EventOutput         = progress.EventOutput
+)
+
+// Результаты сборки
+const (
+	ResultSuccess = store.ResultSuccess
+	ResultFailed  = store.ResultFailed
+)
+
+// Options - параметры сборки; соответствуют флагам sysweaver build
+type Options struct {
+	Template string // Каталог шаблона или git URL (обязателен)
+	Config   string // Файл конфигурации вместо config.yaml шаблона
+	Output   string // Директория вывода, по умолчанию ./output
+	StateDir string // Каталог состояния (хранилище, кэши), по умолчанию store.DefaultDir()
+
+	Profiles  []string // Профили конфигурации (--profile)
+	Overrides []string // Переопределения path=value (--set)
+
+	Vars               []string // Переменные конфигурации NAME=VALUE (--var)
+	IncludeDirs        []string // Общие каталоги фрагментов include (--include-dir)
+	AllowUnknownFields bool     // Не отклонять неизвестные ключи (--allow-unknown-fields)
+
+	// Выбор стадий
+	SkipImage   bool   // Остановиться перед стадией image (--skip-image)
+	ReuseRootfs string // Rootfs предыдущего запуска для стадии image (--reuse-rootfs)
+	ScriptsFrom string // Начать со стадии (--scripts-from)
+	Checkpoint  bool   // Контрольные точки после стадий (--checkpoint)
+	Resume      bool   // Продолжить упавшую сборку (--resume)
+
+	// Выбор скриптов
+	Skip  []string // --skip
+	Only  []string // --only
+	From  string   // --from
+	Until string   // --until
+
+	Jobs      int    // Параллельных скриптов стадии, по умолчанию 1 (--jobs)
+	Workspace string // Рабочий каталог jail (--workspace)
+
+	// Кэш слоев скриптов
+	Cache       bool   // --cache
+	CacheRemote string // --cache-remote
+	CachePush   bool   // --cache-push
+
+	UpdateLock        bool   // Обновить sysweaver.lock (--update)
+	UpdatePackageLock bool   // Обновить packages.lock (--update-lock)
+	JUnitReport       string // Отчет JUnit XML стадии test (--junit)
+	DryRun            bool   // Вывести план сборки без нее (--dry-run)
+
+	// Интерактивные режимы; читают stdin процесса
+	Step   bool // Пауза перед каждым скриптом (--step)
+	Manual bool // Оболочка jail после скриптов (--manual)
+
+	Verbose     bool   // Подробный вывод команд jail
+	ToolVersion string // Версия программы для манифеста сборки
+}
+
+// Builder выполняет сборки. Нулевое значение готово к использованию.
+type Builder struct {
+	// Events получает события этой сборки по мере выполнения (может быть nil).
+	// Вызовы не пересекаются и идут в порядке событий и при параллельных
+	// скриптах (Jobs > 1); событие доставляется из горутины, разбирающей
+	// очередь сборки, поэтому Events не должен ждать других событий сборки.
+	Events func(Event)
+
+	// Logger - журнал сборки (предупреждения, подробности команд); по
+	// умолчанию текстовый журнал в stderr
+	Logger *slog.Logger
+}
+
+// build - состояние одной сборки: параметры, контекст и журнал. Стадии и
+// встроенные шаги получают его явно, поэтому сборки одного процесса не
+// делят параметры и не меняют журнал slog по умолчанию.
+type build struct {
+	opts        Options
+	ctx         context.Context
+	log         *slog.Logger  // Журнал сборки; после создания записи - с ее ID
+	subscribers []func(Event) // Builder.Events и получатели самой сборки (subscribe)
+	id          string        // ID записи о сборке для событий
+
+	eventsMu   sync.Mutex
+	queue      []Event // События, ожидающие доставки получателям
+	delivering bool    // Очередь разбирает другой вызов emit
+}
+
+// Build выполняет сборку и возвращает запись о ней. Запись возвращается и
+// при ошибке, если сборка успела начаться; при DryRun записи нет. Отмена ctx
+// прерывает сборку перед следующим скриптом или стадией.
+func (b *Builder) Build(ctx context.Context, options Options) (*Result, error) {
+	if options.Template == "" {
+		return nil, fmt.Errorf("template is required")
+	}
+	if options.Output == "" {
+		options.Output = "./output"
+	}
+	if options.StateDir == "" {
+		options.StateDir = store.DefaultDir()
+	}
+	if options.Jobs < 1 {
+		options.Jobs = 1
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("build canceled: %w", err)
+	}
+
+	logger := b.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+	}
+	current := &build{opts: options, ctx: ctx}
+	if b.Events != nil {
+		current.subscribe(b.Events)
+	}
+	// Предупреждения журнала дублируются событиями warning
+	current.log = slog.New(progress.WarningEvents(logger.Handler(), current.emit))
+	return current.runBuild(options.Template)
+}
+
+// subscribe добавляет получателя событий этой сборки; вызывается до запуска
+// стадий, пока события не приходят из других горутин
+func (b *build) subscribe(f func(Event)) {
+	b.eventsMu.Lock()
+	defer b.eventsMu.Unlock()
+	b.subscribers = append(b.subscribers, f)
+}
+
+// emit передает событие сборки ее получателям, затем общей шине. Как и в
+// progress.Emit, событие встает в очередь, которую разбирает один вызов
+// emit: при параллельных скриптах (Jobs > 1) получатели вызываются по
+// одному, в порядке событий, а событие из самого получателя (например,
+// предупреждение журнала) доставляется после текущего.
+func (b *build) emit(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	b.eventsMu.Lock()
+	if e.Build == "" {
+		e.Build = b.id
+	}
+	b.queue = append(b.queue, e)
+	if b.delivering {
+		b.eventsMu.Unlock()
+		return
+	}
+
+	b.delivering = true
+	for len(b.queue) > 0 {
+		next := b.queue[0]
+		b.queue = b.queue[1:]
+		current := slices.Clone(b.subscribers)
+		b.eventsMu.Unlock()
+		for _, f := range current {
+			f(next)
+		}
+		progress.Emit(next)
+		b.eventsMu.Lock()
+	}
+	b.delivering = false
+	b.eventsMu.Unlock()
+}
+
+// printf выводит ход сборки событием output
+func (b *build) printf(format string, args ...interface{}) {
+	b.emit(Event{Type: EventOutput, Message: fmt.Sprintf(format, args...)})
+}
+
+// println выводит строку хода сборки событием output
+func (b *build) println(args ...interface{}) {
+	b.emit(Event{Type: EventOutput, Message: fmt.Sprintln(args...)})
+}
+
+// Write передает вывод команд jail, хуков и плагинов событиями output
+func (b *build) Write(p []byte) (int, error) {
+	b.emit(Event{Type: EventOutput, Message: string(p)})
+	return len(p), nil
+}
+
+// Subscribe передает функции f события всех сборок процесса до вызова
+// возвращаемой функции отписки. События доставляются по одному в потоке
+// одной из сборок, поэтому f не должна блокироваться надолго.
+func Subscribe(f func(Event)) (unsubscribe func()) {
+	return progress.Subscribe(f)
+}
+
+// Stages возвращает стадии сборки в порядке выполнения
+func Stages() []string {
+	return slices.Clone(buildStages)
+}
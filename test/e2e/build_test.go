@@ -0,0 +1,97 @@
+//go:build integration
+
+package e2e
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestBuildRootfs выполняет стадию install в jail и сохраняет rootfs (--skip-image)
+func TestBuildRootfs(t *testing.T) {
+	builder := busyboxRootfs(t)
+	tpl := newTemplate(t, builder, "name: e2e\nversion: \"1.0\"\n", map[string]string{
+		"install/01-motd.sh": "#!/bin/sh\necho 'built by sysweaver' > /etc/motd\n",
+	})
+
+	tpl.build(t, "--skip-image")
+
+	data, err := os.ReadFile(filepath.Join(tpl.Output, "rootfs/etc/motd"))
+	if err != nil {
+		t.Fatalf("rootfs was not exported: %v", err)
+	}
+	if strings.TrimSpace(string(data)) != "built by sysweaver" {
+		t.Fatalf("unexpected /etc/motd: %q", data)
+	}
+
+	// Служебные точки монтирования jail не попадают в rootfs
+	for _, dir := range []string{"template", "scripts"} {
+		entries, _ := os.ReadDir(filepath.Join(tpl.Output, "rootfs", dir))
+		if len(entries) > 0 {
+			t.Errorf("rootfs contains jail mount %s", dir)
+		}
+	}
+}
+
+// TestRawImage собирает raw-образ с одним разделом ext4
+func TestRawImage(t *testing.T) {
+	requireTools(t, "parted", "losetup", "mkfs.ext4", "udevadm")
+
+	builder := busyboxRootfs(t)
+	tpl := newTemplate(t, builder, `name: e2e
+version: "1.0"
+partitions:
+  - name: root
+    size: 32M
+    filesystem: ext4
+    mount: /
+outputs:
+  - raw
+`, map[string]string{
+		"install/01-motd.sh": "#!/bin/sh\necho raw > /etc/motd\n",
+	})
+
+	tpl.build(t)
+
+	img := filepath.Join(tpl.Output, "e2e-1.0.img")
+	out, err := exec.Command("parted", "-s", "-m", img, "unit", "MiB", "print").CombinedOutput()
+	if err != nil {
+		t.Fatalf("parted failed on %s: %v\n%s", img, err, out)
+	}
+	if !strings.Contains(string(out), "gpt") || !strings.Contains(string(out), "ext4") {
+		t.Fatalf("unexpected partition table:\n%s", out)
+	}
+
+	// Файл из rootfs находится поиском по образу
+	found := run(t, "search", img, "motd")
+	if !strings.Contains(found, "etc/motd") {
+		t.Fatalf("search did not find /etc/motd in raw image:\n%s", found)
+	}
+}
+
+// TestISO проверяет монтирование и поиск по незагрузочному ISO из собранного rootfs
+func TestISO(t *testing.T) {
+	requireTools(t, "xorriso")
+
+	builder := busyboxRootfs(t)
+	tpl := newTemplate(t, builder, "name: e2e\nversion: \"1.0\"\n", map[string]string{
+		"install/01-motd.sh": "#!/bin/sh\necho iso > /etc/motd\n",
+	})
+
+	tpl.build(t, "--skip-image")
+
+	iso := filepath.Join(tpl.Output, "e2e.iso")
+	out, err := exec.Command("xorriso", "-as", "mkisofs", "-R", "-V", "E2E", "-o", iso,
+		filepath.Join(tpl.Output, "rootfs")).CombinedOutput()
+	if err != nil {
+		t.Fatalf("xorriso failed: %v\n%s", err, out)
+	}
+
+	found := run(t, "search", iso, "^iso$", "--content")
+	if !strings.Contains(found, "etc/motd") {
+		t.Fatalf("search did not find /etc/motd in ISO:\n%s", found)
+	}
+}
@@ -0,0 +1,157 @@
+//go:build integration
+
+// Package e2e содержит сквозные тесты SysWeaver на реальных миниатюрных сборках.
+// Тесты требуют root (overlay, loop, mount) и собираются только с тегом integration:
+//
+//	sudo go test -tags integration ./test/e2e/...
+//
+// Билдер собирается из статического busybox хоста (или SYSWEAVER_E2E_BUSYBOX).
+package e2e
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Собранный бинарник sysweaver для всех тестов
+var binary string
+
+func TestMain(m *testing.M) {
+	if os.Geteuid() != 0 {
+		fmt.Println("e2e tests require root, skipping")
+		os.Exit(0)
+	}
+
+	dir, err := os.MkdirTemp("", "sysweaver-e2e-bin-")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	binary = filepath.Join(dir, "sysweaver")
+	build := exec.Command("go", "build", "-o", binary, "../../cmd")
+	build.Stdout = os.Stdout
+	build.Stderr = os.Stderr
+	if err := build.Run(); err != nil {
+		fmt.Printf("error building sysweaver: %v\n", err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// requireTools пропускает тест, если на хосте нет нужных утилит
+func requireTools(t *testing.T, tools ...string) {
+	t.Helper()
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not found in PATH", tool)
+		}
+	}
+}
+
+// busyboxRootfs создает минимальную корневую ФС на статическом busybox
+func busyboxRootfs(t *testing.T) string {
+	t.Helper()
+
+	busybox := os.Getenv("SYSWEAVER_E2E_BUSYBOX")
+	if busybox == "" {
+		path, err := exec.LookPath("busybox")
+		if err != nil {
+			t.Skip("busybox not found (set SYSWEAVER_E2E_BUSYBOX to a static busybox binary)")
+		}
+		busybox = path
+	}
+
+	root := t.TempDir()
+	for _, dir := range []string{"bin", "sbin", "etc", "tmp", "proc", "sys", "dev", "root", "usr/bin", "usr/sbin"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(busybox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "bin/busybox"), data, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ссылки на апплеты busybox (/bin/sh, /bin/ash, ...)
+	if out, err := exec.Command("chroot", root, "/bin/busybox", "--install", "-s").CombinedOutput(); err != nil {
+		t.Fatalf("error installing busybox applets (is busybox static?): %v\n%s", err, out)
+	}
+
+	writeFile(t, filepath.Join(root, "etc/os-release"), "ID=busybox\nNAME=\"SysWeaver e2e\"\n")
+	writeFile(t, filepath.Join(root, "etc/passwd"), "root:x:0:0:root:/root:/bin/sh\n")
+	writeFile(t, filepath.Join(root, "etc/group"), "root:x:0:\n")
+
+	return root
+}
+
+// template - шаблон сборки во временной директории
+type template struct {
+	Dir    string
+	Output string
+}
+
+// newTemplate создает шаблон с jail.yaml, config.yaml и скриптами стадий.
+// Ключи scripts - пути относительно scripts/ (например, install/01-motd.sh).
+func newTemplate(t *testing.T, builder, config string, scripts map[string]string) *template {
+	t.Helper()
+
+	dir := t.TempDir()
+	chroot := filepath.Join(t.TempDir(), "chroot")
+
+	writeFile(t, filepath.Join(dir, "jail.yaml"), fmt.Sprintf(
+		"chroot_dir: %s\nbuilder_path: %s\ncheckpoint_dir: %s\n",
+		chroot, builder, filepath.Join(t.TempDir(), "checkpoints")))
+	writeFile(t, filepath.Join(dir, "config.yaml"), config)
+
+	for name, content := range scripts {
+		writeFile(t, filepath.Join(dir, "scripts", name), content)
+	}
+
+	return &template{Dir: dir, Output: filepath.Join(t.TempDir(), "output")}
+}
+
+// build запускает sysweaver build для шаблона с дополнительными флагами
+func (tpl *template) build(t *testing.T, args ...string) string {
+	t.Helper()
+	args = append([]string{"build", tpl.Dir, "-o", tpl.Output}, args...)
+	return run(t, args...)
+}
+
+// run запускает sysweaver с отдельной директорией состояния и возвращает вывод
+func run(t *testing.T, args ...string) string {
+	t.Helper()
+
+	var out bytes.Buffer
+	cmd := exec.Command(binary, args...)
+	cmd.Env = append(os.Environ(), "SYSWEAVER_STATE_DIR="+filepath.Join(os.TempDir(), "sysweaver-e2e-state"))
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("sysweaver %s failed: %v\n%s", strings.Join(args, " "), err, out.String())
+	}
+	return out.String()
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+}